@@ -4,17 +4,23 @@ package database
 
 import (
 	"bytes"
+	"encoding/json"
 	"errors"
 	"io"
 	"log"
 	"os"
 	"slices"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/mhsanaei/3x-ui/v2/config"
 	"github.com/mhsanaei/3x-ui/v2/database/model"
 	"github.com/mhsanaei/3x-ui/v2/util/crypto"
+	"github.com/mhsanaei/3x-ui/v2/util/random"
 	"github.com/mhsanaei/3x-ui/v2/xray"
 
+	"gorm.io/datatypes"
 	"gorm.io/driver/sqlite"
 	"gorm.io/gorm"
 	"gorm.io/gorm/logger"
@@ -33,18 +39,50 @@ func initModels() error {
 		&model.Inbound{},
 		&model.OutboundTraffics{},
 		&model.Setting{},
+		&model.UserRecoveryCode{},
+		&model.WebAuthnCredential{},
+		&model.Session{},
+		&model.ResellerQuota{},
+		&model.APIToken{},
+		&model.AuditLog{},
 		&model.InboundClientIps{},
+		&model.IPLimitBan{},
 		&xray.ClientTraffic{},
+		&model.Client{},
+		&model.ClientGroup{},
+		&model.ClientGroupMember{},
+		&model.Plan{},
+		&model.ClientRenewal{},
+		&model.SubAccount{},
+		&model.RecycledItem{},
+		&model.ScheduledAction{},
+		&model.TrafficStat{},
+		&model.ClientDestination{},
 		&model.HistoryOfSeeders{},
 	}
+	if db.Dialector.Name() != "sqlite" {
+		return autoMigrate(db, models)
+	}
+	// SQLite changes tables by copying them into new ones and dropping the old ones,
+	// which must not run the cascades of their foreign keys
+	return db.Connection(func(conn *gorm.DB) error {
+		if err := conn.Exec("PRAGMA foreign_keys = OFF").Error; err != nil {
+			return err
+		}
+		defer conn.Exec("PRAGMA foreign_keys = ON")
+		return autoMigrate(conn, models)
+	})
+}
+
+// autoMigrate migrates the tables of models one by one on conn.
+func autoMigrate(conn *gorm.DB, models []any) error {
 	for _, m := range models {
 		log.Printf("AutoMigrate: %T", m)
-		if err := db.Debug().AutoMigrate(m); err != nil {
+		if err := conn.Debug().AutoMigrate(m); err != nil {
 			log.Printf("FAILED on %T: %v", m, err)
 			return err
 		}
 	}
-
 	return nil
 }
 
@@ -66,6 +104,7 @@ func initUser() error {
 		user := &model.User{
 			Username: defaultUsername,
 			Password: hashedPassword,
+			Role:     model.RoleOwner,
 		}
 		return db.Create(user).Error
 	}
@@ -112,6 +151,147 @@ func runSeeders(isUsersEmpty bool) error {
 	return nil
 }
 
+// migrateTwoFactorSetting moves the former panel-wide two-factor secret to the first user
+// and removes the global settings. It runs once and is recorded as a seeder.
+func migrateTwoFactorSetting() error {
+	var seedersHistory []string
+	db.Model(&model.HistoryOfSeeders{}).Pluck("seeder_name", &seedersHistory)
+	if slices.Contains(seedersHistory, "UserTwoFactor") {
+		return nil
+	}
+
+	return db.Transaction(func(tx *gorm.DB) error {
+		var settings []model.Setting
+		err := tx.Where("key IN ?", []string{"twoFactorEnable", "twoFactorToken"}).Find(&settings).Error
+		if err != nil {
+			return err
+		}
+		values := map[string]string{}
+		for _, setting := range settings {
+			values[setting.Key] = setting.Value
+		}
+		if values["twoFactorEnable"] == "true" && values["twoFactorToken"] != "" {
+			user := &model.User{}
+			err = tx.Order("id").First(user).Error
+			if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+				return err
+			}
+			if err == nil {
+				err = tx.Model(user).Updates(map[string]any{
+					"two_factor_enable": true,
+					"two_factor_secret": values["twoFactorToken"],
+				}).Error
+				if err != nil {
+					return err
+				}
+				log.Printf("Moved two-factor authentication to user '%s'", user.Username)
+			}
+		}
+		if len(settings) > 0 {
+			if err := tx.Where("key IN ?", []string{"twoFactorEnable", "twoFactorToken"}).Delete(&model.Setting{}).Error; err != nil {
+				return err
+			}
+		}
+		return tx.Create(&model.HistoryOfSeeders{SeederName: "UserTwoFactor"}).Error
+	})
+}
+
+// migrateInboundClients moves the clients kept in the settings of inbounds into the
+// clients table. The table links clients to their traffic by email, so clients without
+// one get a generated email and clients without traffic get a traffic row. Fields the
+// panel does not know are kept with the client. It runs once and is recorded as a seeder.
+func migrateInboundClients() error {
+	var seedersHistory []string
+	db.Model(&model.HistoryOfSeeders{}).Pluck("seeder_name", &seedersHistory)
+	if slices.Contains(seedersHistory, "InboundClients") {
+		return nil
+	}
+
+	return db.Transaction(func(tx *gorm.DB) error {
+		var inbounds []*model.Inbound
+		if err := tx.Find(&inbounds).Error; err != nil {
+			return err
+		}
+		now := time.Now().UnixMilli()
+		moved := 0
+		for _, inbound := range inbounds {
+			settings := map[string]any{}
+			if err := json.Unmarshal(inbound.Settings, &settings); err != nil {
+				log.Printf("Skipping clients of inbound %d with unreadable settings: %v", inbound.Id, err)
+				continue
+			}
+			items, ok := settings["clients"]
+			if !ok {
+				continue
+			}
+			list, _ := items.([]any)
+			for _, item := range list {
+				c, ok := item.(map[string]any)
+				if !ok {
+					continue
+				}
+				if tgId, ok := c["tgId"].(string); ok {
+					c["tgId"], _ = strconv.ParseInt(strings.ReplaceAll(tgId, " ", ""), 10, 64)
+				}
+				data, err := json.Marshal(c)
+				if err != nil {
+					return err
+				}
+				// Fields of the wrong type are left empty instead of failing the migration.
+				client := model.Client{}
+				var typeErr *json.UnmarshalTypeError
+				if err := json.Unmarshal(data, &client); err != nil && !errors.As(err, &typeErr) {
+					return err
+				}
+				client.InboundId = inbound.Id
+				if client.Email == "" {
+					client.Email = random.Seq(8)
+					log.Printf("Client of inbound %d had no email, it is now '%s'", inbound.Id, client.Email)
+				}
+				if client.CreatedAt == 0 {
+					client.CreatedAt = now
+				}
+
+				var count int64
+				if err := tx.Model(xray.ClientTraffic{}).Where("email = ?", client.Email).Count(&count).Error; err != nil {
+					return err
+				}
+				if count == 0 {
+					err = tx.Create(&xray.ClientTraffic{
+						InboundId:  inbound.Id,
+						Email:      client.Email,
+						Enable:     client.Enable,
+						Total:      client.TotalGB,
+						ExpiryTime: client.ExpiryTime,
+						Reset:      client.Reset,
+					}).Error
+					if err != nil {
+						return err
+					}
+				}
+				if err := tx.Create(&client).Error; err != nil {
+					return err
+				}
+				moved++
+			}
+
+			delete(settings, "clients")
+			data, err := json.MarshalIndent(settings, "", "  ")
+			if err != nil {
+				return err
+			}
+			err = tx.Model(&model.Inbound{}).Where("id = ?", inbound.Id).Update("settings", datatypes.JSON(data)).Error
+			if err != nil {
+				return err
+			}
+		}
+		if moved > 0 {
+			log.Printf("Moved %d clients from inbound settings to the clients table", moved)
+		}
+		return tx.Create(&model.HistoryOfSeeders{SeederName: "InboundClients"}).Error
+	})
+}
+
 // isTableEmpty returns true if the named table contains zero rows.
 func isTableEmpty(tableName string) (bool, error) {
 	var count int64
@@ -0,0 +1,144 @@
+package database
+
+import (
+	"encoding/json"
+	"path/filepath"
+	"testing"
+
+	"github.com/mhsanaei/3x-ui/v2/database/model"
+	"github.com/mhsanaei/3x-ui/v2/xray"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMigrateInboundClients(t *testing.T) {
+	require.NoError(t, InitDB(filepath.Join(t.TempDir(), "x-ui.db")))
+	t.Cleanup(func() { CloseDB() })
+
+	settings := `{
+		"decryption": "none",
+		"clients": [
+			{"id": "uuid-1", "email": "alice", "enable": true, "totalGB": 100, "tgId": "12 34", "level": 1, "custom": {"note": "vip"}},
+			{"id": "uuid-2", "email": "bob", "enable": false, "limitIp": "two"},
+			{"id": "uuid-3", "enable": true},
+			"not a client"
+		]
+	}`
+	inbound := &model.Inbound{Tag: "inbound-1", Port: 1000, Protocol: model.VLESS, Settings: []byte(settings)}
+	require.NoError(t, db.Create(inbound).Error)
+	require.NoError(t, db.Create(&xray.ClientTraffic{InboundId: inbound.Id, Email: "bob", Up: 5}).Error)
+	require.NoError(t, db.Where("seeder_name = ?", "InboundClients").Delete(&model.HistoryOfSeeders{}).Error)
+
+	require.NoError(t, migrateInboundClients())
+
+	var clients []model.Client
+	require.NoError(t, db.Order("record_id").Find(&clients).Error)
+	require.Len(t, clients, 3)
+	alice := clients[0]
+	assert.Equal(t, "uuid-1", alice.ID)
+	assert.Equal(t, int64(1234), alice.TgID)
+	assert.Equal(t, int64(100), alice.TotalGB)
+	assert.Equal(t, map[string]json.RawMessage{"level": json.RawMessage(`1`), "custom": json.RawMessage(`{"note":"vip"}`)}, alice.Extra)
+	assert.Equal(t, "bob", clients[1].Email)
+	assert.Zero(t, clients[1].LimitIP, "field of the wrong type is left empty")
+	assert.Len(t, clients[2].Email, 8, "generated email")
+
+	// Every client has a traffic row, an existing one is kept
+	var traffics []xray.ClientTraffic
+	require.NoError(t, db.Order("id").Find(&traffics).Error)
+	require.Len(t, traffics, 3)
+	assert.Equal(t, int64(5), traffics[0].Up)
+
+	// The list is gone from the settings and the unknown fields come back with the client
+	stored := &model.Inbound{}
+	require.NoError(t, db.First(stored, inbound.Id).Error)
+	assert.NotContains(t, string(stored.Settings), "clients")
+	assert.Contains(t, string(stored.Settings), "decryption")
+	data, err := json.Marshal(alice)
+	require.NoError(t, err)
+	assert.Contains(t, string(data), `"level":1`)
+	assert.Contains(t, string(data), `"custom":{"note":"vip"}`)
+
+	// It runs once
+	require.NoError(t, migrateInboundClients())
+	var count int64
+	require.NoError(t, db.Model(model.Client{}).Count(&count).Error)
+	assert.Equal(t, int64(3), count)
+}
+
+func TestClientJSON(t *testing.T) {
+	client := model.Client{}
+	require.NoError(t, json.Unmarshal([]byte(`{"id":"uuid","Email":"alice","tgId":"","level":0}`), &client))
+	assert.Equal(t, "alice", client.Email, "keys match case-insensitively")
+	assert.Zero(t, client.TgID)
+	assert.Equal(t, map[string]json.RawMessage{"level": json.RawMessage(`0`)}, client.Extra)
+
+	var clients []model.Client
+	require.NoError(t, json.Unmarshal([]byte(`[{"email":"a","tgId":""},{"email":"b"}]`), &clients))
+	require.Len(t, clients, 2, "a field of the wrong type does not stop the list")
+	assert.Nil(t, clients[1].Extra)
+}
+
+func TestForeignKeyCascades(t *testing.T) {
+	require.NoError(t, InitDB(filepath.Join(t.TempDir(), "x-ui.db")))
+	t.Cleanup(func() { CloseDB() })
+
+	var enabled int
+	require.NoError(t, db.Raw("PRAGMA foreign_keys").Scan(&enabled).Error)
+	assert.Equal(t, 1, enabled)
+
+	first := &model.Inbound{Tag: "inbound-1", Port: 1000, Protocol: model.VLESS}
+	second := &model.Inbound{Tag: "inbound-2", Port: 1001, Protocol: model.VLESS}
+	require.NoError(t, db.Create(first).Error)
+	require.NoError(t, db.Create(second).Error)
+	group := &model.ClientGroup{Name: "customers"}
+	require.NoError(t, db.Create(group).Error)
+	var clients []model.Client
+	for i, inboundId := range []int{first.Id, first.Id, second.Id} {
+		email := string(rune('a' + i))
+		require.NoError(t, db.Create(&xray.ClientTraffic{InboundId: inboundId, Email: email}).Error)
+		client := model.Client{InboundId: inboundId, ID: "uuid-" + email, Email: email}
+		require.NoError(t, db.Create(&client).Error)
+		require.NoError(t, db.Create(&model.ClientGroupMember{GroupId: group.Id, ClientId: client.RecordId}).Error)
+		clients = append(clients, client)
+	}
+
+	// Deleting clients drops their memberships with them
+	require.NoError(t, db.Delete(&model.Client{}, clients[0].RecordId).Error)
+	require.NoError(t, db.Where("inbound_id = ?", second.Id).Delete(&model.Client{}).Error)
+	var members []model.ClientGroupMember
+	require.NoError(t, db.Find(&members).Error)
+	require.Len(t, members, 1)
+	assert.Equal(t, clients[1].RecordId, members[0].ClientId)
+
+	// Migrating again keeps the rows
+	require.NoError(t, initModels())
+	require.NoError(t, db.Find(&members).Error)
+	assert.Len(t, members, 1)
+
+	require.NoError(t, db.Delete(&model.ClientGroup{}, group.Id).Error)
+	require.NoError(t, db.Find(&members).Error)
+	assert.Empty(t, members)
+}
+
+func TestClientTrafficForeignKey(t *testing.T) {
+	require.NoError(t, InitDB(filepath.Join(t.TempDir(), "x-ui.db")))
+	t.Cleanup(func() { CloseDB() })
+	inbound := &model.Inbound{Tag: "inbound-1", Port: 1000, Protocol: model.VLESS}
+	require.NoError(t, db.Create(inbound).Error)
+
+	assert.Error(t, db.Create(&model.Client{InboundId: inbound.Id, ID: "uuid-1", Email: "alice"}).Error, "client without traffic")
+	require.NoError(t, db.Create(&xray.ClientTraffic{InboundId: inbound.Id, Email: "alice", Up: 5}).Error)
+	client := &model.Client{InboundId: inbound.Id, ID: "uuid-1", Email: "alice"}
+	require.NoError(t, db.Create(client).Error)
+
+	// Renaming the traffic row renames the client
+	require.NoError(t, db.Model(xray.ClientTraffic{}).Where("email = ?", "alice").Update("email", "alice2").Error)
+	require.NoError(t, db.First(client, client.RecordId).Error)
+	assert.Equal(t, "alice2", client.Email)
+
+	assert.Error(t, db.Where("email = ?", "alice2").Delete(&xray.ClientTraffic{}).Error, "traffic of a client")
+	require.NoError(t, db.Delete(client).Error)
+	require.NoError(t, db.Where("email = ?", "alice2").Delete(&xray.ClientTraffic{}).Error)
+}
@@ -38,7 +38,9 @@ func InitDBWithConfig(dbConfig *config.DatabaseConfig) error {
 	var err error
 	switch dbConfig.Type {
 	case config.DatabaseTypeSQLite:
-		db, err = gorm.Open(sqlite.Open(dbConfig.GetDSN()), c)
+		// SQLite only enforces foreign keys, and runs their cascades, when asked to on
+		// every connection
+		db, err = gorm.Open(sqlite.Open(dbConfig.GetDSN()+"?_foreign_keys=on"), c)
 	case config.DatabaseTypePostgreSQL:
 		db, err = gorm.Open(postgres.Open(dbConfig.GetDSN()), c)
 	default:
@@ -61,5 +63,11 @@ func InitDBWithConfig(dbConfig *config.DatabaseConfig) error {
 	if err := initUser(); err != nil {
 		return err
 	}
-	return runSeeders(isUsersEmpty)
+	if err := runSeeders(isUsersEmpty); err != nil {
+		return err
+	}
+	if err := migrateTwoFactorSetting(); err != nil {
+		return err
+	}
+	return migrateInboundClients()
 }
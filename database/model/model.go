@@ -2,7 +2,13 @@
 package model
 
 import (
+	"encoding/json"
+	"errors"
 	"fmt"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
 
 	"github.com/mhsanaei/3x-ui/v2/util/json_util"
 	"github.com/mhsanaei/3x-ui/v2/xray"
@@ -25,11 +31,125 @@ const (
 	WireGuard   Protocol = "wireguard"
 )
 
+// HasClients reports whether inbounds of the protocol have a list of clients.
+func (p Protocol) HasClients() bool {
+	switch p {
+	case VMESS, VLESS, Trojan, Shadowsocks:
+		return true
+	}
+	return false
+}
+
 // User represents a user account in the 3x-ui panel.
 type User struct {
 	Id       int    `json:"id" gorm:"primaryKey;autoIncrement"`
 	Username string `json:"username"`
 	Password string `json:"password"`
+	Role     Role   `json:"role" gorm:"default:owner"`
+
+	TwoFactorEnable bool   `json:"twoFactorEnable"`
+	TwoFactorSecret string `json:"-"` // Base32 TOTP secret, never sent to the browser
+
+	// OpenID Connect identity linked to the user, matched on single sign-on instead of the username
+	OidcIssuer  string `json:"-" gorm:"index:idx_user_oidc"`
+	OidcSubject string `json:"-" gorm:"index:idx_user_oidc"`
+}
+
+// UserRecoveryCode is a one-time code that replaces the TOTP code of a user who lost their device.
+type UserRecoveryCode struct {
+	Id       int    `json:"id" gorm:"primaryKey;autoIncrement"`
+	UserId   int    `json:"userId" gorm:"index"`
+	CodeHash string `json:"-"`
+}
+
+// WebAuthnCredential is a security key or passkey registered by a panel user.
+type WebAuthnCredential struct {
+	Id           int    `json:"id" gorm:"primaryKey;autoIncrement"`
+	UserId       int    `json:"userId" gorm:"index"`
+	Name         string `json:"name"`
+	CredentialId string `json:"credentialId" gorm:"uniqueIndex"` // Base64url credential id
+	Credential   string `json:"-"`                               // JSON encoded webauthn.Credential with the public key
+	CreatedAt    int64  `json:"createdAt" gorm:"autoCreateTime:milli"`
+	LastUsed     int64  `json:"lastUsed"` // Timestamp in milliseconds, 0 if never used
+}
+
+// Session is a server-side panel session. The browser cookie only carries the
+// signed opaque session id, of which the SHA-256 hash is stored.
+type Session struct {
+	Id        int    `json:"id" gorm:"primaryKey;autoIncrement"`
+	KeyHash   string `json:"-" gorm:"uniqueIndex"`                  // SHA-256 hash of the session id
+	UserId    int    `json:"userId" gorm:"index"`                   // Logged-in panel user, 0 before login
+	Data      []byte `json:"-"`                                     // Gob encoded session values
+	Ip        string `json:"ip"`                                    // Client IP address of the last request
+	UserAgent string `json:"userAgent"`                             // Browser user agent of the last request
+	CreatedAt int64  `json:"createdAt" gorm:"autoCreateTime:milli"` // Creation timestamp in milliseconds
+	LastSeen  int64  `json:"lastSeen"`                              // Last request timestamp in milliseconds
+	ExpiresAt int64  `json:"expiresAt" gorm:"index"`                // Expiration timestamp in milliseconds
+	Current   bool   `json:"current" gorm:"-"`                      // Whether this is the session of the listing request
+}
+
+// Role identifies the access level of a panel user.
+type Role string
+
+// Role constants for panel users
+const (
+	RoleOwner    Role = "owner"    // Full access, including settings and user management
+	RoleOperator Role = "operator" // Manages inbounds and clients
+	RoleSupport  Role = "support"  // Read access plus client support actions
+	RoleViewer   Role = "viewer"   // Read-only access
+	RoleReseller Role = "reseller" // Manages clients of the inbounds assigned to them, within a quota
+)
+
+// Permission is a single capability checked by the panel API.
+type Permission string
+
+// Permission constants checked by the panel controllers
+const (
+	PermView          Permission = "view"           // Read inbounds, clients and server status
+	PermClientSupport Permission = "client_support" // Reset client traffic, clear client IPs, read logs
+	PermClientManage  Permission = "client_manage"  // Add, update and delete clients
+	PermInboundManage Permission = "inbound_manage" // Add, update, delete and import inbounds
+	PermServerManage  Permission = "server_manage"  // Control Xray, geofiles and database backups
+	PermSettings      Permission = "settings"       // Read and change panel and Xray settings
+	PermUserManage    Permission = "user_manage"    // Manage panel users and their roles
+	PermAudit         Permission = "audit"          // Read the audit log
+)
+
+var rolePermissions = map[Role][]Permission{
+	RoleOwner: {
+		PermView, PermClientSupport, PermClientManage, PermInboundManage,
+		PermServerManage, PermSettings, PermUserManage, PermAudit,
+	},
+	RoleOperator: {PermView, PermClientSupport, PermClientManage, PermInboundManage},
+	RoleSupport:  {PermView, PermClientSupport},
+	RoleViewer:   {PermView},
+	RoleReseller: {PermView, PermClientSupport, PermClientManage},
+}
+
+// IsValid reports whether r is one of the known roles.
+func (r Role) IsValid() bool {
+	_, ok := rolePermissions[r]
+	return ok
+}
+
+// Can reports whether the role grants the given permission.
+func (r Role) Can(perm Permission) bool {
+	return slices.Contains(rolePermissions[r], perm)
+}
+
+// IsScoped reports whether the role only sees the inbounds assigned to the user
+// through Inbound.UserId instead of all inbounds.
+func (r Role) IsScoped() bool {
+	return r == RoleReseller
+}
+
+// ResellerQuota caps what a reseller may hand out across their inbounds. Zero means unlimited.
+type ResellerQuota struct {
+	Id            int   `json:"id" gorm:"primaryKey;autoIncrement"`
+	UserId        int   `json:"userId" form:"userId" gorm:"uniqueIndex"` // Reseller panel user
+	MaxClients    int   `json:"maxClients" form:"maxClients"`            // Maximum number of clients
+	TotalGB       int64 `json:"totalGB" form:"totalGB"`                  // Traffic in bytes that may be allocated to clients in total
+	MaxExpiryDays int   `json:"maxExpiryDays" form:"maxExpiryDays"`      // Furthest client expiry, in days from now
 }
 
 // Inbound represents an Xray inbound configuration with traffic statistics and settings.
@@ -46,6 +166,8 @@ type Inbound struct {
 	TrafficReset         string               `json:"trafficReset" form:"trafficReset" gorm:"default:never;index:idx_enable_traffic_reset,priority:2"` // Traffic reset schedule
 	LastTrafficResetTime int64                `json:"lastTrafficResetTime" form:"lastTrafficResetTime" gorm:"default:0"`                               // Last traffic reset timestamp
 	ClientStats          []xray.ClientTraffic `gorm:"foreignKey:InboundId;references:Id" json:"clientStats" form:"clientStats"`                        // Client traffic statistics
+	SpeedLimitUp         int64                `json:"speedLimitUp" form:"speedLimitUp" gorm:"default:0"`                                               // Default client upload limit in kbit/s
+	SpeedLimitDown       int64                `json:"speedLimitDown" form:"speedLimitDown" gorm:"default:0"`                                           // Default client download limit in kbit/s
 
 	// Xray configuration fields
 	Listen         string         `json:"listen" form:"listen"`
@@ -81,6 +203,118 @@ type InboundClientIps struct {
 	Ips         string `json:"ips" form:"ips"`
 }
 
+// IP limit enforcement modes, set by the "ipLimitMode" setting
+const (
+	IPLimitModeFail2Ban = "fail2ban" // Log excess IPs for Fail2Ban to ban
+	IPLimitModeXray     = "xray"     // Remove the client from Xray for the cooldown
+	IPLimitModeNftables = "nftables" // Drop the excess IPs with nftables for the cooldown
+)
+
+// IPLimitBan records a client blocked by the panel for connecting from more IPs than
+// its limit allows. The block is lifted at Until or when an admin lifts it early.
+type IPLimitBan struct {
+	Id          int    `json:"id" gorm:"primaryKey;autoIncrement"`
+	ClientEmail string `json:"clientEmail" gorm:"index"`
+	InboundId   int    `json:"inboundId"`
+	Mode        string `json:"mode"`                   // IPLimitModeXray or IPLimitModeNftables
+	Ips         string `json:"ips"`                    // Comma-separated excess IPs
+	Port        int    `json:"port"`                   // Inbound port the IPs are blocked on in the nftables mode
+	CreatedAt   int64  `json:"createdAt" gorm:"index"` // Timestamp in milliseconds
+	Until       int64  `json:"until"`                  // End of the cooldown in milliseconds
+	Lifted      bool   `json:"lifted" gorm:"index"`
+}
+
+// Kinds of recycle bin entries
+const (
+	RecycledClient  = "client"
+	RecycledInbound = "inbound"
+)
+
+// RecycledItem keeps a deleted client or inbound together with its traffic counters
+// and IPs until it is restored, purged, or older than the "recycleBinDays" setting.
+type RecycledItem struct {
+	Id        int    `json:"id" gorm:"primaryKey;autoIncrement"`
+	Kind      string `json:"kind" gorm:"index"`      // RecycledClient or RecycledInbound
+	InboundId int    `json:"inboundId" gorm:"index"` // Inbound of the client, or the inbound itself
+	Email     string `json:"email" gorm:"index"`     // Email of the client, empty for inbounds
+	Remark    string `json:"remark"`                 // Remark of the inbound
+	Data      string `json:"-" gorm:"type:text"`     // JSON snapshot of the deleted rows
+	DeletedAt int64  `json:"deletedAt" gorm:"index"` // Timestamp in milliseconds
+}
+
+// Operations of scheduled actions. On an inbound, enable and disable switch the
+// inbound itself and the others apply to every client of it.
+const (
+	ScheduleEnable       = "enable"
+	ScheduleDisable      = "disable"
+	ScheduleSetLimitIP   = "setLimitIp"   // Value is the IP limit, 0 for none
+	ScheduleAddTraffic   = "addTraffic"   // Value is the traffic to add in bytes
+	ScheduleExtendExpiry = "extendExpiry" // Value is the number of days
+	ScheduleResetTraffic = "resetTraffic"
+)
+
+// Targets of scheduled actions
+const (
+	ScheduleTargetClient  = "client"
+	ScheduleTargetInbound = "inbound"
+)
+
+// States of scheduled actions
+const (
+	SchedulePending  = "pending"
+	ScheduleRunning  = "running" // Claimed by a run that is applying it
+	ScheduleDone     = "done"
+	ScheduleFailed   = "failed"
+	ScheduleCanceled = "canceled"
+)
+
+// ScheduledAction is a change to a client or inbound that the panel makes at RunAt.
+type ScheduledAction struct {
+	Id         int    `json:"id" form:"id" gorm:"primaryKey;autoIncrement"`
+	TargetType string `json:"targetType" form:"targetType"`            // ScheduleTargetClient or ScheduleTargetInbound
+	Email      string `json:"email" form:"email" gorm:"index"`         // Email of a target client
+	InboundId  int    `json:"inboundId" form:"inboundId" gorm:"index"` // Id of a target inbound
+	Operation  string `json:"operation" form:"operation"`              // One of the Schedule* operations
+	Value      int64  `json:"value" form:"value"`                      // Argument of the operation
+	RunAt      int64  `json:"runAt" form:"runAt" gorm:"index"`         // Timestamp in milliseconds
+	Notify     bool   `json:"notify" form:"notify"`                    // Report the outcome to Telegram bot admins
+	Comment    string `json:"comment" form:"comment"`                  // Free-text description
+	Status     string `json:"status" gorm:"index;default:pending"`     // One of the Schedule* states
+	Result     string `json:"result"`                                  // Outcome or error of the run
+	ExecutedAt int64  `json:"executedAt"`                              // Timestamp in milliseconds
+	CreatedAt  int64  `json:"createdAt" gorm:"autoCreateTime:milli"`   // Creation timestamp
+}
+
+// Spans of traffic statistics buckets
+const (
+	TrafficSpanHour = "hour"
+	TrafficSpanDay  = "day"
+)
+
+// TrafficStat is the traffic of a client, or of an inbound itself when Email is empty,
+// within the hour or day that starts at Bucket.
+type TrafficStat struct {
+	Id        int64  `json:"-" gorm:"primaryKey;autoIncrement"`
+	Span      string `json:"span" gorm:"uniqueIndex:idx_traffic_stat;not null"`        // TrafficSpanHour or TrafficSpanDay
+	Bucket    int64  `json:"bucket" gorm:"uniqueIndex:idx_traffic_stat;not null"`      // Start of the bucket in milliseconds
+	InboundId int    `json:"inboundId" gorm:"uniqueIndex:idx_traffic_stat;not null"`   // Inbound the traffic went through
+	Email     string `json:"email" gorm:"uniqueIndex:idx_traffic_stat;not null;index"` // Client email, empty for the inbound
+	Up        int64  `json:"up"`
+	Down      int64  `json:"down"`
+}
+
+// ClientDestination counts the connections a client opened to a destination through
+// an outbound within the day that starts at Bucket, as read from the Xray access log.
+type ClientDestination struct {
+	Id          int64  `json:"-" gorm:"primaryKey;autoIncrement"`
+	Bucket      int64  `json:"bucket" gorm:"uniqueIndex:idx_client_destination;not null"`      // Start of the day in milliseconds
+	Email       string `json:"email" gorm:"uniqueIndex:idx_client_destination;not null;index"` // Client email
+	Destination string `json:"destination" gorm:"uniqueIndex:idx_client_destination;not null"` // Domain or IP, without the port
+	Outbound    string `json:"outbound" gorm:"uniqueIndex:idx_client_destination;not null"`    // Tag of the outbound the routing chose
+	Connections int64  `json:"connections"`
+	LastSeen    int64  `json:"lastSeen"` // Last connection in milliseconds
+}
+
 // HistoryOfSeeders tracks which database seeders have been executed to prevent re-running.
 type HistoryOfSeeders struct {
 	Id         int    `json:"id" gorm:"primaryKey;autoIncrement"`
@@ -114,21 +348,308 @@ type Setting struct {
 	Value string `json:"value" form:"value"`
 }
 
+// APIToken is a long-lived bearer token for the /panel/api endpoints.
+// Only the SHA-256 hash of the token is stored; the token acts as its owner
+// with permissions limited to both the owner's role and the token scopes.
+type APIToken struct {
+	Id         int    `json:"id" gorm:"primaryKey;autoIncrement"`
+	UserId     int    `json:"userId" gorm:"index"`                   // Owning panel user
+	Name       string `json:"name"`                                  // Human-readable token name
+	TokenHash  string `json:"-" gorm:"uniqueIndex"`                  // SHA-256 hash of the token
+	Prefix     string `json:"prefix"`                                // First characters of the token for identification
+	Scopes     string `json:"scopes"`                                // Comma-separated list of scopes
+	ExpiryTime int64  `json:"expiryTime"`                            // Expiration timestamp in milliseconds, 0 for never
+	LastUsed   int64  `json:"lastUsed"`                              // Last successful use timestamp in milliseconds
+	CreatedAt  int64  `json:"createdAt" gorm:"autoCreateTime:milli"` // Creation timestamp in milliseconds
+}
+
+// Token scope constants accepted by APIToken.Scopes
+const (
+	ScopeInboundsRead  = "inbounds:read"
+	ScopeInboundsWrite = "inbounds:write"
+	ScopeClientsWrite  = "clients:write"
+	ScopeServerAdmin   = "server:admin"
+	ScopeSettingsAdmin = "settings:admin"
+)
+
+// scopePermissions lists the permissions each scope grants. Tokens only reach the
+// /panel/api routes, so panel settings and users stay managed with a session and no
+// scope grants PermSettings or PermUserManage; settings:admin covers the audit log.
+var scopePermissions = map[string][]Permission{
+	ScopeInboundsRead:  {PermView},
+	ScopeInboundsWrite: {PermView, PermInboundManage},
+	ScopeClientsWrite:  {PermView, PermClientSupport, PermClientManage},
+	ScopeServerAdmin:   {PermView, PermServerManage},
+	ScopeSettingsAdmin: {PermView, PermAudit},
+}
+
+// IsValidScope reports whether scope is a known token scope.
+func IsValidScope(scope string) bool {
+	_, ok := scopePermissions[scope]
+	return ok
+}
+
+// ScopeList returns the token scopes as a slice.
+func (t *APIToken) ScopeList() []string {
+	var scopes []string
+	for _, scope := range strings.Split(t.Scopes, ",") {
+		if scope = strings.TrimSpace(scope); scope != "" {
+			scopes = append(scopes, scope)
+		}
+	}
+	return scopes
+}
+
+// Allows reports whether any of the token scopes grants perm.
+func (t *APIToken) Allows(perm Permission) bool {
+	for _, scope := range t.ScopeList() {
+		if slices.Contains(scopePermissions[scope], perm) {
+			return true
+		}
+	}
+	return false
+}
+
+// Audit actor types recorded in AuditLog.ActorType
+const (
+	AuditActorUser     = "user"     // Panel user with a cookie session
+	AuditActorToken    = "token"    // API token
+	AuditActorTelegram = "telegram" // Telegram bot admin
+	AuditActorSystem   = "system"   // Background jobs and internal callers
+)
+
+// AuditLog records a mutating action performed from the panel, the API or the Telegram bot.
+type AuditLog struct {
+	Id          int    `json:"id" gorm:"primaryKey;autoIncrement"`
+	ActorType   string `json:"actorType" gorm:"index"`   // One of the AuditActor* constants
+	ActorId     int64  `json:"actorId"`                  // User id, token id or Telegram chat id
+	ActorName   string `json:"actorName"`                // Username, token name or Telegram username
+	Action      string `json:"action" gorm:"index"`      // Action name, e.g. "client.update"
+	InboundId   int    `json:"inboundId" gorm:"index"`   // Target inbound id, 0 if none
+	ClientEmail string `json:"clientEmail" gorm:"index"` // Target client email, empty if none
+	Before      string `json:"before"`                   // JSON of the changed fields before the action
+	After       string `json:"after"`                    // JSON of the changed fields after the action
+	Ip          string `json:"ip"`                       // Source IP address
+	CreatedAt   int64  `json:"createdAt" gorm:"index"`   // Timestamp in milliseconds
+}
+
 // Client represents a client configuration for Xray inbounds with traffic limits and settings.
+// Clients are stored in their own table; the "clients" list in the settings of an inbound is
+// filled from it when the inbound is loaded and is never stored with the inbound itself.
+// A client is linked to its xray.ClientTraffic row by email, through a foreign key that
+// takes renames of the traffic row along to the client.
 type Client struct {
-	ID         string `json:"id"`                           // Unique client identifier
-	Security   string `json:"security"`                     // Security method (e.g., "auto", "aes-128-gcm")
-	Password   string `json:"password"`                     // Client password
-	Flow       string `json:"flow"`                         // Flow control (XTLS)
-	Email      string `json:"email"`                        // Client email identifier
-	LimitIP    int    `json:"limitIp"`                      // IP limit for this client
-	TotalGB    int64  `json:"totalGB" form:"totalGB"`       // Total traffic limit in GB
-	ExpiryTime int64  `json:"expiryTime" form:"expiryTime"` // Expiration timestamp
-	Enable     bool   `json:"enable" form:"enable"`         // Whether the client is enabled
-	TgID       int64  `json:"tgId" form:"tgId"`             // Telegram user ID for notifications
-	SubID      string `json:"subId" form:"subId"`           // Subscription identifier
-	Comment    string `json:"comment" form:"comment"`       // Client comment
-	Reset      int    `json:"reset" form:"reset"`           // Reset period in days
-	CreatedAt  int64  `json:"created_at,omitempty"`         // Creation timestamp
-	UpdatedAt  int64  `json:"updated_at,omitempty"`         // Last update timestamp
+	RecordId   int    `json:"-" gorm:"primaryKey;autoIncrement"`                // Row identifier
+	InboundId  int    `json:"-" gorm:"index;not null"`                          // Inbound the client belongs to
+	ID         string `json:"id" gorm:"column:uuid;index"`                      // Unique client identifier
+	Security   string `json:"security"`                                         // Security method (e.g., "auto", "aes-128-gcm")
+	Password   string `json:"password" gorm:"index"`                            // Client password
+	Flow       string `json:"flow"`                                             // Flow control (XTLS)
+	Method     string `json:"method,omitempty"`                                 // Shadowsocks cipher of the client
+	Email      string `json:"email" gorm:"index;not null"`                      // Client email identifier
+	LimitIP    int    `json:"limitIp"`                                          // IP limit for this client
+	TotalGB    int64  `json:"totalGB" form:"totalGB"`                           // Total traffic limit in GB
+	ExpiryTime int64  `json:"expiryTime" form:"expiryTime"`                     // Expiration timestamp
+	Enable     bool   `json:"enable" form:"enable"`                             // Whether the client is enabled
+	TgID       int64  `json:"tgId" form:"tgId" gorm:"index"`                    // Telegram user ID for notifications
+	SubID      string `json:"subId" form:"subId" gorm:"index"`                  // Subscription identifier
+	Comment    string `json:"comment" form:"comment"`                           // Client comment
+	Reset      int    `json:"reset" form:"reset"`                               // Reset period in days
+	CreatedAt  int64  `json:"created_at,omitempty" gorm:"autoCreateTime:milli"` // Creation timestamp
+	UpdatedAt  int64  `json:"updated_at,omitempty" gorm:"autoUpdateTime:false"` // Last update timestamp
+
+	// Throughput caps in kbit/s. Zero uses the default of the inbound, -1 lifts it.
+	SpeedLimitUp   int64 `json:"speedLimitUp,omitempty" form:"speedLimitUp"`
+	SpeedLimitDown int64 `json:"speedLimitDown,omitempty" form:"speedLimitDown"`
+
+	PlanId int `json:"planId,omitempty" form:"planId" gorm:"index"` // Plan the client subscribes to, 0 for none
+
+	// Times the client may connect at, nil for any time
+	AccessSchedule *AccessSchedule `json:"accessSchedule,omitempty" gorm:"serializer:json;type:text"`
+
+	// Fields of the client the panel does not know, such as ones set by hand or by other
+	// tools, kept so they survive the trip through the table
+	Extra map[string]json.RawMessage `json:"-" gorm:"serializer:json;type:text"`
+
+	Inbound *Inbound          `json:"-" gorm:"constraint:OnDelete:CASCADE"`                                          // Owning inbound, only used for the foreign key
+	Traffic *ClientTrafficKey `json:"-" gorm:"foreignKey:Email;references:TrafficEmail;constraint:OnUpdate:CASCADE"` // Traffic row, only used for the foreign key
+}
+
+// ClientTrafficKey is the email column of the client_traffics table that the traffic
+// foreign key of Client references. The xray package can not refer to clients, so the
+// key of the table is declared here; xray.ClientTraffic maps the whole row.
+type ClientTrafficKey struct {
+	TrafficEmail string `gorm:"column:email;unique"`
+}
+
+// TableName returns the table of xray.ClientTraffic.
+func (ClientTrafficKey) TableName() string {
+	return "client_traffics"
+}
+
+// clientFields is Client without its JSON methods, encoded with the default rules.
+type clientFields Client
+
+// clientKeys holds the lower-cased JSON keys of the fields of Client.
+var clientKeys = func() map[string]bool {
+	keys := map[string]bool{}
+	t := reflect.TypeOf(clientFields{})
+	for i := 0; i < t.NumField(); i++ {
+		name, _, _ := strings.Cut(t.Field(i).Tag.Get("json"), ",")
+		if name == "-" {
+			continue
+		}
+		if name == "" {
+			name = t.Field(i).Name
+		}
+		keys[strings.ToLower(name)] = true
+	}
+	return keys
+}()
+
+// MarshalJSON encodes the client with its unknown fields.
+func (c Client) MarshalJSON() ([]byte, error) {
+	data, err := json.Marshal(clientFields(c))
+	if err != nil || len(c.Extra) == 0 {
+		return data, err
+	}
+	fields := map[string]json.RawMessage{}
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return nil, err
+	}
+	for key, value := range c.Extra {
+		fields[key] = value
+	}
+	return json.Marshal(fields)
+}
+
+// UnmarshalJSON decodes the client and keeps the fields it does not know in Extra.
+// Fields of the wrong type, such as a tgId sent as "", are left empty instead of
+// failing the whole list of clients the client is part of.
+func (c *Client) UnmarshalJSON(data []byte) error {
+	fields := map[string]json.RawMessage{}
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return err
+	}
+	decoded := clientFields{}
+	var typeErr *json.UnmarshalTypeError
+	if err := json.Unmarshal(data, &decoded); err != nil && !errors.As(err, &typeErr) {
+		return err
+	}
+	decoded.Extra = nil
+	for key, value := range fields {
+		if clientKeys[strings.ToLower(key)] {
+			continue
+		}
+		if decoded.Extra == nil {
+			decoded.Extra = map[string]json.RawMessage{}
+		}
+		decoded.Extra[key] = value
+	}
+	*c = Client(decoded)
+	return nil
+}
+
+// AccessSchedule limits the times a client may connect at to a set of weekly windows
+// in the time zone of the client.
+type AccessSchedule struct {
+	TimeZone string         `json:"timeZone"` // IANA time zone name, empty for the zone of the server
+	Windows  []AccessWindow `json:"windows"`
+}
+
+// AccessWindow is a daily time range on some weekdays. An end before the start runs
+// past midnight into the next day.
+type AccessWindow struct {
+	Days  []int  `json:"days"`  // Weekdays the window starts on, 0 for Sunday
+	Start string `json:"start"` // Start time as HH:MM
+	End   string `json:"end"`   // End time as HH:MM, up to 24:00
+}
+
+// ClientGroup is a named set of clients, such as a customer or a plan, that bulk
+// actions can target. A client may belong to any number of groups.
+type ClientGroup struct {
+	Id        int    `json:"id" form:"id" gorm:"primaryKey;autoIncrement"`
+	Name      string `json:"name" form:"name" gorm:"uniqueIndex;not null"` // Unique group name
+	Comment   string `json:"comment" form:"comment"`                       // Free-text description
+	CreatedAt int64  `json:"createdAt" gorm:"autoCreateTime:milli"`        // Creation timestamp
+}
+
+// ClientGroupMember puts a client into a group. Members follow the client across
+// email changes and moves between inbounds and go away with the client.
+type ClientGroupMember struct {
+	GroupId  int `json:"groupId" gorm:"primaryKey"`
+	ClientId int `json:"clientId" gorm:"primaryKey;index"` // Client.RecordId
+
+	Group  *ClientGroup `json:"-" gorm:"constraint:OnDelete:CASCADE"`                                         // Only used for the foreign key
+	Client *Client      `json:"-" gorm:"foreignKey:ClientId;references:RecordId;constraint:OnDelete:CASCADE"` // Only used for the foreign key
+}
+
+// SubAccount pools the traffic quota and expiry of all clients that share a
+// subscription id, whatever inbound they are on. Their traffic counts against TotalGB
+// together and all of them are disabled once the pool is used up or expires. The
+// limits of each client still apply on their own.
+type SubAccount struct {
+	Id         int    `json:"id" form:"id" gorm:"primaryKey;autoIncrement"`
+	SubId      string `json:"subId" form:"subId" gorm:"uniqueIndex;not null"` // Subscription id of the linked clients
+	TotalGB    int64  `json:"totalGB" form:"totalGB"`                         // Shared traffic limit in bytes, 0 for unlimited
+	ExpiryTime int64  `json:"expiryTime" form:"expiryTime"`                   // Shared expiry in milliseconds, 0 for none
+	Comment    string `json:"comment" form:"comment"`                         // Free-text description
+	CreatedAt  int64  `json:"createdAt" gorm:"autoCreateTime:milli"`          // Creation timestamp
+}
+
+// Plan traffic modes, how a renewal treats the traffic of a client
+const (
+	PlanTrafficReset = "reset" // Reset the used traffic and set the quota as the limit
+	PlanTrafficAdd   = "add"   // Keep the used traffic and add the quota to the limit
+)
+
+// Plan is a service package clients subscribe to. Renewing a client applies the
+// limits of its plan at once.
+type Plan struct {
+	Id          int    `json:"id" form:"id" gorm:"primaryKey;autoIncrement"`
+	Name        string `json:"name" form:"name" gorm:"uniqueIndex;not null"` // Unique plan name
+	TotalGB     int64  `json:"totalGB" form:"totalGB"`                       // Traffic quota in bytes, 0 for unlimited
+	Days        int    `json:"days" form:"days"`                             // Duration added by a renewal, 0 keeps the expiry
+	LimitIP     int    `json:"limitIp" form:"limitIp"`                       // IP limit of the clients
+	Reset       int    `json:"reset" form:"reset"`                           // Traffic reset cycle of the clients in days
+	TrafficMode string `json:"trafficMode" form:"trafficMode"`               // PlanTrafficReset or PlanTrafficAdd
+	InboundIds  string `json:"inboundIds" form:"inboundIds"`                 // Comma-separated inbounds the plan is offered on, empty for all
+	Comment     string `json:"comment" form:"comment"`                       // Free-text description
+	CreatedAt   int64  `json:"createdAt" gorm:"autoCreateTime:milli"`        // Creation timestamp
+}
+
+// InboundIdList returns the inbounds the plan is offered on, none for all.
+func (p *Plan) InboundIdList() []int {
+	var ids []int
+	for _, field := range strings.Split(p.InboundIds, ",") {
+		if id, err := strconv.Atoi(strings.TrimSpace(field)); err == nil {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+// Allows reports whether clients of inbound may subscribe to the plan.
+func (p *Plan) Allows(inboundId int) bool {
+	ids := p.InboundIdList()
+	return len(ids) == 0 || slices.Contains(ids, inboundId)
+}
+
+// ResetsTraffic reports whether renewing the plan resets the used traffic of the client.
+func (p *Plan) ResetsTraffic() bool {
+	return p.TrafficMode != PlanTrafficAdd
+}
+
+// ClientRenewal records a renewal of a client with a plan and the limits it changed.
+type ClientRenewal struct {
+	Id           int    `json:"id" gorm:"primaryKey;autoIncrement"`
+	ClientEmail  string `json:"clientEmail" gorm:"index"`
+	PlanId       int    `json:"planId" gorm:"index"`
+	PlanName     string `json:"planName"`     // Name of the plan at the renewal
+	ExpiryBefore int64  `json:"expiryBefore"` // Expiry of the client before the renewal
+	ExpiryAfter  int64  `json:"expiryAfter"`
+	TotalBefore  int64  `json:"totalBefore"` // Traffic limit of the client before the renewal
+	TotalAfter   int64  `json:"totalAfter"`
+	UsedBefore   int64  `json:"usedBefore"`                            // Traffic the client had used
+	CreatedAt    int64  `json:"createdAt" gorm:"autoCreateTime:milli"` // Renewal timestamp
 }
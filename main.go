@@ -248,7 +248,8 @@ func updateTgbotSetting(tgBotToken string, tgBotChatid string, tgBotRuntime stri
 }
 
 // updateSetting updates various panel settings including port, credentials, base path, listen IP, and two-factor authentication.
-func updateSetting(port int, username string, password string, webBasePath string, listenIP string, resetTwoFactor bool) {
+// With resetTwoFactor set, two-factor is reset for twoFactorUser, or for every panel user when it is empty.
+func updateSetting(port int, username string, password string, webBasePath string, listenIP string, resetTwoFactor bool, twoFactorUser string) {
 	err := database.InitDB(config.GetDBPath())
 	if err != nil {
 		fmt.Println("Database initialization failed:", err)
@@ -286,13 +287,14 @@ func updateSetting(port int, username string, password string, webBasePath strin
 	}
 
 	if resetTwoFactor {
-		err := settingService.SetTwoFactorEnable(false)
+		err := userService.ResetTwoFactorByUsername(twoFactorUser)
 
 		if err != nil {
 			fmt.Println("Failed to reset two-factor authentication:", err)
+		} else if twoFactorUser != "" {
+			fmt.Printf("Two-factor authentication reset successfully for user: %v\n", twoFactorUser)
 		} else {
-			settingService.SetTwoFactorToken("")
-			fmt.Println("Two-factor authentication reset successfully")
+			fmt.Println("Two-factor authentication reset successfully for all users")
 		}
 	}
 
@@ -424,6 +426,7 @@ func main() {
 	var show bool
 	var getCert bool
 	var resetTwoFactor bool
+	var twoFactorUser string
 	settingCmd.BoolVar(&reset, "reset", false, "Reset all settings")
 	settingCmd.BoolVar(&show, "show", false, "Display current settings")
 	settingCmd.IntVar(&port, "port", 0, "Set panel port number")
@@ -431,7 +434,8 @@ func main() {
 	settingCmd.StringVar(&password, "password", "", "Set login password")
 	settingCmd.StringVar(&webBasePath, "webBasePath", "", "Set base path for Panel")
 	settingCmd.StringVar(&listenIP, "listenIP", "", "set panel listenIP IP")
-	settingCmd.BoolVar(&resetTwoFactor, "resetTwoFactor", false, "Reset two-factor authentication settings")
+	settingCmd.BoolVar(&resetTwoFactor, "resetTwoFactor", false, "Reset two-factor authentication and recovery codes")
+	settingCmd.StringVar(&twoFactorUser, "twoFactorUser", "", "Limit -resetTwoFactor to this username (default: all users)")
 	settingCmd.BoolVar(&getListen, "getListen", false, "Display current panel listenIP IP")
 	settingCmd.BoolVar(&getCert, "getCert", false, "Display current certificate settings")
 	settingCmd.StringVar(&webCertFile, "webCert", "", "Set path to public key file for panel")
@@ -476,7 +480,7 @@ func main() {
 		if reset {
 			resetSetting()
 		} else {
-			updateSetting(port, username, password, webBasePath, listenIP, resetTwoFactor)
+			updateSetting(port, username, password, webBasePath, listenIP, resetTwoFactor, twoFactorUser)
 		}
 		if show {
 			showSetting(show)
@@ -136,6 +136,8 @@ func (s *SubJsonService) GetJson(subId string, host string) (string, string, err
 		}
 	}
 
+	s.SubService.applySubAccount(subId, &traffic)
+
 	// Combile outbounds
 	var finalJson []byte
 	if len(configArray) == 1 {
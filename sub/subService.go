@@ -22,12 +22,13 @@ import (
 
 // SubService provides business logic for generating subscription links and managing subscription data.
 type SubService struct {
-	address        string
-	showInfo       bool
-	remarkModel    string
-	datepicker     string
-	inboundService service.InboundService
-	settingService service.SettingService
+	address           string
+	showInfo          bool
+	remarkModel       string
+	datepicker        string
+	inboundService    service.InboundService
+	settingService    service.SettingService
+	subAccountService service.SubAccountService
 }
 
 // NewSubService creates a new subscription service with the given configuration.
@@ -109,25 +110,40 @@ func (s *SubService) GetSubs(subId string, host string) ([]string, int64, xray.C
 			}
 		}
 	}
+	s.applySubAccount(subId, &traffic)
 	return result, lastOnline, traffic, nil
 }
 
+// applySubAccount replaces the statistics in traffic with the pooled ones when subId
+// has a subscription account, counting the traffic of all of its clients.
+func (s *SubService) applySubAccount(subId string, traffic *xray.ClientTraffic) {
+	account, err := s.subAccountService.GetSubAccount(subId)
+	if err != nil {
+		logger.Warning("SubService - GetSubAccount:", err)
+		return
+	}
+	if account == nil {
+		return
+	}
+	traffic.Up = account.Up
+	traffic.Down = account.Down
+	traffic.Total = account.TotalGB
+	traffic.ExpiryTime = account.ExpiryTime
+}
+
 func (s *SubService) getInboundsBySubId(subId string) ([]*model.Inbound, error) {
 	db := database.GetDB()
 	var inbounds []*model.Inbound
 
-	err := db.Model(model.Inbound{}).Preload("ClientStats").Where(`id in (
-		SELECT DISTINCT inbounds.id
-		FROM inbounds,
-			jsonb_array_elements(inbounds.settings::jsonb->'clients') AS client 
-		WHERE
-			protocol in ('vmess','vless','trojan','shadowsocks')
-			AND client->>'subId' = ? AND enable = ?
-	)`, subId, true).Find(&inbounds).Error
-
+	err := db.Model(model.Inbound{}).Preload("ClientStats").
+		Where("id IN (?) AND enable = ?", db.Model(model.Client{}).Select("inbound_id").Where("sub_id = ?", subId), true).
+		Find(&inbounds).Error
 	if err != nil {
 		return nil, err
 	}
+	if err := s.inboundService.LoadClients(inbounds...); err != nil {
+		return nil, err
+	}
 	return inbounds, nil
 }
 
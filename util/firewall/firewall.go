@@ -0,0 +1,8 @@
+// Package firewall blocks client addresses from inbound ports with an nftables table of
+// the panel. The blocks are kept in sets with a timeout, so nftables lifts each by itself.
+package firewall
+
+import "errors"
+
+// ErrUnsupported is returned when addresses are blocked on a system without nftables.
+var ErrUnsupported = errors.New("blocking addresses is only supported on Linux")
@@ -0,0 +1,148 @@
+//go:build linux
+// +build linux
+
+package firewall
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+)
+
+// table is the nftables table of the panel. It drops input from the addresses in its
+// sets to the port each is blocked on, before the filter hooks of other tables accept
+// it, so that other services and inbounds stay reachable from them.
+const table = `table inet x_ui {
+	set ip_limit4 {
+		type ipv4_addr . inet_service
+		flags timeout
+	}
+	set ip_limit6 {
+		type ipv6_addr . inet_service
+		flags timeout
+	}
+	chain input {
+		type filter hook input priority filter - 10; policy accept;
+		ip saddr . th dport @ip_limit4 drop
+		ip6 saddr . th dport @ip_limit6 drop
+	}
+}
+`
+
+// tableType is in the sets of the current table, and missing from tables of earlier
+// versions of the panel that blocked whole addresses.
+const tableType = "ipv4_addr . inet_service"
+
+var (
+	mu    sync.Mutex
+	ready bool // The table is in place
+)
+
+// Block drops the traffic from ips to port for d. Addresses that are already blocked
+// on port keep their timeout.
+func Block(ips []string, port int, d time.Duration) error {
+	if port <= 0 || port > 65535 {
+		return fmt.Errorf("invalid port to block addresses on: %d", port)
+	}
+	v4, v6 := split(ips)
+	if len(v4) == 0 && len(v6) == 0 {
+		return nil
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if err := ensureTable(); err != nil {
+		return err
+	}
+	if err := nft(blockScript(v4, v6, port, d)); err != nil {
+		// The table may have been flushed by another firewall tool
+		ready = false
+		return err
+	}
+	return nil
+}
+
+// Unblock lifts the block of ips on port before it times out.
+func Unblock(ips []string, port int) error {
+	v4, v6 := split(ips)
+	mu.Lock()
+	defer mu.Unlock()
+	if _, err := exec.LookPath("nft"); err != nil {
+		return nil
+	}
+	// Deleting an element that timed out fails, so each address goes on its own
+	for set, addrs := range map[string][]string{"ip_limit4": v4, "ip_limit6": v6} {
+		for _, addr := range addrs {
+			nft(fmt.Sprintf("delete element inet x_ui %s { %s . %d }\n", set, addr, port))
+		}
+	}
+	return nil
+}
+
+// ensureTable creates the table unless it exists, keeping the blocks of a previous
+// run of the panel. A table of an earlier version is replaced.
+func ensureTable() error {
+	if ready {
+		return nil
+	}
+	if _, err := exec.LookPath("nft"); err != nil {
+		return errors.New("nft is not installed, install nftables to block addresses")
+	}
+	out, err := exec.Command("nft", "list", "table", "inet", "x_ui").Output()
+	switch {
+	case err != nil:
+		err = nft(table)
+	case !strings.Contains(string(out), tableType):
+		err = nft("delete table inet x_ui\n" + table)
+	}
+	if err != nil {
+		return err
+	}
+	ready = true
+	return nil
+}
+
+// blockScript returns the nftables script that blocks v4 and v6 on port for d.
+func blockScript(v4, v6 []string, port int, d time.Duration) string {
+	timeout := fmt.Sprintf(" . %d timeout %ds", port, max(int(d.Seconds()), 1))
+	var script strings.Builder
+	for _, set := range []struct {
+		name  string
+		addrs []string
+	}{{"ip_limit4", v4}, {"ip_limit6", v6}} {
+		if len(set.addrs) > 0 {
+			fmt.Fprintf(&script, "add element inet x_ui %s { %s }\n", set.name, strings.Join(set.addrs, timeout+", ")+timeout)
+		}
+	}
+	return script.String()
+}
+
+// split returns the valid IPv4 and IPv6 addresses among ips.
+func split(ips []string) (v4 []string, v6 []string) {
+	for _, ip := range ips {
+		parsed := net.ParseIP(ip)
+		switch {
+		case parsed == nil:
+		case parsed.To4() != nil:
+			v4 = append(v4, parsed.To4().String())
+		default:
+			v6 = append(v6, parsed.String())
+		}
+	}
+	return v4, v6
+}
+
+// nft runs an nftables script.
+func nft(script string) error {
+	cmd := exec.Command("nft", "-f", "-")
+	cmd.Stdin = strings.NewReader(script)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("nft: %v: %s", err, bytes.TrimSpace(out))
+	}
+	return nil
+}
@@ -0,0 +1,83 @@
+//go:build linux
+// +build linux
+
+package firewall
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBlockScript(t *testing.T) {
+	tests := []struct {
+		name   string
+		v4     []string
+		v6     []string
+		d      time.Duration
+		script string
+	}{
+		{
+			name:   "one address",
+			v4:     []string{"10.0.0.1"},
+			d:      time.Minute,
+			script: "add element inet x_ui ip_limit4 { 10.0.0.1 . 443 timeout 60s }\n",
+		},
+		{
+			name: "both families",
+			v4:   []string{"10.0.0.1", "10.0.0.2"},
+			v6:   []string{"2001:db8::1"},
+			d:    90 * time.Second,
+			script: "add element inet x_ui ip_limit4 { 10.0.0.1 . 443 timeout 90s, 10.0.0.2 . 443 timeout 90s }\n" +
+				"add element inet x_ui ip_limit6 { 2001:db8::1 . 443 timeout 90s }\n",
+		},
+		{
+			name:   "timeout of at least a second",
+			v6:     []string{"2001:db8::1"},
+			d:      time.Millisecond,
+			script: "add element inet x_ui ip_limit6 { 2001:db8::1 . 443 timeout 1s }\n",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert.Equal(t, test.script, blockScript(test.v4, test.v6, 443, test.d))
+		})
+	}
+}
+
+func TestTableDropsOnlyThePort(t *testing.T) {
+	assert.Contains(t, table, "type "+tableType)
+	assert.Contains(t, table, "ip saddr . th dport @ip_limit4 drop")
+	assert.Contains(t, table, "ip6 saddr . th dport @ip_limit6 drop")
+	assert.NotContains(t, table, "ip saddr @ip_limit4")
+}
+
+func TestSplit(t *testing.T) {
+	tests := []struct {
+		name string
+		ips  []string
+		v4   []string
+		v6   []string
+	}{
+		{"empty", nil, nil, nil},
+		{"ipv4", []string{"10.0.0.1"}, []string{"10.0.0.1"}, nil},
+		{"ipv6 normalized", []string{"2001:DB8:0:0::1"}, nil, []string{"2001:db8::1"}},
+		{"ipv4 mapped ipv6", []string{"::ffff:10.0.0.1"}, []string{"10.0.0.1"}, nil},
+		{"invalid skipped", []string{"", "bogus", "10.0.0.256", "10.0.0.1:443", "10.0.0.2"}, []string{"10.0.0.2"}, nil},
+		{"mixed", []string{"2001:db8::1", "10.0.0.1"}, []string{"10.0.0.1"}, []string{"2001:db8::1"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			v4, v6 := split(test.ips)
+			assert.Equal(t, test.v4, v4)
+			assert.Equal(t, test.v6, v6)
+		})
+	}
+}
+
+func TestBlockRejectsInvalidPort(t *testing.T) {
+	for _, port := range []int{0, -1, 65536} {
+		assert.Error(t, Block([]string{"10.0.0.1"}, port, time.Minute), port)
+	}
+}
@@ -0,0 +1,19 @@
+//go:build !linux
+// +build !linux
+
+package firewall
+
+import "time"
+
+// Block drops the traffic from ips to port for d.
+func Block(ips []string, port int, d time.Duration) error {
+	if len(ips) > 0 {
+		return ErrUnsupported
+	}
+	return nil
+}
+
+// Unblock lifts the block of ips on port before it times out.
+func Unblock(ips []string, port int) error {
+	return nil
+}
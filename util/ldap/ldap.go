@@ -16,6 +16,7 @@ type Config struct {
 	BaseDN     string
 	UserFilter string
 	UserAttr   string
+	GroupAttr  string // Attribute listing the groups of a user, e.g. memberOf
 	FlagField  string
 	TruthyVals []string
 	Invert     bool
@@ -97,8 +98,19 @@ func FetchVlessFlags(cfg Config) (map[string]bool, error) {
 	return result, nil
 }
 
+// Identity is the directory entry of a user authenticated by AuthenticateUser.
+type Identity struct {
+	DN       string
+	Username string   // Value of cfg.UserAttr as stored in the directory
+	Groups   []string // Names of the groups listed in cfg.GroupAttr
+}
+
 // AuthenticateUser searches user by cfg.UserAttr and attempts to bind with provided password.
-func AuthenticateUser(cfg Config, username, password string) (bool, error) {
+// It returns nil without error when the user does not exist or the password is wrong.
+func AuthenticateUser(cfg Config, username, password string) (*Identity, error) {
+	if password == "" {
+		return nil, nil
+	}
 	addr := fmt.Sprintf("%s:%d", cfg.Host, cfg.Port)
 
 	scheme := "ldap"
@@ -117,14 +129,14 @@ func AuthenticateUser(cfg Config, username, password string) (bool, error) {
 
 	conn, err := ldap.DialURL(ldapURL, opts...)
 	if err != nil {
-		return false, err
+		return nil, err
 	}
 	defer conn.Close()
 
 	// Optional initial bind for search
 	if cfg.BindDN != "" {
 		if err := conn.Bind(cfg.BindDN, cfg.Password); err != nil {
-			return false, err
+			return nil, err
 		}
 	}
 
@@ -134,6 +146,10 @@ func AuthenticateUser(cfg Config, username, password string) (bool, error) {
 	if cfg.UserAttr == "" {
 		cfg.UserAttr = "uid"
 	}
+	attributes := []string{cfg.UserAttr}
+	if cfg.GroupAttr != "" {
+		attributes = append(attributes, cfg.GroupAttr)
+	}
 
 	// Build filter to find specific user
 	filter := fmt.Sprintf("(&%s(%s=%s))", cfg.UserFilter, cfg.UserAttr, ldap.EscapeFilter(username))
@@ -141,20 +157,40 @@ func AuthenticateUser(cfg Config, username, password string) (bool, error) {
 		cfg.BaseDN,
 		ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 1, 0, false,
 		filter,
-		[]string{"dn"},
+		attributes,
 		nil,
 	)
 	res, err := conn.Search(req)
 	if err != nil {
-		return false, err
+		return nil, err
 	}
 	if len(res.Entries) == 0 {
-		return false, nil
+		return nil, nil
 	}
-	userDN := res.Entries[0].DN
+	entry := res.Entries[0]
 	// Try to bind as the user
-	if err := conn.Bind(userDN, password); err != nil {
-		return false, nil
+	if err := conn.Bind(entry.DN, password); err != nil {
+		return nil, nil
+	}
+
+	identity := &Identity{DN: entry.DN, Username: entry.GetAttributeValue(cfg.UserAttr)}
+	if identity.Username == "" {
+		identity.Username = username
+	}
+	if cfg.GroupAttr != "" {
+		for _, value := range entry.GetAttributeValues(cfg.GroupAttr) {
+			identity.Groups = append(identity.Groups, groupName(value))
+		}
+	}
+	return identity, nil
+}
+
+// groupName returns the value of the first RDN when value is a DN such as
+// "cn=admins,ou=groups,dc=example,dc=com", and value itself otherwise.
+func groupName(value string) string {
+	dn, err := ldap.ParseDN(value)
+	if err != nil || len(dn.RDNs) == 0 || len(dn.RDNs[0].Attributes) == 0 {
+		return value
 	}
-	return true, nil
+	return dn.RDNs[0].Attributes[0].Value
 }
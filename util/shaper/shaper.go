@@ -0,0 +1,18 @@
+// Package shaper caps the throughput of client addresses with Linux traffic control.
+// Download limits shape the egress of the default network interface by destination
+// address; upload limits shape its ingress, redirected to an IFB device, by source address.
+package shaper
+
+import "errors"
+
+// ErrUnsupported is returned when limits are requested on a system without tc.
+var ErrUnsupported = errors.New("speed limits are only supported on Linux")
+
+// Limit caps the traffic of the addresses a client connects from, in kbit/s.
+// Zero leaves a direction uncapped. Clients behind the same address share its cap.
+type Limit struct {
+	Name string   // Client email, for logs
+	IPs  []string // Addresses the client connects from
+	Up   int64    // Upload cap, client to server
+	Down int64    // Download cap, server to client
+}
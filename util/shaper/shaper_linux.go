@@ -0,0 +1,332 @@
+//go:build linux
+// +build linux
+
+package shaper
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// ifbDevice receives the ingress of the default interface for upload shaping.
+const ifbDevice = "ifb-xui"
+
+// maxClasses is the number of client classes the filter priorities leave room for,
+// as every class has one priority for IPv4 and one for IPv6 filters.
+const maxClasses = 0x7ff0
+
+// leafQdiscs are the classless qdiscs that can shape the default class in place of
+// the root qdisc they were, and be restored as root when the shaping is removed.
+var leafQdiscs = []string{"fq", "fq_codel", "fq_pie", "cake", "sfq", "codel", "pie", "pfifo_fast", "pfifo", "bfifo"}
+
+// shaped is the shaping in place for a client.
+type shaped struct {
+	class int
+	limit Limit
+}
+
+var (
+	mu       sync.Mutex
+	device   string            // Interface the shaping is installed on
+	rootKind string            // Root qdisc of device before the shaping
+	classes  map[string]shaped // Shaping in place by client name
+)
+
+// Apply replaces the shaping with limits. The qdiscs are installed once; later calls
+// only change the classes and filters of the clients whose limits changed.
+func Apply(limits []Limit) error {
+	mu.Lock()
+	defer mu.Unlock()
+
+	if len(limits) == 0 {
+		removeShaping()
+		return nil
+	}
+	if len(limits) > maxClasses {
+		return fmt.Errorf("too many clients with speed limits: %d", len(limits))
+	}
+	if _, err := exec.LookPath("tc"); err != nil {
+		return errors.New("tc is not installed, install iproute2 to enforce speed limits")
+	}
+	dev, err := defaultDevice()
+	if err != nil {
+		return err
+	}
+	if dev != device {
+		removeShaping()
+		if err := install(dev); err != nil {
+			removeShaping()
+			return err
+		}
+	}
+
+	batch, next := buildBatch(dev, classes, limits)
+	if batch == "" {
+		return nil
+	}
+	if err := runBatch(batch); err != nil {
+		// The classes in place are unknown now, start over on the next call
+		removeShaping()
+		return err
+	}
+	classes = next
+	return nil
+}
+
+// Clear removes all shaping.
+func Clear() error {
+	mu.Lock()
+	defer mu.Unlock()
+	removeShaping()
+	return nil
+}
+
+// install sets up the qdiscs of the shaping on dev and the IFB device, without classes.
+// Shaping left on dev by a previous run of the panel is dropped first.
+func install(dev string) error {
+	kind, handle := parseRootQdisc(output("tc", "qdisc", "show", "dev", dev, "root"))
+	if kind == "htb" && handle == "1:" {
+		removeQdiscs(dev)
+		kind, _ = parseRootQdisc(output("tc", "qdisc", "show", "dev", dev, "root"))
+	}
+	device = dev
+	rootKind = kind
+	classes = make(map[string]shaped)
+
+	run("ip", "link", "del", ifbDevice)
+	if err := run("ip", "link", "add", ifbDevice, "type", "ifb"); err != nil {
+		return err
+	}
+	if err := run("ip", "link", "set", ifbDevice, "up"); err != nil {
+		return err
+	}
+	return runBatch(baseBatch(dev, leafQdisc(kind)))
+}
+
+// removeShaping removes the shaping in place and restores the root qdisc it replaced.
+// Errors are ignored, as parts of the shaping may not exist.
+func removeShaping() {
+	if device != "" {
+		removeQdiscs(device)
+		if slices.Contains(leafQdiscs, rootKind) {
+			run("tc", "qdisc", "replace", "dev", device, "root", rootKind)
+		}
+	}
+	device = ""
+	rootKind = ""
+	classes = nil
+}
+
+// removeQdiscs removes the qdiscs of the shaping on dev and the IFB device. Deleting
+// the root qdisc puts back the default one of the system.
+func removeQdiscs(dev string) {
+	run("tc", "qdisc", "del", "dev", dev, "root")
+	run("tc", "qdisc", "del", "dev", dev, "ingress")
+	run("ip", "link", "del", ifbDevice)
+}
+
+// leafQdisc returns the qdisc for the default class that keeps the queueing of a root
+// qdisc of kind for unshaped traffic, or "" to keep the HTB default.
+func leafQdisc(kind string) string {
+	if slices.Contains(leafQdiscs, kind) {
+		return kind
+	}
+	// Multiqueue and virtual interfaces run the system default qdisc under their root
+	data, err := os.ReadFile("/proc/sys/net/core/default_qdisc")
+	if err != nil {
+		return ""
+	}
+	if kind = strings.TrimSpace(string(data)); slices.Contains(leafQdiscs, kind) {
+		return kind
+	}
+	return ""
+}
+
+// parseRootQdisc returns the kind and handle of the root qdisc in the output of
+// tc qdisc show, or empty strings when there is none.
+func parseRootQdisc(out string) (kind, handle string) {
+	for _, line := range strings.Split(out, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) > 3 && fields[0] == "qdisc" && fields[3] == "root" {
+			return fields[1], fields[2]
+		}
+	}
+	return "", ""
+}
+
+// baseBatch returns the tc commands that install the qdiscs of the shaping on dev and
+// the IFB device. Unmatched traffic goes to an uncapped default class queued by leaf.
+func baseBatch(dev, leaf string) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "qdisc add dev %s root handle 1: htb default ffff\n", dev)
+	fmt.Fprintf(&b, "class add dev %s parent 1: classid 1:ffff htb rate 10gbit\n", dev)
+	if leaf != "" {
+		fmt.Fprintf(&b, "qdisc add dev %s parent 1:ffff %s\n", dev, leaf)
+	}
+	fmt.Fprintf(&b, "qdisc add dev %s handle ffff: ingress\n", dev)
+	fmt.Fprintf(&b, "filter add dev %s parent ffff: protocol all prio 1 u32 match u32 0 0 action mirred egress redirect dev %s\n", dev, ifbDevice)
+	fmt.Fprintf(&b, "qdisc add dev %s root handle 1: htb default ffff\n", ifbDevice)
+	fmt.Fprintf(&b, "class add dev %s parent 1: classid 1:ffff htb rate 10gbit\n", ifbDevice)
+	return b.String()
+}
+
+// buildBatch returns the tc commands that change the shaping in place on dev from
+// installed to limits, and the shaping in place after them. Every client keeps its HTB
+// class while it has a limit; a class is capped per direction, with the filters of
+// each address family at their own priority so that they can be replaced together.
+func buildBatch(dev string, installed map[string]shaped, limits []Limit) (string, map[string]shaped) {
+	next := make(map[string]shaped, len(limits))
+	used := make(map[int]bool, len(installed))
+	for _, limit := range limits {
+		if old, ok := installed[limit.Name]; ok {
+			used[old.class] = true
+		}
+	}
+
+	var b strings.Builder
+	removed := make([]shaped, 0)
+	for _, old := range installed {
+		if !used[old.class] {
+			removed = append(removed, old)
+		}
+	}
+	sort.Slice(removed, func(i, j int) bool { return removed[i].class < removed[j].class })
+	for _, old := range removed {
+		shapeClass(&b, dev, old.class, "dst", old.limit.Down, old.limit.IPs, 0, nil)
+		shapeClass(&b, ifbDevice, old.class, "src", old.limit.Up, old.limit.IPs, 0, nil)
+	}
+
+	free := 2
+	for _, limit := range limits {
+		old, ok := installed[limit.Name]
+		if !ok {
+			for used[free] {
+				free++
+			}
+			used[free] = true
+			old = shaped{class: free}
+		}
+		shapeClass(&b, dev, old.class, "dst", old.limit.Down, old.limit.IPs, limit.Down, limit.IPs)
+		shapeClass(&b, ifbDevice, old.class, "src", old.limit.Up, old.limit.IPs, limit.Up, limit.IPs)
+		next[limit.Name] = shaped{class: old.class, limit: limit}
+	}
+	return b.String(), next
+}
+
+// shapeClass writes the tc commands that change class on dev from a cap of oldRate
+// kbit/s on oldIPs to one of rate on ips, matched by their direction field. A rate
+// of zero leaves the direction uncapped, without a class.
+func shapeClass(b *strings.Builder, dev string, class int, direction string, oldRate int64, oldIPs []string, rate int64, ips []string) {
+	var oldV4, oldV6, v4, v6 []string
+	if oldRate > 0 {
+		oldV4, oldV6 = splitIPs(oldIPs)
+	}
+	if rate > 0 {
+		v4, v6 = splitIPs(ips)
+	}
+	switch {
+	case oldRate == 0 && rate == 0:
+		return
+	case rate == 0:
+		deleteFilters(b, dev, "ip", 2*class, oldV4)
+		deleteFilters(b, dev, "ipv6", 2*class+1, oldV6)
+		fmt.Fprintf(b, "class del dev %s parent 1: classid 1:%x\n", dev, class)
+		return
+	case oldRate == 0:
+		fmt.Fprintf(b, "class add dev %s parent 1: classid 1:%x htb rate %dkbit ceil %dkbit\n", dev, class, rate, rate)
+	case oldRate != rate:
+		fmt.Fprintf(b, "class change dev %s parent 1: classid 1:%x htb rate %dkbit ceil %dkbit\n", dev, class, rate, rate)
+	}
+	if !slices.Equal(oldV4, v4) {
+		deleteFilters(b, dev, "ip", 2*class, oldV4)
+		for _, ip := range v4 {
+			fmt.Fprintf(b, "filter add dev %s parent 1: protocol ip prio %d u32 match ip %s %s/32 flowid 1:%x\n", dev, 2*class, direction, ip, class)
+		}
+	}
+	if !slices.Equal(oldV6, v6) {
+		deleteFilters(b, dev, "ipv6", 2*class+1, oldV6)
+		for _, ip := range v6 {
+			fmt.Fprintf(b, "filter add dev %s parent 1: protocol ipv6 prio %d u32 match ip6 %s %s/128 flowid 1:%x\n", dev, 2*class+1, direction, ip, class)
+		}
+	}
+}
+
+// deleteFilters writes the tc command that deletes the filters at prio on dev, if ips
+// put any there.
+func deleteFilters(b *strings.Builder, dev, protocol string, prio int, ips []string) {
+	if len(ips) > 0 {
+		fmt.Fprintf(b, "filter del dev %s parent 1: protocol %s prio %d\n", dev, protocol, prio)
+	}
+}
+
+// splitIPs returns the valid addresses of ips by family, sorted and without duplicates.
+func splitIPs(ips []string) (v4, v6 []string) {
+	for _, ip := range ips {
+		addr := net.ParseIP(ip)
+		switch {
+		case addr == nil:
+			continue
+		case addr.To4() != nil:
+			v4 = append(v4, addr.To4().String())
+		default:
+			v6 = append(v6, addr.String())
+		}
+	}
+	slices.Sort(v4)
+	slices.Sort(v6)
+	return slices.Compact(v4), slices.Compact(v6)
+}
+
+// defaultDevice returns the interface of the IPv4 default route.
+func defaultDevice() (string, error) {
+	file, err := os.Open("/proc/net/route")
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) > 2 && fields[1] == "00000000" && fields[0] != "Iface" {
+			return fields[0], nil
+		}
+	}
+	return "", errors.New("no default route found for speed limits")
+}
+
+// runBatch runs tc commands, stopping at the first that fails.
+func runBatch(batch string) error {
+	cmd := exec.Command("tc", "-batch", "-")
+	cmd.Stdin = strings.NewReader(batch)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("tc: %v: %s", err, bytes.TrimSpace(out))
+	}
+	return nil
+}
+
+// run runs a command and returns its output as the error when it fails.
+func run(name string, args ...string) error {
+	out, err := exec.Command(name, args...).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%s %s: %v: %s", name, strings.Join(args, " "), err, bytes.TrimSpace(out))
+	}
+	return nil
+}
+
+// output runs a command and returns its output, or "" when it fails.
+func output(name string, args ...string) string {
+	out, err := exec.Command(name, args...).Output()
+	if err != nil {
+		return ""
+	}
+	return string(out)
+}
@@ -0,0 +1,162 @@
+//go:build linux
+// +build linux
+
+package shaper
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBuildBatch(t *testing.T) {
+	alice := Limit{Name: "alice", IPs: []string{"10.0.0.1", "2001:db8::1"}, Up: 1000, Down: 2000}
+	bob := Limit{Name: "bob", IPs: []string{"10.0.0.2"}, Down: 500}
+	installed := map[string]shaped{
+		"alice": {class: 2, limit: alice},
+		"bob":   {class: 3, limit: bob},
+	}
+
+	tests := []struct {
+		name      string
+		installed map[string]shaped
+		limits    []Limit
+		batch     string
+		classes   map[string]int
+	}{
+		{
+			name:   "first clients",
+			limits: []Limit{alice, bob},
+			batch: "class add dev eth0 parent 1: classid 1:2 htb rate 2000kbit ceil 2000kbit\n" +
+				"filter add dev eth0 parent 1: protocol ip prio 4 u32 match ip dst 10.0.0.1/32 flowid 1:2\n" +
+				"filter add dev eth0 parent 1: protocol ipv6 prio 5 u32 match ip6 dst 2001:db8::1/128 flowid 1:2\n" +
+				"class add dev ifb-xui parent 1: classid 1:2 htb rate 1000kbit ceil 1000kbit\n" +
+				"filter add dev ifb-xui parent 1: protocol ip prio 4 u32 match ip src 10.0.0.1/32 flowid 1:2\n" +
+				"filter add dev ifb-xui parent 1: protocol ipv6 prio 5 u32 match ip6 src 2001:db8::1/128 flowid 1:2\n" +
+				"class add dev eth0 parent 1: classid 1:3 htb rate 500kbit ceil 500kbit\n" +
+				"filter add dev eth0 parent 1: protocol ip prio 6 u32 match ip dst 10.0.0.2/32 flowid 1:3\n",
+			classes: map[string]int{"alice": 2, "bob": 3},
+		},
+		{
+			name:      "unchanged in another order",
+			installed: installed,
+			limits: []Limit{
+				bob,
+				{Name: "alice", IPs: []string{"2001:db8::1", "10.0.0.1", "10.0.0.1"}, Up: 1000, Down: 2000},
+			},
+			batch:   "",
+			classes: map[string]int{"alice": 2, "bob": 3},
+		},
+		{
+			name:      "address change replaces one family",
+			installed: installed,
+			limits: []Limit{
+				{Name: "alice", IPs: []string{"10.0.0.9", "2001:db8::1", "bogus"}, Up: 1000, Down: 2000},
+				bob,
+			},
+			batch: "filter del dev eth0 parent 1: protocol ip prio 4\n" +
+				"filter add dev eth0 parent 1: protocol ip prio 4 u32 match ip dst 10.0.0.9/32 flowid 1:2\n" +
+				"filter del dev ifb-xui parent 1: protocol ip prio 4\n" +
+				"filter add dev ifb-xui parent 1: protocol ip prio 4 u32 match ip src 10.0.0.9/32 flowid 1:2\n",
+			classes: map[string]int{"alice": 2, "bob": 3},
+		},
+		{
+			name:      "rate change",
+			installed: installed,
+			limits:    []Limit{alice, {Name: "bob", IPs: bob.IPs, Down: 800}},
+			batch:     "class change dev eth0 parent 1: classid 1:3 htb rate 800kbit ceil 800kbit\n",
+			classes:   map[string]int{"alice": 2, "bob": 3},
+		},
+		{
+			name:      "direction capped and uncapped",
+			installed: installed,
+			limits:    []Limit{{Name: "alice", IPs: alice.IPs, Up: 1000}, {Name: "bob", IPs: bob.IPs, Up: 300, Down: 500}},
+			batch: "filter del dev eth0 parent 1: protocol ip prio 4\n" +
+				"filter del dev eth0 parent 1: protocol ipv6 prio 5\n" +
+				"class del dev eth0 parent 1: classid 1:2\n" +
+				"class add dev ifb-xui parent 1: classid 1:3 htb rate 300kbit ceil 300kbit\n" +
+				"filter add dev ifb-xui parent 1: protocol ip prio 6 u32 match ip src 10.0.0.2/32 flowid 1:3\n",
+			classes: map[string]int{"alice": 2, "bob": 3},
+		},
+		{
+			name:      "removed client frees its class",
+			installed: installed,
+			limits:    []Limit{bob, {Name: "carol", IPs: []string{"10.0.0.3"}, Down: 100}},
+			batch: "filter del dev eth0 parent 1: protocol ip prio 4\n" +
+				"filter del dev eth0 parent 1: protocol ipv6 prio 5\n" +
+				"class del dev eth0 parent 1: classid 1:2\n" +
+				"filter del dev ifb-xui parent 1: protocol ip prio 4\n" +
+				"filter del dev ifb-xui parent 1: protocol ipv6 prio 5\n" +
+				"class del dev ifb-xui parent 1: classid 1:2\n" +
+				"class add dev eth0 parent 1: classid 1:2 htb rate 100kbit ceil 100kbit\n" +
+				"filter add dev eth0 parent 1: protocol ip prio 4 u32 match ip dst 10.0.0.3/32 flowid 1:2\n",
+			classes: map[string]int{"bob": 3, "carol": 2},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			batch, next := buildBatch("eth0", test.installed, test.limits)
+			assert.Equal(t, test.batch, batch)
+			classes := make(map[string]int, len(next))
+			for name, s := range next {
+				classes[name] = s.class
+			}
+			assert.Equal(t, test.classes, classes)
+		})
+	}
+}
+
+func TestBaseBatch(t *testing.T) {
+	tests := []struct {
+		name  string
+		leaf  string
+		batch string
+	}{
+		{
+			name: "with leaf",
+			leaf: "fq",
+			batch: "qdisc add dev eth0 root handle 1: htb default ffff\n" +
+				"class add dev eth0 parent 1: classid 1:ffff htb rate 10gbit\n" +
+				"qdisc add dev eth0 parent 1:ffff fq\n" +
+				"qdisc add dev eth0 handle ffff: ingress\n" +
+				"filter add dev eth0 parent ffff: protocol all prio 1 u32 match u32 0 0 action mirred egress redirect dev ifb-xui\n" +
+				"qdisc add dev ifb-xui root handle 1: htb default ffff\n" +
+				"class add dev ifb-xui parent 1: classid 1:ffff htb rate 10gbit\n",
+		},
+		{
+			name: "without leaf",
+			batch: "qdisc add dev eth0 root handle 1: htb default ffff\n" +
+				"class add dev eth0 parent 1: classid 1:ffff htb rate 10gbit\n" +
+				"qdisc add dev eth0 handle ffff: ingress\n" +
+				"filter add dev eth0 parent ffff: protocol all prio 1 u32 match u32 0 0 action mirred egress redirect dev ifb-xui\n" +
+				"qdisc add dev ifb-xui root handle 1: htb default ffff\n" +
+				"class add dev ifb-xui parent 1: classid 1:ffff htb rate 10gbit\n",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert.Equal(t, test.batch, baseBatch("eth0", test.leaf))
+		})
+	}
+}
+
+func TestParseRootQdisc(t *testing.T) {
+	tests := []struct {
+		name   string
+		out    string
+		kind   string
+		handle string
+	}{
+		{"fq", "qdisc fq 8001: root refcnt 2 limit 10000p flow_limit 100p\n", "fq", "8001:"},
+		{"multiqueue", "qdisc mq 0: root \n", "mq", "0:"},
+		{"left by the panel", "qdisc htb 1: root refcnt 2 r2q 10 default 0xffff\n", "htb", "1:"},
+		{"no output", "", "", ""},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			kind, handle := parseRootQdisc(test.out)
+			assert.Equal(t, test.kind, kind)
+			assert.Equal(t, test.handle, handle)
+		})
+	}
+}
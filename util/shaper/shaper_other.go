@@ -0,0 +1,17 @@
+//go:build !linux
+// +build !linux
+
+package shaper
+
+// Apply replaces the shaping with limits.
+func Apply(limits []Limit) error {
+	if len(limits) > 0 {
+		return ErrUnsupported
+	}
+	return nil
+}
+
+// Clear removes all shaping.
+func Clear() error {
+	return nil
+}
@@ -12,6 +12,8 @@ class DBInbound {
         this.expiryTime = 0;
         this.trafficReset = "never";
         this.lastTrafficResetTime = 0;
+        this.speedLimitUp = 0;
+        this.speedLimitDown = 0;
 
         this.listen = "";
         this.port = 0;
@@ -1904,7 +1904,11 @@ Inbound.VmessSettings.VMESS = class extends XrayCommonClass {
         comment = '',
         reset = 0,
         created_at = undefined,
-        updated_at = undefined
+        updated_at = undefined,
+        speedLimitUp = 0,
+        speedLimitDown = 0,
+        planId = 0,
+        accessSchedule = undefined,
     ) {
         super();
         this.id = id;
@@ -1920,6 +1924,10 @@ Inbound.VmessSettings.VMESS = class extends XrayCommonClass {
         this.reset = reset;
         this.created_at = created_at;
         this.updated_at = updated_at;
+        this.speedLimitUp = speedLimitUp;
+        this.speedLimitDown = speedLimitDown;
+        this.planId = planId;
+        this.accessSchedule = accessSchedule;
     }
 
     static fromJson(json = {}) {
@@ -1937,6 +1945,10 @@ Inbound.VmessSettings.VMESS = class extends XrayCommonClass {
             json.reset,
             json.created_at,
             json.updated_at,
+            json.speedLimitUp,
+            json.speedLimitDown,
+            json.planId,
+            json.accessSchedule,
         );
     }
     get _expiryTime() {
@@ -2059,7 +2071,11 @@ Inbound.VLESSSettings.VLESS = class extends XrayCommonClass {
         comment = '',
         reset = 0,
         created_at = undefined,
-        updated_at = undefined
+        updated_at = undefined,
+        speedLimitUp = 0,
+        speedLimitDown = 0,
+        planId = 0,
+        accessSchedule = undefined,
     ) {
         super();
         this.id = id;
@@ -2075,6 +2091,10 @@ Inbound.VLESSSettings.VLESS = class extends XrayCommonClass {
         this.reset = reset;
         this.created_at = created_at;
         this.updated_at = updated_at;
+        this.speedLimitUp = speedLimitUp;
+        this.speedLimitDown = speedLimitDown;
+        this.planId = planId;
+        this.accessSchedule = accessSchedule;
     }
 
     static fromJson(json = {}) {
@@ -2092,6 +2112,10 @@ Inbound.VLESSSettings.VLESS = class extends XrayCommonClass {
             json.reset,
             json.created_at,
             json.updated_at,
+            json.speedLimitUp,
+            json.speedLimitDown,
+            json.planId,
+            json.accessSchedule,
         );
     }
 
@@ -2204,7 +2228,11 @@ Inbound.TrojanSettings.Trojan = class extends XrayCommonClass {
         comment = '',
         reset = 0,
         created_at = undefined,
-        updated_at = undefined
+        updated_at = undefined,
+        speedLimitUp = 0,
+        speedLimitDown = 0,
+        planId = 0,
+        accessSchedule = undefined,
     ) {
         super();
         this.password = password;
@@ -2219,6 +2247,10 @@ Inbound.TrojanSettings.Trojan = class extends XrayCommonClass {
         this.reset = reset;
         this.created_at = created_at;
         this.updated_at = updated_at;
+        this.speedLimitUp = speedLimitUp;
+        this.speedLimitDown = speedLimitDown;
+        this.planId = planId;
+        this.accessSchedule = accessSchedule;
     }
 
     toJson() {
@@ -2235,6 +2267,10 @@ Inbound.TrojanSettings.Trojan = class extends XrayCommonClass {
             reset: this.reset,
             created_at: this.created_at,
             updated_at: this.updated_at,
+            speedLimitUp: this.speedLimitUp,
+            speedLimitDown: this.speedLimitDown,
+            planId: this.planId,
+            accessSchedule: this.accessSchedule,
         };
     }
 
@@ -2252,6 +2288,10 @@ Inbound.TrojanSettings.Trojan = class extends XrayCommonClass {
             json.reset,
             json.created_at,
             json.updated_at,
+            json.speedLimitUp,
+            json.speedLimitDown,
+            json.planId,
+            json.accessSchedule,
         );
     }
 
@@ -2373,7 +2413,11 @@ Inbound.ShadowsocksSettings.Shadowsocks = class extends XrayCommonClass {
         comment = '',
         reset = 0,
         created_at = undefined,
-        updated_at = undefined
+        updated_at = undefined,
+        speedLimitUp = 0,
+        speedLimitDown = 0,
+        planId = 0,
+        accessSchedule = undefined,
     ) {
         super();
         this.method = method;
@@ -2389,6 +2433,10 @@ Inbound.ShadowsocksSettings.Shadowsocks = class extends XrayCommonClass {
         this.reset = reset;
         this.created_at = created_at;
         this.updated_at = updated_at;
+        this.speedLimitUp = speedLimitUp;
+        this.speedLimitDown = speedLimitDown;
+        this.planId = planId;
+        this.accessSchedule = accessSchedule;
     }
 
     toJson() {
@@ -2406,6 +2454,10 @@ Inbound.ShadowsocksSettings.Shadowsocks = class extends XrayCommonClass {
             reset: this.reset,
             created_at: this.created_at,
             updated_at: this.updated_at,
+            speedLimitUp: this.speedLimitUp,
+            speedLimitDown: this.speedLimitDown,
+            planId: this.planId,
+            accessSchedule: this.accessSchedule,
         };
     }
 
@@ -2424,6 +2476,10 @@ Inbound.ShadowsocksSettings.Shadowsocks = class extends XrayCommonClass {
             json.reset,
             json.created_at,
             json.updated_at,
+            json.speedLimitUp,
+            json.speedLimitDown,
+            json.planId,
+            json.accessSchedule,
         );
     }
 
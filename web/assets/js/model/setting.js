@@ -23,8 +23,22 @@ class AllSetting {
         this.tgBotLoginNotify = true;
         this.tgCpu = 80;
         this.tgLang = "en-US";
-        this.twoFactorEnable = false;
-        this.twoFactorToken = "";
+        this.loginLimitEnable = true;
+        this.loginMaxAttempts = 5;
+        this.loginLockoutSeconds = 60;
+        this.loginLockoutMaxSeconds = 3600;
+        this.loginAttemptWindow = 900;
+        this.ipLimitMode = "fail2ban";
+        this.ipLimitCooldown = 300;
+        this.recycleBinDays = 30;
+        this.trafficStatsHourlyDays = 7;
+        this.trafficStatsDailyDays = 365;
+        this.metricsEnable = false;
+        this.metricsListen = "127.0.0.1";
+        this.metricsPort = 0;
+        this.destinationStatsEnable = false;
+        this.destinationStatsTopN = 50;
+        this.destinationStatsDays = 30;
         this.xrayTemplateConfig = "";
         this.subEnable = true;
         this.subJsonEnable = false;
@@ -76,6 +90,21 @@ class AllSetting {
         this.ldapDefaultTotalGB = 0;
         this.ldapDefaultExpiryDays = 0;
         this.ldapDefaultLimitIP = 0;
+        this.ldapGroupAttr = "memberOf";
+        this.ldapRoleMapping = "";
+        this.ldapDefaultRole = "";
+        this.ldapAutoProvision = false;
+        this.oidcEnable = false;
+        this.oidcIssuer = "";
+        this.oidcClientId = "";
+        this.oidcClientSecret = "";
+        this.oidcRedirectUrl = "";
+        this.oidcScopes = "openid profile email";
+        this.oidcUsernameClaim = "preferred_username";
+        this.oidcGroupsClaim = "groups";
+        this.oidcRoleMapping = "";
+        this.oidcDefaultRole = "";
+        this.oidcAutoProvision = false;
 
         if (data == null) {
             return
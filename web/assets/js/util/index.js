@@ -916,4 +916,71 @@ class IntlUtil {
 
         return formatter.format(diff, 'day');
     }
-}
\ No newline at end of file
+}
+class WebAuthnUtil {
+    static isSupported() {
+        return !!(window.PublicKeyCredential && navigator.credentials);
+    }
+
+    static toBuffer(value) {
+        const base64 = value.replace(/-/g, '+').replace(/_/g, '/');
+        const padded = base64 + '='.repeat((4 - base64.length % 4) % 4);
+        return Uint8Array.from(window.atob(padded), c => c.charCodeAt(0)).buffer;
+    }
+
+    static fromBuffer(buffer) {
+        if (!buffer) {
+            return undefined;
+        }
+        return window.btoa(String.fromCharCode(...new Uint8Array(buffer)))
+            .replace(/\+/g, '-')
+            .replace(/\//g, '_')
+            .replace(/=/g, '');
+    }
+
+    // create registers a new credential from the options returned by the server
+    // and returns the result as JSON to post back.
+    static async create(options) {
+        const publicKey = { ...options.publicKey };
+        publicKey.challenge = this.toBuffer(publicKey.challenge);
+        publicKey.user = { ...publicKey.user, id: this.toBuffer(publicKey.user.id) };
+        publicKey.excludeCredentials = (publicKey.excludeCredentials || [])
+            .map(c => ({ ...c, id: this.toBuffer(c.id) }));
+        const credential = await navigator.credentials.create({ publicKey });
+        return JSON.stringify({
+            id: credential.id,
+            rawId: this.fromBuffer(credential.rawId),
+            type: credential.type,
+            authenticatorAttachment: credential.authenticatorAttachment,
+            clientExtensionResults: credential.getClientExtensionResults(),
+            response: {
+                clientDataJSON: this.fromBuffer(credential.response.clientDataJSON),
+                attestationObject: this.fromBuffer(credential.response.attestationObject),
+                transports: credential.response.getTransports ? credential.response.getTransports() : [],
+            },
+        });
+    }
+
+    // get signs the challenge in the options returned by the server
+    // and returns the assertion as JSON to post back.
+    static async get(options) {
+        const publicKey = { ...options.publicKey };
+        publicKey.challenge = this.toBuffer(publicKey.challenge);
+        publicKey.allowCredentials = (publicKey.allowCredentials || [])
+            .map(c => ({ ...c, id: this.toBuffer(c.id) }));
+        const credential = await navigator.credentials.get({ publicKey });
+        return JSON.stringify({
+            id: credential.id,
+            rawId: this.fromBuffer(credential.rawId),
+            type: credential.type,
+            authenticatorAttachment: credential.authenticatorAttachment,
+            clientExtensionResults: credential.getClientExtensionResults(),
+            response: {
+                clientDataJSON: this.fromBuffer(credential.response.clientDataJSON),
+                authenticatorData: this.fromBuffer(credential.response.authenticatorData),
+                signature: this.fromBuffer(credential.response.signature),
+                userHandle: this.fromBuffer(credential.response.userHandle),
+            },
+        });
+    }
+}
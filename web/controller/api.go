@@ -2,7 +2,10 @@ package controller
 
 import (
 	"net/http"
+	"strings"
 
+	"github.com/mhsanaei/3x-ui/v2/database/model"
+	"github.com/mhsanaei/3x-ui/v2/logger"
 	"github.com/mhsanaei/3x-ui/v2/web/service"
 	"github.com/mhsanaei/3x-ui/v2/web/session"
 
@@ -12,9 +15,23 @@ import (
 // APIController handles the main API routes for the 3x-ui panel, including inbounds and server management.
 type APIController struct {
 	BaseController
-	inboundController *InboundController
-	serverController  *ServerController
-	Tgbot             service.Tgbot
+	inboundController      *InboundController
+	serverController       *ServerController
+	apiTokenController     *APITokenController
+	auditController        *AuditController
+	webAuthnController     *WebAuthnController
+	sessionController      *SessionController
+	resellerController     *ResellerController
+	groupController        *ClientGroupController
+	planController         *PlanController
+	subAccountController   *SubAccountController
+	recycleBinController   *RecycleBinController
+	scheduleController     *ScheduledActionController
+	trafficStatsController *TrafficStatsController
+	destinationController  *ClientDestinationController
+	metricsController      *MetricsController
+	apiTokenService        service.APITokenService
+	Tgbot                  service.Tgbot
 }
 
 // NewAPIController creates a new APIController instance and initializes its routes.
@@ -25,12 +42,26 @@ func NewAPIController(g *gin.RouterGroup) *APIController {
 }
 
 // checkAPIAuth is a middleware that returns 404 for unauthenticated API requests
-// to hide the existence of API endpoints from unauthorized users
+// to hide the existence of API endpoints from unauthorized users.
+// Requests without a session may authenticate with an "Authorization: Bearer" API token.
 func (a *APIController) checkAPIAuth(c *gin.Context) {
-	if !session.IsLogin(c) {
+	if session.IsLogin(c) {
+		c.Next()
+		return
+	}
+	plain, ok := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer ")
+	if !ok {
+		c.AbortWithStatus(http.StatusNotFound)
+		return
+	}
+	token, user, err := a.apiTokenService.Authenticate(strings.TrimSpace(plain))
+	if err != nil {
+		logger.Warning("API token rejected from", getRemoteIp(c), ":", err)
 		c.AbortWithStatus(http.StatusNotFound)
 		return
 	}
+	c.Set(apiTokenKey, token)
+	c.Set(apiTokenUserKey, user)
 	c.Next()
 }
 
@@ -48,8 +79,59 @@ func (a *APIController) initRouter(g *gin.RouterGroup) {
 	server := api.Group("/server")
 	a.serverController = NewServerController(server)
 
+	// API tokens
+	tokens := api.Group("/tokens")
+	a.apiTokenController = NewAPITokenController(tokens)
+
+	// Audit log
+	audit := api.Group("/audit")
+	a.auditController = NewAuditController(audit)
+
+	// Security keys and passkeys
+	webAuthn := api.Group("/webauthn")
+	a.webAuthnController = NewWebAuthnController(webAuthn)
+
+	// Login sessions
+	sessions := api.Group("/sessions")
+	a.sessionController = NewSessionController(sessions)
+
+	// Reseller quotas and usage
+	resellers := api.Group("/resellers")
+	a.resellerController = NewResellerController(resellers)
+
+	// Client groups and bulk actions
+	groups := api.Group("/groups")
+	a.groupController = NewClientGroupController(groups)
+
+	// Service plans
+	plans := api.Group("/plans")
+	a.planController = NewPlanController(plans)
+
+	// Subscription accounts with shared quotas
+	subAccounts := api.Group("/subAccounts")
+	a.subAccountController = NewSubAccountController(subAccounts)
+
+	// Deleted clients and inbounds
+	recycleBin := api.Group("/recycleBin")
+	a.recycleBinController = NewRecycleBinController(recycleBin)
+
+	// Actions scheduled on clients and inbounds
+	schedules := api.Group("/schedules")
+	a.scheduleController = NewScheduledActionController(schedules)
+
+	// Traffic history
+	trafficStats := api.Group("/trafficStats")
+	a.trafficStatsController = NewTrafficStatsController(trafficStats)
+
+	// Destinations clients connect to most
+	destinations := api.Group("/destinations")
+	a.destinationController = NewClientDestinationController(destinations)
+
+	// Prometheus metrics, outside the API but for the same tokens
+	a.metricsController = NewMetricsController(g.Group("/metrics", a.checkAPIAuth))
+
 	// Extra routes
-	api.GET("/backuptotgbot", a.BackuptoTgbot)
+	api.GET("/backuptotgbot", checkPermission(model.PermServerManage), a.BackuptoTgbot)
 }
 
 // BackuptoTgbot sends a backup of the panel data to Telegram bot admins.
@@ -0,0 +1,88 @@
+package controller
+
+import (
+	"strconv"
+
+	"github.com/mhsanaei/3x-ui/v2/database/model"
+	"github.com/mhsanaei/3x-ui/v2/web/service"
+
+	"github.com/gin-gonic/gin"
+)
+
+// apiTokenForm represents the form for creating an API token.
+type apiTokenForm struct {
+	Name       string   `json:"name" form:"name"`
+	Scopes     []string `json:"scopes" form:"scopes"`
+	ExpiryTime int64    `json:"expiryTime" form:"expiryTime"`
+}
+
+// APITokenController handles management of scoped API bearer tokens.
+// Tokens can only be managed from a cookie session, never with another token.
+type APITokenController struct {
+	apiTokenService service.APITokenService
+	userService     service.UserService
+}
+
+// NewAPITokenController creates a new APITokenController and initializes its routes.
+func NewAPITokenController(g *gin.RouterGroup) *APITokenController {
+	a := &APITokenController{}
+	a.initRouter(g)
+	return a
+}
+
+// initRouter sets up the routes for API token management.
+func (a *APITokenController) initRouter(g *gin.RouterGroup) {
+	g.Use(checkSession, checkPermission(model.PermView))
+
+	g.GET("/list", a.getTokens)
+	g.POST("/add", a.addToken)
+	g.POST("/del/:id", a.delToken)
+}
+
+// tokenOwnerFilter returns the user id whose tokens the caller may manage,
+// or 0 when the caller may manage all tokens.
+func (a *APITokenController) tokenOwnerFilter(c *gin.Context) int {
+	user := getLoginUser(c)
+	current, err := a.userService.GetUserById(user.Id)
+	if err == nil && current.Role.Can(model.PermUserManage) {
+		return 0
+	}
+	return user.Id
+}
+
+// getTokens lists the API tokens visible to the current user.
+func (a *APITokenController) getTokens(c *gin.Context) {
+	tokens, err := a.apiTokenService.GetTokens(a.tokenOwnerFilter(c))
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "somethingWentWrong"), err)
+		return
+	}
+	jsonObj(c, tokens, nil)
+}
+
+// addToken creates an API token for the current user. The plain token is returned only once.
+func (a *APITokenController) addToken(c *gin.Context) {
+	form := &apiTokenForm{}
+	err := c.ShouldBind(form)
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "somethingWentWrong"), err)
+		return
+	}
+	token, plain, err := a.apiTokenService.AddToken(getLoginUser(c).Id, form.Name, form.Scopes, form.ExpiryTime)
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "somethingWentWrong"), err)
+		return
+	}
+	jsonMsgObj(c, "Token created successfully", gin.H{"token": plain, "info": token}, nil)
+}
+
+// delToken revokes an API token.
+func (a *APITokenController) delToken(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "somethingWentWrong"), err)
+		return
+	}
+	err = a.apiTokenService.DelToken(id, a.tokenOwnerFilter(c))
+	jsonMsg(c, "Token revoked successfully", err)
+}
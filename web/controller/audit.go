@@ -0,0 +1,42 @@
+package controller
+
+import (
+	"github.com/mhsanaei/3x-ui/v2/database/model"
+	"github.com/mhsanaei/3x-ui/v2/web/service"
+
+	"github.com/gin-gonic/gin"
+)
+
+// AuditController handles queries of the audit log.
+type AuditController struct {
+	auditService service.AuditService
+}
+
+// NewAuditController creates a new AuditController and initializes its routes.
+func NewAuditController(g *gin.RouterGroup) *AuditController {
+	a := &AuditController{}
+	a.initRouter(g)
+	return a
+}
+
+// initRouter sets up the routes for audit log queries.
+func (a *AuditController) initRouter(g *gin.RouterGroup) {
+	g.Use(checkPermission(model.PermAudit))
+
+	g.GET("/list", a.getLogs)
+}
+
+// getLogs returns audit entries filtered by actor, action, target and time range.
+func (a *AuditController) getLogs(c *gin.Context) {
+	filter := &service.AuditFilter{}
+	if err := c.ShouldBind(filter); err != nil {
+		jsonMsg(c, I18nWeb(c, "somethingWentWrong"), err)
+		return
+	}
+	logs, total, err := a.auditService.GetLogs(filter)
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "somethingWentWrong"), err)
+		return
+	}
+	jsonObj(c, gin.H{"logs": logs, "total": total}, nil)
+}
@@ -0,0 +1,77 @@
+package controller
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/mhsanaei/3x-ui/v2/database/model"
+	"github.com/mhsanaei/3x-ui/v2/web/service"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ClientDestinationController serves the destinations clients connect to most, as
+// read from the Xray access log. Users limited to their own inbounds only see the
+// clients assigned to them.
+type ClientDestinationController struct {
+	destinationService service.ClientDestinationService
+	inboundService     service.InboundService
+	settingService     service.SettingService
+}
+
+// NewClientDestinationController creates a new ClientDestinationController and initializes its routes.
+func NewClientDestinationController(g *gin.RouterGroup) *ClientDestinationController {
+	a := &ClientDestinationController{}
+	a.initRouter(g)
+	return a
+}
+
+// initRouter sets up the routes for the destination report.
+func (a *ClientDestinationController) initRouter(g *gin.RouterGroup) {
+	g.Use(checkPermission(model.PermView))
+
+	g.GET("/client/:email", a.getClientDestinations)
+}
+
+// getClientDestinations returns the top destinations of a client over the range in the
+// "from" and "to" queries, in milliseconds, which defaults to the last 7 days. The
+// "limit" query defaults to the destinations kept per client and day.
+func (a *ClientDestinationController) getClientDestinations(c *gin.Context) {
+	email := c.Param("email")
+	if err := a.inboundService.WithActor(auditActor(c)).CheckClientAccess(email); err != nil {
+		pureJsonMsg(c, http.StatusForbidden, false, "permission denied")
+		return
+	}
+	to := time.Now().UnixMilli()
+	if value := c.Query("to"); value != "" {
+		parsed, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			jsonMsg(c, I18nWeb(c, "somethingWentWrong"), err)
+			return
+		}
+		to = parsed
+	}
+	from := time.UnixMilli(to).AddDate(0, 0, -7).UnixMilli()
+	if value := c.Query("from"); value != "" {
+		parsed, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			jsonMsg(c, I18nWeb(c, "somethingWentWrong"), err)
+			return
+		}
+		from = parsed
+	}
+	limit, err := a.settingService.GetDestinationStatsTopN()
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "somethingWentWrong"), err)
+		return
+	}
+	if value := c.Query("limit"); value != "" {
+		if limit, err = strconv.Atoi(value); err != nil {
+			jsonMsg(c, I18nWeb(c, "somethingWentWrong"), err)
+			return
+		}
+	}
+	report, err := a.destinationService.GetTopDestinations(email, from, to, limit)
+	jsonObj(c, report, err)
+}
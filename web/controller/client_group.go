@@ -0,0 +1,251 @@
+package controller
+
+import (
+	"strconv"
+
+	"github.com/mhsanaei/3x-ui/v2/database/model"
+	"github.com/mhsanaei/3x-ui/v2/web/service"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ClientGroupController handles client groups and bulk actions on their clients.
+// Groups span inbounds, so users limited to their own inbounds can not use them.
+type ClientGroupController struct {
+	clientGroupService service.ClientGroupService
+	inboundService     service.InboundService
+	xrayService        service.XrayService
+}
+
+// NewClientGroupController creates a new ClientGroupController and initializes its routes.
+func NewClientGroupController(g *gin.RouterGroup) *ClientGroupController {
+	a := &ClientGroupController{}
+	a.initRouter(g)
+	return a
+}
+
+// initRouter sets up the routes for client groups.
+func (a *ClientGroupController) initRouter(g *gin.RouterGroup) {
+	g.Use(checkNotScoped)
+	view := checkPermission(model.PermView)
+	support := checkPermission(model.PermClientSupport)
+	manageClients := checkPermission(model.PermClientManage)
+
+	g.GET("/list", view, a.getGroups)
+	g.GET("/get/:id", view, a.getGroup)
+
+	g.POST("/add", manageClients, a.addGroup)
+	g.POST("/update/:id", manageClients, a.updateGroup)
+	g.POST("/del/:id", manageClients, a.delGroup)
+	g.POST("/:id/addClients", manageClients, a.addClients)
+	g.POST("/:id/removeClients", manageClients, a.removeClients)
+
+	g.POST("/:id/enable", manageClients, a.enableClients)
+	g.POST("/:id/disable", manageClients, a.disableClients)
+	g.POST("/:id/extend", manageClients, a.extendExpiry)
+	g.POST("/:id/addTraffic", manageClients, a.addTraffic)
+	g.POST("/:id/resetTraffic", support, a.resetTraffic)
+	g.POST("/:id/delClients", manageClients, a.delClients)
+	g.POST("/:id/move", manageClients, a.moveClients)
+}
+
+// groupEmailsForm lists the clients to add to or remove from a group.
+type groupEmailsForm struct {
+	Emails []string `json:"emails" form:"emails"`
+}
+
+// groupId parses the group id in the path, responding with an error when it is invalid.
+func groupId(c *gin.Context) (int, bool) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "somethingWentWrong"), err)
+		return 0, false
+	}
+	return id, true
+}
+
+// getGroups lists all groups with their client counts.
+func (a *ClientGroupController) getGroups(c *gin.Context) {
+	groups, err := a.clientGroupService.GetGroups()
+	jsonObj(c, groups, err)
+}
+
+// getGroup returns a group with its clients.
+func (a *ClientGroupController) getGroup(c *gin.Context) {
+	id, ok := groupId(c)
+	if !ok {
+		return
+	}
+	group, err := a.clientGroupService.GetGroup(id)
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "somethingWentWrong"), err)
+		return
+	}
+	clients, err := a.clientGroupService.GetGroupClients(id)
+	jsonObj(c, gin.H{"group": group, "clients": clients}, err)
+}
+
+// addGroup creates a group from the "name" and "comment" fields.
+func (a *ClientGroupController) addGroup(c *gin.Context) {
+	group := &model.ClientGroup{}
+	if err := c.ShouldBind(group); err != nil {
+		jsonMsg(c, I18nWeb(c, "somethingWentWrong"), err)
+		return
+	}
+	err := a.clientGroupService.AddGroup(group)
+	jsonMsgObj(c, "Group created successfully", group, err)
+}
+
+// updateGroup renames a group or changes its comment.
+func (a *ClientGroupController) updateGroup(c *gin.Context) {
+	id, ok := groupId(c)
+	if !ok {
+		return
+	}
+	group := &model.ClientGroup{}
+	if err := c.ShouldBind(group); err != nil {
+		jsonMsg(c, I18nWeb(c, "somethingWentWrong"), err)
+		return
+	}
+	group.Id = id
+	err := a.clientGroupService.UpdateGroup(group)
+	jsonMsgObj(c, "Group updated successfully", group, err)
+}
+
+// delGroup deletes a group, keeping its clients.
+func (a *ClientGroupController) delGroup(c *gin.Context) {
+	id, ok := groupId(c)
+	if !ok {
+		return
+	}
+	err := a.clientGroupService.DelGroup(id)
+	jsonMsg(c, "Group deleted successfully", err)
+}
+
+// addClients puts the clients in the "emails" field into a group.
+func (a *ClientGroupController) addClients(c *gin.Context) {
+	id, ok := groupId(c)
+	if !ok {
+		return
+	}
+	form := &groupEmailsForm{}
+	if err := c.ShouldBind(form); err != nil {
+		jsonMsg(c, I18nWeb(c, "somethingWentWrong"), err)
+		return
+	}
+	err := a.clientGroupService.AddGroupClients(id, form.Emails)
+	jsonMsg(c, "Clients added to group", err)
+}
+
+// removeClients takes the clients in the "emails" field out of a group.
+func (a *ClientGroupController) removeClients(c *gin.Context) {
+	id, ok := groupId(c)
+	if !ok {
+		return
+	}
+	form := &groupEmailsForm{}
+	if err := c.ShouldBind(form); err != nil {
+		jsonMsg(c, I18nWeb(c, "somethingWentWrong"), err)
+		return
+	}
+	err := a.clientGroupService.RemoveGroupClients(id, form.Emails)
+	jsonMsg(c, "Clients removed from group", err)
+}
+
+// groupActionDone responds with the number of clients a bulk action applied to and
+// schedules an Xray restart when the Xray API could not apply it.
+func (a *ClientGroupController) groupActionDone(c *gin.Context, count int, needRestart bool, err error) {
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "somethingWentWrong"), err)
+		return
+	}
+	jsonMsgObj(c, "Group clients updated", gin.H{"clients": count}, nil)
+	if needRestart {
+		a.xrayService.SetToNeedRestart()
+	}
+}
+
+// enableClients enables every client of a group.
+func (a *ClientGroupController) enableClients(c *gin.Context) {
+	id, ok := groupId(c)
+	if !ok {
+		return
+	}
+	count, needRestart, err := a.inboundService.WithActor(auditActor(c)).SetGroupEnable(id, true)
+	a.groupActionDone(c, count, needRestart, err)
+}
+
+// disableClients disables every client of a group.
+func (a *ClientGroupController) disableClients(c *gin.Context) {
+	id, ok := groupId(c)
+	if !ok {
+		return
+	}
+	count, needRestart, err := a.inboundService.WithActor(auditActor(c)).SetGroupEnable(id, false)
+	a.groupActionDone(c, count, needRestart, err)
+}
+
+// extendExpiry extends the expiry of the clients of a group by the "days" field.
+func (a *ClientGroupController) extendExpiry(c *gin.Context) {
+	id, ok := groupId(c)
+	if !ok {
+		return
+	}
+	days, err := strconv.Atoi(c.PostForm("days"))
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "somethingWentWrong"), err)
+		return
+	}
+	count, needRestart, err := a.inboundService.WithActor(auditActor(c)).ExtendGroupExpiry(id, days)
+	a.groupActionDone(c, count, needRestart, err)
+}
+
+// addTraffic raises the traffic limit of the clients of a group by the "gb" field.
+func (a *ClientGroupController) addTraffic(c *gin.Context) {
+	id, ok := groupId(c)
+	if !ok {
+		return
+	}
+	gb, err := strconv.ParseFloat(c.PostForm("gb"), 64)
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "somethingWentWrong"), err)
+		return
+	}
+	count, needRestart, err := a.inboundService.WithActor(auditActor(c)).AddGroupTraffic(id, int64(gb*1024*1024*1024))
+	a.groupActionDone(c, count, needRestart, err)
+}
+
+// resetTraffic resets the used traffic of the clients of a group.
+func (a *ClientGroupController) resetTraffic(c *gin.Context) {
+	id, ok := groupId(c)
+	if !ok {
+		return
+	}
+	count, needRestart, err := a.inboundService.WithActor(auditActor(c)).ResetGroupTraffic(id)
+	a.groupActionDone(c, count, needRestart, err)
+}
+
+// delClients deletes the clients of a group.
+func (a *ClientGroupController) delClients(c *gin.Context) {
+	id, ok := groupId(c)
+	if !ok {
+		return
+	}
+	count, needRestart, err := a.inboundService.WithActor(auditActor(c)).DelGroupClients(id)
+	a.groupActionDone(c, count, needRestart, err)
+}
+
+// moveClients moves the clients of a group to the inbound in the "inboundId" field.
+func (a *ClientGroupController) moveClients(c *gin.Context) {
+	id, ok := groupId(c)
+	if !ok {
+		return
+	}
+	inboundId, err := strconv.Atoi(c.PostForm("inboundId"))
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "somethingWentWrong"), err)
+		return
+	}
+	count, needRestart, err := a.inboundService.WithActor(auditActor(c)).MoveGroupClients(id, inboundId)
+	a.groupActionDone(c, count, needRestart, err)
+}
@@ -3,14 +3,17 @@ package controller
 import (
 	"encoding/json"
 	"fmt"
+	"maps"
+	"net/http"
+	"slices"
 	"strconv"
 	"time"
 
 	"github.com/mhsanaei/3x-ui/v2/database/model"
 	"github.com/mhsanaei/3x-ui/v2/pkg/x/xs"
 	"github.com/mhsanaei/3x-ui/v2/web/service"
-	"github.com/mhsanaei/3x-ui/v2/web/session"
 	"github.com/mhsanaei/3x-ui/v2/web/websocket"
+	"github.com/mhsanaei/3x-ui/v2/xray"
 
 	"github.com/gin-gonic/gin"
 )
@@ -18,6 +21,7 @@ import (
 // InboundController handles HTTP requests related to Xray inbounds management.
 type InboundController struct {
 	inboundService service.InboundService
+	planService    service.PlanService
 	xrayService    service.XrayService
 }
 
@@ -31,33 +35,86 @@ func NewInboundController(g *gin.RouterGroup) *InboundController {
 // initRouter initializes the routes for inbound-related operations.
 func (a *InboundController) initRouter(g *gin.RouterGroup) {
 
-	g.GET("/list", a.getInbounds)
-	g.GET("/get/:id", a.getInbound)
-	g.GET("/getClientTraffics/:email", a.getClientTraffics)
-	g.GET("/getClientTrafficsById/:id", a.getClientTrafficsById)
-
-	g.POST("/add", a.addInbound)
-	g.POST("/del/:id", a.delInbound)
-	g.POST("/update/:id", a.updateInbound)
-	g.POST("/clientIps/:email", a.getClientIps)
-	g.POST("/clearClientIps/:email", a.clearClientIps)
-	g.POST("/addClient", a.addInboundClient)
-	g.POST("/:id/delClient/:clientId", a.delInboundClient)
-	g.POST("/updateClient/:clientId", a.updateInboundClient)
-	g.POST("/:id/resetClientTraffic/:email", a.resetClientTraffic)
-	g.POST("/resetAllTraffics", a.resetAllTraffics)
-	g.POST("/resetAllClientTraffics/:id", a.resetAllClientTraffics)
-	g.POST("/delDepletedClients/:id", a.delDepletedClients)
-	g.POST("/import", a.importInbound)
-	g.POST("/onlines", a.onlines)
-	g.POST("/lastOnline", a.lastOnline)
-	g.POST("/updateClientTraffic/:email", a.updateClientTraffic)
-	g.POST("/:id/delClientByEmail/:email", a.delInboundClientByEmail)
+	view := checkPermission(model.PermView)
+	support := checkPermission(model.PermClientSupport)
+	manageClients := checkPermission(model.PermClientManage)
+	manageInbounds := checkPermission(model.PermInboundManage)
+
+	g.GET("/list", view, a.getInbounds)
+	g.GET("/get/:id", view, a.getInbound)
+	g.GET("/getClientTraffics/:email", view, a.getClientTraffics)
+	g.GET("/getClientTrafficsById/:id", view, a.getClientTrafficsById)
+
+	g.POST("/add", manageInbounds, a.addInbound)
+	g.POST("/del/:id", manageInbounds, a.delInbound)
+	g.POST("/update/:id", manageInbounds, a.updateInbound)
+	g.POST("/clientIps/:email", view, a.getClientIps)
+	g.POST("/clearClientIps/:email", support, a.clearClientIps)
+	g.GET("/ipLimitBans", view, checkNotScoped, a.getIPLimitBans)
+	g.POST("/ipLimitBans/lift/:id", support, checkNotScoped, a.liftIPLimitBan)
+	g.POST("/addClient", manageClients, a.addInboundClient)
+	g.POST("/generateClients", manageClients, a.generateClients)
+	g.POST("/renewClient/:email", manageClients, a.renewClient)
+	g.GET("/renewals/:email", view, a.getRenewals)
+	g.POST("/moveClient/:email", manageClients, a.moveClient)
+	g.POST("/copyClient/:email", manageClients, a.copyClient)
+	g.POST("/:id/delClient/:clientId", manageClients, a.delInboundClient)
+	g.POST("/updateClient/:clientId", manageClients, a.updateInboundClient)
+	g.POST("/:id/resetClientTraffic/:email", support, checkNotScoped, a.resetClientTraffic)
+	g.POST("/resetAllTraffics", manageInbounds, a.resetAllTraffics)
+	g.POST("/resetAllClientTraffics/:id", manageClients, checkNotScoped, a.resetAllClientTraffics)
+	g.POST("/delDepletedClients/:id", manageClients, a.delDepletedClients)
+	g.POST("/import", manageInbounds, a.importInbound)
+	g.GET("/exportClients", view, checkNotScoped, a.exportClients)
+	g.POST("/importClients", manageClients, checkNotScoped, a.importClients)
+	g.POST("/onlines", view, a.onlines)
+	g.POST("/lastOnline", view, a.lastOnline)
+	g.POST("/updateClientTraffic/:email", manageClients, checkNotScoped, a.updateClientTraffic)
+	g.POST("/:id/delClientByEmail/:email", manageClients, a.delInboundClientByEmail)
+}
+
+// checkInboundAccess responds with 403 and returns false when the caller is limited
+// to their own inbounds and inbound id is not one of them.
+func (a *InboundController) checkInboundAccess(c *gin.Context, id int) bool {
+	if err := a.inboundService.WithActor(auditActor(c)).CheckInboundAccess(id); err != nil {
+		pureJsonMsg(c, http.StatusForbidden, false, "permission denied")
+		return false
+	}
+	return true
+}
+
+// checkClientAccess is checkInboundAccess for the inbound of the client with email.
+func (a *InboundController) checkClientAccess(c *gin.Context, email string) bool {
+	if err := a.inboundService.WithActor(auditActor(c)).CheckClientAccess(email); err != nil {
+		pureJsonMsg(c, http.StatusForbidden, false, "permission denied")
+		return false
+	}
+	return true
+}
+
+// scopedEmails returns the client emails of the inbounds assigned to the caller when
+// they are limited to their own inbounds, or nil when they see all clients.
+func (a *InboundController) scopedEmails(c *gin.Context) map[string]bool {
+	userId := scopedUserId(c)
+	if userId == 0 {
+		return nil
+	}
+	emails := make(map[string]bool)
+	inbounds, _ := a.inboundService.GetInbounds(userId)
+	for _, inbound := range inbounds {
+		for _, stat := range inbound.ClientStats {
+			emails[stat.Email] = true
+		}
+	}
+	return emails
 }
 
 // getClientTraffics retrieves client traffic information by email.
 func (a *InboundController) getClientTraffics(c *gin.Context) {
 	email := c.Param("email")
+	if !a.checkClientAccess(c, email) {
+		return
+	}
 	clientTraffics, err := a.inboundService.GetClientTrafficByEmail(email)
 	if err != nil {
 		jsonMsg(c, I18nWeb(c, "pages.inbounds.toasts.trafficGetError"), err)
@@ -74,6 +131,11 @@ func (a *InboundController) getClientTrafficsById(c *gin.Context) {
 		jsonMsg(c, I18nWeb(c, "pages.inbounds.toasts.trafficGetError"), err)
 		return
 	}
+	if emails := a.scopedEmails(c); emails != nil {
+		clientTraffics = slices.DeleteFunc(clientTraffics, func(traffic xray.ClientTraffic) bool {
+			return !emails[traffic.Email]
+		})
+	}
 	jsonObj(c, clientTraffics, nil)
 }
 
@@ -84,7 +146,7 @@ func (a *InboundController) delInbound(c *gin.Context) {
 		jsonMsg(c, I18nWeb(c, "pages.inbounds.toasts.inboundDeleteSuccess"), err)
 		return
 	}
-	needRestart, err := a.inboundService.DelInbound(id)
+	needRestart, err := a.inboundService.WithActor(auditActor(c)).DelInbound(id)
 	if err != nil {
 		jsonMsg(c, I18nWeb(c, "somethingWentWrong"), err)
 		return
@@ -94,8 +156,7 @@ func (a *InboundController) delInbound(c *gin.Context) {
 		a.xrayService.SetToNeedRestart()
 	}
 	// Broadcast inbounds update via WebSocket
-	user := session.GetLoginUser(c)
-	inbounds, _ := a.inboundService.GetInbounds(user.Id)
+	inbounds, _ := a.inboundService.GetAllInbounds()
 
 	websocket.BroadcastInbounds(xs.Map(inbounds, func(item *model.Inbound, index int) InboundDTO {
 		return toInboundDTOPtr(item)
@@ -105,6 +166,9 @@ func (a *InboundController) delInbound(c *gin.Context) {
 // getClientIps retrieves the IP addresses associated with a client by email.
 func (a *InboundController) getClientIps(c *gin.Context) {
 	email := c.Param("email")
+	if !a.checkClientAccess(c, email) {
+		return
+	}
 
 	ips, err := a.inboundService.GetInboundClientIps(email)
 	if err != nil || ips == "" {
@@ -149,8 +213,11 @@ func (a *InboundController) getClientIps(c *gin.Context) {
 // clearClientIps clears the IP addresses for a client by email.
 func (a *InboundController) clearClientIps(c *gin.Context) {
 	email := c.Param("email")
+	if !a.checkClientAccess(c, email) {
+		return
+	}
 
-	err := a.inboundService.ClearClientIps(email)
+	err := a.inboundService.WithActor(auditActor(c)).ClearClientIps(email)
 	if err != nil {
 		jsonMsg(c, I18nWeb(c, "pages.inbounds.toasts.updateSuccess"), err)
 		return
@@ -166,8 +233,11 @@ func (a *InboundController) delInboundClient(c *gin.Context) {
 		return
 	}
 	clientId := c.Param("clientId")
+	if !a.checkInboundAccess(c, id) {
+		return
+	}
 
-	needRestart, err := a.inboundService.DelInboundClient(id, clientId)
+	needRestart, err := a.inboundService.WithActor(auditActor(c)).DelInboundClient(id, clientId)
 	if err != nil {
 		jsonMsg(c, I18nWeb(c, "somethingWentWrong"), err)
 		return
@@ -187,7 +257,7 @@ func (a *InboundController) resetClientTraffic(c *gin.Context) {
 	}
 	email := c.Param("email")
 
-	needRestart, err := a.inboundService.ResetClientTraffic(id, email)
+	needRestart, err := a.inboundService.WithActor(auditActor(c)).ResetClientTraffic(id, email)
 	if err != nil {
 		jsonMsg(c, I18nWeb(c, "somethingWentWrong"), err)
 		return
@@ -200,7 +270,7 @@ func (a *InboundController) resetClientTraffic(c *gin.Context) {
 
 // resetAllTraffics resets all traffic counters across all inbounds.
 func (a *InboundController) resetAllTraffics(c *gin.Context) {
-	err := a.inboundService.ResetAllTraffics()
+	err := a.inboundService.WithActor(auditActor(c)).ResetAllTraffics()
 	if err != nil {
 		jsonMsg(c, I18nWeb(c, "somethingWentWrong"), err)
 		return
@@ -218,7 +288,7 @@ func (a *InboundController) resetAllClientTraffics(c *gin.Context) {
 		return
 	}
 
-	err = a.inboundService.ResetAllClientTraffics(id)
+	err = a.inboundService.WithActor(auditActor(c)).ResetAllClientTraffics(id)
 	if err != nil {
 		jsonMsg(c, I18nWeb(c, "somethingWentWrong"), err)
 		return
@@ -236,7 +306,7 @@ func (a *InboundController) importInbound(c *gin.Context) {
 		jsonMsg(c, I18nWeb(c, "somethingWentWrong"), err)
 		return
 	}
-	user := session.GetLoginUser(c)
+	user := getLoginUser(c)
 	inbound.Id = 0
 	inbound.UserId = user.Id
 	if inbound.Listen == "" || inbound.Listen == "0.0.0.0" || inbound.Listen == "::" || inbound.Listen == "::0" {
@@ -251,7 +321,7 @@ func (a *InboundController) importInbound(c *gin.Context) {
 	}
 
 	needRestart := false
-	inbound, needRestart, err = a.inboundService.AddInbound(inbound)
+	inbound, needRestart, err = a.inboundService.WithActor(auditActor(c)).AddInbound(inbound)
 	jsonMsgObj(c, I18nWeb(c, "pages.inbounds.toasts.inboundCreateSuccess"), inbound, err)
 	if err == nil && needRestart {
 		a.xrayService.SetToNeedRestart()
@@ -265,7 +335,10 @@ func (a *InboundController) delDepletedClients(c *gin.Context) {
 		jsonMsg(c, I18nWeb(c, "pages.inbounds.toasts.inboundUpdateSuccess"), err)
 		return
 	}
-	err = a.inboundService.DelDepletedClients(id)
+	if !a.checkInboundAccess(c, id) {
+		return
+	}
+	err = a.inboundService.WithActor(auditActor(c)).DelDepletedClients(id)
 	if err != nil {
 		jsonMsg(c, I18nWeb(c, "somethingWentWrong"), err)
 		return
@@ -275,12 +348,23 @@ func (a *InboundController) delDepletedClients(c *gin.Context) {
 
 // onlines retrieves the list of currently online clients.
 func (a *InboundController) onlines(c *gin.Context) {
-	jsonObj(c, a.inboundService.GetOnlineClients(), nil)
+	onlines := a.inboundService.GetOnlineClients()
+	if emails := a.scopedEmails(c); emails != nil {
+		onlines = slices.DeleteFunc(slices.Clone(onlines), func(email string) bool {
+			return !emails[email]
+		})
+	}
+	jsonObj(c, onlines, nil)
 }
 
 // lastOnline retrieves the last online timestamps for clients.
 func (a *InboundController) lastOnline(c *gin.Context) {
 	data, err := a.inboundService.GetClientsLastOnline()
+	if emails := a.scopedEmails(c); emails != nil {
+		maps.DeleteFunc(data, func(email string, _ int64) bool {
+			return !emails[email]
+		})
+	}
 	jsonObj(c, data, err)
 }
 
@@ -301,7 +385,7 @@ func (a *InboundController) updateClientTraffic(c *gin.Context) {
 		return
 	}
 
-	err = a.inboundService.UpdateClientTrafficByEmail(email, request.Upload, request.Download)
+	err = a.inboundService.WithActor(auditActor(c)).UpdateClientTrafficByEmail(email, request.Upload, request.Download)
 	if err != nil {
 		jsonMsg(c, I18nWeb(c, "somethingWentWrong"), err)
 		return
@@ -319,7 +403,10 @@ func (a *InboundController) delInboundClientByEmail(c *gin.Context) {
 	}
 
 	email := c.Param("email")
-	needRestart, err := a.inboundService.DelInboundClientByEmail(inboundId, email)
+	if !a.checkInboundAccess(c, inboundId) {
+		return
+	}
+	needRestart, err := a.inboundService.WithActor(auditActor(c)).DelInboundClientByEmail(inboundId, email)
 	if err != nil {
 		jsonMsg(c, "Failed to delete client by email", err)
 		return
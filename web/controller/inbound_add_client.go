@@ -34,7 +34,7 @@ func (a *InboundController) addInboundClient(c *gin.Context) {
 	data := &model.Inbound{Id: dto.Id}
 	data.SetSettingsString(dto.Settings)
 
-	needRestart, err := a.inboundService.AddInboundClient(data)
+	needRestart, err := a.inboundService.WithActor(auditActor(c)).AddInboundClient(data)
 	if err != nil {
 		jsonMsg(c, I18nWeb(c, "somethingWentWrong"), err)
 		return
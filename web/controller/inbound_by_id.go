@@ -12,6 +12,9 @@ func (a *InboundController) getInbound(c *gin.Context) {
 		jsonMsg(c, I18nWeb(c, "somethingWentWrong"), err)
 		return
 	}
+	if !a.checkInboundAccess(c, id) {
+		return
+	}
 
 	inbound, err := a.inboundService.GetInbound(id)
 	if err != nil {
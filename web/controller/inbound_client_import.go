@@ -0,0 +1,101 @@
+package controller
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mhsanaei/3x-ui/v2/util/common"
+	"github.com/mhsanaei/3x-ui/v2/web/service"
+
+	"github.com/gin-gonic/gin"
+)
+
+// maxImportSize caps the size of an uploaded client import.
+const maxImportSize = 16 << 20
+
+// exportClients sends the clients that match the "inboundId", "enable" and "comment"
+// queries as a CSV file, or as JSON when the "format" query is "json".
+func (a *InboundController) exportClients(c *gin.Context) {
+	var filter service.ClientFilter
+	if err := c.ShouldBindQuery(&filter); err != nil {
+		jsonMsg(c, I18nWeb(c, "somethingWentWrong"), err)
+		return
+	}
+	format := c.DefaultQuery("format", service.ClientFormatCSV)
+	if format != service.ClientFormatCSV && format != service.ClientFormatJSON {
+		jsonMsg(c, I18nWeb(c, "somethingWentWrong"), common.NewError("unknown format:", format))
+		return
+	}
+	records, err := a.inboundService.ExportClients(&filter)
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "somethingWentWrong"), err)
+		return
+	}
+
+	var buf bytes.Buffer
+	if err := service.WriteClientRecords(&buf, format, records); err != nil {
+		jsonMsg(c, I18nWeb(c, "somethingWentWrong"), err)
+		return
+	}
+	contentType := "text/csv; charset=utf-8"
+	if format == service.ClientFormatJSON {
+		contentType = "application/json"
+	}
+	filename := "clients-" + time.Now().Format("20060102-150405") + "." + format
+	c.Header("Content-Disposition", "attachment; filename="+filename)
+	c.Data(http.StatusOK, contentType, buf.Bytes())
+}
+
+// importClients imports clients from the uploaded "file", or from the "data" field,
+// in the "format" field or the one its file name ends with. The "mode" field decides
+// what happens to clients whose email is taken, and with "dryRun" nothing is changed.
+// The report lists what the import did or would do.
+func (a *InboundController) importClients(c *gin.Context) {
+	var data []byte
+	format := strings.ToLower(c.PostForm("format"))
+	if file, err := c.FormFile("file"); err == nil {
+		if file.Size > maxImportSize {
+			jsonMsg(c, I18nWeb(c, "somethingWentWrong"), common.NewError("file is too large:", file.Filename))
+			return
+		}
+		if format == "" {
+			format = strings.TrimPrefix(strings.ToLower(filepath.Ext(file.Filename)), ".")
+		}
+		f, err := file.Open()
+		if err != nil {
+			jsonMsg(c, I18nWeb(c, "somethingWentWrong"), err)
+			return
+		}
+		defer f.Close()
+		if data, err = io.ReadAll(io.LimitReader(f, maxImportSize)); err != nil {
+			jsonMsg(c, I18nWeb(c, "somethingWentWrong"), err)
+			return
+		}
+	} else {
+		data = []byte(c.PostForm("data"))
+	}
+	if format == "" {
+		format = service.ClientFormatCSV
+	}
+	dryRun, _ := strconv.ParseBool(c.PostForm("dryRun"))
+
+	records, err := service.ParseClientRecords(bytes.NewReader(data), format)
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "somethingWentWrong"), err)
+		return
+	}
+	report, needRestart, err := a.inboundService.WithActor(auditActor(c)).ImportClients(records, c.PostForm("mode"), dryRun)
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "somethingWentWrong"), err)
+		return
+	}
+	jsonObj(c, report, nil)
+	if needRestart {
+		a.xrayService.SetToNeedRestart()
+	}
+}
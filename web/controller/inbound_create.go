@@ -8,7 +8,6 @@ import (
 
 	"github.com/mhsanaei/3x-ui/v2/database/model"
 	"github.com/mhsanaei/3x-ui/v2/pkg/x/xs"
-	"github.com/mhsanaei/3x-ui/v2/web/session"
 	"github.com/mhsanaei/3x-ui/v2/web/websocket"
 )
 
@@ -21,6 +20,8 @@ type InboundCreateDTO struct {
 	ExpiryTime           int64          `form:"expiryTime" json:"expiryTime"`
 	TrafficReset         string         `form:"trafficReset" json:"trafficReset"`
 	LastTrafficResetTime int64          `form:"lastTrafficResetTime" json:"lastTrafficResetTime"`
+	SpeedLimitUp         int64          `form:"speedLimitUp" json:"speedLimitUp"`
+	SpeedLimitDown       int64          `form:"speedLimitDown" json:"speedLimitDown"`
 	Listen               string         `form:"listen" json:"listen"`
 	Port                 int            `form:"port" json:"port"`
 	Protocol             model.Protocol `form:"protocol" json:"protocol"`
@@ -54,7 +55,7 @@ func (a *InboundController) addInbound(c *gin.Context) {
 		return
 	}
 
-	user := session.GetLoginUser(c)
+	user := getLoginUser(c)
 
 	inbound := &model.Inbound{
 		UserId:               user.Id,
@@ -67,6 +68,8 @@ func (a *InboundController) addInbound(c *gin.Context) {
 		ExpiryTime:           dto.ExpiryTime,
 		TrafficReset:         dto.TrafficReset,
 		LastTrafficResetTime: dto.LastTrafficResetTime,
+		SpeedLimitUp:         dto.SpeedLimitUp,
+		SpeedLimitDown:       dto.SpeedLimitDown,
 		ClientStats:          nil,
 		Listen:               dto.Listen,
 		Port:                 dto.Port,
@@ -85,7 +88,7 @@ func (a *InboundController) addInbound(c *gin.Context) {
 		inbound.Tag = fmt.Sprintf("inbound-%v:%v", inbound.Listen, inbound.Port)
 	}
 
-	created, needRestart, err := a.inboundService.AddInbound(inbound)
+	created, needRestart, err := a.inboundService.WithActor(auditActor(c)).AddInbound(inbound)
 	if err != nil {
 		jsonMsg(c, I18nWeb(c, "somethingWentWrong"), err)
 		return
@@ -96,7 +99,7 @@ func (a *InboundController) addInbound(c *gin.Context) {
 		a.xrayService.SetToNeedRestart()
 	}
 
-	inbounds, _ := a.inboundService.GetInbounds(user.Id)
+	inbounds, _ := a.inboundService.GetAllInbounds()
 	websocket.BroadcastInbounds(xs.Map(inbounds, func(item *model.Inbound, index int) InboundDTO {
 		return toInboundDTOPtr(item)
 	}))
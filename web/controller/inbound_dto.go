@@ -18,6 +18,8 @@ type InboundDTO struct {
 	TrafficReset         string               `json:"trafficReset"`
 	LastTrafficResetTime int64                `json:"lastTrafficResetTime"`
 	ClientStats          []xray.ClientTraffic `json:"clientStats"`
+	SpeedLimitUp         int64                `json:"speedLimitUp"`
+	SpeedLimitDown       int64                `json:"speedLimitDown"`
 
 	Listen   string         `json:"listen"`
 	Port     int            `json:"port"`
@@ -45,6 +47,8 @@ func toInboundDTO(in model.Inbound) InboundDTO {
 		TrafficReset:         in.TrafficReset,
 		LastTrafficResetTime: in.LastTrafficResetTime,
 		ClientStats:          in.ClientStats,
+		SpeedLimitUp:         in.SpeedLimitUp,
+		SpeedLimitDown:       in.SpeedLimitDown,
 		Listen:               in.Listen,
 		Port:                 in.Port,
 		Protocol:             in.Protocol,
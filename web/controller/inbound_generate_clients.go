@@ -0,0 +1,33 @@
+package controller
+
+import (
+	"github.com/gin-gonic/gin"
+
+	"github.com/mhsanaei/3x-ui/v2/web/service"
+)
+
+// generateClients creates clients on one or more inbounds from a template and
+// returns them with their subscription URLs.
+func (a *InboundController) generateClients(c *gin.Context) {
+	var batch service.ClientBatch
+	if err := c.ShouldBind(&batch); err != nil {
+		jsonMsg(c, I18nWeb(c, "somethingWentWrong"), err)
+		return
+	}
+	for _, id := range batch.InboundIds {
+		if !a.checkInboundAccess(c, id) {
+			return
+		}
+	}
+
+	clients, needRestart, err := a.inboundService.WithActor(auditActor(c)).GenerateClients(&batch, c.Request.Host)
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "somethingWentWrong"), err)
+		return
+	}
+	jsonMsgObj(c, I18nWeb(c, "pages.inbounds.toasts.inboundClientAddSuccess"), clients, nil)
+
+	if needRestart {
+		a.xrayService.SetToNeedRestart()
+	}
+}
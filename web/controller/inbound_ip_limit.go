@@ -0,0 +1,29 @@
+package controller
+
+import (
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// getIPLimitBans lists the clients the panel blocked for exceeding their IP limit,
+// only the blocks in force when "active" is set.
+func (a *InboundController) getIPLimitBans(c *gin.Context) {
+	active, _ := strconv.ParseBool(c.Query("active"))
+	bans, err := a.inboundService.GetIPLimitBans(active)
+	jsonObj(c, bans, err)
+}
+
+// liftIPLimitBan lifts a block before its cooldown ends.
+func (a *InboundController) liftIPLimitBan(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "somethingWentWrong"), err)
+		return
+	}
+	needRestart, err := a.inboundService.WithActor(auditActor(c)).LiftIPLimitBan(id)
+	jsonMsg(c, "IP limit ban lifted", err)
+	if err == nil && needRestart {
+		a.xrayService.SetToNeedRestart()
+	}
+}
@@ -1,16 +1,22 @@
 package controller
 
 import (
-	"github.com/gin-gonic/gin"
+	"github.com/mhsanaei/3x-ui/v2/database/model"
 
-	"github.com/mhsanaei/3x-ui/v2/web/session"
+	"github.com/gin-gonic/gin"
 )
 
-// getInbounds retrieves the list of inbounds for the logged-in user.
+// getInbounds retrieves the list of inbounds. Panel users share all inbounds
+// regardless of which account created them; access is limited by role, and
+// resellers only see the inbounds assigned to them.
 func (a *InboundController) getInbounds(c *gin.Context) {
-	user := session.GetLoginUser(c)
-
-	inbounds, err := a.inboundService.GetInbounds(user.Id)
+	var inbounds []*model.Inbound
+	var err error
+	if userId := scopedUserId(c); userId > 0 {
+		inbounds, err = a.inboundService.GetInbounds(userId)
+	} else {
+		inbounds, err = a.inboundService.GetAllInbounds()
+	}
 	if err != nil {
 		jsonMsg(c, I18nWeb(c, "pages.inbounds.toasts.obtain"), err)
 		return
@@ -0,0 +1,55 @@
+package controller
+
+import (
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// moveClient moves a client to the inbound in the "inboundId" field, keeping its
+// identity and traffic counters.
+func (a *InboundController) moveClient(c *gin.Context) {
+	email := c.Param("email")
+	inboundId, err := strconv.Atoi(c.PostForm("inboundId"))
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "somethingWentWrong"), err)
+		return
+	}
+	if !a.checkClientAccess(c, email) || !a.checkInboundAccess(c, inboundId) {
+		return
+	}
+
+	client, needRestart, err := a.inboundService.WithActor(auditActor(c)).MoveClient(email, inboundId)
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "somethingWentWrong"), err)
+		return
+	}
+	jsonMsgObj(c, "Client moved successfully", client, nil)
+	if needRestart {
+		a.xrayService.SetToNeedRestart()
+	}
+}
+
+// copyClient copies a client to the inbound in the "inboundId" field under the email
+// in the "newEmail" field.
+func (a *InboundController) copyClient(c *gin.Context) {
+	email := c.Param("email")
+	inboundId, err := strconv.Atoi(c.PostForm("inboundId"))
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "somethingWentWrong"), err)
+		return
+	}
+	if !a.checkClientAccess(c, email) || !a.checkInboundAccess(c, inboundId) {
+		return
+	}
+
+	client, needRestart, err := a.inboundService.WithActor(auditActor(c)).CopyClient(email, inboundId, c.PostForm("newEmail"))
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "somethingWentWrong"), err)
+		return
+	}
+	jsonMsgObj(c, "Client copied successfully", client, nil)
+	if needRestart {
+		a.xrayService.SetToNeedRestart()
+	}
+}
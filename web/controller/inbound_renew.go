@@ -0,0 +1,44 @@
+package controller
+
+import (
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// renewClient renews a client with the plan in the "planId" field, or with its
+// current plan when there is none.
+func (a *InboundController) renewClient(c *gin.Context) {
+	email := c.Param("email")
+	if !a.checkClientAccess(c, email) {
+		return
+	}
+	planId := 0
+	if value := c.PostForm("planId"); value != "" {
+		var err error
+		if planId, err = strconv.Atoi(value); err != nil {
+			jsonMsg(c, I18nWeb(c, "somethingWentWrong"), err)
+			return
+		}
+	}
+
+	renewal, needRestart, err := a.inboundService.WithActor(auditActor(c)).RenewClient(email, planId)
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "somethingWentWrong"), err)
+		return
+	}
+	jsonMsgObj(c, "Client renewed successfully", renewal, nil)
+	if needRestart {
+		a.xrayService.SetToNeedRestart()
+	}
+}
+
+// getRenewals returns the renewal history of a client.
+func (a *InboundController) getRenewals(c *gin.Context) {
+	email := c.Param("email")
+	if !a.checkClientAccess(c, email) {
+		return
+	}
+	renewals, err := a.planService.GetRenewals(email)
+	jsonObj(c, renewals, err)
+}
@@ -20,6 +20,8 @@ type InboundUpdateDTO struct {
 	ExpiryTime           int64          `json:"expiryTime" form:"expiryTime"`
 	TrafficReset         string         `json:"trafficReset" form:"trafficReset"`
 	LastTrafficResetTime int64          `json:"lastTrafficResetTime" form:"lastTrafficResetTime"`
+	SpeedLimitUp         int64          `form:"speedLimitUp" json:"speedLimitUp"`
+	SpeedLimitDown       int64          `form:"speedLimitDown" json:"speedLimitDown"`
 	Listen               string         `json:"listen" form:"listen"`
 	Port                 int            `json:"port" form:"port"`
 	Protocol             model.Protocol `json:"protocol" form:"protocol"`
@@ -65,6 +67,8 @@ func bindInboundUpdate(c *gin.Context, id int) (*model.Inbound, error) {
 		ExpiryTime:           dto.ExpiryTime,
 		TrafficReset:         dto.TrafficReset,
 		LastTrafficResetTime: dto.LastTrafficResetTime,
+		SpeedLimitUp:         dto.SpeedLimitUp,
+		SpeedLimitDown:       dto.SpeedLimitDown,
 		Listen:               dto.Listen,
 		Port:                 dto.Port,
 		Protocol:             dto.Protocol,
@@ -91,7 +95,7 @@ func (a *InboundController) updateInbound(c *gin.Context) {
 		return
 	}
 
-	updated, needRestart, err := a.inboundService.UpdateInbound(inbound)
+	updated, needRestart, err := a.inboundService.WithActor(auditActor(c)).UpdateInbound(inbound)
 	if err != nil {
 		jsonMsg(c, I18nWeb(c, "somethingWentWrong"), err)
 		return
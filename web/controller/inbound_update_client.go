@@ -36,7 +36,7 @@ func (a *InboundController) updateInboundClient(c *gin.Context) {
 	inbound := &model.Inbound{Id: dto.Id}
 	inbound.SetSettingsString(dto.Settings)
 
-	needRestart, err := a.inboundService.UpdateInboundClient(inbound, clientId)
+	needRestart, err := a.inboundService.WithActor(auditActor(c)).UpdateInboundClient(inbound, clientId)
 	if err != nil {
 		jsonMsg(c, I18nWeb(c, "somethingWentWrong"), err)
 		return
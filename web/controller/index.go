@@ -1,11 +1,17 @@
 package controller
 
 import (
+	"errors"
+	"net"
 	"net/http"
+	"strings"
 	"text/template"
 	"time"
 
+	"github.com/mhsanaei/3x-ui/v2/database/model"
 	"github.com/mhsanaei/3x-ui/v2/logger"
+	"github.com/mhsanaei/3x-ui/v2/util/common"
+	"github.com/mhsanaei/3x-ui/v2/web/entity"
 	"github.com/mhsanaei/3x-ui/v2/web/service"
 	"github.com/mhsanaei/3x-ui/v2/web/session"
 
@@ -26,6 +32,8 @@ type IndexController struct {
 
 	settingService service.SettingService
 	userService    service.UserService
+	oidcService    service.OIDCService
+	webAuthn       service.WebAuthnService
 	tgbot          service.Tgbot
 }
 
@@ -43,6 +51,10 @@ func (a *IndexController) initRouter(g *gin.RouterGroup) {
 
 	g.POST("/login", a.login)
 	g.POST("/getTwoFactorEnable", a.getTwoFactorEnable)
+	g.GET("/oidc/login", a.oidcLogin)
+	g.GET("/oidc/callback", a.oidcCallback)
+	g.POST("/webauthn/login/begin", a.webauthnLoginBegin)
+	g.POST("/webauthn/login/finish", a.webauthnLoginFinish)
 }
 
 // index handles the root route, redirecting logged-in users to the panel or showing the login page.
@@ -51,7 +63,7 @@ func (a *IndexController) index(c *gin.Context) {
 		c.Redirect(http.StatusTemporaryRedirect, "panel/")
 		return
 	}
-	html(c, "login.html", "pages.login.title", nil)
+	html(c, "login.html", "pages.login.title", gin.H{"oidc_enable": a.oidcService.IsEnabled()})
 }
 
 // login handles user authentication and session creation.
@@ -71,11 +83,25 @@ func (a *IndexController) login(c *gin.Context) {
 		return
 	}
 
-	user := a.userService.CheckUser(form.Username, form.Password, form.TwoFactorCode)
+	user, err := a.userService.CheckUser(form.Username, form.Password, form.TwoFactorCode, getRemoteIp(c))
 	timeStr := time.Now().Format("2006-01-02 15:04:05")
 	safeUser := template.HTMLEscapeString(form.Username)
 	safePass := template.HTMLEscapeString(form.Password)
 
+	var lockedErr *service.LoginLockedError
+	if errors.As(err, &lockedErr) {
+		logger.Warningf("login locked for username: \"%s\", IP: \"%s\"", safeUser, getRemoteIp(c))
+		pureJsonMsg(c, http.StatusOK, false, lockedErr.Error())
+		return
+	}
+	if errors.Is(err, service.ErrSecurityKeyRequired) {
+		c.JSON(http.StatusOK, entity.Msg{
+			Success: false,
+			Msg:     I18nWeb(c, "pages.login.toasts.securityKeyRequired"),
+			Obj:     gin.H{"securityKey": true},
+		})
+		return
+	}
 	if user == nil {
 		logger.Warningf("wrong username: \"%s\", password: \"%s\", IP: \"%s\"", safeUser, safePass, getRemoteIp(c))
 		a.tgbot.UserLoginNotify(safeUser, safePass, getRemoteIp(c), timeStr, 0)
@@ -86,6 +112,17 @@ func (a *IndexController) login(c *gin.Context) {
 	logger.Infof("%s logged in successfully, Ip Address: %s\n", safeUser, getRemoteIp(c))
 	a.tgbot.UserLoginNotify(safeUser, ``, getRemoteIp(c), timeStr, 1)
 
+	if err := a.startSession(c, user); err != nil {
+		logger.Warning("Unable to save session: ", err)
+		return
+	}
+
+	logger.Infof("%s logged in successfully", safeUser)
+	jsonMsg(c, I18nWeb(c, "pages.login.toasts.successLogin"), nil)
+}
+
+// startSession stores user in the session cookie with the configured lifetime.
+func (a *IndexController) startSession(c *gin.Context, user *model.User) error {
 	sessionMaxAge, err := a.settingService.GetSessionMaxAge()
 	if err != nil {
 		logger.Warning("Unable to get session's max age from DB")
@@ -93,15 +130,158 @@ func (a *IndexController) login(c *gin.Context) {
 
 	session.SetMaxAge(c, sessionMaxAge*60)
 	session.SetLoginUser(c, user)
+	return sessions.Default(c).Save()
+}
+
+// webauthnLoginBegin starts a security key login. With a username and password the key
+// is the second factor of that account, without them any passkey may sign in.
+func (a *IndexController) webauthnLoginBegin(c *gin.Context) {
+	var form LoginForm
+	if err := c.ShouldBind(&form); err != nil {
+		pureJsonMsg(c, http.StatusOK, false, I18nWeb(c, "pages.login.toasts.invalidFormData"))
+		return
+	}
+
+	var user *model.User
+	if form.Username != "" {
+		var err error
+		user, err = a.userService.CheckPassword(form.Username, form.Password, getRemoteIp(c))
+		var lockedErr *service.LoginLockedError
+		if errors.As(err, &lockedErr) {
+			logger.Warningf("login locked for username: \"%s\", IP: \"%s\"", template.HTMLEscapeString(form.Username), getRemoteIp(c))
+			pureJsonMsg(c, http.StatusOK, false, lockedErr.Error())
+			return
+		}
+		if user == nil {
+			safeUser := template.HTMLEscapeString(form.Username)
+			logger.Warningf("wrong username: \"%s\", IP: \"%s\"", safeUser, getRemoteIp(c))
+			a.tgbot.UserLoginNotify(safeUser, template.HTMLEscapeString(form.Password), getRemoteIp(c), time.Now().Format("2006-01-02 15:04:05"), 0)
+			pureJsonMsg(c, http.StatusOK, false, I18nWeb(c, "pages.login.toasts.wrongUsernameOrPassword"))
+			return
+		}
+	}
+
+	assertion, state, err := a.webAuthn.BeginLogin(user, webauthnOrigin(c))
+	if err != nil {
+		pureJsonMsg(c, http.StatusOK, false, I18nWeb(c, "pages.login.toasts.securityKeyFailed")+" ("+strings.TrimSpace(err.Error())+")")
+		return
+	}
+	session.SetWebAuthnSession(c, state)
 	if err := sessions.Default(c).Save(); err != nil {
 		logger.Warning("Unable to save session: ", err)
 		return
 	}
+	jsonObj(c, assertion, nil)
+}
 
-	logger.Infof("%s logged in successfully", safeUser)
+// webauthnLoginFinish verifies the signed assertion of a security key and logs the user in.
+func (a *IndexController) webauthnLoginFinish(c *gin.Context) {
+	state := session.PopWebAuthnSession(c)
+	user, err := a.webAuthn.FinishLogin(webauthnOrigin(c), state, c.PostForm("credential"))
+	timeStr := time.Now().Format("2006-01-02 15:04:05")
+	if err != nil {
+		logger.Warningf("security key login failed, IP: \"%s\": %v", getRemoteIp(c), err)
+		if err := sessions.Default(c).Save(); err != nil {
+			logger.Warning("Unable to save session: ", err)
+		}
+		pureJsonMsg(c, http.StatusOK, false, I18nWeb(c, "pages.login.toasts.securityKeyFailed"))
+		return
+	}
+
+	safeUser := template.HTMLEscapeString(user.Username)
+	logger.Infof("%s logged in successfully with a security key, Ip Address: %s\n", safeUser, getRemoteIp(c))
+	a.tgbot.UserLoginNotify(safeUser, ``, getRemoteIp(c), timeStr, 1)
+
+	if err := a.startSession(c, user); err != nil {
+		logger.Warning("Unable to save session: ", err)
+		return
+	}
 	jsonMsg(c, I18nWeb(c, "pages.login.toasts.successLogin"), nil)
 }
 
+// oidcLogin starts a single sign-on login by redirecting to the identity provider.
+func (a *IndexController) oidcLogin(c *gin.Context) {
+	authUrl, login, err := a.oidcService.BeginLogin(c.Request.Context(), oidcRedirectUrl(c))
+	if err != nil {
+		logger.Warning("OIDC login failed:", err)
+		a.oidcFailed(c, err)
+		return
+	}
+	session.SetOIDCLogin(c, login.State, login.Verifier, login.Nonce)
+	if err := sessions.Default(c).Save(); err != nil {
+		logger.Warning("Unable to save session: ", err)
+		a.oidcFailed(c, err)
+		return
+	}
+	c.Redirect(http.StatusFound, authUrl)
+}
+
+// oidcCallback completes a single sign-on login when the identity provider redirects back.
+func (a *IndexController) oidcCallback(c *gin.Context) {
+	state, verifier, nonce := session.PopOIDCLogin(c)
+	if errParam := c.Query("error"); errParam != "" {
+		a.oidcFailed(c, common.NewError(errParam, c.Query("error_description")))
+		return
+	}
+	login := &service.OIDCLogin{State: state, Verifier: verifier, Nonce: nonce}
+	// A logged-in user coming back links the identity to their account
+	linkUserId := 0
+	if current := session.GetLoginUser(c); current != nil {
+		linkUserId = current.Id
+	}
+	user, err := a.oidcService.FinishLogin(c.Request.Context(), oidcRedirectUrl(c), login, c.Query("state"), c.Query("code"), linkUserId)
+	timeStr := time.Now().Format("2006-01-02 15:04:05")
+	if err != nil {
+		logger.Warningf("OIDC login failed, IP: \"%s\": %v", getRemoteIp(c), err)
+		a.oidcFailed(c, err)
+		return
+	}
+	if linkUserId > 0 {
+		if err := sessions.Default(c).Save(); err != nil {
+			logger.Warning("Unable to save session: ", err)
+		}
+		c.Redirect(http.StatusFound, c.GetString("base_path")+"panel/settings")
+		return
+	}
+
+	safeUser := template.HTMLEscapeString(user.Username)
+	logger.Infof("%s logged in successfully via OIDC, Ip Address: %s\n", safeUser, getRemoteIp(c))
+	a.tgbot.UserLoginNotify(safeUser, ``, getRemoteIp(c), timeStr, 1)
+
+	if err := a.startSession(c, user); err != nil {
+		logger.Warning("Unable to save session: ", err)
+		a.oidcFailed(c, err)
+		return
+	}
+	c.Redirect(http.StatusFound, c.GetString("base_path")+"panel/")
+}
+
+// oidcFailed shows the login page with the reason a single sign-on login failed.
+func (a *IndexController) oidcFailed(c *gin.Context, err error) {
+	if err := sessions.Default(c).Save(); err != nil {
+		logger.Warning("Unable to save session: ", err)
+	}
+	html(c, "login.html", "pages.login.title", gin.H{
+		"oidc_enable": a.oidcService.IsEnabled(),
+		"oidc_error":  strings.TrimSpace(err.Error()),
+	})
+}
+
+// oidcRedirectUrl returns the callback URL of this panel as seen by the browser.
+func oidcRedirectUrl(c *gin.Context) string {
+	return requestOrigin(c) + c.GetString("base_path") + "oidc/callback"
+}
+
+// webauthnOrigin returns the WebAuthn relying party of this panel as seen by the browser.
+func webauthnOrigin(c *gin.Context) service.WebAuthnOrigin {
+	origin := requestOrigin(c)
+	rpId := strings.TrimPrefix(strings.TrimPrefix(origin, "https://"), "http://")
+	if host, _, err := net.SplitHostPort(rpId); err == nil {
+		rpId = host
+	}
+	return service.WebAuthnOrigin{RPID: strings.Trim(rpId, "[]"), Origin: origin}
+}
+
 // logout handles user logout by clearing the session and redirecting to the login page.
 func (a *IndexController) logout(c *gin.Context) {
 	user := session.GetLoginUser(c)
@@ -115,9 +295,10 @@ func (a *IndexController) logout(c *gin.Context) {
 	c.Redirect(http.StatusTemporaryRedirect, c.GetString("base_path"))
 }
 
-// getTwoFactorEnable retrieves the current status of two-factor authentication.
+// getTwoFactorEnable reports whether any user enrolled two-factor, so the login page shows the code field.
+// It does not tell which accounts use it.
 func (a *IndexController) getTwoFactorEnable(c *gin.Context) {
-	status, err := a.settingService.GetTwoFactorEnable()
+	status, err := a.userService.HasTwoFactorUsers()
 	if err == nil {
 		jsonObj(c, status, nil)
 	}
@@ -0,0 +1,72 @@
+package controller
+
+import (
+	"bytes"
+	"net/http"
+
+	"github.com/mhsanaei/3x-ui/v2/database/model"
+	"github.com/mhsanaei/3x-ui/v2/logger"
+	"github.com/mhsanaei/3x-ui/v2/web/service"
+
+	"github.com/gin-gonic/gin"
+)
+
+// metricsContentType is the content type of the Prometheus text exposition format.
+const metricsContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// MetricsController serves Prometheus metrics on the panel, to callers with an API
+// token or a session that may view everything.
+type MetricsController struct {
+	metricsService service.MetricsService
+	settingService service.SettingService
+}
+
+// NewMetricsController creates a new MetricsController and initializes its routes.
+func NewMetricsController(g *gin.RouterGroup) *MetricsController {
+	a := &MetricsController{}
+	a.initRouter(g)
+	return a
+}
+
+// initRouter sets up the route for metrics.
+func (a *MetricsController) initRouter(g *gin.RouterGroup) {
+	g.GET("", a.checkEnabled, checkPermission(model.PermView), checkNotScoped, a.metrics)
+}
+
+// checkEnabled answers 404 while metrics are disabled in the settings.
+func (a *MetricsController) checkEnabled(c *gin.Context) {
+	if enabled, err := a.settingService.GetMetricsEnable(); err != nil || !enabled {
+		c.AbortWithStatus(http.StatusNotFound)
+		return
+	}
+	c.Next()
+}
+
+// metrics writes all metrics.
+func (a *MetricsController) metrics(c *gin.Context) {
+	writeMetrics(c.Writer, &a.metricsService)
+}
+
+// writeMetrics renders the metrics of metricsService to w, or a 500 if that fails.
+func writeMetrics(w http.ResponseWriter, metricsService *service.MetricsService) {
+	var buf bytes.Buffer
+	if err := metricsService.WriteMetrics(&buf); err != nil {
+		logger.Warning("write metrics failed:", err)
+		http.Error(w, "metrics unavailable", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", metricsContentType)
+	w.WriteHeader(http.StatusOK)
+	w.Write(buf.Bytes())
+}
+
+// MetricsHandler returns a handler that serves the metrics at /metrics without
+// authentication, for the metrics port of its own.
+func MetricsHandler() http.Handler {
+	metricsService := &service.MetricsService{}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
+		writeMetrics(w, metricsService)
+	})
+	return mux
+}
@@ -0,0 +1,102 @@
+package controller
+
+import (
+	"strconv"
+
+	"github.com/mhsanaei/3x-ui/v2/database/model"
+	"github.com/mhsanaei/3x-ui/v2/web/service"
+
+	"github.com/gin-gonic/gin"
+)
+
+// PlanController handles service plans. Every user may read them to renew their
+// clients; only users not limited to their own inbounds may change them.
+type PlanController struct {
+	planService service.PlanService
+}
+
+// NewPlanController creates a new PlanController and initializes its routes.
+func NewPlanController(g *gin.RouterGroup) *PlanController {
+	a := &PlanController{}
+	a.initRouter(g)
+	return a
+}
+
+// initRouter sets up the routes for plans.
+func (a *PlanController) initRouter(g *gin.RouterGroup) {
+	view := checkPermission(model.PermView)
+	manageClients := checkPermission(model.PermClientManage)
+
+	g.GET("/list", view, a.getPlans)
+	g.GET("/get/:id", view, a.getPlan)
+
+	g.POST("/add", manageClients, checkNotScoped, a.addPlan)
+	g.POST("/update/:id", manageClients, checkNotScoped, a.updatePlan)
+	g.POST("/del/:id", manageClients, checkNotScoped, a.delPlan)
+}
+
+// getPlans lists all plans, or the ones offered on the inbound in the "inboundId" query.
+func (a *PlanController) getPlans(c *gin.Context) {
+	if c.Query("inboundId") == "" {
+		plans, err := a.planService.GetPlans()
+		jsonObj(c, plans, err)
+		return
+	}
+	inboundId, err := strconv.Atoi(c.Query("inboundId"))
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "somethingWentWrong"), err)
+		return
+	}
+	plans, err := a.planService.GetInboundPlans(inboundId)
+	jsonObj(c, plans, err)
+}
+
+// getPlan returns a plan.
+func (a *PlanController) getPlan(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "somethingWentWrong"), err)
+		return
+	}
+	plan, err := a.planService.GetPlan(id)
+	jsonObj(c, plan, err)
+}
+
+// addPlan creates a plan.
+func (a *PlanController) addPlan(c *gin.Context) {
+	plan := &model.Plan{}
+	if err := c.ShouldBind(plan); err != nil {
+		jsonMsg(c, I18nWeb(c, "somethingWentWrong"), err)
+		return
+	}
+	err := a.planService.AddPlan(plan)
+	jsonMsgObj(c, "Plan created successfully", plan, err)
+}
+
+// updatePlan changes a plan.
+func (a *PlanController) updatePlan(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "somethingWentWrong"), err)
+		return
+	}
+	plan := &model.Plan{}
+	if err := c.ShouldBind(plan); err != nil {
+		jsonMsg(c, I18nWeb(c, "somethingWentWrong"), err)
+		return
+	}
+	plan.Id = id
+	err = a.planService.UpdatePlan(plan)
+	jsonMsgObj(c, "Plan updated successfully", plan, err)
+}
+
+// delPlan deletes a plan, keeping the limits of its clients.
+func (a *PlanController) delPlan(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "somethingWentWrong"), err)
+		return
+	}
+	err = a.planService.DelPlan(id)
+	jsonMsg(c, "Plan deleted successfully", err)
+}
@@ -0,0 +1,97 @@
+package controller
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/mhsanaei/3x-ui/v2/database/model"
+	"github.com/mhsanaei/3x-ui/v2/web/service"
+
+	"github.com/gin-gonic/gin"
+)
+
+// RecycleBinController handles deleted clients and inbounds kept in the recycle bin.
+// Entries of any inbound are listed, so users limited to their own inbounds can not
+// use it. Inbound entries need the permission to manage inbounds.
+type RecycleBinController struct {
+	recycleBinService service.RecycleBinService
+	inboundService    service.InboundService
+	xrayService       service.XrayService
+}
+
+// NewRecycleBinController creates a new RecycleBinController and initializes its routes.
+func NewRecycleBinController(g *gin.RouterGroup) *RecycleBinController {
+	a := &RecycleBinController{}
+	a.initRouter(g)
+	return a
+}
+
+// initRouter sets up the routes for the recycle bin.
+func (a *RecycleBinController) initRouter(g *gin.RouterGroup) {
+	g.Use(checkNotScoped)
+	view := checkPermission(model.PermView)
+	manageClients := checkPermission(model.PermClientManage)
+	manageInbounds := checkPermission(model.PermInboundManage)
+
+	g.GET("/list", view, a.getItems)
+
+	g.POST("/restore/:id", manageClients, a.restoreItem)
+	g.POST("/purge/:id", manageClients, a.purgeItem)
+	g.POST("/purgeAll", manageInbounds, a.purgeAll)
+}
+
+// getItems lists the recycle bin entries, or only the ones of the "kind" query.
+func (a *RecycleBinController) getItems(c *gin.Context) {
+	items, err := a.recycleBinService.GetItems(c.Query("kind"))
+	jsonObj(c, items, err)
+}
+
+// checkItemAccess loads the entry in the "id" parameter and rejects the request with
+// 403 when it holds an inbound and the user may not manage inbounds.
+func (a *RecycleBinController) checkItemAccess(c *gin.Context) (int, bool) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "somethingWentWrong"), err)
+		return 0, false
+	}
+	item, err := a.recycleBinService.GetItem(id)
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "somethingWentWrong"), err)
+		return 0, false
+	}
+	if item.Kind == model.RecycledInbound && !hasPermission(c, model.PermInboundManage) {
+		pureJsonMsg(c, http.StatusForbidden, false, "permission denied")
+		c.Abort()
+		return 0, false
+	}
+	return id, true
+}
+
+// restoreItem brings a client or inbound back from the recycle bin.
+func (a *RecycleBinController) restoreItem(c *gin.Context) {
+	id, ok := a.checkItemAccess(c)
+	if !ok {
+		return
+	}
+	item, needRestart, err := a.inboundService.WithActor(auditActor(c)).RestoreRecycledItem(id)
+	jsonMsgObj(c, "Restored successfully", item, err)
+	if err == nil && needRestart {
+		a.xrayService.SetToNeedRestart()
+	}
+}
+
+// purgeItem deletes a recycle bin entry for good.
+func (a *RecycleBinController) purgeItem(c *gin.Context) {
+	id, ok := a.checkItemAccess(c)
+	if !ok {
+		return
+	}
+	err := a.inboundService.WithActor(auditActor(c)).PurgeRecycledItem(id)
+	jsonMsg(c, "Purged successfully", err)
+}
+
+// purgeAll empties the recycle bin.
+func (a *RecycleBinController) purgeAll(c *gin.Context) {
+	count, err := a.inboundService.WithActor(auditActor(c)).PurgeRecycleBin(false)
+	jsonMsgObj(c, "Recycle bin emptied successfully", count, err)
+}
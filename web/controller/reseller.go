@@ -0,0 +1,107 @@
+package controller
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/mhsanaei/3x-ui/v2/database/model"
+	"github.com/mhsanaei/3x-ui/v2/web/service"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ResellerController handles reseller quotas, inbound assignment and usage reports.
+type ResellerController struct {
+	resellerService service.ResellerService
+	inboundService  service.InboundService
+	userService     service.UserService
+}
+
+// NewResellerController creates a new ResellerController and initializes its routes.
+func NewResellerController(g *gin.RouterGroup) *ResellerController {
+	a := &ResellerController{}
+	a.initRouter(g)
+	return a
+}
+
+// initRouter sets up the routes for reseller management.
+func (a *ResellerController) initRouter(g *gin.RouterGroup) {
+	view := checkPermission(model.PermView)
+	manageUsers := checkPermission(model.PermUserManage)
+
+	g.GET("/list", manageUsers, a.getResellers)
+	g.GET("/usage", view, a.getUsage)
+	g.GET("/usage/:id", view, a.getUsage)
+	g.POST("/quota/:id", manageUsers, a.setQuota)
+	g.POST("/assign/:id", manageUsers, a.assignInbound)
+}
+
+// getResellers lists all resellers with their quota and usage.
+func (a *ResellerController) getResellers(c *gin.Context) {
+	resellers, err := a.resellerService.GetResellers()
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "somethingWentWrong"), err)
+		return
+	}
+	jsonObj(c, resellers, nil)
+}
+
+// getUsage returns the quota usage of the reseller with the given id, or of the
+// current user without one. Only user managers may read the usage of others.
+func (a *ResellerController) getUsage(c *gin.Context) {
+	userId := getLoginUser(c).Id
+	if param := c.Param("id"); param != "" {
+		id, err := strconv.Atoi(param)
+		if err != nil {
+			jsonMsg(c, I18nWeb(c, "somethingWentWrong"), err)
+			return
+		}
+		if id != userId {
+			current, err := a.userService.GetUserById(userId)
+			if err != nil || !current.Role.Can(model.PermUserManage) {
+				pureJsonMsg(c, http.StatusForbidden, false, "permission denied")
+				return
+			}
+		}
+		userId = id
+	}
+	usage, err := a.resellerService.GetUsage(userId)
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "somethingWentWrong"), err)
+		return
+	}
+	jsonObj(c, usage, nil)
+}
+
+// setQuota replaces the quota of a reseller. Omitted values are unlimited.
+func (a *ResellerController) setQuota(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "somethingWentWrong"), err)
+		return
+	}
+	quota := &model.ResellerQuota{}
+	if err := c.ShouldBind(quota); err != nil {
+		jsonMsg(c, I18nWeb(c, "somethingWentWrong"), err)
+		return
+	}
+	quota.UserId = id
+	err = a.resellerService.SetQuota(quota)
+	jsonMsgObj(c, "Quota updated successfully", quota, err)
+}
+
+// assignInbound hands the inbound with the given id to the panel user in the "userId" form field.
+func (a *ResellerController) assignInbound(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "somethingWentWrong"), err)
+		return
+	}
+	userId, err := strconv.Atoi(c.PostForm("userId"))
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "somethingWentWrong"), err)
+		return
+	}
+	err = a.inboundService.WithActor(auditActor(c)).SetInboundUser(id, userId)
+	jsonMsg(c, "Inbound assigned successfully", err)
+}
@@ -0,0 +1,120 @@
+package controller
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/mhsanaei/3x-ui/v2/database/model"
+	"github.com/mhsanaei/3x-ui/v2/web/service"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ScheduledActionController handles client and inbound actions scheduled to run at a
+// later time. Actions of any inbound are listed, so users limited to their own
+// inbounds can not use it. Enabling and disabling inbounds needs the permission to
+// manage inbounds.
+type ScheduledActionController struct {
+	scheduledActionService service.ScheduledActionService
+	inboundService         service.InboundService
+}
+
+// NewScheduledActionController creates a new ScheduledActionController and initializes its routes.
+func NewScheduledActionController(g *gin.RouterGroup) *ScheduledActionController {
+	a := &ScheduledActionController{}
+	a.initRouter(g)
+	return a
+}
+
+// initRouter sets up the routes for scheduled actions.
+func (a *ScheduledActionController) initRouter(g *gin.RouterGroup) {
+	g.Use(checkNotScoped)
+	view := checkPermission(model.PermView)
+	manageClients := checkPermission(model.PermClientManage)
+
+	g.GET("/list", view, a.getActions)
+	g.GET("/get/:id", view, a.getAction)
+
+	g.POST("/add", manageClients, a.addAction)
+	g.POST("/cancel/:id", manageClients, a.cancelAction)
+	g.POST("/del/:id", manageClients, a.delAction)
+}
+
+// getActions lists the scheduled actions, or only the ones with the "status" query.
+func (a *ScheduledActionController) getActions(c *gin.Context) {
+	actions, err := a.scheduledActionService.GetActions(c.Query("status"))
+	jsonObj(c, actions, err)
+}
+
+// getAction returns a scheduled action with its outcome.
+func (a *ScheduledActionController) getAction(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "somethingWentWrong"), err)
+		return
+	}
+	action, err := a.scheduledActionService.GetAction(id)
+	jsonObj(c, action, err)
+}
+
+// checkTargetAccess rejects the request with 403 when action switches an inbound and
+// the user may not manage inbounds.
+func checkTargetAccess(c *gin.Context, action *model.ScheduledAction) bool {
+	if action.TargetType == model.ScheduleTargetInbound &&
+		(action.Operation == model.ScheduleEnable || action.Operation == model.ScheduleDisable) &&
+		!hasPermission(c, model.PermInboundManage) {
+		pureJsonMsg(c, http.StatusForbidden, false, "permission denied")
+		c.Abort()
+		return false
+	}
+	return true
+}
+
+// addAction schedules an action on a client or inbound.
+func (a *ScheduledActionController) addAction(c *gin.Context) {
+	action := &model.ScheduledAction{}
+	if err := c.ShouldBind(action); err != nil {
+		jsonMsg(c, I18nWeb(c, "somethingWentWrong"), err)
+		return
+	}
+	if !checkTargetAccess(c, action) {
+		return
+	}
+	err := a.inboundService.WithActor(auditActor(c)).AddScheduledAction(action)
+	jsonMsgObj(c, "Action scheduled successfully", action, err)
+}
+
+// loadAction loads the scheduled action in the "id" parameter for a change.
+func (a *ScheduledActionController) loadAction(c *gin.Context) (int, bool) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "somethingWentWrong"), err)
+		return 0, false
+	}
+	action, err := a.scheduledActionService.GetAction(id)
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "somethingWentWrong"), err)
+		return 0, false
+	}
+	return id, checkTargetAccess(c, action)
+}
+
+// cancelAction keeps a pending action from running.
+func (a *ScheduledActionController) cancelAction(c *gin.Context) {
+	id, ok := a.loadAction(c)
+	if !ok {
+		return
+	}
+	err := a.inboundService.WithActor(auditActor(c)).CancelScheduledAction(id)
+	jsonMsg(c, "Action canceled successfully", err)
+}
+
+// delAction deletes a scheduled action and its outcome.
+func (a *ScheduledActionController) delAction(c *gin.Context) {
+	id, ok := a.loadAction(c)
+	if !ok {
+		return
+	}
+	err := a.inboundService.WithActor(auditActor(c)).DelScheduledAction(id)
+	jsonMsg(c, "Action deleted successfully", err)
+}
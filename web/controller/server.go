@@ -7,6 +7,7 @@ import (
 	"strconv"
 	"time"
 
+	"github.com/mhsanaei/3x-ui/v2/database/model"
 	"github.com/mhsanaei/3x-ui/v2/web/global"
 	"github.com/mhsanaei/3x-ui/v2/web/service"
 	"github.com/mhsanaei/3x-ui/v2/web/websocket"
@@ -39,27 +40,32 @@ func NewServerController(g *gin.RouterGroup) *ServerController {
 
 // initRouter sets up the routes for server status, Xray management, and utility endpoints.
 func (a *ServerController) initRouter(g *gin.RouterGroup) {
-
-	g.GET("/status", a.status)
-	g.GET("/cpuHistory/:bucket", a.getCpuHistoryBucket)
-	g.GET("/getXrayVersion", a.getXrayVersion)
-	g.GET("/getConfigJson", a.getConfigJson)
-	g.GET("/getDb", a.getDb)
-	g.GET("/getNewUUID", a.getNewUUID)
-	g.GET("/getNewX25519Cert", a.getNewX25519Cert)
-	g.GET("/getNewmldsa65", a.getNewmldsa65)
-	g.GET("/getNewmlkem768", a.getNewmlkem768)
-	g.GET("/getNewVlessEnc", a.getNewVlessEnc)
-
-	g.POST("/stopXrayService", a.stopXrayService)
-	g.POST("/restartXrayService", a.restartXrayService)
-	g.POST("/installXray/:version", a.installXray)
-	g.POST("/updateGeofile", a.updateGeofile)
-	g.POST("/updateGeofile/:fileName", a.updateGeofile)
-	g.POST("/logs/:count", a.getLogs)
-	g.POST("/xraylogs/:count", a.getXrayLogs)
-	g.POST("/importDB", a.importDB)
-	g.POST("/getNewEchCert", a.getNewEchCert)
+	view := checkPermission(model.PermView)
+	support := checkPermission(model.PermClientSupport)
+	manageClients := checkPermission(model.PermClientManage)
+	manageInbounds := checkPermission(model.PermInboundManage)
+	manageServer := checkPermission(model.PermServerManage)
+
+	g.GET("/status", view, a.status)
+	g.GET("/cpuHistory/:bucket", view, a.getCpuHistoryBucket)
+	g.GET("/getXrayVersion", view, a.getXrayVersion)
+	g.GET("/getConfigJson", manageServer, a.getConfigJson)
+	g.GET("/getDb", manageServer, a.getDb)
+	g.GET("/getNewUUID", manageClients, a.getNewUUID)
+	g.GET("/getNewX25519Cert", manageInbounds, a.getNewX25519Cert)
+	g.GET("/getNewmldsa65", manageInbounds, a.getNewmldsa65)
+	g.GET("/getNewmlkem768", manageInbounds, a.getNewmlkem768)
+	g.GET("/getNewVlessEnc", manageInbounds, a.getNewVlessEnc)
+
+	g.POST("/stopXrayService", manageServer, a.stopXrayService)
+	g.POST("/restartXrayService", manageServer, a.restartXrayService)
+	g.POST("/installXray/:version", manageServer, a.installXray)
+	g.POST("/updateGeofile", manageServer, a.updateGeofile)
+	g.POST("/updateGeofile/:fileName", manageServer, a.updateGeofile)
+	g.POST("/logs/:count", support, checkNotScoped, a.getLogs)
+	g.POST("/xraylogs/:count", support, checkNotScoped, a.getXrayLogs)
+	g.POST("/importDB", manageServer, a.importDB)
+	g.POST("/getNewEchCert", manageInbounds, a.getNewEchCert)
 }
 
 // refreshStatus updates the cached server status and collects CPU history.
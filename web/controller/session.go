@@ -0,0 +1,77 @@
+package controller
+
+import (
+	"strconv"
+
+	"github.com/mhsanaei/3x-ui/v2/database/model"
+	"github.com/mhsanaei/3x-ui/v2/web/service"
+	"github.com/mhsanaei/3x-ui/v2/web/session"
+
+	"github.com/gin-gonic/gin"
+)
+
+// SessionController handles listing and revoking the login sessions of panel users.
+// Sessions can only be managed from a cookie session, never with an API token.
+type SessionController struct {
+	sessionService service.SessionService
+	userService    service.UserService
+}
+
+// NewSessionController creates a new SessionController and initializes its routes.
+func NewSessionController(g *gin.RouterGroup) *SessionController {
+	a := &SessionController{}
+	a.initRouter(g)
+	return a
+}
+
+// initRouter sets up the routes for session management.
+func (a *SessionController) initRouter(g *gin.RouterGroup) {
+	g.Use(checkSession, checkPermission(model.PermView))
+
+	g.GET("/list", a.getSessions)
+	g.POST("/del/:id", a.delSession)
+	g.POST("/delAll", a.delAllSessions)
+}
+
+// sessionOwnerFilter returns the user id whose sessions the caller may manage,
+// or 0 when the caller may manage the sessions of all users.
+func (a *SessionController) sessionOwnerFilter(c *gin.Context) int {
+	user := getLoginUser(c)
+	current, err := a.userService.GetUserById(user.Id)
+	if err == nil && current.Role.Can(model.PermUserManage) {
+		return 0
+	}
+	return user.Id
+}
+
+// getSessions lists the sessions visible to the current user,
+// or only their own sessions with the "self" query parameter.
+func (a *SessionController) getSessions(c *gin.Context) {
+	userId := a.sessionOwnerFilter(c)
+	if c.Query("self") == "true" {
+		userId = getLoginUser(c).Id
+	}
+	sessions, err := a.sessionService.GetSessions(userId, session.KeyHash(c))
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "somethingWentWrong"), err)
+		return
+	}
+	jsonObj(c, sessions, nil)
+}
+
+// delSession revokes a session. Revoking the current session logs the caller out.
+func (a *SessionController) delSession(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "somethingWentWrong"), err)
+		return
+	}
+	err = a.sessionService.DelSession(id, a.sessionOwnerFilter(c))
+	jsonMsg(c, "Session revoked successfully", err)
+}
+
+// delAllSessions revokes all sessions of the current user except the one making the request.
+func (a *SessionController) delAllSessions(c *gin.Context) {
+	err := a.sessionService.DelUserSessions(getLoginUser(c).Id, session.KeyHash(c))
+	jsonMsg(c, "Sessions revoked successfully", err)
+}
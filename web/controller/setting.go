@@ -2,8 +2,10 @@ package controller
 
 import (
 	"errors"
+	"strconv"
 	"time"
 
+	"github.com/mhsanaei/3x-ui/v2/database/model"
 	"github.com/mhsanaei/3x-ui/v2/util/crypto"
 	"github.com/mhsanaei/3x-ui/v2/web/entity"
 	"github.com/mhsanaei/3x-ui/v2/web/service"
@@ -18,6 +20,35 @@ type updateUserForm struct {
 	OldPassword string `json:"oldPassword" form:"oldPassword"`
 	NewUsername string `json:"newUsername" form:"newUsername"`
 	NewPassword string `json:"newPassword" form:"newPassword"`
+
+	TwoFactorCode string `json:"twoFactorCode" form:"twoFactorCode"`
+}
+
+// twoFactorForm carries the secret being enrolled and the code proving it.
+type twoFactorForm struct {
+	Secret string `json:"secret" form:"secret"`
+	Code   string `json:"code" form:"code"`
+}
+
+// userForm represents the form for creating or editing a panel user.
+type userForm struct {
+	Username string     `json:"username" form:"username"`
+	Password string     `json:"password" form:"password"`
+	Role     model.Role `json:"role" form:"role"`
+}
+
+// clearLockoutForm selects the login lockout to clear; an empty kind clears all.
+type clearLockoutForm struct {
+	Kind string `json:"kind" form:"kind"`
+	Key  string `json:"key" form:"key"`
+}
+
+// userView is the panel user representation returned by the API, without the password hash.
+type userView struct {
+	Id              int        `json:"id"`
+	Username        string     `json:"username"`
+	Role            model.Role `json:"role"`
+	TwoFactorEnable bool       `json:"twoFactorEnable"`
 }
 
 // SettingController handles settings and user management operations.
@@ -38,12 +69,27 @@ func NewSettingController(g *gin.RouterGroup) *SettingController {
 func (a *SettingController) initRouter(g *gin.RouterGroup) {
 	g = g.Group("/setting")
 
-	g.POST("/all", a.getAllSetting)
-	g.POST("/defaultSettings", a.getDefaultSettings)
-	g.POST("/update", a.updateSetting)
+	settings := checkPermission(model.PermSettings)
+	manageUsers := checkPermission(model.PermUserManage)
+
+	g.POST("/all", settings, a.getAllSetting)
+	g.POST("/defaultSettings", checkPermission(model.PermView), a.getDefaultSettings)
+	g.POST("/update", settings, a.updateSetting)
 	g.POST("/updateUser", a.updateUser)
-	g.POST("/restartPanel", a.restartPanel)
-	g.GET("/getDefaultJsonConfig", a.getDefaultXrayConfig)
+	g.POST("/twoFactor/status", a.getTwoFactorStatus)
+	g.POST("/twoFactor/enable", a.enableTwoFactor)
+	g.POST("/twoFactor/disable", a.disableTwoFactor)
+	g.POST("/twoFactor/recoveryCodes", a.regenerateRecoveryCodes)
+	g.POST("/restartPanel", checkPermission(model.PermServerManage), a.restartPanel)
+	g.GET("/getDefaultJsonConfig", settings, a.getDefaultXrayConfig)
+
+	g.POST("/users", manageUsers, a.getUsers)
+	g.POST("/addUser", manageUsers, a.addUser)
+	g.POST("/editUser/:id", manageUsers, a.editUser)
+	g.POST("/delUser/:id", manageUsers, a.delUser)
+	g.POST("/resetTwoFactor/:id", manageUsers, a.resetTwoFactor)
+	g.POST("/lockouts", manageUsers, a.getLoginLockouts)
+	g.POST("/clearLockout", manageUsers, a.clearLoginLockout)
 }
 
 // getAllSetting retrieves all current settings.
@@ -86,7 +132,12 @@ func (a *SettingController) updateUser(c *gin.Context) {
 		jsonMsg(c, I18nWeb(c, "pages.settings.toasts.modifySettings"), err)
 		return
 	}
-	user := session.GetLoginUser(c)
+	// The session does not hold the password hash, the stored user is checked.
+	user, err := a.userService.GetUserById(session.GetLoginUser(c).Id)
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "pages.settings.toasts.modifyUserError"), err)
+		return
+	}
 	if user.Username != form.OldUsername || !crypto.CheckPasswordHash(user.Password, form.OldPassword) {
 		jsonMsg(c, I18nWeb(c, "pages.settings.toasts.modifyUserError"), errors.New(I18nWeb(c, "pages.settings.toasts.originalUserPassIncorrect")))
 		return
@@ -95,15 +146,94 @@ func (a *SettingController) updateUser(c *gin.Context) {
 		jsonMsg(c, I18nWeb(c, "pages.settings.toasts.modifyUserError"), errors.New(I18nWeb(c, "pages.settings.toasts.userPassMustBeNotEmpty")))
 		return
 	}
-	err = a.userService.UpdateUser(user.Id, form.NewUsername, form.NewPassword)
-	if err == nil {
-		user.Username = form.NewUsername
-		user.Password, _ = crypto.HashPasswordAsBcrypt(form.NewPassword)
-		session.SetLoginUser(c, user)
+	if err := a.userService.VerifyTwoFactorCode(user.Id, form.TwoFactorCode); err != nil {
+		jsonMsg(c, I18nWeb(c, "pages.settings.toasts.modifyUserError"), err)
+		return
 	}
+	// This also ends the current session, the page logs out afterwards.
+	err = a.userService.UpdateUser(user.Id, form.NewUsername, form.NewPassword)
 	jsonMsg(c, I18nWeb(c, "pages.settings.toasts.modifyUser"), err)
 }
 
+// getUsers lists all panel users with their roles.
+func (a *SettingController) getUsers(c *gin.Context) {
+	users, err := a.userService.GetUsers()
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "somethingWentWrong"), err)
+		return
+	}
+	views := make([]userView, 0, len(users))
+	for _, user := range users {
+		views = append(views, userView{Id: user.Id, Username: user.Username, Role: user.Role, TwoFactorEnable: user.TwoFactorEnable})
+	}
+	jsonObj(c, views, nil)
+}
+
+// addUser creates a new panel user with the given role.
+func (a *SettingController) addUser(c *gin.Context) {
+	form := &userForm{}
+	err := c.ShouldBind(form)
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "somethingWentWrong"), err)
+		return
+	}
+	user, err := a.userService.AddUser(form.Username, form.Password, form.Role)
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "somethingWentWrong"), err)
+		return
+	}
+	jsonMsgObj(c, "User added successfully", userView{Id: user.Id, Username: user.Username, Role: user.Role}, nil)
+}
+
+// editUser changes the username, role and optionally the password of a panel user.
+func (a *SettingController) editUser(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "somethingWentWrong"), err)
+		return
+	}
+	form := &userForm{}
+	err = c.ShouldBind(form)
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "somethingWentWrong"), err)
+		return
+	}
+	err = a.userService.UpdateUserById(id, form.Username, form.Password, form.Role)
+	jsonMsg(c, "User updated successfully", err)
+}
+
+// delUser deletes a panel user. Users can not delete their own account.
+func (a *SettingController) delUser(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "somethingWentWrong"), err)
+		return
+	}
+	if user := session.GetLoginUser(c); user != nil && user.Id == id {
+		jsonMsg(c, I18nWeb(c, "somethingWentWrong"), errors.New("can not delete the current user"))
+		return
+	}
+	err = a.userService.DelUser(id)
+	jsonMsg(c, "User deleted successfully", err)
+}
+
+// getLoginLockouts lists IPs and accounts with recent failed logins and their lockouts.
+func (a *SettingController) getLoginLockouts(c *gin.Context) {
+	jsonObj(c, a.userService.GetLoginLockouts(), nil)
+}
+
+// clearLoginLockout clears the lockout of one IP or account, or all lockouts when no kind is given.
+func (a *SettingController) clearLoginLockout(c *gin.Context) {
+	form := &clearLockoutForm{}
+	err := c.ShouldBind(form)
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "somethingWentWrong"), err)
+		return
+	}
+	err = a.userService.ClearLoginLockout(form.Kind, form.Key)
+	jsonMsg(c, "Lockout cleared successfully", err)
+}
+
 // restartPanel restarts the panel service after a delay.
 func (a *SettingController) restartPanel(c *gin.Context) {
 	err := a.panelService.RestartPanel(time.Second * 3)
@@ -119,3 +249,53 @@ func (a *SettingController) getDefaultXrayConfig(c *gin.Context) {
 	}
 	jsonObj(c, defaultJsonConfig, nil)
 }
+
+// getTwoFactorStatus returns the two-factor state of the current user.
+func (a *SettingController) getTwoFactorStatus(c *gin.Context) {
+	status, err := a.userService.GetTwoFactorStatus(session.GetLoginUser(c).Id)
+	jsonObj(c, status, err)
+}
+
+// enableTwoFactor enrols the current user's authenticator app and returns their recovery codes.
+func (a *SettingController) enableTwoFactor(c *gin.Context) {
+	form := &twoFactorForm{}
+	if err := c.ShouldBind(form); err != nil {
+		jsonMsg(c, I18nWeb(c, "somethingWentWrong"), err)
+		return
+	}
+	codes, err := a.userService.EnableTwoFactor(session.GetLoginUser(c).Id, form.Secret, form.Code)
+	jsonMsgObj(c, "Two-factor authentication enabled", codes, err)
+}
+
+// disableTwoFactor turns two-factor off for the current user given a TOTP or recovery code.
+func (a *SettingController) disableTwoFactor(c *gin.Context) {
+	form := &twoFactorForm{}
+	if err := c.ShouldBind(form); err != nil {
+		jsonMsg(c, I18nWeb(c, "somethingWentWrong"), err)
+		return
+	}
+	err := a.userService.DisableTwoFactor(session.GetLoginUser(c).Id, form.Code)
+	jsonMsg(c, "Two-factor authentication disabled", err)
+}
+
+// regenerateRecoveryCodes replaces the current user's recovery codes given a TOTP code.
+func (a *SettingController) regenerateRecoveryCodes(c *gin.Context) {
+	form := &twoFactorForm{}
+	if err := c.ShouldBind(form); err != nil {
+		jsonMsg(c, I18nWeb(c, "somethingWentWrong"), err)
+		return
+	}
+	codes, err := a.userService.RegenerateRecoveryCodes(session.GetLoginUser(c).Id, form.Code)
+	jsonMsgObj(c, "Recovery codes regenerated", codes, err)
+}
+
+// resetTwoFactor turns two-factor off for another user who lost their device.
+func (a *SettingController) resetTwoFactor(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "somethingWentWrong"), err)
+		return
+	}
+	err = a.userService.ResetTwoFactor(id)
+	jsonMsg(c, "Two-factor authentication reset", err)
+}
@@ -0,0 +1,78 @@
+package controller
+
+import (
+	"github.com/mhsanaei/3x-ui/v2/database/model"
+	"github.com/mhsanaei/3x-ui/v2/util/common"
+	"github.com/mhsanaei/3x-ui/v2/web/service"
+
+	"github.com/gin-gonic/gin"
+)
+
+// SubAccountController handles subscription accounts, which share a quota and expiry
+// between the clients of a subscription id. Subscriptions span inbounds, so users
+// limited to their own inbounds can not use them.
+type SubAccountController struct {
+	subAccountService service.SubAccountService
+	inboundService    service.InboundService
+	xrayService       service.XrayService
+}
+
+// NewSubAccountController creates a new SubAccountController and initializes its routes.
+func NewSubAccountController(g *gin.RouterGroup) *SubAccountController {
+	a := &SubAccountController{}
+	a.initRouter(g)
+	return a
+}
+
+// initRouter sets up the routes for subscription accounts.
+func (a *SubAccountController) initRouter(g *gin.RouterGroup) {
+	g.Use(checkNotScoped)
+	view := checkPermission(model.PermView)
+	manageClients := checkPermission(model.PermClientManage)
+
+	g.GET("/list", view, a.getSubAccounts)
+	g.GET("/get/:subId", view, a.getSubAccount)
+
+	g.POST("/save", manageClients, a.saveSubAccount)
+	g.POST("/del/:subId", manageClients, a.delSubAccount)
+}
+
+// getSubAccounts lists all subscription accounts with their usage.
+func (a *SubAccountController) getSubAccounts(c *gin.Context) {
+	accounts, err := a.subAccountService.GetSubAccounts()
+	jsonObj(c, accounts, err)
+}
+
+// getSubAccount returns the account of a subscription id with its usage.
+func (a *SubAccountController) getSubAccount(c *gin.Context) {
+	subId := c.Param("subId")
+	account, err := a.subAccountService.GetSubAccount(subId)
+	if err == nil && account == nil {
+		err = common.NewError("Subscription account not found:", subId)
+	}
+	jsonObj(c, account, err)
+}
+
+// saveSubAccount creates or changes the account of a subscription id.
+func (a *SubAccountController) saveSubAccount(c *gin.Context) {
+	account := &model.SubAccount{}
+	if err := c.ShouldBind(account); err != nil {
+		jsonMsg(c, I18nWeb(c, "somethingWentWrong"), err)
+		return
+	}
+	needRestart, err := a.inboundService.WithActor(auditActor(c)).SaveSubAccount(account)
+	jsonMsgObj(c, "Subscription account saved successfully", account, err)
+	if err == nil && needRestart {
+		a.xrayService.SetToNeedRestart()
+	}
+}
+
+// delSubAccount deletes the account of a subscription id, leaving its clients with
+// their own limits.
+func (a *SubAccountController) delSubAccount(c *gin.Context) {
+	needRestart, err := a.inboundService.WithActor(auditActor(c)).DelSubAccount(c.Param("subId"))
+	jsonMsg(c, "Subscription account deleted successfully", err)
+	if err == nil && needRestart {
+		a.xrayService.SetToNeedRestart()
+	}
+}
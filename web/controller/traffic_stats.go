@@ -0,0 +1,101 @@
+package controller
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/mhsanaei/3x-ui/v2/database/model"
+	"github.com/mhsanaei/3x-ui/v2/web/service"
+
+	"github.com/gin-gonic/gin"
+)
+
+// TrafficStatsController serves the traffic history of clients, inbounds,
+// subscriptions and the whole server. Users limited to their own inbounds only see
+// the clients and inbounds assigned to them.
+type TrafficStatsController struct {
+	trafficStatService service.TrafficStatService
+	inboundService     service.InboundService
+}
+
+// NewTrafficStatsController creates a new TrafficStatsController and initializes its routes.
+func NewTrafficStatsController(g *gin.RouterGroup) *TrafficStatsController {
+	a := &TrafficStatsController{}
+	a.initRouter(g)
+	return a
+}
+
+// initRouter sets up the routes for traffic history.
+func (a *TrafficStatsController) initRouter(g *gin.RouterGroup) {
+	g.Use(checkPermission(model.PermView))
+
+	g.GET("/client/:email", a.getClientHistory)
+	g.GET("/inbound/:id", a.getInboundHistory)
+	g.GET("/sub/:subId", checkNotScoped, a.getSubHistory)
+	g.GET("/server", checkNotScoped, a.getServerHistory)
+}
+
+// getHistory answers with the history of scope and key over the range in the "from"
+// and "to" queries, in milliseconds, in buckets of the "span" query. The span defaults
+// to days and the range to the last 30 days, or the last day for hours.
+func (a *TrafficStatsController) getHistory(c *gin.Context, scope string, key string) {
+	span := c.DefaultQuery("span", model.TrafficSpanDay)
+	to := time.Now().UnixMilli()
+	if value := c.Query("to"); value != "" {
+		parsed, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			jsonMsg(c, I18nWeb(c, "somethingWentWrong"), err)
+			return
+		}
+		to = parsed
+	}
+	from := time.UnixMilli(to).AddDate(0, 0, -30).UnixMilli()
+	if span == model.TrafficSpanHour {
+		from = time.UnixMilli(to).AddDate(0, 0, -1).UnixMilli()
+	}
+	if value := c.Query("from"); value != "" {
+		parsed, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			jsonMsg(c, I18nWeb(c, "somethingWentWrong"), err)
+			return
+		}
+		from = parsed
+	}
+	history, err := a.trafficStatService.GetTrafficHistory(scope, key, span, from, to)
+	jsonObj(c, history, err)
+}
+
+// getClientHistory returns the traffic history of a client.
+func (a *TrafficStatsController) getClientHistory(c *gin.Context) {
+	email := c.Param("email")
+	if err := a.inboundService.WithActor(auditActor(c)).CheckClientAccess(email); err != nil {
+		pureJsonMsg(c, http.StatusForbidden, false, "permission denied")
+		return
+	}
+	a.getHistory(c, service.TrafficScopeClient, email)
+}
+
+// getInboundHistory returns the traffic history of an inbound.
+func (a *TrafficStatsController) getInboundHistory(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "somethingWentWrong"), err)
+		return
+	}
+	if err := a.inboundService.WithActor(auditActor(c)).CheckInboundAccess(id); err != nil {
+		pureJsonMsg(c, http.StatusForbidden, false, "permission denied")
+		return
+	}
+	a.getHistory(c, service.TrafficScopeInbound, c.Param("id"))
+}
+
+// getSubHistory returns the traffic history of the clients of a subscription.
+func (a *TrafficStatsController) getSubHistory(c *gin.Context) {
+	a.getHistory(c, service.TrafficScopeSub, c.Param("subId"))
+}
+
+// getServerHistory returns the traffic history of all inbounds together.
+func (a *TrafficStatsController) getServerHistory(c *gin.Context) {
+	a.getHistory(c, service.TrafficScopeServer, "")
+}
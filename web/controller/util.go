@@ -28,6 +28,20 @@ func getRemoteIp(c *gin.Context) string {
 	return ip
 }
 
+// requestOrigin returns the scheme and host of this panel as seen by the browser,
+// honouring the X-Forwarded-Proto and X-Forwarded-Host headers of a reverse proxy.
+func requestOrigin(c *gin.Context) string {
+	scheme := "http"
+	if c.Request.TLS != nil || c.GetHeader("X-Forwarded-Proto") == "https" {
+		scheme = "https"
+	}
+	host := c.GetHeader("X-Forwarded-Host")
+	if host == "" {
+		host = c.Request.Host
+	}
+	return scheme + "://" + host
+}
+
 // jsonMsg sends a JSON response with a message and error status.
 func jsonMsg(c *gin.Context, msg string, err error) {
 	jsonMsgObj(c, msg, nil, err)
@@ -0,0 +1,104 @@
+package controller
+
+import (
+	"strconv"
+
+	"github.com/mhsanaei/3x-ui/v2/database/model"
+	"github.com/mhsanaei/3x-ui/v2/logger"
+	"github.com/mhsanaei/3x-ui/v2/web/service"
+	"github.com/mhsanaei/3x-ui/v2/web/session"
+
+	"github.com/gin-contrib/sessions"
+	"github.com/gin-gonic/gin"
+)
+
+// WebAuthnController handles registration and revocation of security keys and passkeys.
+// Like API tokens they can only be managed from a cookie session.
+type WebAuthnController struct {
+	webAuthn    service.WebAuthnService
+	userService service.UserService
+}
+
+// NewWebAuthnController creates a new WebAuthnController and initializes its routes.
+func NewWebAuthnController(g *gin.RouterGroup) *WebAuthnController {
+	a := &WebAuthnController{}
+	a.initRouter(g)
+	return a
+}
+
+// initRouter sets up the routes for security key management.
+func (a *WebAuthnController) initRouter(g *gin.RouterGroup) {
+	g.Use(checkSession, checkPermission(model.PermView))
+
+	g.GET("/list", a.getCredentials)
+	g.POST("/registerBegin", a.registerBegin)
+	g.POST("/registerFinish", a.registerFinish)
+	g.POST("/del/:id", a.delCredential)
+}
+
+// credentialOwnerFilter returns the user id whose security keys the caller may manage,
+// or 0 when the caller may manage the keys of all users.
+func (a *WebAuthnController) credentialOwnerFilter(c *gin.Context) int {
+	user := getLoginUser(c)
+	current, err := a.userService.GetUserById(user.Id)
+	if err == nil && current.Role.Can(model.PermUserManage) {
+		return 0
+	}
+	return user.Id
+}
+
+// getCredentials lists the security keys visible to the current user,
+// or only their own keys with the "self" query parameter.
+func (a *WebAuthnController) getCredentials(c *gin.Context) {
+	userId := a.credentialOwnerFilter(c)
+	if c.Query("self") == "true" {
+		userId = getLoginUser(c).Id
+	}
+	credentials, err := a.webAuthn.GetCredentials(userId)
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "somethingWentWrong"), err)
+		return
+	}
+	jsonObj(c, credentials, nil)
+}
+
+// registerBegin returns the options for navigator.credentials.create() to register a key for the current user.
+func (a *WebAuthnController) registerBegin(c *gin.Context) {
+	creation, state, err := a.webAuthn.BeginRegistration(getLoginUser(c).Id, webauthnOrigin(c))
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "somethingWentWrong"), err)
+		return
+	}
+	session.SetWebAuthnSession(c, state)
+	if err := sessions.Default(c).Save(); err != nil {
+		logger.Warning("Unable to save session: ", err)
+		jsonMsg(c, I18nWeb(c, "somethingWentWrong"), err)
+		return
+	}
+	jsonObj(c, creation, nil)
+}
+
+// registerFinish stores the key created by the browser under the given name.
+func (a *WebAuthnController) registerFinish(c *gin.Context) {
+	state := session.PopWebAuthnSession(c)
+	if err := sessions.Default(c).Save(); err != nil {
+		logger.Warning("Unable to save session: ", err)
+	}
+	credential, err := a.webAuthn.FinishRegistration(getLoginUser(c).Id, webauthnOrigin(c), state, c.PostForm("name"), c.PostForm("credential"))
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "somethingWentWrong"), err)
+		return
+	}
+	jsonMsgObj(c, "Security key registered successfully", credential, nil)
+}
+
+// delCredential revokes a security key.
+func (a *WebAuthnController) delCredential(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "somethingWentWrong"), err)
+		return
+	}
+	err = a.webAuthn.DelCredential(id, a.credentialOwnerFilter(c))
+	jsonMsg(c, "Security key revoked successfully", err)
+}
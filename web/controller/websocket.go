@@ -102,6 +102,13 @@ func (w *WebSocketController) HandleWebSocket(c *gin.Context) {
 		Send:   make(chan []byte, 512), // Increased from 256 to 512 to prevent overflow
 		Topics: make(map[websocket.MessageType]bool),
 	}
+	// Inbound and traffic updates cover every inbound, so resellers only get the
+	// server wide topics and reload their own inbounds through the API.
+	if scopedUserId(c) > 0 {
+		client.Topics[websocket.MessageTypeStatus] = true
+		client.Topics[websocket.MessageTypeNotification] = true
+		client.Topics[websocket.MessageTypeXrayState] = true
+	}
 
 	// Register client
 	w.hub.Register(client)
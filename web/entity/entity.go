@@ -8,6 +8,7 @@ import (
 	"strings"
 	"time"
 
+	"github.com/mhsanaei/3x-ui/v2/database/model"
 	"github.com/mhsanaei/3x-ui/v2/util/common"
 )
 
@@ -49,9 +50,36 @@ type AllSetting struct {
 	TgLang           string `json:"tgLang" form:"tgLang"`                     // Telegram bot language
 
 	// Security settings
-	TimeLocation    string `json:"timeLocation" form:"timeLocation"`       // Time zone location
-	TwoFactorEnable bool   `json:"twoFactorEnable" form:"twoFactorEnable"` // Enable two-factor authentication
-	TwoFactorToken  string `json:"twoFactorToken" form:"twoFactorToken"`   // Two-factor authentication token
+	TimeLocation string `json:"timeLocation" form:"timeLocation"` // Time zone location
+
+	// Login brute-force protection settings
+	LoginLimitEnable       bool `json:"loginLimitEnable" form:"loginLimitEnable"`             // Enable failed login lockouts
+	LoginMaxAttempts       int  `json:"loginMaxAttempts" form:"loginMaxAttempts"`             // Failed attempts before a lockout
+	LoginLockoutSeconds    int  `json:"loginLockoutSeconds" form:"loginLockoutSeconds"`       // First lockout duration, doubled for each further lockout
+	LoginLockoutMaxSeconds int  `json:"loginLockoutMaxSeconds" form:"loginLockoutMaxSeconds"` // Maximum lockout duration
+	LoginAttemptWindow     int  `json:"loginAttemptWindow" form:"loginAttemptWindow"`         // Seconds after which failures are forgotten
+
+	// Client IP limit enforcement settings
+	IpLimitMode     string `json:"ipLimitMode" form:"ipLimitMode"`         // One of the model.IPLimitMode* constants
+	IpLimitCooldown int    `json:"ipLimitCooldown" form:"ipLimitCooldown"` // Seconds a client over its IP limit stays blocked
+
+	// Recycle bin settings
+	RecycleBinDays int `json:"recycleBinDays" form:"recycleBinDays"` // Days deleted clients and inbounds are kept, 0 deletes them for good
+
+	// Traffic history settings
+	TrafficStatsHourlyDays int `json:"trafficStatsHourlyDays" form:"trafficStatsHourlyDays"` // Days hourly traffic buckets are kept, 0 keeps them for good
+	TrafficStatsDailyDays  int `json:"trafficStatsDailyDays" form:"trafficStatsDailyDays"`   // Days daily traffic buckets are kept, 0 keeps them for good
+
+	// Prometheus metrics settings. The panel serves them at /metrics to API tokens and
+	// sessions; a metrics port serves them without authentication on its own address.
+	MetricsEnable bool   `json:"metricsEnable" form:"metricsEnable"`
+	MetricsListen string `json:"metricsListen" form:"metricsListen"` // Listen IP of the metrics port
+	MetricsPort   int    `json:"metricsPort" form:"metricsPort"`     // Port of its own for metrics, 0 for none
+
+	// Destination report settings, read from the Xray access log
+	DestinationStatsEnable bool `json:"destinationStatsEnable" form:"destinationStatsEnable"` // Count the destinations clients connect to
+	DestinationStatsTopN   int  `json:"destinationStatsTopN" form:"destinationStatsTopN"`     // Destinations kept per client and day
+	DestinationStatsDays   int  `json:"destinationStatsDays" form:"destinationStatsDays"`     // Days destinations are kept, 0 keeps them for good
 
 	// Subscription server settings
 	SubEnable                   bool   `json:"subEnable" form:"subEnable"`                                     // Enable subscription server
@@ -103,6 +131,24 @@ type AllSetting struct {
 	LdapDefaultTotalGB    int    `json:"ldapDefaultTotalGB" form:"ldapDefaultTotalGB"`
 	LdapDefaultExpiryDays int    `json:"ldapDefaultExpiryDays" form:"ldapDefaultExpiryDays"`
 	LdapDefaultLimitIP    int    `json:"ldapDefaultLimitIP" form:"ldapDefaultLimitIP"`
+	// Panel login through LDAP
+	LdapGroupAttr     string `json:"ldapGroupAttr" form:"ldapGroupAttr"`     // e.g., memberOf
+	LdapRoleMapping   string `json:"ldapRoleMapping" form:"ldapRoleMapping"` // e.g. "panel-admins=owner,helpdesk=support"
+	LdapDefaultRole   string `json:"ldapDefaultRole" form:"ldapDefaultRole"` // Role when no group matches, empty denies the login
+	LdapAutoProvision bool   `json:"ldapAutoProvision" form:"ldapAutoProvision"`
+
+	// OpenID Connect single sign-on settings
+	OidcEnable        bool   `json:"oidcEnable" form:"oidcEnable"`
+	OidcIssuer        string `json:"oidcIssuer" form:"oidcIssuer"`
+	OidcClientId      string `json:"oidcClientId" form:"oidcClientId"`
+	OidcClientSecret  string `json:"oidcClientSecret" form:"oidcClientSecret"`
+	OidcRedirectUrl   string `json:"oidcRedirectUrl" form:"oidcRedirectUrl"`     // Empty derives it from the request
+	OidcScopes        string `json:"oidcScopes" form:"oidcScopes"`               // Space-separated
+	OidcUsernameClaim string `json:"oidcUsernameClaim" form:"oidcUsernameClaim"` // Claim matched against local usernames
+	OidcGroupsClaim   string `json:"oidcGroupsClaim" form:"oidcGroupsClaim"`
+	OidcRoleMapping   string `json:"oidcRoleMapping" form:"oidcRoleMapping"` // e.g. "panel-admins=owner,helpdesk=support"
+	OidcDefaultRole   string `json:"oidcDefaultRole" form:"oidcDefaultRole"` // Role when no group matches, empty denies the login
+	OidcAutoProvision bool   `json:"oidcAutoProvision" form:"oidcAutoProvision"`
 	// JSON subscription routing rules
 }
 
@@ -130,6 +176,51 @@ func (s *AllSetting) CheckValid() error {
 		return common.NewError("Sub port is not a valid port:", s.SubPort)
 	}
 
+	if s.LoginLimitEnable && (s.LoginMaxAttempts <= 0 || s.LoginLockoutSeconds <= 0 || s.LoginLockoutMaxSeconds < s.LoginLockoutSeconds) {
+		return common.NewError("login limit settings are not valid")
+	}
+
+	switch s.IpLimitMode {
+	case model.IPLimitModeFail2Ban, model.IPLimitModeXray, model.IPLimitModeNftables:
+	default:
+		return common.NewError("IP limit mode is not valid:", s.IpLimitMode)
+	}
+	if s.IpLimitCooldown <= 0 {
+		return common.NewError("IP limit cooldown must be positive")
+	}
+
+	if s.RecycleBinDays < 0 {
+		return common.NewError("recycle bin retention can not be negative")
+	}
+
+	if s.TrafficStatsHourlyDays < 0 || s.TrafficStatsDailyDays < 0 {
+		return common.NewError("traffic history retention can not be negative")
+	}
+
+	if s.MetricsListen != "" && net.ParseIP(s.MetricsListen) == nil {
+		return common.NewError("metrics listen is not valid ip:", s.MetricsListen)
+	}
+
+	if s.MetricsPort < 0 || s.MetricsPort > math.MaxUint16 {
+		return common.NewError("metrics port is not a valid port:", s.MetricsPort)
+	}
+
+	if s.MetricsPort == s.WebPort || s.MetricsPort == s.SubPort {
+		return common.NewError("metrics port is already used by the panel or subscriptions:", s.MetricsPort)
+	}
+
+	if s.DestinationStatsTopN < 1 {
+		return common.NewError("destinations kept per client must be at least 1")
+	}
+
+	if s.DestinationStatsDays < 0 {
+		return common.NewError("destination retention can not be negative")
+	}
+
+	if s.OidcEnable && (s.OidcIssuer == "" || s.OidcClientId == "") {
+		return common.NewError("OIDC issuer and client id are required")
+	}
+
 	if (s.SubPort == s.WebPort) && (s.WebListen == s.SubListen) {
 		return common.NewError("Sub and Web could not use same ip:port, ", s.SubListen, ":", s.SubPort, " & ", s.WebListen, ":", s.WebPort)
 	}
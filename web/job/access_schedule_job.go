@@ -0,0 +1,31 @@
+package job
+
+import (
+	"time"
+
+	"github.com/mhsanaei/3x-ui/v2/logger"
+	"github.com/mhsanaei/3x-ui/v2/web/service"
+)
+
+// AccessScheduleJob turns clients on and off at the boundaries of their access windows.
+type AccessScheduleJob struct {
+	inboundService service.InboundService
+	xrayService    service.XrayService
+}
+
+// NewAccessScheduleJob creates a new access schedule job instance.
+func NewAccessScheduleJob() *AccessScheduleJob {
+	return new(AccessScheduleJob)
+}
+
+// Run applies the access windows of the current minute to the running Xray.
+func (j *AccessScheduleJob) Run() {
+	needRestart, err := j.inboundService.ApplyAccessSchedules(time.Now(), j.xrayService.IsXrayRunning())
+	if err != nil {
+		logger.Warning("[AccessSchedule] apply access windows failed:", err)
+		return
+	}
+	if needRestart {
+		j.xrayService.SetToNeedRestart()
+	}
+}
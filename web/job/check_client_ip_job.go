@@ -11,11 +11,13 @@ import (
 	"runtime"
 	"sort"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/mhsanaei/3x-ui/v2/database"
 	"github.com/mhsanaei/3x-ui/v2/database/model"
 	"github.com/mhsanaei/3x-ui/v2/logger"
+	"github.com/mhsanaei/3x-ui/v2/web/service"
 	"github.com/mhsanaei/3x-ui/v2/xray"
 )
 
@@ -27,12 +29,25 @@ type IPWithTimestamp struct {
 
 // CheckClientIpJob monitors client IP addresses from access logs and manages IP blocking based on configured limits.
 type CheckClientIpJob struct {
-	lastClear     int64
-	disAllowedIps []string
+	lastClear          int64
+	disAllowedIps      []string
+	ipLimitMode        string
+	destinationOffset  int64 // Bytes of the access log the destinations were counted from
+	inboundService     service.InboundService
+	xrayService        service.XrayService
+	destinationService service.ClientDestinationService
 }
 
 var job *CheckClientIpJob
 
+var (
+	emailRegex     = regexp.MustCompile(`email: (.+)$`)
+	timestampRegex = regexp.MustCompile(`^(\d{4}/\d{2}/\d{2} \d{2}:\d{2}:\d{2})`)
+	// destinationRegex matches the destination of an accepted connection and the
+	// routing of it in brackets, if logged. IPv6 destinations keep their brackets.
+	destinationRegex = regexp.MustCompile(`accepted (?:tcp:|udp:)?(\[[0-9a-fA-F:]+\]|[^\s\[\]]+):\d+(?: \[([^\]]*)\])?`)
+)
+
 // NewCheckClientIpJob creates a new client IP monitoring job instance.
 func NewCheckClientIpJob() *CheckClientIpJob {
 	job = new(CheckClientIpJob)
@@ -44,10 +59,16 @@ func (j *CheckClientIpJob) Run() {
 		j.lastClear = time.Now().Unix()
 	}
 
+	j.liftExpiredBans()
+
 	shouldClearAccessLog := false
 	iplimitActive := j.hasLimitIp()
-	f2bInstalled := j.checkFail2BanInstalled()
-	isAccessLogAvailable := j.checkAccessLogAvailable(iplimitActive)
+	speedLimitActive := j.hasSpeedLimit()
+	settingService := service.SettingService{}
+	destinationsActive, _ := settingService.GetDestinationStatsEnable()
+	isAccessLogAvailable := j.checkAccessLogAvailable(iplimitActive || speedLimitActive || destinationsActive)
+
+	j.ipLimitMode, _ = settingService.GetIpLimitMode()
 
 	if isAccessLogAvailable {
 		if runtime.GOOS == "windows" {
@@ -55,18 +76,28 @@ func (j *CheckClientIpJob) Run() {
 				shouldClearAccessLog = j.processLogFile()
 			}
 		} else {
+			ipsRecorded := false
 			if iplimitActive {
-				if f2bInstalled {
+				// The panel enforces the other modes by itself
+				if j.ipLimitMode != model.IPLimitModeFail2Ban || j.checkFail2BanInstalled() {
 					shouldClearAccessLog = j.processLogFile()
+					ipsRecorded = true
 				} else {
-					if !f2bInstalled {
-						logger.Warning("[LimitIP] Fail2Ban is not installed, Please install Fail2Ban from the x-ui bash menu.")
-					}
+					logger.Warning("[LimitIP] Fail2Ban is not installed, Please install Fail2Ban from the x-ui bash menu or set the IP limit mode to xray or nftables.")
 				}
 			}
+			// Speed limits shape the recorded ips and do not need Fail2Ban
+			if speedLimitActive && !ipsRecorded {
+				shouldClearAccessLog = j.processLogFile()
+			}
 		}
 	}
 
+	// Destinations are counted before the log is cleared so no line is missed
+	if isAccessLogAvailable && destinationsActive {
+		j.recordDestinations()
+	}
+
 	if shouldClearAccessLog || (isAccessLogAvailable && time.Now().Unix()-j.lastClear > 3600) {
 		j.clearAccessLog()
 	}
@@ -91,42 +122,42 @@ func (j *CheckClientIpJob) clearAccessLog() {
 	j.checkError(err)
 
 	j.lastClear = time.Now().Unix()
+	j.destinationOffset = 0
 }
 
 func (j *CheckClientIpJob) hasLimitIp() bool {
 	db := database.GetDB()
-	var inbounds []*model.Inbound
+	var count int64
 
-	err := db.Model(model.Inbound{}).Find(&inbounds).Error
+	err := db.Model(model.Client{}).Where("limit_ip > 0").Count(&count).Error
 	if err != nil {
 		return false
 	}
 
-	for _, inbound := range inbounds {
-		if len(inbound.Settings) == 0 {
-			continue
-		}
-
-		settings := map[string][]model.Client{}
-		json.Unmarshal(inbound.Settings, &settings)
-		clients := settings["clients"]
+	return count > 0
+}
 
-		for _, client := range clients {
-			limitIp := client.LimitIP
-			if limitIp > 0 {
-				return true
-			}
-		}
+// liftExpiredBans lifts the IP limit bans whose cooldown is over.
+func (j *CheckClientIpJob) liftExpiredBans() {
+	needRestart, err := j.inboundService.LiftExpiredIPLimitBans()
+	if err != nil {
+		logger.Warning("[LimitIP] lift expired bans failed:", err)
 	}
+	if needRestart {
+		j.xrayService.SetToNeedRestart()
+	}
+}
 
-	return false
+// hasSpeedLimit reports whether any client is capped by a speed limit, which is
+// enforced on the ips recorded here.
+func (j *CheckClientIpJob) hasSpeedLimit() bool {
+	limits, err := j.inboundService.GetClientSpeedLimits()
+	return err == nil && len(limits) > 0
 }
 
 func (j *CheckClientIpJob) processLogFile() bool {
 
 	ipRegex := regexp.MustCompile(`from (?:tcp:|udp:)?\[?([0-9a-fA-F\.:]+)\]?:\d+ accepted`)
-	emailRegex := regexp.MustCompile(`email: (.+)$`)
-	timestampRegex := regexp.MustCompile(`^(\d{4}/\d{2}/\d{2} \d{2}:\d{2}:\d{2})`)
 
 	accessLogPath, _ := xray.GetAccessLogPath()
 	file, _ := os.Open(accessLogPath)
@@ -200,6 +231,80 @@ func (j *CheckClientIpJob) processLogFile() bool {
 	return shouldCleanLog
 }
 
+// recordDestinations counts the destinations of the connections logged since the last
+// call. Only whole lines are read, so one Xray is still writing is left for the next run.
+func (j *CheckClientIpJob) recordDestinations() {
+	accessLogPath, err := xray.GetAccessLogPath()
+	if err != nil {
+		return
+	}
+	file, err := os.Open(accessLogPath)
+	if err != nil {
+		j.checkError(err)
+		return
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		j.checkError(err)
+		return
+	}
+	if info.Size() < j.destinationOffset {
+		// The log was truncated by someone else
+		j.destinationOffset = 0
+	}
+	if _, err := file.Seek(j.destinationOffset, io.SeekStart); err != nil {
+		j.checkError(err)
+		return
+	}
+
+	var hits []service.DestinationHit
+	reader := bufio.NewReader(file)
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			break
+		}
+		j.destinationOffset += int64(len(line))
+		if hit, ok := parseDestinationHit(strings.TrimRight(line, "\r\n")); ok {
+			hits = append(hits, hit)
+		}
+	}
+	if err := j.destinationService.RecordDestinations(hits); err != nil {
+		logger.Warning("[Destinations] record failed:", err)
+	}
+}
+
+// parseDestinationHit reads the client, destination and outbound of an accepted
+// connection from a line of the access log, such as
+// "2006/01/02 15:04:05.000000 from 1.2.3.4:5678 accepted tcp:example.com:443 [inbound-443 >> direct] email: user".
+func parseDestinationHit(line string) (service.DestinationHit, bool) {
+	emailMatches := emailRegex.FindStringSubmatch(line)
+	if len(emailMatches) < 2 {
+		return service.DestinationHit{}, false
+	}
+	matches := destinationRegex.FindStringSubmatch(line)
+	if len(matches) < 2 {
+		return service.DestinationHit{}, false
+	}
+	hit := service.DestinationHit{
+		Email:       emailMatches[1],
+		Destination: strings.ToLower(strings.Trim(matches[1], "[]")),
+		Time:        time.Now(),
+	}
+	// Xray separates the inbound and outbound tags with ">>" or "->"
+	if i := strings.LastIndex(matches[2], ">"); i >= 0 {
+		hit.Outbound = strings.TrimSpace(matches[2][i+1:])
+	}
+	if timestampMatches := timestampRegex.FindStringSubmatch(line); len(timestampMatches) >= 2 {
+		if t, err := time.ParseInLocation("2006/01/02 15:04:05", timestampMatches[1], time.Local); err == nil {
+			hit.Time = t
+		}
+	}
+	return hit, true
+}
+
 func (j *CheckClientIpJob) checkFail2BanInstalled() bool {
 	cmd := "fail2ban-client"
 	args := []string{"-h"}
@@ -331,16 +436,6 @@ func (j *CheckClientIpJob) updateInboundClientIps(inboundClientIps *model.Inboun
 	shouldCleanLog := false
 	j.disAllowedIps = []string{}
 
-	// Open log file
-	logIpFile, err := os.OpenFile(xray.GetIPLimitLogPath(), os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
-	if err != nil {
-		logger.Errorf("failed to open IP limit log file: %s", err)
-		return false
-	}
-	defer logIpFile.Close()
-	log.SetOutput(logIpFile)
-	log.SetFlags(log.LstdFlags)
-
 	// Check if we exceed the limit
 	if len(allIps) > limitIp {
 		shouldCleanLog = true
@@ -348,16 +443,25 @@ func (j *CheckClientIpJob) updateInboundClientIps(inboundClientIps *model.Inboun
 		// Keep only the newest IPs (up to limitIp)
 		keptIps := allIps[:limitIp]
 		disconnectedIps := allIps[limitIp:]
-
-		// Log the disconnected IPs (old ones)
 		for _, ipTime := range disconnectedIps {
 			j.disAllowedIps = append(j.disAllowedIps, ipTime.IP)
-			log.Printf("[LIMIT_IP] Email = %s || Disconnecting OLD IP = %s || Timestamp = %d", clientEmail, ipTime.IP, ipTime.Timestamp)
 		}
 
-		// Actually disconnect old IPs by temporarily removing and re-adding user
-		// This forces Xray to drop existing connections from old IPs
-		if len(disconnectedIps) > 0 {
+		switch j.ipLimitMode {
+		case model.IPLimitModeXray, model.IPLimitModeNftables:
+			// Block the client or its old IPs for the cooldown
+			needRestart, err := j.inboundService.BanIPLimitClient(j.ipLimitMode, inbound, clientEmail, j.disAllowedIps)
+			if err != nil {
+				logger.Warningf("[LIMIT_IP] Failed to ban %s: %v", clientEmail, err)
+			} else if needRestart {
+				j.xrayService.SetToNeedRestart()
+			}
+		default:
+			if !j.logDisallowedIps(clientEmail, disconnectedIps) {
+				return false
+			}
+			// Actually disconnect old IPs by temporarily removing and re-adding user
+			// This forces Xray to drop existing connections from old IPs
 			j.disconnectClientTemporarily(inbound, clientEmail, clients)
 		}
 
@@ -384,6 +488,23 @@ func (j *CheckClientIpJob) updateInboundClientIps(inboundClientIps *model.Inboun
 	return shouldCleanLog
 }
 
+// logDisallowedIps writes the old IPs of a client to the IP limit log for Fail2Ban.
+func (j *CheckClientIpJob) logDisallowedIps(clientEmail string, disconnectedIps []IPWithTimestamp) bool {
+	logIpFile, err := os.OpenFile(xray.GetIPLimitLogPath(), os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		logger.Errorf("failed to open IP limit log file: %s", err)
+		return false
+	}
+	defer logIpFile.Close()
+	log.SetOutput(logIpFile)
+	log.SetFlags(log.LstdFlags)
+
+	for _, ipTime := range disconnectedIps {
+		log.Printf("[LIMIT_IP] Email = %s || Disconnecting OLD IP = %s || Timestamp = %d", clientEmail, ipTime.IP, ipTime.Timestamp)
+	}
+	return true
+}
+
 // disconnectClientTemporarily removes and re-adds a client to force disconnect old connections
 func (j *CheckClientIpJob) disconnectClientTemporarily(inbound *model.Inbound, clientEmail string, clients []model.Client) {
 	var xrayAPI xray.XrayAPI
@@ -443,10 +564,16 @@ func (j *CheckClientIpJob) getInboundByEmail(clientEmail string) (*model.Inbound
 	db := database.GetDB()
 	inbound := &model.Inbound{}
 
-	err := db.Model(&model.Inbound{}).Where("settings LIKE ?", "%"+clientEmail+"%").First(inbound).Error
+	err := db.Model(&model.Inbound{}).
+		Where("id IN (?)", db.Model(model.Client{}).Select("inbound_id").Where("email = ?", clientEmail)).
+		First(inbound).Error
 	if err != nil {
 		return nil, err
 	}
 
+	inboundService := service.InboundService{}
+	if err := inboundService.LoadClients(inbound); err != nil {
+		return nil, err
+	}
 	return inbound, nil
 }
@@ -0,0 +1,151 @@
+package job
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/mhsanaei/3x-ui/v2/database"
+	"github.com/mhsanaei/3x-ui/v2/database/model"
+	"github.com/mhsanaei/3x-ui/v2/logger"
+	"github.com/mhsanaei/3x-ui/v2/web/service"
+
+	"github.com/op/go-logging"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseDestinationHit(t *testing.T) {
+	logged := time.Date(2024, 5, 6, 7, 8, 9, 0, time.Local)
+	tests := []struct {
+		name  string
+		line  string
+		ok    bool
+		hit   service.DestinationHit
+		timed bool // The line has a timestamp
+	}{
+		{
+			name:  "domain routed by a rule",
+			line:  "2024/05/06 07:08:09.123456 from 1.2.3.4:51234 accepted tcp:www.Example.com:443 [inbound-443 >> direct] email: alice",
+			ok:    true,
+			hit:   service.DestinationHit{Email: "alice", Destination: "www.example.com", Outbound: "direct"},
+			timed: true,
+		},
+		{
+			name:  "default outbound",
+			line:  "2024/05/06 07:08:09.123456 from 1.2.3.4:51234 accepted udp:8.8.8.8:53 [inbound-443 -> dns-out] email: bob@example.com",
+			ok:    true,
+			hit:   service.DestinationHit{Email: "bob@example.com", Destination: "8.8.8.8", Outbound: "dns-out"},
+			timed: true,
+		},
+		{
+			name:  "ipv6 destination and source",
+			line:  "2024/05/06 07:08:09.123456 from [2001:db8::5]:51234 accepted tcp:[2001:DB8::1]:443 [inbound-443 >> warp] email: carol",
+			ok:    true,
+			hit:   service.DestinationHit{Email: "carol", Destination: "2001:db8::1", Outbound: "warp"},
+			timed: true,
+		},
+		{
+			name:  "source with network",
+			line:  "2024/05/06 07:08:09 from tcp:1.2.3.4:51234 accepted tcp:example.com:80 [inbound-443 >> block] email: dave",
+			ok:    true,
+			hit:   service.DestinationHit{Email: "dave", Destination: "example.com", Outbound: "block"},
+			timed: true,
+		},
+		{
+			name:  "without routing",
+			line:  "2024/05/06 07:08:09.123456 from 1.2.3.4:51234 accepted tcp:example.com:443 email: erin",
+			ok:    true,
+			hit:   service.DestinationHit{Email: "erin", Destination: "example.com"},
+			timed: true,
+		},
+		{
+			name: "without network or timestamp",
+			line: "from 1.2.3.4:51234 accepted example.com:443 [inbound-443 >> direct] email: frank",
+			ok:   true,
+			hit:  service.DestinationHit{Email: "frank", Destination: "example.com", Outbound: "direct"},
+		},
+		{
+			name: "without email",
+			line: "2024/05/06 07:08:09.123456 from 1.2.3.4:51234 accepted tcp:example.com:443 [inbound-443 >> direct]",
+		},
+		{
+			name: "rejected",
+			line: "2024/05/06 07:08:09.123456 from 1.2.3.4:51234 rejected  proxy/vless/encoding: invalid request user id email: grace",
+		},
+		{
+			name: "empty",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			hit, ok := parseDestinationHit(test.line)
+			require.Equal(t, test.ok, ok)
+			if !ok {
+				return
+			}
+			if test.timed {
+				assert.Equal(t, logged, hit.Time)
+			} else {
+				assert.WithinDuration(t, time.Now(), hit.Time, time.Minute)
+			}
+			hit.Time = time.Time{}
+			assert.Equal(t, test.hit, hit)
+		})
+	}
+}
+
+func TestRecordDestinations(t *testing.T) {
+	logger.InitLogger(logging.ERROR)
+	dir := t.TempDir()
+	require.NoError(t, database.InitDB(filepath.Join(dir, "x-ui.db")))
+	t.Cleanup(func() { database.CloseDB() })
+	accessLog := filepath.Join(dir, "access.log")
+	t.Setenv("XUI_BIN_FOLDER", dir)
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "config.json"), []byte(`{"log":{"access":"`+accessLog+`"}}`), 0o644))
+
+	line := func(email string) string {
+		return "2024/05/06 07:08:09.123456 from 1.2.3.4:51234 accepted tcp:example.com:443 [inbound-443 >> direct] email: " + email + "\n"
+	}
+	appendLog := func(text string) {
+		file, err := os.OpenFile(accessLog, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+		require.NoError(t, err)
+		_, err = file.WriteString(text)
+		require.NoError(t, err)
+		require.NoError(t, file.Close())
+	}
+	connections := func() map[string]int64 {
+		var rows []model.ClientDestination
+		require.NoError(t, database.GetDB().Find(&rows).Error)
+		counts := map[string]int64{}
+		for _, row := range rows {
+			counts[row.Email] += row.Connections
+		}
+		return counts
+	}
+
+	j := &CheckClientIpJob{}
+	partial := line("bob")
+	appendLog(line("alice") + line("alice") + partial[:20])
+	j.recordDestinations()
+	assert.Equal(t, map[string]int64{"alice": 2}, connections())
+	assert.Equal(t, int64(2*len(line("alice"))), j.destinationOffset)
+
+	// The line Xray was still writing is counted once it is whole
+	appendLog(partial[20:])
+	j.recordDestinations()
+	assert.Equal(t, map[string]int64{"alice": 2, "bob": 1}, connections())
+	assert.Equal(t, int64(2*len(line("alice"))+len(partial)), j.destinationOffset)
+
+	// Nothing new, nothing counted
+	j.recordDestinations()
+	assert.Equal(t, map[string]int64{"alice": 2, "bob": 1}, connections())
+
+	// A log truncated by someone else is read from the start
+	require.NoError(t, os.Truncate(accessLog, 0))
+	appendLog(line("carol"))
+	j.recordDestinations()
+	assert.Equal(t, map[string]int64{"alice": 2, "bob": 1, "carol": 1}, connections())
+	assert.Equal(t, int64(len(line("carol"))), j.destinationOffset)
+}
@@ -0,0 +1,29 @@
+package job
+
+import (
+	"github.com/mhsanaei/3x-ui/v2/logger"
+	"github.com/mhsanaei/3x-ui/v2/web/service"
+)
+
+// ClientDestinationJob drops the destination counts past their retention and trims the
+// closed days to the top destinations of each client.
+type ClientDestinationJob struct {
+	destinationService service.ClientDestinationService
+}
+
+// NewClientDestinationJob creates a new destination report cleanup job instance.
+func NewClientDestinationJob() *ClientDestinationJob {
+	return new(ClientDestinationJob)
+}
+
+// Run prunes the destination counts.
+func (j *ClientDestinationJob) Run() {
+	count, err := j.destinationService.PruneDestinations()
+	if err != nil {
+		logger.Warning("[Destinations] prune failed:", err)
+		return
+	}
+	if count > 0 {
+		logger.Infof("[Destinations] pruned %d destination counts", count)
+	}
+}
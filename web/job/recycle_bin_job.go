@@ -0,0 +1,29 @@
+package job
+
+import (
+	"github.com/mhsanaei/3x-ui/v2/logger"
+	"github.com/mhsanaei/3x-ui/v2/web/service"
+)
+
+// RecycleBinJob purges deleted clients and inbounds kept longer than the
+// "recycleBinDays" setting.
+type RecycleBinJob struct {
+	inboundService service.InboundService
+}
+
+// NewRecycleBinJob creates a new recycle bin cleanup job instance.
+func NewRecycleBinJob() *RecycleBinJob {
+	return new(RecycleBinJob)
+}
+
+// Run deletes the expired recycle bin entries for good.
+func (j *RecycleBinJob) Run() {
+	count, err := j.inboundService.PurgeRecycleBin(true)
+	if err != nil {
+		logger.Warning("[RecycleBin] purge expired items failed:", err)
+		return
+	}
+	if count > 0 {
+		logger.Infof("[RecycleBin] purged %d expired items", count)
+	}
+}
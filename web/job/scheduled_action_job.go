@@ -0,0 +1,55 @@
+package job
+
+import (
+	"strconv"
+
+	"github.com/mhsanaei/3x-ui/v2/database/model"
+	"github.com/mhsanaei/3x-ui/v2/logger"
+	"github.com/mhsanaei/3x-ui/v2/web/service"
+)
+
+// ScheduledActionJob runs the scheduled client and inbound actions that are due and
+// reports their outcome to the Telegram bot admins where asked.
+type ScheduledActionJob struct {
+	inboundService service.InboundService
+	xrayService    service.XrayService
+	tgbotService   service.Tgbot
+}
+
+// NewScheduledActionJob creates a new scheduled action job instance.
+func NewScheduledActionJob() *ScheduledActionJob {
+	return new(ScheduledActionJob)
+}
+
+// Run executes the due actions and restarts Xray if its API could not apply them.
+func (j *ScheduledActionJob) Run() {
+	actions, needRestart, err := j.inboundService.RunScheduledActions()
+	if err != nil {
+		logger.Warning("[ScheduledAction] run due actions failed:", err)
+		return
+	}
+	if needRestart {
+		j.xrayService.SetToNeedRestart()
+	}
+	for _, action := range actions {
+		if action.Status == model.ScheduleFailed {
+			logger.Warningf("[ScheduledAction] action %d failed: %s", action.Id, action.Result)
+		}
+		if !action.Notify || !j.tgbotService.IsRunning() {
+			continue
+		}
+		target := action.Email
+		if action.TargetType == model.ScheduleTargetInbound {
+			target = "inbound " + strconv.Itoa(action.InboundId)
+		}
+		key := "tgbot.messages.scheduledActionDone"
+		if action.Status == model.ScheduleFailed {
+			key = "tgbot.messages.scheduledActionFailed"
+		}
+		msg := j.tgbotService.I18nBot(key,
+			"Operation=="+action.Operation,
+			"Target=="+target,
+			"Result=="+action.Result)
+		j.tgbotService.SendMsgToTgbotAdmins(msg)
+	}
+}
@@ -0,0 +1,29 @@
+package job
+
+import (
+	"github.com/mhsanaei/3x-ui/v2/logger"
+	"github.com/mhsanaei/3x-ui/v2/web/service"
+)
+
+// SessionCleanupJob deletes the login sessions past their expiry, including the short
+// lived ones left behind by logins that were never finished.
+type SessionCleanupJob struct {
+	sessionService service.SessionService
+}
+
+// NewSessionCleanupJob creates a new session cleanup job instance.
+func NewSessionCleanupJob() *SessionCleanupJob {
+	return new(SessionCleanupJob)
+}
+
+// Run deletes the expired sessions.
+func (j *SessionCleanupJob) Run() {
+	count, err := j.sessionService.DelExpiredSessions()
+	if err != nil {
+		logger.Warning("[Sessions] delete expired sessions failed:", err)
+		return
+	}
+	if count > 0 {
+		logger.Debugf("[Sessions] deleted %d expired sessions", count)
+	}
+}
@@ -0,0 +1,91 @@
+package job
+
+import (
+	"encoding/json"
+
+	"github.com/mhsanaei/3x-ui/v2/database"
+	"github.com/mhsanaei/3x-ui/v2/database/model"
+	"github.com/mhsanaei/3x-ui/v2/logger"
+	"github.com/mhsanaei/3x-ui/v2/util/shaper"
+	"github.com/mhsanaei/3x-ui/v2/web/service"
+)
+
+// SpeedLimitJob caps the throughput of clients with a speed limit. Clients are matched
+// by the addresses CheckClientIpJob records for them from the Xray access log, so the
+// access log must be enabled.
+type SpeedLimitJob struct {
+	inboundService service.InboundService
+	lastErr        string
+}
+
+// NewSpeedLimitJob creates a new speed limit job instance.
+func NewSpeedLimitJob() *SpeedLimitJob {
+	return new(SpeedLimitJob)
+}
+
+// Run shapes the recorded addresses of every client with a speed limit.
+func (j *SpeedLimitJob) Run() {
+	speedLimits, err := j.inboundService.GetClientSpeedLimits()
+	if err != nil {
+		logger.Warning("[SpeedLimit] get client speed limits failed:", err)
+		return
+	}
+	limits := make([]shaper.Limit, 0, len(speedLimits))
+	if len(speedLimits) > 0 {
+		ips, err := j.clientIps(speedLimits)
+		if err != nil {
+			logger.Warning("[SpeedLimit] get client ips failed:", err)
+			return
+		}
+		for _, speedLimit := range speedLimits {
+			if len(ips[speedLimit.Email]) == 0 {
+				continue
+			}
+			limits = append(limits, shaper.Limit{
+				Name: speedLimit.Email,
+				IPs:  ips[speedLimit.Email],
+				Up:   speedLimit.Up,
+				Down: speedLimit.Down,
+			})
+		}
+	}
+
+	// Log each distinct failure once instead of every run
+	if err := shaper.Apply(limits); err != nil {
+		if err.Error() != j.lastErr {
+			logger.Warning("[SpeedLimit]", err)
+			j.lastErr = err.Error()
+		}
+		return
+	}
+	j.lastErr = ""
+}
+
+// clientIps returns the addresses recorded for the clients by email.
+func (j *SpeedLimitJob) clientIps(speedLimits []service.ClientSpeedLimit) (map[string][]string, error) {
+	emails := make([]string, 0, len(speedLimits))
+	for _, speedLimit := range speedLimits {
+		emails = append(emails, speedLimit.Email)
+	}
+	var records []model.InboundClientIps
+	err := database.GetDB().Where("client_email IN ?", emails).Find(&records).Error
+	if err != nil {
+		return nil, err
+	}
+	ips := make(map[string][]string, len(records))
+	for _, record := range records {
+		var withTime []IPWithTimestamp
+		if json.Unmarshal([]byte(record.Ips), &withTime) == nil {
+			for _, ip := range withTime {
+				ips[record.ClientEmail] = append(ips[record.ClientEmail], ip.IP)
+			}
+			continue
+		}
+		// Records written before timestamps were kept are plain lists
+		var plain []string
+		if json.Unmarshal([]byte(record.Ips), &plain) == nil {
+			ips[record.ClientEmail] = append(ips[record.ClientEmail], plain...)
+		}
+	}
+	return ips, nil
+}
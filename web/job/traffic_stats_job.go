@@ -0,0 +1,28 @@
+package job
+
+import (
+	"github.com/mhsanaei/3x-ui/v2/logger"
+	"github.com/mhsanaei/3x-ui/v2/web/service"
+)
+
+// TrafficStatsJob drops traffic history buckets past the retention of their span.
+type TrafficStatsJob struct {
+	trafficStatService service.TrafficStatService
+}
+
+// NewTrafficStatsJob creates a new traffic history cleanup job instance.
+func NewTrafficStatsJob() *TrafficStatsJob {
+	return new(TrafficStatsJob)
+}
+
+// Run deletes the expired hourly and daily traffic buckets.
+func (j *TrafficStatsJob) Run() {
+	count, err := j.trafficStatService.PurgeTrafficStats()
+	if err != nil {
+		logger.Warning("[TrafficStats] purge expired buckets failed:", err)
+		return
+	}
+	if count > 0 {
+		logger.Infof("[TrafficStats] purged %d expired buckets", count)
+	}
+}
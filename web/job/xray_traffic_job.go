@@ -71,10 +71,11 @@ func toInboundDTOPtr(in *model.Inbound) InboundDTO {
 
 // XrayTrafficJob collects and processes traffic statistics from Xray, updating the database and optionally informing external APIs.
 type XrayTrafficJob struct {
-	settingService  service.SettingService
-	xrayService     service.XrayService
-	inboundService  service.InboundService
-	outboundService service.OutboundService
+	settingService     service.SettingService
+	xrayService        service.XrayService
+	inboundService     service.InboundService
+	outboundService    service.OutboundService
+	trafficStatService service.TrafficStatService
 }
 
 // NewXrayTrafficJob creates a new traffic collection job instance.
@@ -99,6 +100,9 @@ func (j *XrayTrafficJob) Run() {
 	if err != nil {
 		logger.Warning("add outbound traffic failed:", err)
 	}
+	if err := j.trafficStatService.RecordTraffic(traffics, clientTraffics); err != nil {
+		logger.Warning("record traffic history failed:", err)
+	}
 	if ExternalTrafficInformEnable, err := j.settingService.GetExternalTrafficInformEnable(); ExternalTrafficInformEnable {
 		j.informTrafficToExternalAPI(traffics, clientTraffics)
 	} else if err != nil {
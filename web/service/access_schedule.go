@@ -0,0 +1,188 @@
+package service
+
+import (
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mhsanaei/3x-ui/v2/database"
+	"github.com/mhsanaei/3x-ui/v2/database/model"
+	"github.com/mhsanaei/3x-ui/v2/logger"
+	"github.com/mhsanaei/3x-ui/v2/util/common"
+	"github.com/mhsanaei/3x-ui/v2/xray"
+
+	"gorm.io/gorm"
+)
+
+// notScheduledOff is a condition on the client_traffics table that leaves out clients
+// outside their access windows.
+const notScheduledOff = "client_traffics.scheduled_off IS NULL OR client_traffics.scheduled_off = false"
+
+// parseClock returns the minutes since midnight of an HH:MM time, up to 24:00.
+func parseClock(value string) (int, error) {
+	hours, minutes, ok := strings.Cut(value, ":")
+	if ok && len(hours) == 2 && len(minutes) == 2 {
+		h, err1 := strconv.Atoi(hours)
+		m, err2 := strconv.Atoi(minutes)
+		if err1 == nil && err2 == nil && h >= 0 && m >= 0 && m < 60 && h*60+m <= 24*60 {
+			return h*60 + m, nil
+		}
+	}
+	return 0, common.NewError("invalid time of day:", value)
+}
+
+// scheduleLocation returns the time zone of schedule, the one of the server when it names none.
+func scheduleLocation(schedule *model.AccessSchedule) (*time.Location, error) {
+	if schedule.TimeZone == "" {
+		return time.Local, nil
+	}
+	return time.LoadLocation(schedule.TimeZone)
+}
+
+// checkAccessSchedule validates the access schedule of a client, if it has one.
+func checkAccessSchedule(schedule *model.AccessSchedule) error {
+	if schedule == nil {
+		return nil
+	}
+	if _, err := scheduleLocation(schedule); err != nil {
+		return common.NewError("unknown time zone:", schedule.TimeZone)
+	}
+	if len(schedule.Windows) == 0 {
+		return common.NewError("access schedule needs at least one window")
+	}
+	for _, window := range schedule.Windows {
+		if len(window.Days) == 0 {
+			return common.NewError("access window needs at least one weekday")
+		}
+		for _, day := range window.Days {
+			if day < 0 || day > 6 {
+				return common.NewError("invalid weekday:", day)
+			}
+		}
+		start, err := parseClock(window.Start)
+		if err != nil {
+			return err
+		}
+		end, err := parseClock(window.End)
+		if err != nil {
+			return err
+		}
+		if start == end {
+			return common.NewError("access window can not start and end at", window.Start)
+		}
+	}
+	return nil
+}
+
+// accessOpen reports whether schedule lets its client connect at now. Clients without
+// a schedule may always connect.
+func accessOpen(schedule *model.AccessSchedule, now time.Time) bool {
+	if schedule == nil {
+		return true
+	}
+	location, err := scheduleLocation(schedule)
+	if err != nil {
+		logger.Warning("Unable to load time zone of access schedule:", err)
+		return true
+	}
+	local := now.In(location)
+	minute := local.Hour()*60 + local.Minute()
+	today := int(local.Weekday())
+	yesterday := (today + 6) % 7
+	for _, window := range schedule.Windows {
+		start, err1 := parseClock(window.Start)
+		end, err2 := parseClock(window.End)
+		if err1 != nil || err2 != nil {
+			continue
+		}
+		if start < end {
+			if slices.Contains(window.Days, today) && minute >= start && minute < end {
+				return true
+			}
+			continue
+		}
+		// Overnight windows run from the start on their day to the end on the next one
+		if slices.Contains(window.Days, today) && minute >= start ||
+			slices.Contains(window.Days, yesterday) && minute < end {
+			return true
+		}
+	}
+	return false
+}
+
+// ApplyAccessSchedules marks the clients outside their access windows at now as
+// scheduled off and the rest as on again, and adds and removes their users through
+// the Xray API. When running is set, users of clients that stay off are removed again
+// too, in case another change added them back in the meantime. It reports whether
+// Xray needs a restart because the API failed.
+func (s *InboundService) ApplyAccessSchedules(now time.Time, running bool) (bool, error) {
+	var rows []struct {
+		model.Client
+		TrafficId    int
+		ScheduledOff bool
+	}
+	err := database.GetDB().Table("clients").
+		Select("clients.*, client_traffics.id AS traffic_id, client_traffics.scheduled_off").
+		Joins("JOIN client_traffics ON client_traffics.email = clients.email").
+		Where("clients.access_schedule IS NOT NULL OR client_traffics.scheduled_off = ?", true).
+		Scan(&rows).Error
+	if err != nil || len(rows) == 0 {
+		return false, err
+	}
+
+	var offIds, onIds, changedIds []int
+	stayingOff := map[int]model.Client{}
+	for _, row := range rows {
+		off := !accessOpen(row.AccessSchedule, now)
+		switch {
+		case off && row.ScheduledOff:
+			stayingOff[row.RecordId] = row.Client
+		case off:
+			offIds = append(offIds, row.TrafficId)
+			changedIds = append(changedIds, row.RecordId)
+		case row.ScheduledOff:
+			onIds = append(onIds, row.TrafficId)
+			changedIds = append(changedIds, row.RecordId)
+		}
+	}
+
+	var before, after map[int]model.Client
+	if len(changedIds) > 0 {
+		err = database.GetDB().Transaction(func(tx *gorm.DB) error {
+			var err error
+			if before, err = servedClients(tx, changedIds); err != nil {
+				return err
+			}
+			if len(offIds) > 0 {
+				err = tx.Model(xray.ClientTraffic{}).Where("id IN ?", offIds).Update("scheduled_off", true).Error
+				if err != nil {
+					return err
+				}
+			}
+			if len(onIds) > 0 {
+				err = tx.Model(xray.ClientTraffic{}).Where("id IN ?", onIds).Update("scheduled_off", false).Error
+				if err != nil {
+					return err
+				}
+			}
+			after, err = servedClients(tx, changedIds)
+			return err
+		})
+		if err != nil {
+			return false, err
+		}
+		logger.Infof("Access schedules turned %d clients off and %d on", len(offIds), len(onIds))
+	}
+	if !running {
+		// Xray serves the clients from the marks once it is started
+		return false, nil
+	}
+	if before == nil {
+		before = map[int]model.Client{}
+	}
+	for id, client := range stayingOff {
+		before[id] = client
+	}
+	return s.applyServedClients(before, after), nil
+}
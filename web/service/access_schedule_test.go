@@ -0,0 +1,124 @@
+package service
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/mhsanaei/3x-ui/v2/database"
+	"github.com/mhsanaei/3x-ui/v2/database/model"
+	"github.com/mhsanaei/3x-ui/v2/logger"
+	"github.com/mhsanaei/3x-ui/v2/xray"
+
+	"github.com/op/go-logging"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAccessOpen(t *testing.T) {
+	logger.InitLogger(logging.ERROR)
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	require.NoError(t, err)
+	// Monday 2026-03-02 in Berlin, UTC+1
+	monday := func(hour, minute int) time.Time {
+		return time.Date(2026, 3, 2, hour, minute, 0, 0, berlin)
+	}
+	weekdays := model.AccessWindow{Days: []int{1, 2, 3, 4, 5}, Start: "09:00", End: "17:30"}
+	nights := model.AccessWindow{Days: []int{1}, Start: "22:00", End: "06:00"}
+	allDay := model.AccessWindow{Days: []int{0}, Start: "00:00", End: "24:00"}
+
+	tests := []struct {
+		name     string
+		schedule *model.AccessSchedule
+		now      time.Time
+		open     bool
+	}{
+		{"no schedule", nil, monday(3, 0), true},
+		{"before the window", &model.AccessSchedule{TimeZone: "Europe/Berlin", Windows: []model.AccessWindow{weekdays}}, monday(8, 59), false},
+		{"at the start", &model.AccessSchedule{TimeZone: "Europe/Berlin", Windows: []model.AccessWindow{weekdays}}, monday(9, 0), true},
+		{"at the end", &model.AccessSchedule{TimeZone: "Europe/Berlin", Windows: []model.AccessWindow{weekdays}}, monday(17, 30), false},
+		{"other weekday", &model.AccessSchedule{TimeZone: "Europe/Berlin", Windows: []model.AccessWindow{weekdays}}, monday(12, 0).AddDate(0, 0, 5), false},
+		{"overnight on its day", &model.AccessSchedule{TimeZone: "Europe/Berlin", Windows: []model.AccessWindow{nights}}, monday(23, 0), true},
+		{"overnight past midnight", &model.AccessSchedule{TimeZone: "Europe/Berlin", Windows: []model.AccessWindow{nights}}, monday(5, 59).AddDate(0, 0, 1), true},
+		{"overnight ended", &model.AccessSchedule{TimeZone: "Europe/Berlin", Windows: []model.AccessWindow{nights}}, monday(6, 0).AddDate(0, 0, 1), false},
+		{"overnight before its day", &model.AccessSchedule{TimeZone: "Europe/Berlin", Windows: []model.AccessWindow{nights}}, monday(5, 0), false},
+		{"until midnight", &model.AccessSchedule{TimeZone: "Europe/Berlin", Windows: []model.AccessWindow{allDay}}, monday(23, 59).AddDate(0, 0, -1), true},
+		{"several windows", &model.AccessSchedule{TimeZone: "Europe/Berlin", Windows: []model.AccessWindow{weekdays, nights}}, monday(23, 0), true},
+		// 09:30 in Berlin is 17:30 in Tokyo, when the window there has just closed
+		{"zone of the client", &model.AccessSchedule{TimeZone: "Asia/Tokyo", Windows: []model.AccessWindow{weekdays}}, monday(9, 30), false},
+		{"zone of the client open", &model.AccessSchedule{TimeZone: "Asia/Tokyo", Windows: []model.AccessWindow{weekdays}}, monday(9, 29), true},
+		// 23:30 on Monday in Berlin is already Tuesday in UTC+14
+		{"weekday of the client", &model.AccessSchedule{TimeZone: "Pacific/Kiritimati", Windows: []model.AccessWindow{nights}}, monday(23, 30), false},
+		{"unknown zone stays open", &model.AccessSchedule{TimeZone: "Mars/Olympus", Windows: []model.AccessWindow{weekdays}}, monday(3, 0), true},
+	}
+	for _, test := range tests {
+		assert.Equal(t, test.open, accessOpen(test.schedule, test.now), test.name)
+	}
+}
+
+func TestAccessOpenDefaultsToServerZone(t *testing.T) {
+	local := time.Local
+	t.Cleanup(func() { time.Local = local })
+	zone, err := time.LoadLocation("America/New_York")
+	require.NoError(t, err)
+	time.Local = zone
+
+	schedule := &model.AccessSchedule{Windows: []model.AccessWindow{{Days: []int{1}, Start: "09:00", End: "17:00"}}}
+	require.NoError(t, checkAccessSchedule(schedule))
+	assert.True(t, accessOpen(schedule, time.Date(2026, 3, 2, 10, 0, 0, 0, zone)))
+	assert.False(t, accessOpen(schedule, time.Date(2026, 3, 2, 13, 30, 0, 0, time.UTC)), "08:30 in New York")
+	assert.True(t, accessOpen(schedule, time.Date(2026, 3, 2, 21, 0, 0, 0, time.UTC)), "16:00 in New York")
+}
+
+func TestCheckAccessSchedule(t *testing.T) {
+	window := model.AccessWindow{Days: []int{1}, Start: "09:00", End: "17:00"}
+	tests := []struct {
+		name     string
+		schedule *model.AccessSchedule
+		valid    bool
+	}{
+		{"none", nil, true},
+		{"server zone", &model.AccessSchedule{Windows: []model.AccessWindow{window}}, true},
+		{"named zone", &model.AccessSchedule{TimeZone: "Asia/Tokyo", Windows: []model.AccessWindow{window}}, true},
+		{"unknown zone", &model.AccessSchedule{TimeZone: "Mars/Olympus", Windows: []model.AccessWindow{window}}, false},
+		{"no windows", &model.AccessSchedule{}, false},
+		{"no days", &model.AccessSchedule{Windows: []model.AccessWindow{{Start: "09:00", End: "17:00"}}}, false},
+		{"bad day", &model.AccessSchedule{Windows: []model.AccessWindow{{Days: []int{7}, Start: "09:00", End: "17:00"}}}, false},
+		{"bad time", &model.AccessSchedule{Windows: []model.AccessWindow{{Days: []int{1}, Start: "9:00", End: "17:00"}}}, false},
+		{"past midnight", &model.AccessSchedule{Windows: []model.AccessWindow{{Days: []int{1}, Start: "09:00", End: "24:01"}}}, false},
+		{"empty window", &model.AccessSchedule{Windows: []model.AccessWindow{{Days: []int{1}, Start: "09:00", End: "09:00"}}}, false},
+	}
+	for _, test := range tests {
+		assert.Equal(t, test.valid, checkAccessSchedule(test.schedule) == nil, test.name)
+	}
+}
+
+func TestAddInboundClientLeavesUnservedClientsOut(t *testing.T) {
+	setupTestDB(t, nil)
+	db := database.GetDB()
+	inbound := addTestInbound(t)
+	addTestClient(t, inbound.Id, model.Client{Email: "first", Enable: true}, xray.ClientTraffic{Enable: true})
+	require.NoError(t, db.Create(&model.IPLimitBan{ClientEmail: "banned", Mode: model.IPLimitModeXray, Until: time.Now().Add(time.Hour).UnixMilli()}).Error)
+
+	// A window the day after tomorrow is closed now
+	later := strconv.Itoa((int(time.Now().Weekday()) + 2) % 7)
+	closed := `{"timeZone":"","windows":[{"days":[` + later + `],"start":"00:00","end":"01:00"}]}`
+	inbound.Settings = []byte(`{"clients":[
+		{"id":"uuid-scheduled","email":"scheduled","enable":true,"accessSchedule":` + closed + `},
+		{"id":"uuid-banned","email":"banned","enable":true}
+	]}`)
+	// No Xray runs in the test, adding a user through its API would fail
+	needRestart, err := (&InboundService{}).AddInboundClient(inbound)
+	require.NoError(t, err)
+	assert.False(t, needRestart)
+
+	traffic := &xray.ClientTraffic{}
+	require.NoError(t, db.Where("email = ?", "scheduled").First(traffic).Error)
+	assert.True(t, traffic.ScheduledOff, "marked off from the start")
+	var ids []int
+	require.NoError(t, db.Model(model.Client{}).Where("email IN ?", []string{"scheduled", "banned"}).Pluck("record_id", &ids).Error)
+	require.Len(t, ids, 2)
+	served, err := servedClients(db, ids)
+	require.NoError(t, err)
+	assert.Empty(t, served)
+}
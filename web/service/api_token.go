@@ -0,0 +1,130 @@
+package service
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/mhsanaei/3x-ui/v2/database"
+	"github.com/mhsanaei/3x-ui/v2/database/model"
+	"github.com/mhsanaei/3x-ui/v2/util/common"
+	"github.com/mhsanaei/3x-ui/v2/util/random"
+)
+
+const (
+	apiTokenPrefix = "xui_"
+	apiTokenLength = 40
+
+	// apiTokenLastUsedInterval limits how often the last-used timestamp is written.
+	apiTokenLastUsedInterval = int64(60 * 1000)
+)
+
+// APITokenService provides business logic for scoped bearer tokens used by the panel API.
+type APITokenService struct {
+	userService UserService
+}
+
+// hashAPIToken returns the hex-encoded SHA-256 hash under which a token is stored.
+func hashAPIToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// GetTokens returns the tokens owned by userId, or all tokens when userId is 0.
+func (s *APITokenService) GetTokens(userId int) ([]*model.APIToken, error) {
+	db := database.GetDB()
+	var tokens []*model.APIToken
+	query := db.Model(model.APIToken{}).Order("id")
+	if userId > 0 {
+		query = query.Where("user_id = ?", userId)
+	}
+	if err := query.Find(&tokens).Error; err != nil {
+		return nil, err
+	}
+	return tokens, nil
+}
+
+// AddToken creates a token for userId and returns it together with the plain token value.
+// The plain value is never stored and can not be retrieved again.
+func (s *APITokenService) AddToken(userId int, name string, scopes []string, expiryTime int64) (*model.APIToken, string, error) {
+	if name == "" {
+		return nil, "", errors.New("token name can not be empty")
+	}
+	cleaned := make([]string, 0, len(scopes))
+	for _, scope := range scopes {
+		scope = strings.TrimSpace(scope)
+		if scope == "" {
+			continue
+		}
+		if !model.IsValidScope(scope) {
+			return nil, "", common.NewError("invalid token scope:", scope)
+		}
+		cleaned = append(cleaned, scope)
+	}
+	if len(cleaned) == 0 {
+		return nil, "", errors.New("token needs at least one scope")
+	}
+	if expiryTime > 0 && expiryTime <= time.Now().UnixMilli() {
+		return nil, "", errors.New("token expiry time is in the past")
+	}
+
+	plain := apiTokenPrefix + random.Seq(apiTokenLength)
+	token := &model.APIToken{
+		UserId:     userId,
+		Name:       name,
+		TokenHash:  hashAPIToken(plain),
+		Prefix:     plain[:len(apiTokenPrefix)+6],
+		Scopes:     strings.Join(cleaned, ","),
+		ExpiryTime: expiryTime,
+	}
+	if err := database.GetDB().Create(token).Error; err != nil {
+		return nil, "", err
+	}
+	return token, plain, nil
+}
+
+// DelToken revokes a token. When userId is not 0 the token must belong to that user.
+func (s *APITokenService) DelToken(id int, userId int) error {
+	db := database.GetDB()
+	query := db.Where("id = ?", id)
+	if userId > 0 {
+		query = query.Where("user_id = ?", userId)
+	}
+	result := query.Delete(&model.APIToken{})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return common.NewError("token not found:", id)
+	}
+	return nil
+}
+
+// Authenticate resolves a plain bearer token to the token record and its owner.
+// Unknown, expired and orphaned tokens are rejected. The last-used timestamp is refreshed.
+func (s *APITokenService) Authenticate(plain string) (*model.APIToken, *model.User, error) {
+	if !strings.HasPrefix(plain, apiTokenPrefix) {
+		return nil, nil, errors.New("invalid token")
+	}
+	db := database.GetDB()
+	token := &model.APIToken{}
+	err := db.Model(model.APIToken{}).Where("token_hash = ?", hashAPIToken(plain)).First(token).Error
+	if err != nil {
+		return nil, nil, errors.New("invalid token")
+	}
+	now := time.Now().UnixMilli()
+	if token.ExpiryTime > 0 && token.ExpiryTime <= now {
+		return nil, nil, errors.New("token expired")
+	}
+	user, err := s.userService.GetUserById(token.UserId)
+	if err != nil {
+		return nil, nil, errors.New("token owner not found")
+	}
+	if now-token.LastUsed > apiTokenLastUsedInterval {
+		token.LastUsed = now
+		db.Model(model.APIToken{}).Where("id = ?", token.Id).UpdateColumn("last_used", now)
+	}
+	return token, user, nil
+}
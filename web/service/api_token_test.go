@@ -0,0 +1,82 @@
+package service
+
+import (
+	"testing"
+	"time"
+
+	"github.com/mhsanaei/3x-ui/v2/database"
+	"github.com/mhsanaei/3x-ui/v2/database/model"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHashAPIToken(t *testing.T) {
+	assert.Equal(t, "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855", hashAPIToken(""))
+	assert.Len(t, hashAPIToken("xui_token"), 64)
+	assert.NotEqual(t, hashAPIToken("xui_a"), hashAPIToken("xui_b"))
+}
+
+func TestTokenScopes(t *testing.T) {
+	tests := []struct {
+		scopes  string
+		allowed []model.Permission
+		denied  []model.Permission
+	}{
+		{"inbounds:read", []model.Permission{model.PermView}, []model.Permission{model.PermInboundManage, model.PermClientManage}},
+		{"clients:write", []model.Permission{model.PermClientSupport, model.PermClientManage}, []model.Permission{model.PermInboundManage}},
+		{"inbounds:read, server:admin", []model.Permission{model.PermView, model.PermServerManage}, []model.Permission{model.PermSettings}},
+		{"settings:admin", []model.Permission{model.PermAudit}, []model.Permission{model.PermSettings, model.PermUserManage}},
+		{"unknown", nil, []model.Permission{model.PermView}},
+	}
+	for _, test := range tests {
+		token := &model.APIToken{Scopes: test.scopes}
+		for _, perm := range test.allowed {
+			assert.True(t, token.Allows(perm), "%s allows %s", test.scopes, perm)
+		}
+		for _, perm := range test.denied {
+			assert.False(t, token.Allows(perm), "%s denies %s", test.scopes, perm)
+		}
+	}
+}
+
+func TestAPITokenLifecycle(t *testing.T) {
+	setupTestDB(t, nil)
+	s := &APITokenService{}
+
+	_, _, err := s.AddToken(1, "bad", []string{"inbounds:read", "root"}, 0)
+	assert.Error(t, err, "unknown scope")
+	_, _, err = s.AddToken(1, "empty", []string{" "}, 0)
+	assert.Error(t, err, "no scope")
+	_, _, err = s.AddToken(1, "past", []string{"inbounds:read"}, time.Now().Add(-time.Minute).UnixMilli())
+	assert.Error(t, err, "expiry in the past")
+
+	token, plain, err := s.AddToken(1, "ci", []string{" inbounds:read ", "clients:write"}, 0)
+	require.NoError(t, err)
+	assert.Equal(t, "inbounds:read,clients:write", token.Scopes)
+	assert.Equal(t, plain[:len(token.Prefix)], token.Prefix)
+	assert.Equal(t, hashAPIToken(plain), token.TokenHash)
+	stored := &model.APIToken{}
+	require.NoError(t, database.GetDB().First(stored, token.Id).Error)
+	assert.NotContains(t, stored.TokenHash, plain, "plain token is never stored")
+
+	found, user, err := s.Authenticate(plain)
+	require.NoError(t, err)
+	assert.Equal(t, token.Id, found.Id)
+	assert.Equal(t, 1, user.Id)
+	assert.NotZero(t, found.LastUsed)
+
+	for _, bad := range []string{"", "xui_wrong", plain[len(apiTokenPrefix):]} {
+		_, _, err = s.Authenticate(bad)
+		assert.Error(t, err, bad)
+	}
+
+	require.NoError(t, database.GetDB().Model(stored).Update("expiry_time", time.Now().Add(-time.Second).UnixMilli()).Error)
+	_, _, err = s.Authenticate(plain)
+	assert.Error(t, err, "expired token")
+
+	assert.Error(t, s.DelToken(token.Id, 2), "token of another user")
+	require.NoError(t, s.DelToken(token.Id, 1))
+	_, _, err = s.Authenticate(plain)
+	assert.Error(t, err, "revoked token")
+}
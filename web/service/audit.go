@@ -0,0 +1,240 @@
+package service
+
+import (
+	"encoding/json"
+	"reflect"
+	"time"
+
+	"github.com/mhsanaei/3x-ui/v2/database"
+	"github.com/mhsanaei/3x-ui/v2/database/model"
+	"github.com/mhsanaei/3x-ui/v2/logger"
+
+	"gorm.io/gorm"
+)
+
+// AuditActor identifies who performed an audited action.
+type AuditActor struct {
+	Type string // One of the model.AuditActor* constants
+	Id   int64  // User id, token id or Telegram chat id
+	Name string // Username, token name or Telegram username
+	Ip   string // Source IP address, empty for Telegram and system actions
+
+	UserId int // Panel user acting directly or through a token, 0 for Telegram and system actions
+}
+
+// AuditFilter narrows down audit log queries. Zero values are ignored.
+type AuditFilter struct {
+	ActorType   string `json:"actorType" form:"actorType"`
+	ActorName   string `json:"actorName" form:"actorName"`
+	Action      string `json:"action" form:"action"`
+	InboundId   int    `json:"inboundId" form:"inboundId"`
+	ClientEmail string `json:"clientEmail" form:"clientEmail"`
+	From        int64  `json:"from" form:"from"` // Start timestamp in milliseconds
+	To          int64  `json:"to" form:"to"`     // End timestamp in milliseconds
+	Limit       int    `json:"limit" form:"limit"`
+	Offset      int    `json:"offset" form:"offset"`
+}
+
+const (
+	defaultAuditLimit = 100
+	maxAuditLimit     = 1000
+)
+
+// auditRedacted replaces the values of credentials in audit entries.
+const auditRedacted = "[redacted]"
+
+// auditSecrets are the keys whose values are credentials, wherever they appear in
+// audited values: the ids and passwords of clients and accounts, and the keys of
+// inbounds like the REALITY and WireGuard private keys and TLS certificate keys. Only
+// string ids are client UUIDs; numeric ones are row ids and kept.
+var auditSecrets = map[string]bool{
+	"id":           true,
+	"password":     true,
+	"pass":         true,
+	"auth":         true,
+	"seed":         true,
+	"key":          true,
+	"privateKey":   true,
+	"secretKey":    true,
+	"preSharedKey": true,
+	"mldsa65Seed":  true,
+}
+
+// AuditService provides persistence and queries for the audit log.
+type AuditService struct{}
+
+// Record stores an audit entry, inside tx when it is not nil. before and after are
+// marshaled to JSON and reduced to the fields that differ; either may be nil. A nil
+// actor is recorded as system. The entry is written in a nested transaction, which
+// is a savepoint inside tx, so a failed insert is rolled back on its own and does not
+// abort tx on databases like PostgreSQL. Failures are logged and never returned so
+// auditing can not break the audited action.
+func (s *AuditService) Record(tx *gorm.DB, actor *AuditActor, action string, inboundId int, email string, before any, after any) {
+	if actor == nil {
+		actor = &AuditActor{Type: model.AuditActorSystem}
+	}
+	beforeJson, afterJson := auditDiff(before, after)
+	entry := &model.AuditLog{
+		ActorType:   actor.Type,
+		ActorId:     actor.Id,
+		ActorName:   actor.Name,
+		Action:      action,
+		InboundId:   inboundId,
+		ClientEmail: email,
+		Before:      beforeJson,
+		After:       afterJson,
+		Ip:          actor.Ip,
+		CreatedAt:   time.Now().UnixMilli(),
+	}
+	if tx == nil {
+		tx = database.GetDB()
+	}
+	err := tx.Transaction(func(tx *gorm.DB) error {
+		return tx.Create(entry).Error
+	})
+	if err != nil {
+		logger.Warning("Unable to write audit log:", err)
+	}
+}
+
+// GetLogs returns the audit entries matching filter, newest first, and the total match count.
+func (s *AuditService) GetLogs(filter *AuditFilter) ([]model.AuditLog, int64, error) {
+	db := database.GetDB()
+	query := db.Model(model.AuditLog{})
+	if filter.ActorType != "" {
+		query = query.Where("actor_type = ?", filter.ActorType)
+	}
+	if filter.ActorName != "" {
+		query = query.Where("actor_name = ?", filter.ActorName)
+	}
+	if filter.Action != "" {
+		query = query.Where("action = ?", filter.Action)
+	}
+	if filter.InboundId > 0 {
+		query = query.Where("inbound_id = ?", filter.InboundId)
+	}
+	if filter.ClientEmail != "" {
+		query = query.Where("client_email = ?", filter.ClientEmail)
+	}
+	if filter.From > 0 {
+		query = query.Where("created_at >= ?", filter.From)
+	}
+	if filter.To > 0 {
+		query = query.Where("created_at <= ?", filter.To)
+	}
+
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+
+	limit := filter.Limit
+	if limit <= 0 {
+		limit = defaultAuditLimit
+	} else if limit > maxAuditLimit {
+		limit = maxAuditLimit
+	}
+	var logs []model.AuditLog
+	err := query.Order("id desc").Limit(limit).Offset(filter.Offset).Find(&logs).Error
+	if err != nil {
+		return nil, 0, err
+	}
+	return logs, total, nil
+}
+
+// auditDiff marshals before and after to JSON, keeping only the fields that differ
+// when both are objects. Nested objects are reduced recursively. Credentials are
+// redacted after the comparison, so that changing one still shows up.
+func auditDiff(before any, after any) (string, string) {
+	b := toAuditValue(before)
+	a := toAuditValue(after)
+	bMap, bOk := b.(map[string]any)
+	aMap, aOk := a.(map[string]any)
+	if bOk && aOk {
+		b, a = diffMaps(bMap, aMap)
+	}
+	return marshalAuditValue(redactAudit(b)), marshalAuditValue(redactAudit(a))
+}
+
+// redactAudit replaces the non-empty values of the auditSecrets keys anywhere in the
+// generic JSON value v, in place, and returns it.
+func redactAudit(v any) any {
+	switch value := v.(type) {
+	case map[string]any:
+		for key, item := range value {
+			if !auditSecrets[key] {
+				value[key] = redactAudit(item)
+				continue
+			}
+			if _, isString := item.(string); key == "id" && !isString {
+				continue
+			}
+			if item != nil && item != "" {
+				value[key] = auditRedacted
+			}
+		}
+	case []any:
+		for i, item := range value {
+			value[i] = redactAudit(item)
+		}
+	}
+	return v
+}
+
+// diffMaps returns copies of before and after restricted to keys whose values differ.
+func diffMaps(before map[string]any, after map[string]any) (map[string]any, map[string]any) {
+	bOut := map[string]any{}
+	aOut := map[string]any{}
+	for key, bVal := range before {
+		aVal, ok := after[key]
+		if !ok {
+			bOut[key] = bVal
+			continue
+		}
+		if reflect.DeepEqual(bVal, aVal) {
+			continue
+		}
+		bSub, bIsMap := bVal.(map[string]any)
+		aSub, aIsMap := aVal.(map[string]any)
+		if bIsMap && aIsMap {
+			bOut[key], aOut[key] = diffMaps(bSub, aSub)
+		} else {
+			bOut[key] = bVal
+			aOut[key] = aVal
+		}
+	}
+	for key, aVal := range after {
+		if _, ok := before[key]; !ok {
+			aOut[key] = aVal
+		}
+	}
+	return bOut, aOut
+}
+
+// toAuditValue converts v to its generic JSON representation.
+func toAuditValue(v any) any {
+	if v == nil {
+		return nil
+	}
+	bs, err := json.Marshal(v)
+	if err != nil {
+		return nil
+	}
+	var out any
+	if err := json.Unmarshal(bs, &out); err != nil {
+		return nil
+	}
+	return out
+}
+
+// marshalAuditValue encodes a generic JSON value, returning an empty string for nil.
+func marshalAuditValue(v any) string {
+	if v == nil {
+		return ""
+	}
+	bs, err := json.Marshal(v)
+	if err != nil {
+		return ""
+	}
+	return string(bs)
+}
@@ -0,0 +1,79 @@
+package service
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/mhsanaei/3x-ui/v2/database"
+	"github.com/mhsanaei/3x-ui/v2/database/model"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"gorm.io/gorm"
+)
+
+func TestAuditRecordFollowsTransaction(t *testing.T) {
+	setupTestDB(t, nil)
+	s := &AuditService{}
+	db := database.GetDB()
+
+	err := db.Transaction(func(tx *gorm.DB) error {
+		s.Record(tx, nil, "client.add", 1, "kept", nil, map[string]any{"email": "kept"})
+		return nil
+	})
+	require.NoError(t, err)
+	err = db.Transaction(func(tx *gorm.DB) error {
+		s.Record(tx, nil, "client.add", 1, "rolled-back", nil, nil)
+		return errors.New("audited change failed")
+	})
+	require.Error(t, err)
+
+	logs, total, err := s.GetLogs(&AuditFilter{})
+	require.NoError(t, err)
+	require.EqualValues(t, 1, total)
+	assert.Equal(t, "kept", logs[0].ClientEmail)
+	assert.Equal(t, model.AuditActorSystem, logs[0].ActorType)
+}
+
+func TestAuditFailureKeepsAuditedChange(t *testing.T) {
+	setupTestDB(t, nil)
+	db := database.GetDB()
+	require.NoError(t, db.Migrator().DropTable(&model.AuditLog{}))
+
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&model.Setting{Key: "audited", Value: "1"}).Error; err != nil {
+			return err
+		}
+		(&AuditService{}).Record(tx, nil, "setting.update", 0, "", nil, nil)
+		return tx.Model(&model.Setting{}).Where("key = ?", "audited").Update("value", "2").Error
+	})
+	require.NoError(t, err)
+	setting := &model.Setting{}
+	require.NoError(t, db.Where("key = ?", "audited").First(setting).Error)
+	assert.Equal(t, "2", setting.Value)
+}
+
+func TestAuditRedactsCredentials(t *testing.T) {
+	before := model.Client{ID: "uuid-old", Email: "alice", Password: "secret-1", TotalGB: 100}
+	after := model.Client{ID: "uuid-new", Email: "alice", Password: "secret-1", TotalGB: 200}
+	b, a := auditDiff(before, after)
+	assert.JSONEq(t, `{"id":"[redacted]","totalGB":100}`, b)
+	assert.JSONEq(t, `{"id":"[redacted]","totalGB":200}`, a, "a changed id still shows up")
+
+	inbound := &model.Inbound{
+		Id:             7,
+		Protocol:       model.VLESS,
+		Settings:       []byte(`{"clients":[{"id":"uuid-1","email":"alice","flow":""}],"decryption":"none"}`),
+		StreamSettings: []byte(`{"security":"reality","realitySettings":{"privateKey":"private","publicKey":"public","shortIds":[""]},"tlsSettings":{"certificates":[{"certificate":["cert"],"key":["private"]}]}}`),
+	}
+	_, a = auditDiff(nil, inbound)
+	assert.NotContains(t, a, "uuid-1")
+	assert.NotContains(t, a, `"private"`)
+	assert.Contains(t, a, `"id":7`, "row ids are kept")
+	assert.Contains(t, a, `"publicKey":"public"`)
+	assert.Contains(t, a, `"email":"alice"`)
+
+	_, a = auditDiff(nil, map[string]any{"accounts": []any{map[string]any{"user": "bob", "pass": "hunter2"}}, "password": ""})
+	assert.JSONEq(t, `{"accounts":[{"user":"bob","pass":"[redacted]"}],"password":""}`, a)
+}
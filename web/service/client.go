@@ -0,0 +1,460 @@
+package service
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+
+	"github.com/mhsanaei/3x-ui/v2/database"
+	"github.com/mhsanaei/3x-ui/v2/database/model"
+	"github.com/mhsanaei/3x-ui/v2/util/common"
+	"github.com/mhsanaei/3x-ui/v2/xray"
+
+	"gorm.io/gorm"
+)
+
+// Clients live in their own table. Inbounds are stored without the "clients" list in
+// their settings; it is filled from the table whenever inbounds are loaded, so the panel
+// and subscriptions keep reading and editing the settings as a whole, while traffic
+// accounting and Xray config generation query the table directly.
+
+// LoadClients fills the client list in the settings of inbounds that were read
+// directly from the database instead of through InboundService.
+func (s *InboundService) LoadClients(inbounds ...*model.Inbound) error {
+	_, err := s.loadClients(database.GetDB(), inbounds...)
+	return err
+}
+
+// loadClients fills the client list in the settings of inbounds from the clients table
+// and returns the clients by inbound id.
+func (s *InboundService) loadClients(db *gorm.DB, inbounds ...*model.Inbound) (map[int][]model.Client, error) {
+	ids := make([]int, 0, len(inbounds))
+	for _, inbound := range inbounds {
+		if inbound.Protocol.HasClients() {
+			ids = append(ids, inbound.Id)
+		}
+	}
+	byInbound := make(map[int][]model.Client, len(ids))
+	if len(ids) == 0 {
+		return byInbound, nil
+	}
+	var clients []model.Client
+	if err := db.Where("inbound_id IN ?", ids).Order("record_id").Find(&clients).Error; err != nil {
+		return nil, err
+	}
+	for _, client := range clients {
+		byInbound[client.InboundId] = append(byInbound[client.InboundId], client)
+	}
+	for _, inbound := range inbounds {
+		if !inbound.Protocol.HasClients() {
+			continue
+		}
+		clients := byInbound[inbound.Id]
+		if clients == nil {
+			clients = []model.Client{}
+		}
+		if err := setSettingsClients(inbound, clients); err != nil {
+			return nil, err
+		}
+	}
+	return byInbound, nil
+}
+
+// setSettingsClients replaces the client list in the settings of inbound, or removes
+// it when clients is nil.
+func setSettingsClients(inbound *model.Inbound, clients any) error {
+	settings := map[string]json.RawMessage{}
+	if len(inbound.Settings) > 0 {
+		if err := json.Unmarshal(inbound.Settings, &settings); err != nil {
+			return err
+		}
+	}
+	if clients == nil {
+		delete(settings, "clients")
+	} else {
+		data, err := json.Marshal(clients)
+		if err != nil {
+			return err
+		}
+		settings["clients"] = data
+	}
+	data, err := json.MarshalIndent(settings, "", "  ")
+	if err != nil {
+		return err
+	}
+	inbound.SetSettingsString(string(data))
+	return nil
+}
+
+// parseClients returns the client list in settings and whether there is one. Like
+// GetClients it leaves fields of the wrong type empty, such as a tgId sent as "".
+func parseClients(settings []byte) ([]model.Client, bool, error) {
+	parsed := map[string]json.RawMessage{}
+	if err := json.Unmarshal(settings, &parsed); err != nil {
+		return nil, false, err
+	}
+	data, ok := parsed["clients"]
+	if !ok {
+		return nil, false, nil
+	}
+	var clients []model.Client
+	var typeErr *json.UnmarshalTypeError
+	if err := json.Unmarshal(data, &clients); err != nil && !errors.As(err, &typeErr) {
+		return nil, true, err
+	}
+	return clients, true, nil
+}
+
+// saveInbound stores inbound without the client list in its settings and brings the
+// clients table in line with that list. Settings without a list leave the clients as
+// they are, unless the protocol has no clients at all.
+func (s *InboundService) saveInbound(tx *gorm.DB, inbound *model.Inbound) error {
+	clients, hasClients, err := parseClients(inbound.Settings)
+	if err != nil {
+		return err
+	}
+	settings := inbound.Settings
+	if err := setSettingsClients(inbound, nil); err != nil {
+		return err
+	}
+	err = tx.Save(inbound).Error
+	inbound.Settings = settings
+	if err != nil {
+		return err
+	}
+	if !inbound.Protocol.HasClients() {
+		return s.syncClients(tx, inbound, nil)
+	}
+	if !hasClients {
+		return nil
+	}
+	return s.syncClients(tx, inbound, clients)
+}
+
+// syncClients replaces the clients of an inbound. Rows of clients that keep their id,
+// or else their email, are updated in place, so a renamed client keeps its record id
+// and with it its group memberships, and its traffic row. Clients without a traffic
+// row get one and the rows of removed clients are deleted.
+func (s *InboundService) syncClients(tx *gorm.DB, inbound *model.Inbound, clients []model.Client) error {
+	var rows []model.Client
+	if err := tx.Where("inbound_id = ?", inbound.Id).Order("record_id").Find(&rows).Error; err != nil {
+		return err
+	}
+	byKey := make(map[string]model.Client, len(rows))
+	byEmail := make(map[string]model.Client, len(rows))
+	for _, row := range rows {
+		if key := clientKey(inbound.Protocol, &row); key != "" {
+			if _, ok := byKey[key]; !ok {
+				byKey[key] = row
+			}
+		}
+		if _, ok := byEmail[row.Email]; !ok {
+			byEmail[row.Email] = row
+		}
+	}
+	// Ids are matched first, so that a client taking over the email of another one
+	// does not take its row too
+	kept := make(map[int]bool, len(rows))
+	matched := make([]*model.Client, len(clients))
+	for pass, index := range []map[string]model.Client{byKey, byEmail} {
+		for i := range clients {
+			key := clients[i].Email
+			if pass == 0 {
+				key = clientKey(inbound.Protocol, &clients[i])
+			}
+			if row, ok := index[key]; ok && key != "" && matched[i] == nil && !kept[row.RecordId] {
+				kept[row.RecordId] = true
+				matched[i] = &row
+			}
+		}
+	}
+	// Removed clients go first, so that others may take over their emails
+	removed := make([]int, 0, len(rows))
+	removedEmails := make([]string, 0, len(rows))
+	for _, row := range rows {
+		if !kept[row.RecordId] {
+			removed = append(removed, row.RecordId)
+			removedEmails = append(removedEmails, row.Email)
+		}
+	}
+	if len(removed) > 0 {
+		if err := tx.Where("record_id IN ?", removed).Delete(&model.Client{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("email IN ?", removedEmails).Delete(xray.ClientTraffic{}).Error; err != nil {
+			return err
+		}
+	}
+	// Renamed clients take their traffic row along, whose foreign key renames the
+	// client row with it
+	for i, client := range clients {
+		row := matched[i]
+		if row == nil || row.Email == client.Email {
+			continue
+		}
+		err := tx.Model(xray.ClientTraffic{}).Where("email = ?", row.Email).Update("email", client.Email).Error
+		if err != nil {
+			return err
+		}
+		if err := s.UpdateClientIPs(tx, row.Email, client.Email); err != nil {
+			return err
+		}
+	}
+	for i, client := range clients {
+		client.InboundId = inbound.Id
+		client.RecordId = 0
+		if row := matched[i]; row != nil {
+			client.RecordId = row.RecordId
+			if reflect.DeepEqual(client, *row) {
+				continue
+			}
+		}
+		if err := s.ensureClientStat(tx, inbound.Id, &client); err != nil {
+			return err
+		}
+		if err := tx.Save(&client).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ensureClientStat creates the traffic row of client unless there already is one.
+func (s *InboundService) ensureClientStat(tx *gorm.DB, inboundId int, client *model.Client) error {
+	var count int64
+	if err := tx.Model(xray.ClientTraffic{}).Where("email = ?", client.Email).Count(&count).Error; err != nil {
+		return err
+	}
+	if count > 0 {
+		return nil
+	}
+	return s.AddClientStat(tx, inboundId, client)
+}
+
+// validateClients rejects clients without the id their protocol identifies them by, or
+// without an email, which links them to their traffic.
+func validateClients(protocol model.Protocol, clients []model.Client) error {
+	for _, client := range clients {
+		switch protocol {
+		case model.Trojan:
+			if client.Password == "" {
+				return common.NewError("empty client ID")
+			}
+		case model.Shadowsocks:
+			if client.Email == "" {
+				return common.NewError("empty client ID")
+			}
+		default:
+			if client.ID == "" {
+				return common.NewError("empty client ID")
+			}
+		}
+		if client.Email == "" {
+			return common.NewError("empty client email")
+		}
+		if err := checkAccessSchedule(client.AccessSchedule); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// clientKeyColumn returns the column that holds the id clients of protocol are addressed by.
+func clientKeyColumn(protocol model.Protocol) string {
+	switch protocol {
+	case model.Trojan:
+		return "password"
+	case model.Shadowsocks:
+		return "email"
+	default:
+		return "uuid"
+	}
+}
+
+// clientKey returns the id a client of protocol is addressed by.
+func clientKey(protocol model.Protocol, client *model.Client) string {
+	switch protocol {
+	case model.Trojan:
+		return client.Password
+	case model.Shadowsocks:
+		return client.Email
+	default:
+		return client.ID
+	}
+}
+
+// findClient returns the client of inbound addressed by clientId.
+func (s *InboundService) findClient(db *gorm.DB, inbound *model.Inbound, clientId string) (*model.Client, error) {
+	client := &model.Client{}
+	err := db.Where("inbound_id = ? AND "+clientKeyColumn(inbound.Protocol)+" = ?", inbound.Id, clientId).
+		Order("record_id").Limit(1).Find(client).Error
+	if err != nil {
+		return nil, err
+	}
+	if client.RecordId == 0 {
+		return nil, common.NewError("Client Not Found:", clientId)
+	}
+	return client, nil
+}
+
+// apiUser returns the user of the Xray API for client on inbound.
+func apiUser(inbound *model.Inbound, client *model.Client) map[string]any {
+	cipher := ""
+	if inbound.Protocol == model.Shadowsocks {
+		settings := map[string]any{}
+		json.Unmarshal(inbound.Settings, &settings)
+		cipher, _ = settings["method"].(string)
+	}
+	return map[string]any{
+		"email":    client.Email,
+		"id":       client.ID,
+		"security": client.Security,
+		"flow":     client.Flow,
+		"password": client.Password,
+		"cipher":   cipher,
+	}
+}
+
+// xrayClient returns the fields of client that the Xray config of protocol needs.
+func xrayClient(protocol model.Protocol, client *model.Client) map[string]any {
+	c := map[string]any{"email": client.Email}
+	switch protocol {
+	case model.Trojan:
+		c["password"] = client.Password
+	case model.Shadowsocks:
+		c["password"] = client.Password
+		if client.Method != "" {
+			c["method"] = client.Method
+		}
+	default:
+		c["id"] = client.ID
+	}
+	if client.Flow == "xtls-rprx-vision-udp443" {
+		c["flow"] = "xtls-rprx-vision"
+	} else if client.Flow != "" {
+		c["flow"] = client.Flow
+	}
+	return c
+}
+
+// GetXrayClients returns the clients Xray should serve by inbound id: enabled clients
+// whose traffic and time are not used up and that are not banned for their IP limit
+// or outside their access windows, with the fields Xray needs.
+func (s *InboundService) GetXrayClients() (map[int][]any, error) {
+	return xrayClients(database.GetDB())
+}
+
+// xrayClients returns the clients Xray should serve among the ones db selects, like
+// GetXrayClients.
+func xrayClients(db *gorm.DB) (map[int][]any, error) {
+	var rows []struct {
+		model.Client
+		Protocol model.Protocol
+	}
+	err := db.Table("clients").
+		Select("clients.*, inbounds.protocol").
+		Joins("JOIN inbounds ON inbounds.id = clients.inbound_id").
+		Joins("LEFT JOIN client_traffics ON client_traffics.email = clients.email").
+		Where("clients.enable = ? AND (client_traffics.enable IS NULL OR client_traffics.enable = ?)", true, true).
+		Where(notIPLimitBanned).
+		Where(notScheduledOff).
+		Order("clients.record_id").
+		Scan(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+	result := map[int][]any{}
+	for i := range rows {
+		result[rows[i].InboundId] = append(result[rows[i].InboundId], xrayClient(rows[i].Protocol, &rows[i].Client))
+	}
+	return result, nil
+}
+
+// xrayInboundConfig returns the Xray config of inbound for adding it through the API,
+// with only the clients Xray should serve as db sees them, like GetXrayConfig.
+func xrayInboundConfig(db *gorm.DB, inbound *model.Inbound) (*xray.InboundConfig, error) {
+	config := *inbound
+	if inbound.Protocol.HasClients() {
+		clients, err := xrayClients(db.Where("clients.inbound_id = ?", inbound.Id))
+		if err != nil {
+			return nil, err
+		}
+		settings := map[string]any{}
+		if err := json.Unmarshal([]byte(inbound.Settings), &settings); err != nil {
+			return nil, err
+		}
+		settings["clients"] = clients[inbound.Id]
+		modifiedSettings, err := json.MarshalIndent(settings, "", "  ")
+		if err != nil {
+			return nil, err
+		}
+		config.SetSettingsString(string(modifiedSettings))
+	}
+	return config.GenXrayInboundConfig(), nil
+}
+
+// ClientSpeedLimit is the throughput cap of a client in kbit/s, zero for none.
+type ClientSpeedLimit struct {
+	Email string
+	Up    int64
+	Down  int64
+}
+
+// GetClientSpeedLimits returns the caps of the enabled clients on enabled inbounds that
+// have one, either of their own or the default of their inbound.
+func (s *InboundService) GetClientSpeedLimits() ([]ClientSpeedLimit, error) {
+	return s.speedLimits(database.GetDB().
+		Where("clients.enable = ? AND inbounds.enable = ?", true, true).
+		Where("clients.speed_limit_up <> 0 OR clients.speed_limit_down <> 0 OR inbounds.speed_limit_up > 0 OR inbounds.speed_limit_down > 0"))
+}
+
+// GetClientSpeedLimit returns the cap of the client with email, zero when it has none.
+func (s *InboundService) GetClientSpeedLimit(email string) (ClientSpeedLimit, error) {
+	limits, err := s.speedLimits(database.GetDB().Where("clients.email = ?", email))
+	if err != nil || len(limits) == 0 {
+		return ClientSpeedLimit{Email: email}, err
+	}
+	return limits[0], nil
+}
+
+// speedLimits returns the caps of the clients that db selects, leaving out clients
+// without one.
+func (s *InboundService) speedLimits(db *gorm.DB) ([]ClientSpeedLimit, error) {
+	var rows []struct {
+		Email            string
+		SpeedLimitUp     int64
+		SpeedLimitDown   int64
+		InboundLimitUp   int64
+		InboundLimitDown int64
+	}
+	err := db.Table("clients").
+		Select("clients.email, clients.speed_limit_up, clients.speed_limit_down, " +
+			"inbounds.speed_limit_up AS inbound_limit_up, inbounds.speed_limit_down AS inbound_limit_down").
+		Joins("JOIN inbounds ON inbounds.id = clients.inbound_id").
+		Order("clients.record_id").
+		Scan(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+	limits := make([]ClientSpeedLimit, 0, len(rows))
+	for _, row := range rows {
+		limit := ClientSpeedLimit{
+			Email: row.Email,
+			Up:    effectiveSpeedLimit(row.SpeedLimitUp, row.InboundLimitUp),
+			Down:  effectiveSpeedLimit(row.SpeedLimitDown, row.InboundLimitDown),
+		}
+		if limit.Up > 0 || limit.Down > 0 {
+			limits = append(limits, limit)
+		}
+	}
+	return limits, nil
+}
+
+// effectiveSpeedLimit returns the cap of a client from its own limit and the default
+// of its inbound.
+func effectiveSpeedLimit(client int64, inbound int64) int64 {
+	if client == 0 {
+		client = inbound
+	}
+	return max(client, 0)
+}
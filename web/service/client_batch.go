@@ -0,0 +1,195 @@
+package service
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"strconv"
+	"strings"
+
+	"github.com/mhsanaei/3x-ui/v2/database"
+	"github.com/mhsanaei/3x-ui/v2/database/model"
+	"github.com/mhsanaei/3x-ui/v2/util/common"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// maxBatchClients caps the clients a single batch may create on one inbound.
+const maxBatchClients = 1000
+
+// ClientBatch describes clients to generate from a template. The email pattern may
+// contain "{n}" for the running number and "{inbound}" for the inbound id; with several
+// inbounds and no "{inbound}" the inbound id is appended, as emails are unique.
+type ClientBatch struct {
+	InboundIds   []int  `json:"inboundIds" form:"inboundIds"`
+	Count        int    `json:"count" form:"count"`
+	EmailPattern string `json:"emailPattern" form:"emailPattern"` // Defaults to "trial-{n}"
+	Start        int    `json:"start" form:"start"`               // First number, defaults to 1
+	TotalGB      int64  `json:"totalGB" form:"totalGB"`           // Traffic limit in bytes
+	ExpiryTime   int64  `json:"expiryTime" form:"expiryTime"`     // Expiry in milliseconds, negative for a duration from first use
+	LimitIP      int    `json:"limitIp" form:"limitIp"`
+	Flow         string `json:"flow" form:"flow"` // VLESS flow
+	Comment      string `json:"comment" form:"comment"`
+}
+
+// BatchClient is a client created by a batch with its subscription.
+type BatchClient struct {
+	model.Client
+	InboundId  int    `json:"inboundId"`
+	SubURL     string `json:"subUrl"`
+	SubJsonURL string `json:"subJsonUrl,omitempty"`
+}
+
+// GenerateClients creates batch.Count clients on each inbound of the batch in one
+// transaction, with credentials generated for the protocol of the inbound. The clients
+// of the same number share a subscription. Numbers whose email is taken are skipped.
+// host is used for subscription URLs when no subscription or panel domain is set.
+func (s *InboundService) GenerateClients(batch *ClientBatch, host string) ([]BatchClient, bool, error) {
+	if len(batch.InboundIds) == 0 {
+		return nil, false, common.NewError("no inbound given")
+	}
+	if batch.Count <= 0 || batch.Count > maxBatchClients {
+		return nil, false, common.NewErrorf("count must be between 1 and %d", maxBatchClients)
+	}
+	pattern := strings.TrimSpace(batch.EmailPattern)
+	if pattern == "" {
+		pattern = "trial-{n}"
+	}
+	if batch.Count > 1 && !strings.Contains(pattern, "{n}") {
+		return nil, false, common.NewError("email pattern must contain {n}")
+	}
+	if len(batch.InboundIds) > 1 && !strings.Contains(pattern, "{inbound}") {
+		pattern += "-{inbound}"
+	}
+	start := batch.Start
+	if start <= 0 {
+		start = 1
+	}
+
+	inbounds := make([]*model.Inbound, 0, len(batch.InboundIds))
+	for _, id := range batch.InboundIds {
+		inbound, err := s.GetInbound(id)
+		if err != nil {
+			return nil, false, err
+		}
+		if !inbound.Protocol.HasClients() {
+			return nil, false, common.NewErrorf("inbound %d has no clients", id)
+		}
+		inbounds = append(inbounds, inbound)
+	}
+
+	emails, err := s.getAllEmails()
+	if err != nil {
+		return nil, false, err
+	}
+	taken := make(map[string]bool, len(emails))
+	for _, email := range emails {
+		taken[strings.ToLower(email)] = true
+	}
+
+	byInbound := make([][]model.Client, len(inbounds))
+	for n := start; len(byInbound[0]) < batch.Count; n++ {
+		names := make([]string, len(inbounds))
+		free := true
+		for i, inbound := range inbounds {
+			names[i] = strings.NewReplacer("{n}", strconv.Itoa(n), "{inbound}", strconv.Itoa(inbound.Id)).Replace(pattern)
+			if taken[strings.ToLower(names[i])] {
+				free = false
+			}
+		}
+		if !free {
+			if !strings.Contains(pattern, "{n}") {
+				return nil, false, common.NewError("Duplicate email:", names[0])
+			}
+			continue
+		}
+		subId := randomLowerAndNum(16)
+		for i, inbound := range inbounds {
+			taken[strings.ToLower(names[i])] = true
+			byInbound[i] = append(byInbound[i], newBatchClient(inbound, batch, names[i], subId))
+		}
+	}
+
+	var all []model.Client
+	for i, inbound := range inbounds {
+		if err := validateClients(inbound.Protocol, byInbound[i]); err != nil {
+			return nil, false, err
+		}
+		all = append(all, byInbound[i]...)
+	}
+	// The quota covers the whole batch, and every inbound must be assigned to the reseller
+	for _, inbound := range inbounds {
+		if err := s.checkResellerQuota(inbound, all, nil); err != nil {
+			return nil, false, err
+		}
+	}
+
+	err = database.GetDB().Transaction(func(tx *gorm.DB) error {
+		for i, inbound := range inbounds {
+			if err := s.createClients(tx, inbound, byInbound[i]); err != nil {
+				return err
+			}
+			for _, client := range byInbound[i] {
+				s.audit(tx, "client.add", inbound.Id, client.Email, nil, client)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, false, err
+	}
+
+	needRestart := false
+	settingService := SettingService{}
+	result := make([]BatchClient, 0, len(inbounds)*batch.Count)
+	for i, inbound := range inbounds {
+		if s.addXrayUsers(database.GetDB(), inbound, byInbound[i]) {
+			needRestart = true
+		}
+		for _, client := range byInbound[i] {
+			subURL, subJsonURL := settingService.GetSubURLs(client.SubID, host)
+			result = append(result, BatchClient{Client: client, InboundId: inbound.Id, SubURL: subURL, SubJsonURL: subJsonURL})
+		}
+	}
+	return result, needRestart, nil
+}
+
+// newBatchClient returns a client of batch for inbound with fresh credentials for its
+// protocol, like the ones the Telegram bot generates.
+func newBatchClient(inbound *model.Inbound, batch *ClientBatch, email string, subId string) model.Client {
+	client := model.Client{
+		Email:      email,
+		LimitIP:    batch.LimitIP,
+		TotalGB:    batch.TotalGB,
+		ExpiryTime: batch.ExpiryTime,
+		Enable:     true,
+		SubID:      subId,
+		Comment:    batch.Comment,
+	}
+	switch inbound.Protocol {
+	case model.VMESS:
+		client.ID = uuid.New().String()
+		client.Security = "auto"
+	case model.VLESS:
+		client.ID = uuid.New().String()
+		client.Flow = batch.Flow
+	case model.Trojan:
+		client.Password = randomLowerAndNum(10)
+	case model.Shadowsocks:
+		client.Password = shadowsocksPassword(shadowsocksMethod(inbound))
+	}
+	return client
+}
+
+// shadowsocksPassword returns a random client password that fits the key size of a
+// Shadowsocks 2022 method.
+func shadowsocksPassword(method string) string {
+	if method != "2022-blake3-aes-128-gcm" {
+		return randomShadowSocksPassword()
+	}
+	key := make([]byte, 16)
+	if _, err := rand.Read(key); err != nil {
+		return randomLowerAndNum(16)
+	}
+	return base64.StdEncoding.EncodeToString(key)
+}
@@ -0,0 +1,173 @@
+package service
+
+import (
+	"time"
+
+	"github.com/mhsanaei/3x-ui/v2/database"
+	"github.com/mhsanaei/3x-ui/v2/database/model"
+	"github.com/mhsanaei/3x-ui/v2/util/common"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// maxTopDestinations caps the number of destinations one report returns.
+const maxTopDestinations = 1000
+
+// DestinationHit is a connection of a client found in the Xray access log.
+type DestinationHit struct {
+	Email       string
+	Destination string
+	Outbound    string
+	Time        time.Time
+}
+
+// DestinationUsage is the connections of a client to one destination over a range.
+type DestinationUsage struct {
+	Destination string `json:"destination"`
+	Outbound    string `json:"outbound"`
+	Connections int64  `json:"connections"`
+	LastSeen    int64  `json:"lastSeen"` // Last connection in milliseconds
+}
+
+// TopDestinations is the report of the destinations a client connected to most.
+type TopDestinations struct {
+	Email        string             `json:"email"`
+	From         int64              `json:"from"`        // Start of the first day in milliseconds
+	To           int64              `json:"to"`          // End of the range in milliseconds
+	Connections  int64              `json:"connections"` // Connections to all kept destinations
+	Destinations []DestinationUsage `json:"destinations"`
+}
+
+// ClientDestinationService counts the destinations clients connect to, per day, from
+// the connections Xray writes to its access log. The log holds no byte counts, so the
+// report ranks destinations by connections; next to the traffic history it shows
+// where the traffic of a client goes. Closed days only keep their top destinations.
+type ClientDestinationService struct {
+	settingService SettingService
+}
+
+// RecordDestinations adds hits to the counts of the days they fall in.
+func (s *ClientDestinationService) RecordDestinations(hits []DestinationHit) error {
+	if len(hits) == 0 {
+		return nil
+	}
+	location, err := s.settingService.GetTimeLocation()
+	if err != nil {
+		return err
+	}
+	type destinationKey struct {
+		bucket      int64
+		email       string
+		destination string
+		outbound    string
+	}
+	counts := map[destinationKey]*model.ClientDestination{}
+	for _, hit := range hits {
+		key := destinationKey{
+			bucketStart(model.TrafficSpanDay, hit.Time, location).UnixMilli(),
+			hit.Email, hit.Destination, hit.Outbound,
+		}
+		count, ok := counts[key]
+		if !ok {
+			count = &model.ClientDestination{
+				Bucket: key.bucket, Email: hit.Email, Destination: hit.Destination, Outbound: hit.Outbound,
+			}
+			counts[key] = count
+		}
+		count.Connections++
+		count.LastSeen = max(count.LastSeen, hit.Time.UnixMilli())
+	}
+
+	rows := make([]model.ClientDestination, 0, len(counts))
+	for _, count := range counts {
+		rows = append(rows, *count)
+	}
+	return database.GetDB().Clauses(clause.OnConflict{
+		Columns: []clause.Column{{Name: "bucket"}, {Name: "email"}, {Name: "destination"}, {Name: "outbound"}},
+		DoUpdates: clause.Assignments(map[string]any{
+			"connections": gorm.Expr("client_destinations.connections + excluded.connections"),
+			"last_seen": gorm.Expr("CASE WHEN excluded.last_seen > client_destinations.last_seen " +
+				"THEN excluded.last_seen ELSE client_destinations.last_seen END"),
+		}),
+	}).CreateInBatches(&rows, 100).Error
+}
+
+// GetTopDestinations returns up to limit destinations the client with email connected
+// to most between from and to, in milliseconds. Days are counted whole.
+func (s *ClientDestinationService) GetTopDestinations(email string, from int64, to int64, limit int) (*TopDestinations, error) {
+	if from >= to {
+		return nil, common.NewError("the range must end after it starts")
+	}
+	if limit < 1 || limit > maxTopDestinations {
+		return nil, common.NewErrorf("the limit must be between 1 and %d", maxTopDestinations)
+	}
+	location, err := s.settingService.GetTimeLocation()
+	if err != nil {
+		return nil, err
+	}
+	start := bucketStart(model.TrafficSpanDay, time.UnixMilli(from), location).UnixMilli()
+
+	db := database.GetDB().Model(model.ClientDestination{}).
+		Where("email = ? AND bucket >= ? AND bucket < ?", email, start, to).
+		Session(&gorm.Session{})
+	report := &TopDestinations{Email: email, From: start, To: to, Destinations: []DestinationUsage{}}
+	err = db.Select("COALESCE(SUM(connections), 0)").Scan(&report.Connections).Error
+	if err != nil {
+		return nil, err
+	}
+	err = db.Select("destination, outbound, SUM(connections) AS connections, MAX(last_seen) AS last_seen").
+		Group("destination, outbound").
+		Order("connections DESC").Order("last_seen DESC").
+		Limit(limit).Scan(&report.Destinations).Error
+	if err != nil {
+		return nil, err
+	}
+	return report, nil
+}
+
+// PruneDestinations deletes the days older than the "destinationStatsDays" setting and
+// trims the closed days to the "destinationStatsTopN" destinations of each client.
+// It returns how many rows it deleted.
+func (s *ClientDestinationService) PruneDestinations() (int64, error) {
+	days, err := s.settingService.GetDestinationStatsDays()
+	if err != nil {
+		return 0, err
+	}
+	topN, err := s.settingService.GetDestinationStatsTopN()
+	if err != nil {
+		return 0, err
+	}
+	location, err := s.settingService.GetTimeLocation()
+	if err != nil {
+		return 0, err
+	}
+	now := time.Now()
+	db := database.GetDB()
+
+	var pruned int64
+	if days > 0 {
+		cutoff := now.AddDate(0, 0, -days).UnixMilli()
+		result := db.Where("bucket < ?", cutoff).Delete(&model.ClientDestination{})
+		if result.Error != nil {
+			return 0, result.Error
+		}
+		pruned += result.RowsAffected
+	}
+	if topN > 0 {
+		// The current day still counts, so a destination may yet rise into its top
+		today := bucketStart(model.TrafficSpanDay, now, location).UnixMilli()
+		result := db.Exec(`DELETE FROM client_destinations WHERE id IN (
+			SELECT id FROM (
+				SELECT id, ROW_NUMBER() OVER (
+					PARTITION BY email, bucket ORDER BY connections DESC, last_seen DESC
+				) AS row_num
+				FROM client_destinations WHERE bucket < ?
+			) ranked WHERE row_num > ?)`, today, topN)
+		if result.Error != nil {
+			return pruned, result.Error
+		}
+		pruned += result.RowsAffected
+	}
+	return pruned, nil
+}
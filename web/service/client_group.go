@@ -0,0 +1,609 @@
+package service
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/mhsanaei/3x-ui/v2/database"
+	"github.com/mhsanaei/3x-ui/v2/database/model"
+	"github.com/mhsanaei/3x-ui/v2/logger"
+	"github.com/mhsanaei/3x-ui/v2/util/common"
+	"github.com/mhsanaei/3x-ui/v2/xray"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// ClientGroupSummary is a client group with the number of clients in it.
+type ClientGroupSummary struct {
+	model.ClientGroup
+	Clients int `json:"clients"`
+}
+
+// GroupClient is a client of a group with the inbound it belongs to.
+type GroupClient struct {
+	model.Client
+	InboundId int    `json:"inboundId"`
+	Remark    string `json:"remark"` // Remark of the inbound
+}
+
+// ClientGroupService manages client groups and their members. Bulk actions on the
+// clients of a group go through InboundService so they are audited and reach Xray.
+type ClientGroupService struct{}
+
+// GetGroups returns all groups ordered by name.
+func (s *ClientGroupService) GetGroups() ([]ClientGroupSummary, error) {
+	var groups []ClientGroupSummary
+	err := database.GetDB().Model(model.ClientGroup{}).
+		Select("client_groups.*, COUNT(clients.record_id) AS clients").
+		Joins("LEFT JOIN client_group_members ON client_group_members.group_id = client_groups.id").
+		Joins("LEFT JOIN clients ON clients.record_id = client_group_members.client_id").
+		Group("client_groups.id").
+		Order("client_groups.name").
+		Scan(&groups).Error
+	return groups, err
+}
+
+// GetGroup returns the group with id.
+func (s *ClientGroupService) GetGroup(id int) (*model.ClientGroup, error) {
+	group := &model.ClientGroup{}
+	err := database.GetDB().Where("id = ?", id).Limit(1).Find(group).Error
+	if err != nil {
+		return nil, err
+	}
+	if group.Id == 0 {
+		return nil, common.NewError("Group Not Found:", id)
+	}
+	return group, nil
+}
+
+// AddGroup creates group under a name no other group has.
+func (s *ClientGroupService) AddGroup(group *model.ClientGroup) error {
+	group.Id = 0
+	if err := s.checkName(group); err != nil {
+		return err
+	}
+	return database.GetDB().Create(group).Error
+}
+
+// UpdateGroup renames group or changes its comment.
+func (s *ClientGroupService) UpdateGroup(group *model.ClientGroup) error {
+	old, err := s.GetGroup(group.Id)
+	if err != nil {
+		return err
+	}
+	if err := s.checkName(group); err != nil {
+		return err
+	}
+	group.CreatedAt = old.CreatedAt
+	return database.GetDB().Save(group).Error
+}
+
+// checkName trims the name of group and rejects empty and taken names.
+func (s *ClientGroupService) checkName(group *model.ClientGroup) error {
+	group.Name = strings.TrimSpace(group.Name)
+	if group.Name == "" {
+		return common.NewError("group name can not be empty")
+	}
+	var count int64
+	err := database.GetDB().Model(model.ClientGroup{}).Where("name = ? AND id <> ?", group.Name, group.Id).Count(&count).Error
+	if err != nil {
+		return err
+	}
+	if count > 0 {
+		return common.NewError("Duplicate group name:", group.Name)
+	}
+	return nil
+}
+
+// DelGroup deletes a group. Its clients are kept.
+func (s *ClientGroupService) DelGroup(id int) error {
+	return database.GetDB().Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("group_id = ?", id).Delete(&model.ClientGroupMember{}).Error; err != nil {
+			return err
+		}
+		return tx.Delete(&model.ClientGroup{}, id).Error
+	})
+}
+
+// GetGroupClients returns the clients of a group with their inbounds.
+func (s *ClientGroupService) GetGroupClients(id int) ([]GroupClient, error) {
+	if _, err := s.GetGroup(id); err != nil {
+		return nil, err
+	}
+	db := database.GetDB()
+	clients, err := groupClients(db, id)
+	if err != nil {
+		return nil, err
+	}
+	var inbounds []model.Inbound
+	err = db.Model(model.Inbound{}).Select("id", "remark").
+		Where("id IN (?)", db.Model(model.ClientGroupMember{}).Select("clients.inbound_id").
+			Joins("JOIN clients ON clients.record_id = client_group_members.client_id").
+			Where("client_group_members.group_id = ?", id)).
+		Find(&inbounds).Error
+	if err != nil {
+		return nil, err
+	}
+	remarks := make(map[int]string, len(inbounds))
+	for _, inbound := range inbounds {
+		remarks[inbound.Id] = inbound.Remark
+	}
+	result := make([]GroupClient, 0, len(clients))
+	for _, client := range clients {
+		result = append(result, GroupClient{Client: client, InboundId: client.InboundId, Remark: remarks[client.InboundId]})
+	}
+	return result, nil
+}
+
+// AddGroupClients puts the clients with emails into a group. Clients already in it are skipped.
+func (s *ClientGroupService) AddGroupClients(id int, emails []string) error {
+	if _, err := s.GetGroup(id); err != nil {
+		return err
+	}
+	ids, err := clientIdsByEmail(database.GetDB(), emails)
+	if err != nil {
+		return err
+	}
+	if len(ids) == 0 {
+		return nil
+	}
+	members := make([]model.ClientGroupMember, 0, len(ids))
+	for _, clientId := range ids {
+		members = append(members, model.ClientGroupMember{GroupId: id, ClientId: clientId})
+	}
+	return database.GetDB().Clauses(clause.OnConflict{DoNothing: true}).Create(&members).Error
+}
+
+// RemoveGroupClients takes the clients with emails out of a group.
+func (s *ClientGroupService) RemoveGroupClients(id int, emails []string) error {
+	db := database.GetDB()
+	ids, err := clientIdsByEmail(db, emails)
+	if err != nil {
+		return err
+	}
+	if len(ids) == 0 {
+		return nil
+	}
+	return db.Where("group_id = ? AND client_id IN ?", id, ids).Delete(&model.ClientGroupMember{}).Error
+}
+
+// clientIdsByEmail returns the record ids of the clients with emails, failing on unknown emails.
+func clientIdsByEmail(db *gorm.DB, emails []string) ([]int, error) {
+	if len(emails) == 0 {
+		return nil, nil
+	}
+	var clients []model.Client
+	err := db.Model(model.Client{}).Select("record_id", "email").Where("email IN ?", emails).Find(&clients).Error
+	if err != nil {
+		return nil, err
+	}
+	found := make(map[string]bool, len(clients))
+	ids := make([]int, 0, len(clients))
+	for _, client := range clients {
+		found[client.Email] = true
+		ids = append(ids, client.RecordId)
+	}
+	for _, email := range emails {
+		if !found[email] {
+			return nil, common.NewError("Client Not Found:", email)
+		}
+	}
+	return ids, nil
+}
+
+// groupClients returns the clients of a group.
+func groupClients(db *gorm.DB, groupId int) ([]model.Client, error) {
+	var clients []model.Client
+	err := db.Model(model.Client{}).
+		Joins("JOIN client_group_members ON client_group_members.client_id = clients.record_id").
+		Where("client_group_members.group_id = ?", groupId).
+		Order("clients.record_id").
+		Find(&clients).Error
+	return clients, err
+}
+
+// servedClients returns the clients among recordIds that Xray serves, by record id:
+// enabled clients with traffic and time left on enabled inbounds that are not banned
+// for their IP limit or outside their access windows.
+func servedClients(db *gorm.DB, recordIds []int) (map[int]model.Client, error) {
+	var clients []model.Client
+	err := db.Model(model.Client{}).
+		Joins("JOIN inbounds ON inbounds.id = clients.inbound_id").
+		Joins("LEFT JOIN client_traffics ON client_traffics.email = clients.email").
+		Where("clients.record_id IN ? AND clients.enable = ? AND inbounds.enable = ?", recordIds, true, true).
+		Where("client_traffics.enable IS NULL OR client_traffics.enable = ?", true).
+		Where(notIPLimitBanned).
+		Where(notScheduledOff).
+		Find(&clients).Error
+	if err != nil {
+		return nil, err
+	}
+	served := make(map[int]model.Client, len(clients))
+	for _, client := range clients {
+		served[client.RecordId] = client
+	}
+	return served, nil
+}
+
+// sameXrayUser reports whether Xray would see a and b as the same user.
+func sameXrayUser(a, b model.Client) bool {
+	return a.InboundId == b.InboundId && a.Email == b.Email && a.ID == b.ID && a.Password == b.Password &&
+		a.Flow == b.Flow && a.Security == b.Security && a.Method == b.Method
+}
+
+// trafficEnabled reports whether traffic leaves client within its limits at now.
+func trafficEnabled(client *model.Client, traffic *xray.ClientTraffic, now int64) bool {
+	if !client.Enable {
+		return false
+	}
+	if traffic.Total > 0 && traffic.Up+traffic.Down >= traffic.Total {
+		return false
+	}
+	return traffic.ExpiryTime <= 0 || traffic.ExpiryTime > now
+}
+
+// checkClientsRemain fails when removing the clients leaves one of their inbounds
+// without clients, as a single client delete would.
+func checkClientsRemain(tx *gorm.DB, clients []model.Client) error {
+	removed := map[int]int64{}
+	for _, client := range clients {
+		removed[client.InboundId]++
+	}
+	for inboundId, count := range removed {
+		var total int64
+		if err := tx.Model(model.Client{}).Where("inbound_id = ?", inboundId).Count(&total).Error; err != nil {
+			return err
+		}
+		if total <= count {
+			return common.NewErrorf("no client remained in Inbound %d", inboundId)
+		}
+	}
+	return nil
+}
+
+// groupAction runs apply on the clients of a group in one transaction and then adds
+// and removes users through the Xray API wherever apply changed what Xray serves. It
+// returns the number of clients in the group and whether Xray needs a restart because
+// the API failed.
+func (s *InboundService) groupAction(groupId int, action string, params map[string]any,
+	apply func(tx *gorm.DB, clients []model.Client) error) (int, bool, error) {
+	group, err := (&ClientGroupService{}).GetGroup(groupId)
+	if err != nil {
+		return 0, false, err
+	}
+	details := map[string]any{"group": group.Name}
+	for key, value := range params {
+		details[key] = value
+	}
+	return s.clientsAction("group."+action, details, func(tx *gorm.DB) ([]model.Client, error) {
+		return groupClients(tx, groupId)
+	}, apply)
+}
+
+// clientsAction runs apply in one transaction on the clients load returns, audits it
+// as action with details and the emails of the clients, and then syncs Xray like
+// groupAction. It returns the number of clients and whether Xray needs a restart.
+func (s *InboundService) clientsAction(action string, details map[string]any,
+	load func(tx *gorm.DB) ([]model.Client, error),
+	apply func(tx *gorm.DB, clients []model.Client) error) (int, bool, error) {
+	var clients []model.Client
+	var before, after map[int]model.Client
+	err := database.GetDB().Transaction(func(tx *gorm.DB) error {
+		var err error
+		clients, err = load(tx)
+		if err != nil || len(clients) == 0 {
+			return err
+		}
+		ids := make([]int, 0, len(clients))
+		emails := make([]string, 0, len(clients))
+		for _, client := range clients {
+			ids = append(ids, client.RecordId)
+			emails = append(emails, client.Email)
+		}
+		if before, err = servedClients(tx, ids); err != nil {
+			return err
+		}
+		if err = apply(tx, clients); err != nil {
+			return err
+		}
+		if after, err = servedClients(tx, ids); err != nil {
+			return err
+		}
+		audited := map[string]any{"emails": emails}
+		for key, value := range details {
+			audited[key] = value
+		}
+		s.audit(tx, action, 0, "", nil, audited)
+		return nil
+	})
+	if err != nil {
+		return 0, false, err
+	}
+	return len(clients), s.applyServedClients(before, after), nil
+}
+
+// applyServedClients removes the users Xray served before a change and no longer
+// serves as they were, and adds the ones it serves since. It reports whether the
+// Xray API failed and a restart is needed.
+func (s *InboundService) applyServedClients(before, after map[int]model.Client) bool {
+	removed, added := diffServedClients(before, after)
+	if len(removed) == 0 && len(added) == 0 {
+		return false
+	}
+
+	inboundIds := make([]int, 0, len(removed)+len(added))
+	for _, client := range append(removed, added...) {
+		inboundIds = append(inboundIds, client.InboundId)
+	}
+	var inbounds []*model.Inbound
+	if err := database.GetDB().Where("id IN ?", inboundIds).Find(&inbounds).Error; err != nil {
+		logger.Warning("Unable to load inbounds of group clients:", err)
+		return true
+	}
+	byId := make(map[int]*model.Inbound, len(inbounds))
+	for _, inbound := range inbounds {
+		byId[inbound.Id] = inbound
+	}
+
+	needRestart := false
+	s.xrayApi.Init(p.GetAPIPort())
+	defer s.xrayApi.Close()
+	for _, client := range removed {
+		inbound, ok := byId[client.InboundId]
+		if !ok {
+			continue
+		}
+		err := s.xrayApi.RemoveUser(inbound.Tag, client.Email)
+		if err != nil && !strings.Contains(err.Error(), fmt.Sprintf("User %s not found.", client.Email)) {
+			logger.Debug("Error in deleting client by api:", err)
+			needRestart = true
+		}
+	}
+	for _, client := range added {
+		inbound, ok := byId[client.InboundId]
+		if !ok {
+			continue
+		}
+		if err := s.xrayApi.AddUser(string(inbound.Protocol), inbound.Tag, apiUser(inbound, &client)); err != nil {
+			logger.Debug("Error in adding client by api:", err)
+			needRestart = true
+		}
+	}
+	return needRestart
+}
+
+// diffServedClients returns the users to remove from Xray and the ones to add when it
+// served before and serves after, by record id. A user that changed as Xray sees it is
+// removed as it was and added as it is.
+func diffServedClients(before, after map[int]model.Client) (removed, added []model.Client) {
+	for id, old := range before {
+		if client, ok := after[id]; !ok || !sameXrayUser(old, client) {
+			removed = append(removed, old)
+		}
+	}
+	for id, client := range after {
+		if old, ok := before[id]; !ok || !sameXrayUser(old, client) {
+			added = append(added, client)
+		}
+	}
+	return removed, added
+}
+
+// groupTraffics returns the traffic rows of clients by email, locked until tx ends so
+// the traffic job can not add to the counters meanwhile.
+func groupTraffics(tx *gorm.DB, clients []model.Client) (map[string]*xray.ClientTraffic, error) {
+	emails := make([]string, 0, len(clients))
+	for _, client := range clients {
+		emails = append(emails, client.Email)
+	}
+	var traffics []*xray.ClientTraffic
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("email IN ?", emails).Find(&traffics).Error; err != nil {
+		return nil, err
+	}
+	byEmail := make(map[string]*xray.ClientTraffic, len(traffics))
+	for _, traffic := range traffics {
+		byEmail[traffic.Email] = traffic
+	}
+	return byEmail, nil
+}
+
+// updateGroupClients applies change to every client of a group and its traffic row,
+// stores both and enables the traffic again wherever the client is back within its limits.
+// The used traffic is only written when change resets it.
+func updateGroupClients(tx *gorm.DB, clients []model.Client, change func(client *model.Client, traffic *xray.ClientTraffic)) error {
+	traffics, err := groupTraffics(tx, clients)
+	if err != nil {
+		return err
+	}
+	now := time.Now().UnixMilli()
+	for i := range clients {
+		client := &clients[i]
+		traffic, ok := traffics[client.Email]
+		if !ok {
+			traffic = &xray.ClientTraffic{Email: client.Email, Total: client.TotalGB, ExpiryTime: client.ExpiryTime}
+		}
+		up, down := traffic.Up, traffic.Down
+		change(client, traffic)
+		client.UpdatedAt = now
+		err := tx.Model(model.Client{}).Where("record_id = ?", client.RecordId).Updates(map[string]any{
+			"enable":      client.Enable,
+			"total_gb":    client.TotalGB,
+			"expiry_time": client.ExpiryTime,
+			"updated_at":  client.UpdatedAt,
+		}).Error
+		if err != nil {
+			return err
+		}
+		if !ok {
+			continue
+		}
+		traffic.Total = client.TotalGB
+		traffic.ExpiryTime = client.ExpiryTime
+		updates := map[string]any{
+			"enable":      trafficEnabled(client, traffic, now),
+			"total":       client.TotalGB,
+			"expiry_time": client.ExpiryTime,
+		}
+		if traffic.Up != up || traffic.Down != down {
+			updates["up"] = traffic.Up
+			updates["down"] = traffic.Down
+		}
+		err = tx.Model(xray.ClientTraffic{}).Where("id = ?", traffic.Id).Updates(updates).Error
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SetGroupEnable enables or disables every client of a group.
+func (s *InboundService) SetGroupEnable(groupId int, enable bool) (int, bool, error) {
+	action := "disable"
+	if enable {
+		action = "enable"
+	}
+	return s.groupAction(groupId, action, nil, enableClients(enable))
+}
+
+// ExtendGroupExpiry moves the expiry of every client of a group with one by days.
+// Expired clients get days from now; durations that start with the first traffic
+// grow by days.
+func (s *InboundService) ExtendGroupExpiry(groupId int, days int) (int, bool, error) {
+	if days <= 0 {
+		return 0, false, common.NewError("days must be positive")
+	}
+	return s.groupAction(groupId, "extend", map[string]any{"days": days}, extendClients(days))
+}
+
+// AddGroupTraffic raises the traffic limit of every client of a group with one by
+// bytes. Clients without a limit keep having none.
+func (s *InboundService) AddGroupTraffic(groupId int, bytes int64) (int, bool, error) {
+	if bytes <= 0 {
+		return 0, false, common.NewError("traffic must be positive")
+	}
+	return s.groupAction(groupId, "addTraffic", map[string]any{"bytes": bytes}, addClientsTraffic(bytes))
+}
+
+// ResetGroupTraffic resets the used traffic of every client of a group.
+func (s *InboundService) ResetGroupTraffic(groupId int) (int, bool, error) {
+	return s.groupAction(groupId, "resetTraffic", nil, resetClientsTraffic)
+}
+
+// enableClients returns a change that enables or disables clients.
+func enableClients(enable bool) func(tx *gorm.DB, clients []model.Client) error {
+	return func(tx *gorm.DB, clients []model.Client) error {
+		return updateGroupClients(tx, clients, func(client *model.Client, _ *xray.ClientTraffic) {
+			client.Enable = enable
+		})
+	}
+}
+
+// extendClients returns a change that moves the expiry of clients with one by days.
+func extendClients(days int) func(tx *gorm.DB, clients []model.Client) error {
+	extension := int64(days) * 86400000
+	return func(tx *gorm.DB, clients []model.Client) error {
+		now := time.Now().UnixMilli()
+		return updateGroupClients(tx, clients, func(client *model.Client, _ *xray.ClientTraffic) {
+			switch {
+			case client.ExpiryTime < 0:
+				client.ExpiryTime -= extension
+			case client.ExpiryTime > 0:
+				client.ExpiryTime = max(client.ExpiryTime, now) + extension
+			}
+		})
+	}
+}
+
+// addClientsTraffic returns a change that raises the traffic limit of clients with one
+// by bytes.
+func addClientsTraffic(bytes int64) func(tx *gorm.DB, clients []model.Client) error {
+	return func(tx *gorm.DB, clients []model.Client) error {
+		return updateGroupClients(tx, clients, func(client *model.Client, _ *xray.ClientTraffic) {
+			if client.TotalGB > 0 {
+				client.TotalGB += bytes
+			}
+		})
+	}
+}
+
+// resetClientsTraffic resets the used traffic of clients.
+func resetClientsTraffic(tx *gorm.DB, clients []model.Client) error {
+	return updateGroupClients(tx, clients, func(_ *model.Client, traffic *xray.ClientTraffic) {
+		traffic.Up = 0
+		traffic.Down = 0
+	})
+}
+
+// DelGroupClients deletes every client of a group with its traffic and IPs, keeping
+// them in the recycle bin. Like a single delete, it fails when an inbound would be
+// left without clients.
+func (s *InboundService) DelGroupClients(groupId int) (int, bool, error) {
+	return s.groupAction(groupId, "delete", nil, func(tx *gorm.DB, clients []model.Client) error {
+		if err := checkClientsRemain(tx, clients); err != nil {
+			return err
+		}
+		if err := recycleClients(tx, clients); err != nil {
+			return err
+		}
+		ids := make([]int, 0, len(clients))
+		emails := make([]string, 0, len(clients))
+		for _, client := range clients {
+			ids = append(ids, client.RecordId)
+			emails = append(emails, client.Email)
+		}
+		if err := tx.Where("client_id IN ?", ids).Delete(&model.ClientGroupMember{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("record_id IN ?", ids).Delete(&model.Client{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("client_email IN ?", emails).Delete(&model.InboundClientIps{}).Error; err != nil {
+			return err
+		}
+		return tx.Where("email IN ?", emails).Delete(&xray.ClientTraffic{}).Error
+	})
+}
+
+// MoveGroupClients moves every client of a group to another inbound, keeping its
+// credentials, limits and traffic. The clients must carry the id the protocol of
+// that inbound needs.
+func (s *InboundService) MoveGroupClients(groupId int, inboundId int) (int, bool, error) {
+	target, err := s.GetInbound(inboundId)
+	if err != nil {
+		return 0, false, err
+	}
+	if !target.Protocol.HasClients() {
+		return 0, false, common.NewErrorf("inbound %d has no clients", inboundId)
+	}
+	return s.groupAction(groupId, "move", map[string]any{"inboundId": inboundId}, func(tx *gorm.DB, clients []model.Client) error {
+		moving := make([]model.Client, 0, len(clients))
+		for _, client := range clients {
+			if client.InboundId != inboundId {
+				moving = append(moving, client)
+			}
+		}
+		if len(moving) == 0 {
+			return nil
+		}
+		if err := validateClients(target.Protocol, moving); err != nil {
+			return err
+		}
+		if err := checkClientsRemain(tx, moving); err != nil {
+			return err
+		}
+		ids := make([]int, 0, len(moving))
+		emails := make([]string, 0, len(moving))
+		for _, client := range moving {
+			ids = append(ids, client.RecordId)
+			emails = append(emails, client.Email)
+		}
+		err := tx.Model(model.Client{}).Where("record_id IN ?", ids).
+			Updates(map[string]any{"inbound_id": inboundId, "updated_at": time.Now().UnixMilli()}).Error
+		if err != nil {
+			return err
+		}
+		return tx.Model(xray.ClientTraffic{}).Where("email IN ?", emails).Update("inbound_id", inboundId).Error
+	})
+}
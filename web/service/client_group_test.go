@@ -0,0 +1,147 @@
+package service
+
+import (
+	"testing"
+	"time"
+
+	"github.com/mhsanaei/3x-ui/v2/database"
+	"github.com/mhsanaei/3x-ui/v2/database/model"
+	"github.com/mhsanaei/3x-ui/v2/xray"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSameXrayUser(t *testing.T) {
+	base := model.Client{InboundId: 1, Email: "alice", ID: "uuid", Password: "pass", Flow: "xtls-rprx-vision", Security: "auto", Method: ""}
+	tests := []struct {
+		name   string
+		change func(c *model.Client)
+		same   bool
+	}{
+		{"unchanged", func(c *model.Client) {}, true},
+		{"limits", func(c *model.Client) { c.TotalGB, c.ExpiryTime, c.LimitIP, c.Comment = 5, 10, 2, "note" }, true},
+		{"enable", func(c *model.Client) { c.Enable = !c.Enable }, true},
+		{"inbound", func(c *model.Client) { c.InboundId = 2 }, false},
+		{"email", func(c *model.Client) { c.Email = "bob" }, false},
+		{"id", func(c *model.Client) { c.ID = "other" }, false},
+		{"password", func(c *model.Client) { c.Password = "other" }, false},
+		{"flow", func(c *model.Client) { c.Flow = "" }, false},
+		{"security", func(c *model.Client) { c.Security = "none" }, false},
+		{"method", func(c *model.Client) { c.Method = "aes-256-gcm" }, false},
+	}
+	for _, test := range tests {
+		changed := base
+		test.change(&changed)
+		assert.Equal(t, test.same, sameXrayUser(base, changed), test.name)
+	}
+}
+
+func TestDiffServedClients(t *testing.T) {
+	alice := model.Client{RecordId: 1, InboundId: 1, Email: "alice", ID: "uuid-a"}
+	bob := model.Client{RecordId: 2, InboundId: 1, Email: "bob", ID: "uuid-b"}
+	carol := model.Client{RecordId: 3, InboundId: 1, Email: "carol", ID: "uuid-c"}
+	movedBob := bob
+	movedBob.InboundId = 2
+	limitedCarol := carol
+	limitedCarol.TotalGB = 100
+
+	served := func(clients ...model.Client) map[int]model.Client {
+		m := map[int]model.Client{}
+		for _, client := range clients {
+			m[client.RecordId] = client
+		}
+		return m
+	}
+	tests := []struct {
+		name           string
+		before, after  map[int]model.Client
+		removed, added []model.Client
+	}{
+		{"nothing", nil, nil, nil, nil},
+		{"no change", served(alice, bob), served(alice, bob), nil, nil},
+		{"disabled", served(alice, bob), served(alice), []model.Client{bob}, nil},
+		{"enabled", served(alice), served(alice, bob), nil, []model.Client{bob}},
+		{"moved", served(bob), served(movedBob), []model.Client{bob}, []model.Client{movedBob}},
+		{"limits only", served(carol), served(limitedCarol), nil, nil},
+		{"mixed", served(alice, bob), served(movedBob, carol), []model.Client{alice, bob}, []model.Client{movedBob, carol}},
+	}
+	for _, test := range tests {
+		removed, added := diffServedClients(test.before, test.after)
+		assert.ElementsMatch(t, test.removed, removed, test.name)
+		assert.ElementsMatch(t, test.added, added, test.name)
+	}
+}
+
+func TestServedClients(t *testing.T) {
+	setupTestDB(t, nil)
+	db := database.GetDB()
+	inbound := addTestInbound(t)
+	disabledInbound := addTestInbound(t)
+	require.NoError(t, db.Model(disabledInbound).Update("enable", false).Error)
+
+	now := time.Now().UnixMilli()
+	clients := []*model.Client{
+		addTestClient(t, inbound.Id, model.Client{Email: "served", Enable: true}, xray.ClientTraffic{Enable: true}),
+		addTestClient(t, inbound.Id, model.Client{Email: "disabled", Enable: false}, xray.ClientTraffic{Enable: true}),
+		addTestClient(t, inbound.Id, model.Client{Email: "depleted", Enable: true}, xray.ClientTraffic{Enable: false, ExpiryTime: now - 1}),
+		addTestClient(t, inbound.Id, model.Client{Email: "scheduled", Enable: true}, xray.ClientTraffic{Enable: true, ScheduledOff: true}),
+		addTestClient(t, inbound.Id, model.Client{Email: "banned", Enable: true}, xray.ClientTraffic{Enable: true}),
+		addTestClient(t, inbound.Id, model.Client{Email: "nftables", Enable: true}, xray.ClientTraffic{Enable: true}),
+		addTestClient(t, disabledInbound.Id, model.Client{Email: "offline", Enable: true}, xray.ClientTraffic{Enable: true}),
+	}
+	require.NoError(t, db.Create(&model.IPLimitBan{ClientEmail: "banned", Mode: model.IPLimitModeXray, Until: now + 60000}).Error)
+	require.NoError(t, db.Create(&model.IPLimitBan{ClientEmail: "nftables", Mode: model.IPLimitModeNftables, Until: now + 60000}).Error)
+	// A lifted ban does not count
+	require.NoError(t, db.Create(&model.IPLimitBan{ClientEmail: "served", Mode: model.IPLimitModeXray, Lifted: true}).Error)
+
+	ids := make([]int, 0, len(clients))
+	for _, client := range clients {
+		ids = append(ids, client.RecordId)
+	}
+	served, err := servedClients(db, ids)
+	require.NoError(t, err)
+	emails := []string{}
+	for _, client := range served {
+		emails = append(emails, client.Email)
+	}
+	assert.ElementsMatch(t, []string{"served", "nftables"}, emails)
+
+	served, err = servedClients(db, ids[1:])
+	require.NoError(t, err)
+	assert.NotContains(t, served, ids[0], "only the given clients")
+}
+
+func TestGroupActionsEnableClientsBackWithinLimits(t *testing.T) {
+	setupTestDB(t, nil)
+	db := database.GetDB()
+	inbound := addTestInbound(t)
+	expired := time.Now().AddDate(0, 0, -1).UnixMilli()
+	expiredClient := addTestClient(t, inbound.Id, model.Client{Email: "expired", Enable: true, ExpiryTime: expired}, xray.ClientTraffic{Enable: true, ExpiryTime: expired})
+	depletedClient := addTestClient(t, inbound.Id, model.Client{Email: "depleted", Enable: true, TotalGB: 100}, xray.ClientTraffic{Enable: true, Total: 100, Up: 100})
+	addTestClient(t, inbound.Id, model.Client{Email: "other", Enable: true}, xray.ClientTraffic{Enable: true})
+	group := &model.ClientGroup{Name: "customers"}
+	require.NoError(t, db.Create(group).Error)
+	for _, client := range []*model.Client{expiredClient, depletedClient} {
+		require.NoError(t, db.Create(&model.ClientGroupMember{GroupId: group.Id, ClientId: client.RecordId}).Error)
+	}
+	// Both were disabled by the traffic job for their limits
+	require.NoError(t, db.Model(xray.ClientTraffic{}).Where("email IN ?", []string{"expired", "depleted"}).Update("enable", false).Error)
+
+	enabled := func(email string) bool {
+		traffic := &xray.ClientTraffic{}
+		require.NoError(t, db.Where("email = ?", email).First(traffic).Error)
+		return traffic.Enable
+	}
+	s := &InboundService{}
+	// A disabled inbound keeps the clients away from Xray, so the actions need no Xray API
+	require.NoError(t, db.Model(model.Inbound{}).Where("id = ?", inbound.Id).Update("enable", false).Error)
+	_, _, err := s.ExtendGroupExpiry(group.Id, 30)
+	require.NoError(t, err)
+	assert.True(t, enabled("expired"), "expiry moved past now")
+	assert.False(t, enabled("depleted"))
+
+	_, _, err = s.AddGroupTraffic(group.Id, 50)
+	require.NoError(t, err)
+	assert.True(t, enabled("depleted"), "limit raised above the used traffic")
+}
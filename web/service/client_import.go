@@ -0,0 +1,486 @@
+package service
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mhsanaei/3x-ui/v2/database"
+	"github.com/mhsanaei/3x-ui/v2/database/model"
+	"github.com/mhsanaei/3x-ui/v2/util/common"
+	"github.com/mhsanaei/3x-ui/v2/xray"
+
+	"gorm.io/gorm"
+)
+
+// Formats of client exports and imports
+const (
+	ClientFormatCSV  = "csv"
+	ClientFormatJSON = "json"
+)
+
+// Import modes, what an import does with records whose email is taken. Records with
+// a new email are always created.
+const (
+	ImportCreate = "create" // Taken emails are conflicts and nothing is imported
+	ImportUpdate = "update" // Clients with the email take the settings of the record
+	ImportSkip   = "skip"   // Clients with the email are left alone
+)
+
+// maxImportClients caps the records a single import may hold.
+const maxImportClients = 10000
+
+// ClientRecord is a client in an export or import with its inbound and traffic.
+type ClientRecord struct {
+	model.Client
+	InboundId  int   `json:"inboundId"`
+	Up         int64 `json:"up"`
+	Down       int64 `json:"down"`
+	AllTime    int64 `json:"allTime"`
+	LastOnline int64 `json:"lastOnline"`
+
+	columns map[string]bool // Columns of the CSV file the record comes from, nil for all
+}
+
+// has reports whether the record gives the field of column.
+func (r *ClientRecord) has(column string) bool {
+	return r.columns == nil || r.columns[column]
+}
+
+// ClientFilter selects the clients of an export. Zero values match every client.
+type ClientFilter struct {
+	InboundId int    `form:"inboundId"`
+	Enable    *bool  `form:"enable"`
+	Comment   string `form:"comment"` // Part of the comment, ignoring case
+}
+
+// ImportConflict is a record an import can not apply, by its position in the file.
+type ImportConflict struct {
+	Row    int    `json:"row"`
+	Email  string `json:"email"`
+	Reason string `json:"reason"`
+}
+
+// ImportReport lists the emails an import creates, updates and skips, and the records
+// that stop it. Nothing is imported while there are conflicts or on a dry run.
+type ImportReport struct {
+	DryRun    bool             `json:"dryRun"`
+	Applied   bool             `json:"applied"`
+	Created   []string         `json:"created"`
+	Updated   []string         `json:"updated"`
+	Skipped   []string         `json:"skipped"`
+	Conflicts []ImportConflict `json:"conflicts"`
+}
+
+// clientColumns are the columns of a CSV export, in order.
+var clientColumns = []string{
+	"inboundId", "email", "id", "password", "flow", "security", "method", "subId", "tgId", "comment",
+	"enable", "limitIp", "totalGB", "expiryTime", "reset", "up", "down", "allTime", "lastOnline",
+}
+
+// ExportClients returns the clients that match filter with their traffic, ordered by
+// inbound.
+func (s *InboundService) ExportClients(filter *ClientFilter) ([]ClientRecord, error) {
+	db := database.GetDB().Model(model.Client{}).
+		Select("clients.*, clients.inbound_id AS inbound_id, COALESCE(client_traffics.up,0) AS up, " +
+			"COALESCE(client_traffics.down,0) AS down, COALESCE(client_traffics.all_time,0) AS all_time, " +
+			"COALESCE(client_traffics.last_online,0) AS last_online").
+		Joins("LEFT JOIN client_traffics ON client_traffics.email = clients.email")
+	if filter.InboundId > 0 {
+		db = db.Where("clients.inbound_id = ?", filter.InboundId)
+	}
+	if filter.Enable != nil {
+		db = db.Where("clients.enable = ?", *filter.Enable)
+	}
+	if comment := strings.TrimSpace(filter.Comment); comment != "" {
+		db = db.Where("LOWER(clients.comment) LIKE ?", "%"+strings.ToLower(comment)+"%")
+	}
+	var records []ClientRecord
+	err := db.Order("clients.inbound_id, clients.record_id").Scan(&records).Error
+	return records, err
+}
+
+// WriteClientRecords writes records to w in format.
+func WriteClientRecords(w io.Writer, format string, records []ClientRecord) error {
+	if format == ClientFormatJSON {
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(records)
+	}
+	writer := csv.NewWriter(w)
+	if err := writer.Write(clientColumns); err != nil {
+		return err
+	}
+	for _, r := range records {
+		err := writer.Write([]string{
+			strconv.Itoa(r.InboundId), r.Email, r.ID, r.Password, r.Flow, r.Security, r.Method, r.SubID,
+			strconv.FormatInt(r.TgID, 10), r.Comment, strconv.FormatBool(r.Enable), strconv.Itoa(r.LimitIP),
+			strconv.FormatInt(r.TotalGB, 10), strconv.FormatInt(r.ExpiryTime, 10), strconv.Itoa(r.Reset),
+			strconv.FormatInt(r.Up, 10), strconv.FormatInt(r.Down, 10), strconv.FormatInt(r.AllTime, 10),
+			strconv.FormatInt(r.LastOnline, 10),
+		})
+		if err != nil {
+			return err
+		}
+	}
+	writer.Flush()
+	return writer.Error()
+}
+
+// ParseClientRecords reads the records of an export in format. CSV files need a header
+// row naming their columns, as in an export; the email and inbound columns are
+// required. Missing columns are left empty for new clients and unchanged on updates.
+func ParseClientRecords(r io.Reader, format string) ([]ClientRecord, error) {
+	var records []ClientRecord
+	if format == ClientFormatJSON {
+		if err := json.NewDecoder(r).Decode(&records); err != nil {
+			return nil, err
+		}
+		return records, nil
+	}
+	if format != ClientFormatCSV {
+		return nil, common.NewError("unknown format:", format)
+	}
+
+	reader := csv.NewReader(r)
+	reader.TrimLeadingSpace = true
+	header, err := reader.Read()
+	if err != nil {
+		return nil, err
+	}
+	columns := make(map[string]int, len(header))
+	present := make(map[string]bool, len(header))
+	for i, name := range header {
+		name = strings.TrimSpace(strings.TrimPrefix(name, "\ufeff"))
+		columns[name] = i
+		present[name] = true
+	}
+	for _, name := range []string{"inboundId", "email"} {
+		if _, ok := columns[name]; !ok {
+			return nil, common.NewError("missing CSV column:", name)
+		}
+	}
+	for row := 2; ; row++ {
+		fields, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		record, err := parseClientRow(columns, fields)
+		if err != nil {
+			return nil, fmt.Errorf("row %d: %w", row, err)
+		}
+		record.columns = present
+		records = append(records, record)
+	}
+	return records, nil
+}
+
+// parseClientRow returns the record in the fields of a CSV row.
+func parseClientRow(columns map[string]int, fields []string) (ClientRecord, error) {
+	var record ClientRecord
+	var err error
+	text := func(name string) string {
+		if i, ok := columns[name]; ok && i < len(fields) {
+			return strings.TrimSpace(fields[i])
+		}
+		return ""
+	}
+	number := func(name string) int64 {
+		value := text(name)
+		if value == "" || err != nil {
+			return 0
+		}
+		var n int64
+		if n, err = strconv.ParseInt(value, 10, 64); err != nil {
+			err = fmt.Errorf("column %s: %w", name, err)
+		}
+		return n
+	}
+
+	record.InboundId = int(number("inboundId"))
+	record.Email = text("email")
+	record.ID = text("id")
+	record.Password = text("password")
+	record.Flow = text("flow")
+	record.Security = text("security")
+	record.Method = text("method")
+	record.SubID = text("subId")
+	record.TgID = number("tgId")
+	record.Comment = text("comment")
+	record.Enable = true
+	if value := text("enable"); value != "" {
+		enable, parseErr := strconv.ParseBool(value)
+		if parseErr != nil {
+			return record, fmt.Errorf("column enable: %w", parseErr)
+		}
+		record.Enable = enable
+	}
+	record.LimitIP = int(number("limitIp"))
+	record.TotalGB = number("totalGB")
+	record.ExpiryTime = number("expiryTime")
+	record.Reset = int(number("reset"))
+	record.Up = number("up")
+	record.Down = number("down")
+	record.AllTime = number("allTime")
+	record.LastOnline = number("lastOnline")
+	return record, err
+}
+
+// ImportClients imports records in mode, or only reports what it would do on a dry
+// run. New clients are created on the inbound of their record with its traffic
+// counters. Updated clients stay on their inbound and keep their counters; they take
+// the limits, expiry, subscription, Telegram id, comment and enable state of the record,
+// and its credentials where they are given. Nothing is imported when a record
+// conflicts. It reports whether Xray needs a restart because the API failed.
+func (s *InboundService) ImportClients(records []ClientRecord, mode string, dryRun bool) (*ImportReport, bool, error) {
+	switch mode {
+	case "":
+		mode = ImportCreate
+	case ImportCreate, ImportUpdate, ImportSkip:
+	default:
+		return nil, false, common.NewError("unknown import mode:", mode)
+	}
+	if len(records) == 0 {
+		return nil, false, common.NewError("no client to import")
+	}
+	if len(records) > maxImportClients {
+		return nil, false, common.NewErrorf("an import may hold at most %d clients", maxImportClients)
+	}
+
+	db := database.GetDB()
+	var existing []model.Client
+	if err := db.Find(&existing).Error; err != nil {
+		return nil, false, err
+	}
+	byEmail := make(map[string]model.Client, len(existing))
+	for _, client := range existing {
+		byEmail[strings.ToLower(client.Email)] = client
+	}
+	inbounds := map[int]*model.Inbound{}
+
+	report := &ImportReport{DryRun: dryRun, Created: []string{}, Updated: []string{}, Skipped: []string{}, Conflicts: []ImportConflict{}}
+	conflict := func(row int, email string, reason string) {
+		report.Conflicts = append(report.Conflicts, ImportConflict{Row: row, Email: email, Reason: strings.TrimSpace(reason)})
+	}
+	created := map[int][]ClientRecord{}
+	var updated []model.Client
+	seen := make(map[string]bool, len(records))
+	for i, record := range records {
+		row := i + 1
+		email := strings.TrimSpace(record.Email)
+		record.Email = email
+		key := strings.ToLower(email)
+		if email == "" {
+			conflict(row, email, "empty client email")
+			continue
+		}
+		if seen[key] {
+			conflict(row, email, "email appears more than once in the import")
+			continue
+		}
+		seen[key] = true
+
+		if old, ok := byEmail[key]; ok {
+			switch mode {
+			case ImportCreate:
+				conflict(row, email, "Duplicate email: "+old.Email)
+			case ImportSkip:
+				report.Skipped = append(report.Skipped, email)
+			case ImportUpdate:
+				client := importedClient(old, record)
+				inbound, err := s.importInbound(inbounds, old.InboundId)
+				if err == nil {
+					err = validateClients(inbound.Protocol, []model.Client{client})
+				}
+				if err != nil {
+					conflict(row, email, err.Error())
+					continue
+				}
+				updated = append(updated, client)
+				report.Updated = append(report.Updated, email)
+			}
+			continue
+		}
+
+		inbound, err := s.importInbound(inbounds, record.InboundId)
+		if err == nil {
+			err = validateClients(inbound.Protocol, []model.Client{record.Client})
+		}
+		if err != nil {
+			conflict(row, email, err.Error())
+			continue
+		}
+		created[inbound.Id] = append(created[inbound.Id], record)
+		report.Created = append(report.Created, email)
+	}
+	if dryRun || len(report.Conflicts) > 0 || len(created)+len(updated) == 0 {
+		return report, false, nil
+	}
+
+	now := time.Now().UnixMilli()
+	var before, after map[int]model.Client
+	err := db.Transaction(func(tx *gorm.DB) error {
+		ids := make([]int, 0, len(updated))
+		for _, client := range updated {
+			ids = append(ids, client.RecordId)
+		}
+		var err error
+		if before, err = servedClients(tx, ids); err != nil {
+			return err
+		}
+		if err := updateImportedClients(tx, updated, now); err != nil {
+			return err
+		}
+		for _, client := range updated {
+			s.audit(tx, "client.update", client.InboundId, client.Email, byEmail[strings.ToLower(client.Email)], client)
+		}
+		for inboundId, records := range created {
+			clients := make([]model.Client, 0, len(records))
+			for _, record := range records {
+				clients = append(clients, record.Client)
+			}
+			if err := s.createClients(tx, inbounds[inboundId], clients); err != nil {
+				return err
+			}
+			for i, record := range records {
+				err := tx.Model(xray.ClientTraffic{}).Where("email = ?", record.Email).Updates(map[string]any{
+					"up":          record.Up,
+					"down":        record.Down,
+					"all_time":    max(record.AllTime, record.Up+record.Down),
+					"last_online": record.LastOnline,
+					"enable":      trafficEnabled(&clients[i], &xray.ClientTraffic{Up: record.Up, Down: record.Down, Total: record.TotalGB, ExpiryTime: record.ExpiryTime}, now),
+				}).Error
+				if err != nil {
+					return err
+				}
+				ids = append(ids, clients[i].RecordId)
+				s.audit(tx, "client.add", inboundId, clients[i].Email, nil, clients[i])
+			}
+		}
+		after, err = servedClients(tx, ids)
+		return err
+	})
+	if err != nil {
+		return nil, false, err
+	}
+	report.Applied = true
+	return report, s.applyServedClients(before, after), nil
+}
+
+// importInbound returns the inbound with id that clients are imported to, caching it
+// in inbounds.
+func (s *InboundService) importInbound(inbounds map[int]*model.Inbound, id int) (*model.Inbound, error) {
+	if inbound, ok := inbounds[id]; ok {
+		return inbound, nil
+	}
+	var count int64
+	if err := database.GetDB().Model(model.Inbound{}).Where("id = ?", id).Count(&count).Error; err != nil {
+		return nil, err
+	}
+	if count == 0 {
+		return nil, common.NewError("Inbound Not Found:", id)
+	}
+	inbound, err := s.GetInbound(id)
+	if err != nil {
+		return nil, err
+	}
+	if !inbound.Protocol.HasClients() {
+		return nil, common.NewErrorf("inbound %d has no clients", id)
+	}
+	inbounds[id] = inbound
+	return inbound, nil
+}
+
+// importedClient returns old with the settings of record an update imports.
+func importedClient(old model.Client, record ClientRecord) model.Client {
+	client := old
+	if record.ID != "" {
+		client.ID = record.ID
+	}
+	if record.Password != "" {
+		client.Password = record.Password
+	}
+	if record.Flow != "" {
+		client.Flow = record.Flow
+	}
+	if record.Security != "" {
+		client.Security = record.Security
+	}
+	if record.Method != "" {
+		client.Method = record.Method
+	}
+	if record.has("enable") {
+		client.Enable = record.Enable
+	}
+	if record.has("limitIp") {
+		client.LimitIP = record.LimitIP
+	}
+	if record.has("totalGB") {
+		client.TotalGB = record.TotalGB
+	}
+	if record.has("expiryTime") {
+		client.ExpiryTime = record.ExpiryTime
+	}
+	if record.has("reset") {
+		client.Reset = record.Reset
+	}
+	if record.has("subId") {
+		client.SubID = record.SubID
+	}
+	if record.has("tgId") {
+		client.TgID = record.TgID
+	}
+	if record.has("comment") {
+		client.Comment = record.Comment
+	}
+	if record.has("accessSchedule") {
+		client.AccessSchedule = record.AccessSchedule
+	}
+	return client
+}
+
+// updateImportedClients stores clients and the limits of their traffic rows, enabling
+// the traffic wherever the client is within its limits at now.
+func updateImportedClients(tx *gorm.DB, clients []model.Client, now int64) error {
+	if len(clients) == 0 {
+		return nil
+	}
+	traffics, err := groupTraffics(tx, clients)
+	if err != nil {
+		return err
+	}
+	for i := range clients {
+		client := &clients[i]
+		client.UpdatedAt = now
+		// A struct update, as the access schedule is only stored through its serializer
+		err := tx.Model(client).Select("uuid", "password", "flow", "security", "method", "enable",
+			"limit_ip", "total_gb", "expiry_time", "reset", "sub_id", "tg_id", "comment",
+			"access_schedule", "updated_at").Updates(client).Error
+		if err != nil {
+			return err
+		}
+		traffic, ok := traffics[client.Email]
+		if !ok {
+			continue
+		}
+		traffic.Total = client.TotalGB
+		traffic.ExpiryTime = client.ExpiryTime
+		err = tx.Model(xray.ClientTraffic{}).Where("id = ?", traffic.Id).Updates(map[string]any{
+			"total":       client.TotalGB,
+			"expiry_time": client.ExpiryTime,
+			"reset":       client.Reset,
+			"enable":      trafficEnabled(client, traffic, now),
+		}).Error
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,185 @@
+package service
+
+import (
+	"encoding/json"
+	"strings"
+	"time"
+
+	"github.com/mhsanaei/3x-ui/v2/database"
+	"github.com/mhsanaei/3x-ui/v2/database/model"
+	"github.com/mhsanaei/3x-ui/v2/util/common"
+	"github.com/mhsanaei/3x-ui/v2/xray"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// MoveClient moves the client with email to another inbound. It keeps its record, so
+// its email, subscription, limits, expiry, groups and traffic counters stay as they
+// are, and its id and password where the protocol of the target uses them. Xray drops
+// the user from the old inbound and serves it on the new one through its API. It
+// returns the moved client and whether Xray needs a restart because the API failed.
+func (s *InboundService) MoveClient(email string, inboundId int) (*model.Client, bool, error) {
+	client, source, target, err := s.clientTransfer(email, inboundId)
+	if err != nil {
+		return nil, false, err
+	}
+	moved := *client
+	moved.InboundId = target.Id
+	moved.UpdatedAt = time.Now().UnixMilli()
+	adaptClient(source, target, &moved)
+	if err := validateClients(target.Protocol, []model.Client{moved}); err != nil {
+		return nil, false, err
+	}
+	if err := s.checkResellerQuota(target, []model.Client{moved}, client); err != nil {
+		return nil, false, err
+	}
+
+	var before, after map[int]model.Client
+	err = database.GetDB().Transaction(func(tx *gorm.DB) error {
+		if err := checkClientsRemain(tx, []model.Client{*client}); err != nil {
+			return err
+		}
+		ids := []int{client.RecordId}
+		var err error
+		if before, err = servedClients(tx, ids); err != nil {
+			return err
+		}
+		err = tx.Model(model.Client{}).Where("record_id = ?", client.RecordId).Updates(map[string]any{
+			"inbound_id": moved.InboundId,
+			"uuid":       moved.ID,
+			"password":   moved.Password,
+			"security":   moved.Security,
+			"flow":       moved.Flow,
+			"method":     moved.Method,
+			"updated_at": moved.UpdatedAt,
+		}).Error
+		if err != nil {
+			return err
+		}
+		if err := tx.Model(xray.ClientTraffic{}).Where("email = ?", email).Update("inbound_id", target.Id).Error; err != nil {
+			return err
+		}
+		if after, err = servedClients(tx, ids); err != nil {
+			return err
+		}
+		s.audit(tx, "client.move", target.Id, email, client, moved)
+		return nil
+	})
+	if err != nil {
+		return nil, false, err
+	}
+	return &moved, s.applyServedClients(before, after), nil
+}
+
+// CopyClient adds a copy of the client with email to another inbound under newEmail,
+// as emails are unique. The copy shares the subscription, limits, expiry, id and
+// password, where the protocol of the target uses them, and starts with its own
+// traffic counters. It returns the copy and whether Xray needs a restart because the
+// API failed.
+func (s *InboundService) CopyClient(email string, inboundId int, newEmail string) (*model.Client, bool, error) {
+	newEmail = strings.TrimSpace(newEmail)
+	if newEmail == "" {
+		return nil, false, common.NewError("the copy needs an email of its own")
+	}
+	client, source, target, err := s.clientTransfer(email, inboundId)
+	if err != nil {
+		return nil, false, err
+	}
+	copied := *client
+	copied.Email = newEmail
+	copied.CreatedAt = 0
+	adaptClient(source, target, &copied)
+	clients := []model.Client{copied}
+	existEmail, err := s.checkEmailsExistForClients(clients)
+	if err != nil {
+		return nil, false, err
+	}
+	if existEmail != "" {
+		return nil, false, common.NewError("Duplicate email:", existEmail)
+	}
+	if err := validateClients(target.Protocol, clients); err != nil {
+		return nil, false, err
+	}
+	if err := s.checkResellerQuota(target, clients, nil); err != nil {
+		return nil, false, err
+	}
+
+	err = database.GetDB().Transaction(func(tx *gorm.DB) error {
+		if err := s.createClients(tx, target, clients); err != nil {
+			return err
+		}
+		s.audit(tx, "client.copy", target.Id, newEmail, client, clients[0])
+		return nil
+	})
+	if err != nil {
+		return nil, false, err
+	}
+	return &clients[0], s.addXrayUsers(database.GetDB(), target, clients), nil
+}
+
+// clientTransfer loads the client with email, its inbound and the inbound with id it
+// is moved or copied to.
+func (s *InboundService) clientTransfer(email string, inboundId int) (*model.Client, *model.Inbound, *model.Inbound, error) {
+	client := &model.Client{}
+	if err := database.GetDB().Where("email = ?", email).Order("record_id").Limit(1).Find(client).Error; err != nil {
+		return nil, nil, nil, err
+	}
+	if client.RecordId == 0 {
+		return nil, nil, nil, common.NewError("Client Not Found For Email:", email)
+	}
+	if client.InboundId == inboundId {
+		return nil, nil, nil, common.NewError("client is already on inbound:", inboundId)
+	}
+	source, err := s.GetInbound(client.InboundId)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	target, err := s.GetInbound(inboundId)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	if !target.Protocol.HasClients() {
+		return nil, nil, nil, common.NewErrorf("inbound %d has no clients", inboundId)
+	}
+	return client, source, target, nil
+}
+
+// adaptClient gives client, coming from source, the credentials the protocol of target
+// needs and it lacks, and drops the settings that do not apply to target.
+func adaptClient(source *model.Inbound, target *model.Inbound, client *model.Client) {
+	if target.Protocol != model.VLESS {
+		client.Flow = ""
+	}
+	if target.Protocol != model.Shadowsocks {
+		client.Method = ""
+	}
+	switch target.Protocol {
+	case model.VMESS, model.VLESS:
+		if client.ID == "" {
+			client.ID = uuid.New().String()
+		}
+		if target.Protocol == model.VMESS && client.Security == "" {
+			client.Security = "auto"
+		}
+	case model.Trojan:
+		if client.Password == "" {
+			client.Password = randomLowerAndNum(10)
+		}
+	case model.Shadowsocks:
+		// Shadowsocks 2022 keys must fit the method, so only the same method keeps them
+		method := shadowsocksMethod(target)
+		if client.Password == "" || source.Protocol != model.Shadowsocks || shadowsocksMethod(source) != method {
+			client.Password = shadowsocksPassword(method)
+			client.Method = ""
+		}
+	}
+}
+
+// shadowsocksMethod returns the method in the settings of a Shadowsocks inbound.
+func shadowsocksMethod(inbound *model.Inbound) string {
+	settings := map[string]any{}
+	json.Unmarshal(inbound.Settings, &settings)
+	method, _ := settings["method"].(string)
+	return method
+}
@@ -0,0 +1,86 @@
+package service
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/mhsanaei/3x-ui/v2/database"
+	"github.com/mhsanaei/3x-ui/v2/database/model"
+	"github.com/mhsanaei/3x-ui/v2/xray"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestXrayInboundConfig(t *testing.T) {
+	setupTestDB(t, nil)
+	db := database.GetDB()
+	inbound := addTestInbound(t)
+	other := addTestInbound(t)
+	addTestClient(t, inbound.Id, model.Client{Email: "served", Enable: true}, xray.ClientTraffic{Enable: true})
+	addTestClient(t, inbound.Id, model.Client{Email: "disabled"}, xray.ClientTraffic{})
+	addTestClient(t, inbound.Id, model.Client{Email: "depleted", Enable: true}, xray.ClientTraffic{})
+	addTestClient(t, inbound.Id, model.Client{Email: "banned", Enable: true}, xray.ClientTraffic{Enable: true})
+	addTestClient(t, inbound.Id, model.Client{Email: "scheduled", Enable: true}, xray.ClientTraffic{Enable: true, ScheduledOff: true})
+	addTestClient(t, other.Id, model.Client{Email: "elsewhere", Enable: true}, xray.ClientTraffic{Enable: true})
+	require.NoError(t, db.Create(&model.IPLimitBan{ClientEmail: "banned", Mode: model.IPLimitModeXray, Until: time.Now().Add(time.Hour).UnixMilli()}).Error)
+
+	// The settings may still list every client, as the edit form sends them
+	inbound.Settings = []byte(`{"decryption":"none","clients":[{"id":"uuid-banned","email":"banned"}]}`)
+	config, err := xrayInboundConfig(db, inbound)
+	require.NoError(t, err)
+	assert.Equal(t, inbound.Tag, config.Tag)
+	var settings struct {
+		Decryption string           `json:"decryption"`
+		Clients    []map[string]any `json:"clients"`
+	}
+	require.NoError(t, json.Unmarshal(config.Settings, &settings))
+	assert.Equal(t, "none", settings.Decryption)
+	require.Len(t, settings.Clients, 1)
+	assert.Equal(t, "served", settings.Clients[0]["email"])
+	assert.Contains(t, string(inbound.Settings), "banned", "inbound left as it was")
+}
+
+func TestSyncClientsKeepsRecordIds(t *testing.T) {
+	setupTestDB(t, nil)
+	db := database.GetDB()
+	inbound := addTestInbound(t)
+	alice := addTestClient(t, inbound.Id, model.Client{Email: "alice", Enable: true}, xray.ClientTraffic{Enable: true, Up: 30})
+	bob := addTestClient(t, inbound.Id, model.Client{Email: "bob", Enable: true}, xray.ClientTraffic{Enable: true})
+	dave := addTestClient(t, inbound.Id, model.Client{Email: "dave", Enable: true}, xray.ClientTraffic{Enable: true})
+	group := &model.ClientGroup{Name: "customers"}
+	require.NoError(t, db.Create(group).Error)
+	require.NoError(t, db.Create(&model.ClientGroupMember{GroupId: group.Id, ClientId: alice.RecordId}).Error)
+
+	// alice is renamed, bob gets a new id, carol is new and dave is gone
+	inbound.Settings = []byte(`{"decryption":"none","clients":[
+		{"id":"uuid-alice","email":"alice-renamed","enable":true},
+		{"id":"uuid-bob-new","email":"bob","enable":true},
+		{"id":"uuid-carol","email":"carol","enable":true}
+	]}`)
+	require.NoError(t, (&InboundService{}).saveInbound(db, inbound))
+
+	var clients []model.Client
+	require.NoError(t, db.Where("inbound_id = ?", inbound.Id).Order("record_id").Find(&clients).Error)
+	require.Len(t, clients, 3)
+	assert.Equal(t, alice.RecordId, clients[0].RecordId)
+	assert.Equal(t, "alice-renamed", clients[0].Email)
+	assert.Equal(t, bob.RecordId, clients[1].RecordId)
+	assert.Equal(t, "uuid-bob-new", clients[1].ID)
+	assert.Equal(t, "carol", clients[2].Email)
+	assert.NotEqual(t, dave.RecordId, clients[2].RecordId)
+
+	members, err := groupClients(db, group.Id)
+	require.NoError(t, err)
+	require.Len(t, members, 1)
+	assert.Equal(t, "alice-renamed", members[0].Email)
+	// The renamed client keeps its counters and the removed one loses its row
+	var traffics []xray.ClientTraffic
+	require.NoError(t, db.Order("email").Find(&traffics).Error)
+	require.Len(t, traffics, 3)
+	assert.Equal(t, "alice-renamed", traffics[0].Email)
+	assert.Equal(t, int64(30), traffics[0].Up)
+	assert.Equal(t, "bob", traffics[1].Email)
+	assert.Equal(t, "carol", traffics[2].Email)
+}
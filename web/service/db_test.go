@@ -0,0 +1,68 @@
+package service
+
+import (
+	"fmt"
+	"path/filepath"
+	"testing"
+
+	"github.com/mhsanaei/3x-ui/v2/database"
+	"github.com/mhsanaei/3x-ui/v2/database/model"
+	"github.com/mhsanaei/3x-ui/v2/logger"
+	"github.com/mhsanaei/3x-ui/v2/xray"
+
+	"github.com/op/go-logging"
+)
+
+// setupTestDB points the database at a fresh SQLite file for the test and stores
+// settings in it.
+func setupTestDB(t *testing.T, settings map[string]string) {
+	t.Helper()
+	logger.InitLogger(logging.ERROR)
+	if err := database.InitDB(filepath.Join(t.TempDir(), "x-ui.db")); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { database.CloseDB() })
+	settingService := &SettingService{}
+	for key, value := range settings {
+		if err := settingService.saveSetting(key, value); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// addTestInbound stores an enabled VLESS inbound without clients.
+func addTestInbound(t *testing.T) *model.Inbound {
+	t.Helper()
+	var count int64
+	database.GetDB().Model(model.Inbound{}).Count(&count)
+	inbound := &model.Inbound{
+		Enable:   true,
+		Port:     10000 + int(count),
+		Protocol: model.VLESS,
+		Tag:      fmt.Sprintf("inbound-%d", count),
+		Settings: []byte(`{"decryption":"none"}`),
+	}
+	if err := database.GetDB().Create(inbound).Error; err != nil {
+		t.Fatal(err)
+	}
+	return inbound
+}
+
+// addTestClient stores client on inboundId with a traffic row like traffic, which
+// takes the email of the client.
+func addTestClient(t *testing.T, inboundId int, client model.Client, traffic xray.ClientTraffic) *model.Client {
+	t.Helper()
+	client.InboundId = inboundId
+	if client.ID == "" {
+		client.ID = "uuid-" + client.Email
+	}
+	traffic.InboundId = inboundId
+	traffic.Email = client.Email
+	if err := database.GetDB().Create(&traffic).Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := database.GetDB().Create(&client).Error; err != nil {
+		t.Fatal(err)
+	}
+	return &client
+}
@@ -7,7 +7,6 @@ import (
 	"errors"
 	"fmt"
 	"sort"
-	"strconv"
 	"strings"
 	"time"
 
@@ -24,7 +23,21 @@ import (
 // It handles CRUD operations for inbounds, client management, traffic monitoring,
 // and integration with the Xray API for real-time updates.
 type InboundService struct {
-	xrayApi xray.XrayAPI
+	xrayApi      xray.XrayAPI
+	auditService AuditService
+	actor        *AuditActor
+}
+
+// WithActor returns a copy of the service that attributes audit log entries to actor.
+func (s *InboundService) WithActor(actor *AuditActor) *InboundService {
+	c := *s
+	c.actor = actor
+	return &c
+}
+
+// audit records a mutating action performed through this service, inside tx when it is not nil.
+func (s *InboundService) audit(tx *gorm.DB, action string, inboundId int, email string, before any, after any) {
+	s.auditService.Record(tx, s.actor, action, inboundId, email, before, after)
 }
 
 // GetInbounds retrieves all inbounds for a specific user.
@@ -39,24 +52,8 @@ func (s *InboundService) GetInbounds(userId int) ([]*model.Inbound, error) {
 	if err != nil && err != gorm.ErrRecordNotFound {
 		return nil, err
 	}
-	// Enrich client stats with UUID/SubId from inbound settings
-	for _, inbound := range inbounds {
-		clients, _ := s.GetClients(inbound)
-		if len(clients) == 0 || len(inbound.ClientStats) == 0 {
-			continue
-		}
-		// Build a map email -> client
-		cMap := make(map[string]model.Client, len(clients))
-		for _, c := range clients {
-			cMap[strings.ToLower(c.Email)] = c
-		}
-		for i := range inbound.ClientStats {
-			email := strings.ToLower(inbound.ClientStats[i].Email)
-			if c, ok := cMap[email]; ok {
-				inbound.ClientStats[i].UUID = c.ID
-				inbound.ClientStats[i].SubId = c.SubID
-			}
-		}
+	if err := s.enrichInbounds(db, inbounds); err != nil {
+		return nil, err
 	}
 	return inbounds, nil
 }
@@ -70,9 +67,20 @@ func (s *InboundService) GetAllInbounds() ([]*model.Inbound, error) {
 	if err != nil && err != gorm.ErrRecordNotFound {
 		return nil, err
 	}
-	// Enrich client stats with UUID/SubId from inbound settings
+	if err := s.enrichInbounds(db, inbounds); err != nil {
+		return nil, err
+	}
+	return inbounds, nil
+}
+
+// enrichInbounds loads the clients of inbounds and adds their UUID/SubId to the client stats.
+func (s *InboundService) enrichInbounds(db *gorm.DB, inbounds []*model.Inbound) error {
+	byInbound, err := s.loadClients(db, inbounds...)
+	if err != nil {
+		return err
+	}
 	for _, inbound := range inbounds {
-		clients, _ := s.GetClients(inbound)
+		clients := byInbound[inbound.Id]
 		if len(clients) == 0 || len(inbound.ClientStats) == 0 {
 			continue
 		}
@@ -88,7 +96,7 @@ func (s *InboundService) GetAllInbounds() ([]*model.Inbound, error) {
 			}
 		}
 	}
-	return inbounds, nil
+	return nil
 }
 
 func (s *InboundService) GetInboundsByTrafficReset(period string) ([]*model.Inbound, error) {
@@ -98,6 +106,9 @@ func (s *InboundService) GetInboundsByTrafficReset(period string) ([]*model.Inbo
 	if err != nil && err != gorm.ErrRecordNotFound {
 		return nil, err
 	}
+	if _, err := s.loadClients(db, inbounds...); err != nil {
+		return nil, err
+	}
 	return inbounds, nil
 }
 
@@ -141,17 +152,7 @@ func (s *InboundService) GetClients(inbound *model.Inbound) ([]model.Client, err
 func (s *InboundService) getAllEmails() ([]string, error) {
 	db := database.GetDB()
 	var emails []string
-
-	// Для PostgreSQL используем jsonb_array_elements для работы с JSON массивом
-	err := db.Raw(`
-    SELECT client->>'email'
-    FROM inbounds
-    CROSS JOIN LATERAL jsonb_array_elements(
-        COALESCE(inbounds.settings->'clients', '[]'::jsonb)
-    ) AS client
-    WHERE client ? 'email'
-`).Scan(&emails).Error
-
+	err := db.Model(model.Client{}).Pluck("email", &emails).Error
 	if err != nil {
 		return nil, err
 	}
@@ -262,22 +263,9 @@ func (s *InboundService) AddInbound(inbound *model.Inbound) (*model.Inbound, boo
 		}
 	}
 
-	// Secure client ID
-	for _, client := range clients {
-		switch inbound.Protocol {
-		case "trojan":
-			if client.Password == "" {
-				return inbound, false, common.NewError("empty client ID")
-			}
-		case "shadowsocks":
-			if client.Email == "" {
-				return inbound, false, common.NewError("empty client ID")
-			}
-		default:
-			if client.ID == "" {
-				return inbound, false, common.NewError("empty client ID")
-			}
-		}
+	err = validateClients(inbound.Protocol, clients)
+	if err != nil {
+		return inbound, false, err
 	}
 
 	db := database.GetDB()
@@ -290,21 +278,20 @@ func (s *InboundService) AddInbound(inbound *model.Inbound) (*model.Inbound, boo
 		}
 	}()
 
-	err = tx.Save(inbound).Error
-	if err == nil {
-		if len(inbound.ClientStats) == 0 {
-			for _, client := range clients {
-				s.AddClientStat(tx, inbound.Id, &client)
-			}
-		}
-	} else {
+	// Clients without imported stats get a traffic row while their rows are created.
+	err = s.saveInbound(tx, inbound)
+	if err != nil {
 		return inbound, false, err
 	}
 
 	needRestart := false
 	if inbound.Enable {
 		s.xrayApi.Init(p.GetAPIPort())
-		inboundJson, err1 := json.MarshalIndent(inbound.GenXrayInboundConfig(), "", "  ")
+		inboundConfig, err1 := xrayInboundConfig(tx, inbound)
+		var inboundJson []byte
+		if err1 == nil {
+			inboundJson, err1 = json.MarshalIndent(inboundConfig, "", "  ")
+		}
 		if err1 != nil {
 			logger.Debug("Unable to marshal inbound config:", err1)
 		}
@@ -319,11 +306,13 @@ func (s *InboundService) AddInbound(inbound *model.Inbound) (*model.Inbound, boo
 		s.xrayApi.Close()
 	}
 
+	s.audit(tx, "inbound.add", inbound.Id, "", nil, inbound)
 	return inbound, needRestart, err
 }
 
 // DelInbound deletes an inbound configuration by ID.
-// It removes the inbound from the database and the running Xray instance if active.
+// It removes the inbound from the database and the running Xray instance if active,
+// keeping it with its clients in the recycle bin.
 // Returns whether Xray needs restart and any error.
 func (s *InboundService) DelInbound(id int) (bool, error) {
 	db := database.GetDB()
@@ -345,11 +334,6 @@ func (s *InboundService) DelInbound(id int) (bool, error) {
 		logger.Debug("No enabled inbound founded to removing by api", tag)
 	}
 
-	// Delete client traffics of inbounds
-	err := db.Where("inbound_id = ?", id).Delete(xray.ClientTraffic{}).Error
-	if err != nil {
-		return false, err
-	}
 	inbound, err := s.GetInbound(id)
 	if err != nil {
 		return false, err
@@ -358,14 +342,30 @@ func (s *InboundService) DelInbound(id int) (bool, error) {
 	if err != nil {
 		return false, err
 	}
-	for _, client := range clients {
-		err := s.DelClientIPs(db, client.Email)
-		if err != nil {
-			return false, err
+
+	err = db.Transaction(func(tx *gorm.DB) error {
+		if err := recycleInbound(tx, inbound); err != nil {
+			return err
+		}
+		for _, client := range clients {
+			if err := s.DelClientIPs(tx, client.Email); err != nil {
+				return err
+			}
+		}
+		if err := tx.Where("inbound_id = ?", id).Delete(&model.Client{}).Error; err != nil {
+			return err
+		}
+		// Delete client traffics of inbounds
+		if err := tx.Where("inbound_id = ?", id).Delete(xray.ClientTraffic{}).Error; err != nil {
+			return err
 		}
+		return tx.Delete(model.Inbound{}, id).Error
+	})
+	if err != nil {
+		return false, err
 	}
-
-	return needRestart, db.Delete(model.Inbound{}, id).Error
+	s.audit(nil, "inbound.delete", id, "", inbound, nil)
+	return needRestart, nil
 }
 
 func (s *InboundService) GetInbound(id int) (*model.Inbound, error) {
@@ -375,6 +375,9 @@ func (s *InboundService) GetInbound(id int) (*model.Inbound, error) {
 	if err != nil {
 		return nil, err
 	}
+	if _, err := s.loadClients(db, inbound); err != nil {
+		return nil, err
+	}
 	return inbound, nil
 }
 
@@ -396,6 +399,17 @@ func (s *InboundService) UpdateInbound(inbound *model.Inbound) (*model.Inbound,
 	}
 
 	tag := oldInbound.Tag
+	before := *oldInbound
+
+	if inbound.Protocol.HasClients() {
+		clients, err := s.GetClients(inbound)
+		if err != nil {
+			return inbound, false, err
+		}
+		if err := validateClients(inbound.Protocol, clients); err != nil {
+			return inbound, false, err
+		}
+	}
 
 	db := database.GetDB()
 	tx := db.Begin()
@@ -408,11 +422,6 @@ func (s *InboundService) UpdateInbound(inbound *model.Inbound) (*model.Inbound,
 		}
 	}()
 
-	err = s.updateClientTraffics(tx, oldInbound, inbound)
-	if err != nil {
-		return inbound, false, err
-	}
-
 	// Ensure created_at and updated_at exist in inbound.Settings clients
 	{
 		var oldSettings map[string]any
@@ -479,6 +488,8 @@ func (s *InboundService) UpdateInbound(inbound *model.Inbound) (*model.Inbound,
 	oldInbound.Enable = inbound.Enable
 	oldInbound.ExpiryTime = inbound.ExpiryTime
 	oldInbound.TrafficReset = inbound.TrafficReset
+	oldInbound.SpeedLimitUp = inbound.SpeedLimitUp
+	oldInbound.SpeedLimitDown = inbound.SpeedLimitDown
 	oldInbound.Listen = inbound.Listen
 	oldInbound.Port = inbound.Port
 	oldInbound.Protocol = inbound.Protocol
@@ -491,13 +502,23 @@ func (s *InboundService) UpdateInbound(inbound *model.Inbound) (*model.Inbound,
 		oldInbound.Tag = fmt.Sprintf("inbound-%v:%v", inbound.Listen, inbound.Port)
 	}
 
+	// The clients are saved first, as Xray gets the ones it serves from their rows
+	err = s.saveInbound(tx, oldInbound)
+	if err != nil {
+		return inbound, false, err
+	}
+
 	needRestart := false
 	s.xrayApi.Init(p.GetAPIPort())
 	if s.xrayApi.DelInbound(tag) == nil {
 		logger.Debug("Old inbound deleted by api:", tag)
 	}
 	if inbound.Enable {
-		inboundJson, err2 := json.MarshalIndent(oldInbound.GenXrayInboundConfig(), "", "  ")
+		inboundConfig, err2 := xrayInboundConfig(tx, oldInbound)
+		var inboundJson []byte
+		if err2 == nil {
+			inboundJson, err2 = json.MarshalIndent(inboundConfig, "", "  ")
+		}
 		if err2 != nil {
 			logger.Debug("Unable to marshal updated inbound config:", err2)
 			needRestart = true
@@ -513,52 +534,8 @@ func (s *InboundService) UpdateInbound(inbound *model.Inbound) (*model.Inbound,
 	}
 	s.xrayApi.Close()
 
-	return inbound, needRestart, tx.Save(oldInbound).Error
-}
-
-func (s *InboundService) updateClientTraffics(tx *gorm.DB, oldInbound *model.Inbound, newInbound *model.Inbound) error {
-	oldClients, err := s.GetClients(oldInbound)
-	if err != nil {
-		return err
-	}
-	newClients, err := s.GetClients(newInbound)
-	if err != nil {
-		return err
-	}
-
-	var emailExists bool
-
-	for _, oldClient := range oldClients {
-		emailExists = false
-		for _, newClient := range newClients {
-			if oldClient.Email == newClient.Email {
-				emailExists = true
-				break
-			}
-		}
-		if !emailExists {
-			err = s.DelClientStat(tx, oldClient.Email)
-			if err != nil {
-				return err
-			}
-		}
-	}
-	for _, newClient := range newClients {
-		emailExists = false
-		for _, oldClient := range oldClients {
-			if newClient.Email == oldClient.Email {
-				emailExists = true
-				break
-			}
-		}
-		if !emailExists {
-			err = s.AddClientStat(tx, oldInbound.Id, &newClient)
-			if err != nil {
-				return err
-			}
-		}
-	}
-	return nil
+	s.audit(tx, "inbound.update", oldInbound.Id, "", &before, oldInbound)
+	return inbound, needRestart, err
 }
 
 func (s *InboundService) AddInboundClient(data *model.Inbound) (bool, error) {
@@ -567,24 +544,6 @@ func (s *InboundService) AddInboundClient(data *model.Inbound) (bool, error) {
 		return false, err
 	}
 
-	var settings map[string]any
-	err = json.Unmarshal(data.Settings, &settings)
-	if err != nil {
-		return false, err
-	}
-
-	interfaceClients := settings["clients"].([]any)
-	// Add timestamps for new clients being appended
-	nowTs := time.Now().Unix() * 1000
-	for i := range interfaceClients {
-		if cm, ok := interfaceClients[i].(map[string]any); ok {
-			if _, ok2 := cm["created_at"]; !ok2 {
-				cm["created_at"] = nowTs
-			}
-			cm["updated_at"] = nowTs
-			interfaceClients[i] = cm
-		}
-	}
 	existEmail, err := s.checkEmailsExistForClients(clients)
 	if err != nil {
 		return false, err
@@ -597,43 +556,15 @@ func (s *InboundService) AddInboundClient(data *model.Inbound) (bool, error) {
 	if err != nil {
 		return false, err
 	}
-
-	// Secure client ID
-	for _, client := range clients {
-		switch oldInbound.Protocol {
-		case "trojan":
-			if client.Password == "" {
-				return false, common.NewError("empty client ID")
-			}
-		case "shadowsocks":
-			if client.Email == "" {
-				return false, common.NewError("empty client ID")
-			}
-		default:
-			if client.ID == "" {
-				return false, common.NewError("empty client ID")
-			}
-		}
-	}
-
-	var oldSettings map[string]any
-	err = json.Unmarshal(oldInbound.Settings, &oldSettings)
+	err = s.checkResellerQuota(oldInbound, clients, nil)
 	if err != nil {
 		return false, err
 	}
-
-	oldClients := oldSettings["clients"].([]any)
-	oldClients = append(oldClients, interfaceClients...)
-
-	oldSettings["clients"] = oldClients
-
-	newSettings, err := json.MarshalIndent(oldSettings, "", "  ")
+	err = validateClients(oldInbound.Protocol, clients)
 	if err != nil {
 		return false, err
 	}
 
-	oldInbound.SetSettingsString(string(newSettings))
-
 	db := database.GetDB()
 	tx := db.Begin()
 
@@ -645,38 +576,70 @@ func (s *InboundService) AddInboundClient(data *model.Inbound) (bool, error) {
 		}
 	}()
 
+	err = s.createClients(tx, oldInbound, clients)
+	if err != nil {
+		return false, err
+	}
+	needRestart := s.addXrayUsers(tx, oldInbound, clients)
+
+	for _, client := range clients {
+		s.audit(tx, "client.add", data.Id, client.Email, nil, client)
+	}
+	return needRestart, nil
+}
+
+// createClients stores new clients of inbound together with their traffic rows.
+func (s *InboundService) createClients(tx *gorm.DB, inbound *model.Inbound, clients []model.Client) error {
+	nowTs := time.Now().Unix() * 1000
+	for i := range clients {
+		client := &clients[i]
+		client.RecordId = 0
+		client.InboundId = inbound.Id
+		if client.CreatedAt == 0 {
+			client.CreatedAt = nowTs
+		}
+		client.UpdatedAt = nowTs
+		if err := s.AddClientStat(tx, inbound.Id, client); err != nil {
+			return err
+		}
+		if err := tx.Create(client).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// addXrayUsers adds the clients of inbound that Xray serves through its API, leaving
+// out disabled, depleted, IP banned and scheduled off ones, and reports whether that
+// failed and Xray needs a restart instead. db must see the stored clients.
+func (s *InboundService) addXrayUsers(db *gorm.DB, inbound *model.Inbound, clients []model.Client) bool {
+	ids := make([]int, 0, len(clients))
+	for _, client := range clients {
+		ids = append(ids, client.RecordId)
+	}
+	served, err := servedClients(db, ids)
+	if err != nil {
+		logger.Warning("Unable to load served clients:", err)
+		return true
+	}
+	if len(served) == 0 {
+		return false
+	}
 	needRestart := false
 	s.xrayApi.Init(p.GetAPIPort())
 	for _, client := range clients {
-		if len(client.Email) > 0 {
-			s.AddClientStat(tx, data.Id, &client)
-			if client.Enable {
-				cipher := ""
-				if oldInbound.Protocol == "shadowsocks" {
-					cipher = oldSettings["method"].(string)
-				}
-				err1 := s.xrayApi.AddUser(string(oldInbound.Protocol), oldInbound.Tag, map[string]any{
-					"email":    client.Email,
-					"id":       client.ID,
-					"security": client.Security,
-					"flow":     client.Flow,
-					"password": client.Password,
-					"cipher":   cipher,
-				})
-				if err1 == nil {
-					logger.Debug("Client added by api:", client.Email)
-				} else {
-					logger.Debug("Error in adding client by api:", err1)
-					needRestart = true
-				}
+		if _, ok := served[client.RecordId]; ok {
+			err1 := s.xrayApi.AddUser(string(inbound.Protocol), inbound.Tag, apiUser(inbound, &client))
+			if err1 == nil {
+				logger.Debug("Client added by api:", client.Email)
+			} else {
+				logger.Debug("Error in adding client by api:", err1)
+				needRestart = true
 			}
-		} else {
-			needRestart = true
 		}
 	}
 	s.xrayApi.Close()
-
-	return needRestart, tx.Save(oldInbound).Error
+	return needRestart
 }
 
 func (s *InboundService) DelInboundClient(inboundId int, clientId string) (bool, error) {
@@ -685,86 +648,72 @@ func (s *InboundService) DelInboundClient(inboundId int, clientId string) (bool,
 		logger.Error("Load Old Data Error")
 		return false, err
 	}
-	var settings map[string]any
-	err = json.Unmarshal(oldInbound.Settings, &settings)
+	client, err := s.findClient(database.GetDB(), oldInbound, clientId)
 	if err != nil {
 		return false, err
 	}
+	return s.delClient(oldInbound, client)
+}
 
-	email := ""
-	client_key := "id"
-	if oldInbound.Protocol == "trojan" {
-		client_key = "password"
-	}
-	if oldInbound.Protocol == "shadowsocks" {
-		client_key = "email"
-	}
+// delClient removes client from inbound together with its traffic and IPs, keeping
+// them in the recycle bin.
+func (s *InboundService) delClient(inbound *model.Inbound, client *model.Client) (bool, error) {
+	db := database.GetDB()
+	email := client.Email
 
-	interfaceClients := settings["clients"].([]any)
-	var newClients []any
-	needApiDel := false
-	for _, client := range interfaceClients {
-		c := client.(map[string]any)
-		c_id := c[client_key].(string)
-		if c_id == clientId {
-			email, _ = c["email"].(string)
-			needApiDel, _ = c["enable"].(bool)
-		} else {
-			newClients = append(newClients, client)
-		}
+	var count int64
+	err := db.Model(model.Client{}).Where("inbound_id = ?", inbound.Id).Count(&count).Error
+	if err != nil {
+		return false, err
 	}
-
-	if len(newClients) == 0 {
+	if count <= 1 {
 		return false, common.NewError("no client remained in Inbound")
 	}
 
-	settings["clients"] = newClients
-	newSettings, err := json.MarshalIndent(settings, "", "  ")
+	// Depleted clients were already removed from Xray.
+	var enabled []bool
+	err = db.Model(xray.ClientTraffic{}).Where("email = ?", email).Pluck("enable", &enabled).Error
 	if err != nil {
+		logger.Error("Get stats error")
 		return false, err
 	}
+	notDepleted := len(enabled) > 0 && enabled[0]
 
-	oldInbound.SetSettingsString(string(newSettings))
-
-	db := database.GetDB()
-
-	err = s.DelClientIPs(db, email)
+	err = db.Transaction(func(tx *gorm.DB) error {
+		if err := recycleClients(tx, []model.Client{*client}); err != nil {
+			return err
+		}
+		if err := tx.Delete(&model.Client{}, client.RecordId).Error; err != nil {
+			return err
+		}
+		if err := s.DelClientIPs(tx, email); err != nil {
+			return err
+		}
+		return s.DelClientStat(tx, email)
+	})
 	if err != nil {
-		logger.Error("Error in delete client IPs")
+		logger.Error("Delete client error")
 		return false, err
 	}
-	needRestart := false
+	s.audit(nil, "client.delete", inbound.Id, email, client, nil)
 
-	if len(email) > 0 {
-		notDepleted := true
-		err = db.Model(xray.ClientTraffic{}).Select("enable").Where("email = ?", email).First(&notDepleted).Error
-		if err != nil {
-			logger.Error("Get stats error")
-			return false, err
-		}
-		err = s.DelClientStat(db, email)
-		if err != nil {
-			logger.Error("Delete stats Data Error")
-			return false, err
-		}
-		if needApiDel && notDepleted {
-			s.xrayApi.Init(p.GetAPIPort())
-			err1 := s.xrayApi.RemoveUser(oldInbound.Tag, email)
-			if err1 == nil {
-				logger.Debug("Client deleted by api:", email)
-				needRestart = false
+	needRestart := false
+	if client.Enable && notDepleted {
+		s.xrayApi.Init(p.GetAPIPort())
+		err1 := s.xrayApi.RemoveUser(inbound.Tag, email)
+		if err1 == nil {
+			logger.Debug("Client deleted by api:", email)
+		} else {
+			if strings.Contains(err1.Error(), fmt.Sprintf("User %s not found.", email)) {
+				logger.Debug("User is already deleted. Nothing to do more...")
 			} else {
-				if strings.Contains(err1.Error(), fmt.Sprintf("User %s not found.", email)) {
-					logger.Debug("User is already deleted. Nothing to do more...")
-				} else {
-					logger.Debug("Error in deleting client by api:", err1)
-					needRestart = true
-				}
+				logger.Debug("Error in deleting client by api:", err1)
+				needRestart = true
 			}
-			s.xrayApi.Close()
 		}
+		s.xrayApi.Close()
 	}
-	return needRestart, db.Save(oldInbound).Error
+	return needRestart, nil
 }
 
 func (s *InboundService) UpdateInboundClient(data *model.Inbound, clientId string) (bool, error) {
@@ -773,54 +722,32 @@ func (s *InboundService) UpdateInboundClient(data *model.Inbound, clientId strin
 	if err != nil {
 		return false, err
 	}
-
-	var settings map[string]any
-	err = json.Unmarshal(data.Settings, &settings)
-	if err != nil {
-		return false, err
+	if len(clients) == 0 {
+		return false, common.NewError("empty client ID")
 	}
 
-	interfaceClients := settings["clients"].([]any)
-
 	oldInbound, err := s.GetInbound(data.Id)
 	if err != nil {
 		return false, err
 	}
-
-	oldClients, err := s.GetClients(oldInbound)
+	oldClient, err := s.findClient(database.GetDB(), oldInbound, clientId)
 	if err != nil {
 		return false, err
 	}
-
-	oldEmail := ""
-	newClientId := ""
-	clientIndex := -1
-	for index, oldClient := range oldClients {
-		oldClientId := ""
-		switch oldInbound.Protocol {
-		case "trojan":
-			oldClientId = oldClient.Password
-			newClientId = clients[0].Password
-		case "shadowsocks":
-			oldClientId = oldClient.Email
-			newClientId = clients[0].Email
-		default:
-			oldClientId = oldClient.ID
-			newClientId = clients[0].ID
-		}
-		if clientId == oldClientId {
-			oldEmail = oldClient.Email
-			clientIndex = index
-			break
-		}
-	}
+	client := clients[0]
+	oldEmail := oldClient.Email
 
 	// Validate new client ID
-	if newClientId == "" || clientIndex == -1 {
-		return false, common.NewError("empty client ID")
+	err = validateClients(oldInbound.Protocol, clients[:1])
+	if err != nil {
+		return false, err
+	}
+	err = s.checkResellerQuota(oldInbound, clients[:1], oldClient)
+	if err != nil {
+		return false, err
 	}
 
-	if len(clients[0].Email) > 0 && clients[0].Email != oldEmail {
+	if client.Email != oldEmail {
 		existEmail, err := s.checkEmailsExistForClients(clients)
 		if err != nil {
 			return false, err
@@ -830,40 +757,15 @@ func (s *InboundService) UpdateInboundClient(data *model.Inbound, clientId strin
 		}
 	}
 
-	var oldSettings map[string]any
-	err = json.Unmarshal(oldInbound.Settings, &oldSettings)
-	if err != nil {
-		return false, err
-	}
-	settingsClients := oldSettings["clients"].([]any)
 	// Preserve created_at and set updated_at for the replacing client
-	var preservedCreated any
-	if clientIndex >= 0 && clientIndex < len(settingsClients) {
-		if oldMap, ok := settingsClients[clientIndex].(map[string]any); ok {
-			if v, ok2 := oldMap["created_at"]; ok2 {
-				preservedCreated = v
-			}
-		}
-	}
-	if len(interfaceClients) > 0 {
-		if newMap, ok := interfaceClients[0].(map[string]any); ok {
-			if preservedCreated == nil {
-				preservedCreated = time.Now().Unix() * 1000
-			}
-			newMap["created_at"] = preservedCreated
-			newMap["updated_at"] = time.Now().Unix() * 1000
-			interfaceClients[0] = newMap
-		}
-	}
-	settingsClients[clientIndex] = interfaceClients[0]
-	oldSettings["clients"] = settingsClients
-
-	newSettings, err := json.MarshalIndent(oldSettings, "", "  ")
-	if err != nil {
-		return false, err
+	client.RecordId = oldClient.RecordId
+	client.InboundId = oldClient.InboundId
+	client.CreatedAt = oldClient.CreatedAt
+	if client.CreatedAt == 0 {
+		client.CreatedAt = time.Now().Unix() * 1000
 	}
+	client.UpdatedAt = time.Now().Unix() * 1000
 
-	oldInbound.SetSettingsString(string(newSettings))
 	db := database.GetDB()
 	tx := db.Begin()
 
@@ -875,71 +777,56 @@ func (s *InboundService) UpdateInboundClient(data *model.Inbound, clientId strin
 		}
 	}()
 
-	if len(clients[0].Email) > 0 {
-		if len(oldEmail) > 0 {
-			err = s.UpdateClientStat(tx, oldEmail, &clients[0])
-			if err != nil {
-				return false, err
-			}
-			err = s.UpdateClientIPs(tx, oldEmail, clients[0].Email)
-			if err != nil {
-				return false, err
-			}
-		} else {
-			s.AddClientStat(tx, data.Id, &clients[0])
-		}
-	} else {
-		err = s.DelClientStat(tx, oldEmail)
-		if err != nil {
-			return false, err
-		}
-		err = s.DelClientIPs(tx, oldEmail)
-		if err != nil {
-			return false, err
-		}
+	err = s.UpdateClientStat(tx, oldEmail, &client)
+	if err != nil {
+		return false, err
 	}
+	err = s.ensureClientStat(tx, oldInbound.Id, &client)
+	if err != nil {
+		return false, err
+	}
+	err = s.UpdateClientIPs(tx, oldEmail, client.Email)
+	if err != nil {
+		return false, err
+	}
+	err = tx.Save(&client).Error
+	if err != nil {
+		return false, err
+	}
+	served, err := servedClients(tx, []int{client.RecordId})
+	if err != nil {
+		return false, err
+	}
+
 	needRestart := false
-	if len(oldEmail) > 0 {
-		s.xrayApi.Init(p.GetAPIPort())
-		if oldClients[clientIndex].Enable {
-			err1 := s.xrayApi.RemoveUser(oldInbound.Tag, oldEmail)
-			if err1 == nil {
-				logger.Debug("Old client deleted by api:", oldEmail)
-			} else {
-				if strings.Contains(err1.Error(), fmt.Sprintf("User %s not found.", oldEmail)) {
-					logger.Debug("User is already deleted. Nothing to do more...")
-				} else {
-					logger.Debug("Error in deleting client by api:", err1)
-					needRestart = true
-				}
-			}
-		}
-		if clients[0].Enable {
-			cipher := ""
-			if oldInbound.Protocol == "shadowsocks" {
-				cipher = oldSettings["method"].(string)
-			}
-			err1 := s.xrayApi.AddUser(string(oldInbound.Protocol), oldInbound.Tag, map[string]any{
-				"email":    clients[0].Email,
-				"id":       clients[0].ID,
-				"security": clients[0].Security,
-				"flow":     clients[0].Flow,
-				"password": clients[0].Password,
-				"cipher":   cipher,
-			})
-			if err1 == nil {
-				logger.Debug("Client edited by api:", clients[0].Email)
+	s.xrayApi.Init(p.GetAPIPort())
+	if oldClient.Enable {
+		err1 := s.xrayApi.RemoveUser(oldInbound.Tag, oldEmail)
+		if err1 == nil {
+			logger.Debug("Old client deleted by api:", oldEmail)
+		} else {
+			if strings.Contains(err1.Error(), fmt.Sprintf("User %s not found.", oldEmail)) {
+				logger.Debug("User is already deleted. Nothing to do more...")
 			} else {
-				logger.Debug("Error in adding client by api:", err1)
+				logger.Debug("Error in deleting client by api:", err1)
 				needRestart = true
 			}
 		}
-		s.xrayApi.Close()
-	} else {
-		logger.Debug("Client old email not found")
-		needRestart = true
 	}
-	return needRestart, tx.Save(oldInbound).Error
+	// Like a new client, an edited one only goes back to Xray where it is served
+	if _, ok := served[client.RecordId]; ok {
+		err1 := s.xrayApi.AddUser(string(oldInbound.Protocol), oldInbound.Tag, apiUser(oldInbound, &client))
+		if err1 == nil {
+			logger.Debug("Client edited by api:", client.Email)
+		} else {
+			logger.Debug("Error in adding client by api:", err1)
+			needRestart = true
+		}
+	}
+	s.xrayApi.Close()
+
+	s.audit(tx, "client.update", data.Id, client.Email, oldClient, client)
+	return needRestart, nil
 }
 
 func (s *InboundService) AddTraffic(inboundTraffics []*xray.Traffic, clientTraffics []*xray.ClientTraffic) (error, bool) {
@@ -1069,60 +956,19 @@ func (s *InboundService) addClientTraffic(tx *gorm.DB, traffics []*xray.ClientTr
 }
 
 func (s *InboundService) adjustTraffics(tx *gorm.DB, dbClientTraffics []*xray.ClientTraffic) ([]*xray.ClientTraffic, error) {
-	inboundIds := make([]int, 0, len(dbClientTraffics))
-	for _, dbClientTraffic := range dbClientTraffics {
-		if dbClientTraffic.ExpiryTime < 0 {
-			inboundIds = append(inboundIds, dbClientTraffic.InboundId)
-		}
-	}
-
-	if len(inboundIds) > 0 {
-		var inbounds []*model.Inbound
-		err := tx.Model(model.Inbound{}).Where("id IN (?)", inboundIds).Find(&inbounds).Error
-		if err != nil {
-			return nil, err
-		}
-		for inbound_index := range inbounds {
-			settings := map[string]any{}
-			json.Unmarshal(inbounds[inbound_index].Settings, &settings)
-			clients, ok := settings["clients"].([]any)
-			if ok {
-				var newClients []any
-				for client_index := range clients {
-					c := clients[client_index].(map[string]any)
-					for traffic_index := range dbClientTraffics {
-						if dbClientTraffics[traffic_index].ExpiryTime < 0 && c["email"] == dbClientTraffics[traffic_index].Email {
-							oldExpiryTime := c["expiryTime"].(float64)
-							newExpiryTime := (time.Now().Unix() * 1000) - int64(oldExpiryTime)
-							c["expiryTime"] = newExpiryTime
-							c["updated_at"] = time.Now().Unix() * 1000
-							dbClientTraffics[traffic_index].ExpiryTime = newExpiryTime
-							break
-						}
-					}
-					// Backfill created_at and updated_at
-					if _, ok := c["created_at"]; !ok {
-						c["created_at"] = time.Now().Unix() * 1000
-					}
-					c["updated_at"] = time.Now().Unix() * 1000
-					newClients = append(newClients, any(c))
-				}
-				settings["clients"] = newClients
-				modifiedSettings, err := json.MarshalIndent(settings, "", "  ")
-				if err != nil {
-					return nil, err
-				}
-
-				inbounds[inbound_index].SetSettingsString(string(modifiedSettings))
-			}
+	// Negative expiry times are durations that start with the first traffic of the client.
+	now := time.Now().Unix() * 1000
+	for _, traffic := range dbClientTraffics {
+		if traffic.ExpiryTime >= 0 {
+			continue
 		}
-		err = tx.Save(inbounds).Error
+		traffic.ExpiryTime = now - traffic.ExpiryTime
+		err := tx.Model(model.Client{}).Where("email = ?", traffic.Email).
+			Updates(map[string]any{"expiry_time": traffic.ExpiryTime, "updated_at": now}).Error
 		if err != nil {
-			logger.Warning("AddClientTraffic update inbounds ", err)
-			logger.Error(inbounds)
+			logger.Warning("AddClientTraffic update clients ", err)
 		}
 	}
-
 	return dbClientTraffics, nil
 }
 
@@ -1141,8 +987,6 @@ func (s *InboundService) autoRenewClients(tx *gorm.DB) (bool, int64, error) {
 		return false, 0, nil
 	}
 
-	var inbound_ids []int
-	var inbounds []*model.Inbound
 	needRestart := false
 	var clientsToAdd []struct {
 		protocol string
@@ -1151,56 +995,46 @@ func (s *InboundService) autoRenewClients(tx *gorm.DB) (bool, int64, error) {
 	}
 
 	for _, traffic := range traffics {
-		inbound_ids = append(inbound_ids, traffic.InboundId)
-	}
-	err = tx.Model(model.Inbound{}).Where("id IN ?", inbound_ids).Find(&inbounds).Error
-	if err != nil {
-		return false, 0, err
-	}
-	for inbound_index := range inbounds {
-		settings := map[string]any{}
-		json.Unmarshal(inbounds[inbound_index].Settings, &settings)
-		clients := settings["clients"].([]any)
-		for client_index := range clients {
-			c := clients[client_index].(map[string]any)
-			for traffic_index, traffic := range traffics {
-				if traffic.Email == c["email"].(string) {
-					newExpiryTime := traffic.ExpiryTime
-					for newExpiryTime < now {
-						newExpiryTime += (int64(traffic.Reset) * 86400000)
-					}
-					c["expiryTime"] = newExpiryTime
-					traffics[traffic_index].ExpiryTime = newExpiryTime
-					traffics[traffic_index].Down = 0
-					traffics[traffic_index].Up = 0
-					if !traffic.Enable {
-						traffics[traffic_index].Enable = true
-						clientsToAdd = append(clientsToAdd,
-							struct {
-								protocol string
-								tag      string
-								client   map[string]any
-							}{
-								protocol: string(inbounds[inbound_index].Protocol),
-								tag:      inbounds[inbound_index].Tag,
-								client:   c,
-							})
-					}
-					clients[client_index] = any(c)
-					break
-				}
-			}
+		newExpiryTime := traffic.ExpiryTime
+		for newExpiryTime < now {
+			newExpiryTime += (int64(traffic.Reset) * 86400000)
+		}
+		traffic.ExpiryTime = newExpiryTime
+		traffic.Down = 0
+		traffic.Up = 0
+		err = tx.Model(model.Client{}).Where("email = ?", traffic.Email).
+			Updates(map[string]any{"expiry_time": newExpiryTime, "updated_at": now}).Error
+		if err != nil {
+			return false, 0, err
+		}
+		if traffic.Enable {
+			continue
 		}
-		settings["clients"] = clients
-		newSettings, err := json.MarshalIndent(settings, "", "  ")
+		traffic.Enable = true
+
+		client := &model.Client{}
+		err = tx.Where("email = ?", traffic.Email).Limit(1).Find(client).Error
 		if err != nil {
 			return false, 0, err
 		}
-		inbounds[inbound_index].SetSettingsString(string(newSettings))
-	}
-	err = tx.Save(inbounds).Error
-	if err != nil {
-		return false, 0, err
+		if client.RecordId == 0 || !client.Enable {
+			continue
+		}
+		inbound := &model.Inbound{}
+		err = tx.Model(model.Inbound{}).Where("id = ?", client.InboundId).Limit(1).Find(inbound).Error
+		if err != nil {
+			return false, 0, err
+		}
+		clientsToAdd = append(clientsToAdd,
+			struct {
+				protocol string
+				tag      string
+				client   map[string]any
+			}{
+				protocol: string(inbound.Protocol),
+				tag:      inbound.Tag,
+				client:   apiUser(inbound, client),
+			})
 	}
 	err = tx.Save(traffics).Error
 	if err != nil {
@@ -1264,16 +1098,31 @@ func (s *InboundService) disableInvalidClients(tx *gorm.DB) (bool, int64, error)
 	now := time.Now().Unix() * 1000
 	needRestart := false
 
+	// Clients of subscription accounts whose shared limits are used up go together
+	subIds, err := exhaustedSubIds(tx, now)
+	if err != nil {
+		return false, 0, err
+	}
+	whereCT := "((total > 0 AND COALESCE(up,0) + COALESCE(down,0) >= total) OR (expiry_time > 0 AND expiry_time <= ?))"
+	whereArgs := []any{now}
+	if len(subIds) > 0 {
+		whereCT = "(" + whereCT + " OR email IN (SELECT clients.email FROM clients WHERE clients.sub_id IN ?))"
+		whereArgs = append(whereArgs, subIds)
+	}
+
 	if p != nil {
 		var results []struct {
 			Tag   string
 			Email string
 		}
 
-		err := tx.Table("inbounds").
-			Select("DISTINCT inbounds.tag, client_traffics.email").
-			Joins("JOIN client_traffics ON inbounds.id = client_traffics.inbound_id").
-			Where("((client_traffics.total > 0 AND COALESCE(client_traffics.up,0) + COALESCE(client_traffics.down,0) >= client_traffics.total) OR (client_traffics.expiry_time > 0 AND client_traffics.expiry_time <= ?)) AND client_traffics.enable = ?", now, true).
+		// Only clients enabled in their inbound are known to Xray.
+		err := tx.Table("clients").
+			Select("DISTINCT inbounds.tag, clients.email").
+			Joins("JOIN inbounds ON inbounds.id = clients.inbound_id").
+			Joins("JOIN client_traffics ON client_traffics.email = clients.email").
+			Where("client_traffics.email IN (?)", tx.Model(xray.ClientTraffic{}).Select("email").Where(whereCT, whereArgs...)).
+			Where("client_traffics.enable = ? AND clients.enable = ?", true, true).
 			Scan(&results).Error
 		if err != nil {
 			return false, 0, err
@@ -1303,12 +1152,11 @@ func (s *InboundService) disableInvalidClients(tx *gorm.DB) (bool, int64, error)
 		s.xrayApi.Close()
 	}
 
-	whereCT := "((total > 0 AND COALESCE(up,0) + COALESCE(down,0) >= total) OR (expiry_time > 0 AND expiry_time <= ?)) AND enable = ?"
-
 	result := tx.Model(xray.ClientTraffic{}).
-		Where(whereCT, now, true).
+		Where(whereCT, whereArgs...).
+		Where("enable = ?", true).
 		Update("enable", false)
-	err := result.Error
+	err = result.Error
 	count := result.RowsAffected
 	return needRestart, count, err
 }
@@ -1326,14 +1174,9 @@ func (s *InboundService) GetInboundTags() (string, error) {
 func (s *InboundService) MigrationRemoveOrphanedTraffics() {
 	db := database.GetDB()
 	db.Exec(`
-        DELETE FROM client_traffics ct
+        DELETE FROM client_traffics
         WHERE NOT EXISTS (
-            SELECT 1
-            FROM inbounds
-            CROSS JOIN LATERAL jsonb_array_elements(
-                COALESCE(inbounds.settings->'clients', '[]'::jsonb)
-            ) AS client
-            WHERE client->>'email' = ct.email
+            SELECT 1 FROM clients WHERE clients.email = client_traffics.email
         )
     `)
 }
@@ -1348,6 +1191,7 @@ func (s *InboundService) AddClientStat(tx *gorm.DB, inboundId int, client *model
 	clientTraffic.Up = 0
 	clientTraffic.Down = 0
 	clientTraffic.Reset = client.Reset
+	clientTraffic.ScheduledOff = !accessOpen(client.AccessSchedule, time.Now())
 	result := tx.Create(&clientTraffic)
 	err := result.Error
 	return err
@@ -1357,11 +1201,12 @@ func (s *InboundService) UpdateClientStat(tx *gorm.DB, email string, client *mod
 	result := tx.Model(&xray.ClientTraffic{}).
 		Where("email = ?", email).
 		Updates(map[string]any{
-			"enable":      client.Enable,
-			"email":       client.Email,
-			"total":       client.TotalGB,
-			"expiry_time": client.ExpiryTime,
-			"reset":       client.Reset,
+			"enable":        client.Enable,
+			"email":         client.Email,
+			"total":         client.TotalGB,
+			"expiry_time":   client.ExpiryTime,
+			"reset":         client.Reset,
+			"scheduled_off": !accessOpen(client.AccessSchedule, time.Now()),
 		})
 	err := result.Error
 	return err
@@ -1403,390 +1248,136 @@ func (s *InboundService) GetClientInboundByTrafficID(trafficId int) (*xray.Clien
 }
 
 func (s *InboundService) GetClientInboundByEmail(email string) (traffic *xray.ClientTraffic, inbound *model.Inbound, err error) {
-	db := database.GetDB()
-	var traffics []*xray.ClientTraffic
-	err = db.Model(xray.ClientTraffic{}).Where("email = ?", email).Find(&traffics).Error
-	if err != nil {
-		logger.Warningf("Error retrieving ClientTraffic with email %s: %v", email, err)
-		return nil, nil, err
-	}
-	if len(traffics) > 0 {
-		inbound, err = s.GetInbound(traffics[0].InboundId)
-		return traffics[0], inbound, err
-	}
-	return nil, nil, nil
-}
-
-func (s *InboundService) GetClientByEmail(clientEmail string) (*xray.ClientTraffic, *model.Client, error) {
-	traffic, inbound, err := s.GetClientInboundByEmail(clientEmail)
-	if err != nil {
-		return nil, nil, err
-	}
-	if inbound == nil {
-		return nil, nil, common.NewError("Inbound Not Found For Email:", clientEmail)
-	}
-
-	clients, err := s.GetClients(inbound)
-	if err != nil {
-		return nil, nil, err
-	}
-
-	for _, client := range clients {
-		if client.Email == clientEmail {
-			return traffic, &client, nil
-		}
-	}
-
-	return nil, nil, common.NewError("Client Not Found In Inbound For Email:", clientEmail)
-}
-
-func (s *InboundService) SetClientTelegramUserID(trafficId int, tgId int64) (bool, error) {
-	traffic, inbound, err := s.GetClientInboundByTrafficID(trafficId)
-	if err != nil {
-		return false, err
-	}
-	if inbound == nil {
-		return false, common.NewError("Inbound Not Found For Traffic ID:", trafficId)
-	}
-
-	clientEmail := traffic.Email
-
-	oldClients, err := s.GetClients(inbound)
-	if err != nil {
-		return false, err
-	}
-
-	clientId := ""
-
-	for _, oldClient := range oldClients {
-		if oldClient.Email == clientEmail {
-			switch inbound.Protocol {
-			case "trojan":
-				clientId = oldClient.Password
-			case "shadowsocks":
-				clientId = oldClient.Email
-			default:
-				clientId = oldClient.ID
-			}
-			break
-		}
-	}
-
-	if len(clientId) == 0 {
-		return false, common.NewError("Client Not Found For Email:", clientEmail)
-	}
-
-	var settings map[string]any
-	err = json.Unmarshal(inbound.Settings, &settings)
-	if err != nil {
-		return false, err
-	}
-	clients := settings["clients"].([]any)
-	var newClients []any
-	for client_index := range clients {
-		c := clients[client_index].(map[string]any)
-		if c["email"] == clientEmail {
-			c["tgId"] = tgId
-			c["updated_at"] = time.Now().Unix() * 1000
-			newClients = append(newClients, any(c))
-		}
-	}
-	settings["clients"] = newClients
-	modifiedSettings, err := json.MarshalIndent(settings, "", "  ")
-	if err != nil {
-		return false, err
-	}
-	inbound.SetSettingsString(string(modifiedSettings))
-	needRestart, err := s.UpdateInboundClient(inbound, clientId)
-	return needRestart, err
-}
-
-func (s *InboundService) checkIsEnabledByEmail(clientEmail string) (bool, error) {
-	_, inbound, err := s.GetClientInboundByEmail(clientEmail)
-	if err != nil {
-		return false, err
-	}
-	if inbound == nil {
-		return false, common.NewError("Inbound Not Found For Email:", clientEmail)
-	}
-
-	clients, err := s.GetClients(inbound)
-	if err != nil {
-		return false, err
-	}
-
-	isEnable := false
-
-	for _, client := range clients {
-		if client.Email == clientEmail {
-			isEnable = client.Enable
-			break
-		}
-	}
-
-	return isEnable, err
-}
-
-func (s *InboundService) ToggleClientEnableByEmail(clientEmail string) (bool, bool, error) {
-	_, inbound, err := s.GetClientInboundByEmail(clientEmail)
-	if err != nil {
-		return false, false, err
-	}
-	if inbound == nil {
-		return false, false, common.NewError("Inbound Not Found For Email:", clientEmail)
-	}
-
-	oldClients, err := s.GetClients(inbound)
-	if err != nil {
-		return false, false, err
-	}
-
-	clientId := ""
-	clientOldEnabled := false
-
-	for _, oldClient := range oldClients {
-		if oldClient.Email == clientEmail {
-			switch inbound.Protocol {
-			case "trojan":
-				clientId = oldClient.Password
-			case "shadowsocks":
-				clientId = oldClient.Email
-			default:
-				clientId = oldClient.ID
-			}
-			clientOldEnabled = oldClient.Enable
-			break
-		}
-	}
-
-	if len(clientId) == 0 {
-		return false, false, common.NewError("Client Not Found For Email:", clientEmail)
-	}
-
-	var settings map[string]any
-	err = json.Unmarshal(inbound.Settings, &settings)
-	if err != nil {
-		return false, false, err
-	}
-	clients := settings["clients"].([]any)
-	var newClients []any
-	for client_index := range clients {
-		c := clients[client_index].(map[string]any)
-		if c["email"] == clientEmail {
-			c["enable"] = !clientOldEnabled
-			c["updated_at"] = time.Now().Unix() * 1000
-			newClients = append(newClients, any(c))
-		}
-	}
-	settings["clients"] = newClients
-	modifiedSettings, err := json.MarshalIndent(settings, "", "  ")
-	if err != nil {
-		return false, false, err
-	}
-	inbound.SetSettingsString(string(modifiedSettings))
-
-	needRestart, err := s.UpdateInboundClient(inbound, clientId)
-	if err != nil {
-		return false, needRestart, err
-	}
-
-	return !clientOldEnabled, needRestart, nil
-}
-
-// SetClientEnableByEmail sets client enable state to desired value; returns (changed, needRestart, error)
-func (s *InboundService) SetClientEnableByEmail(clientEmail string, enable bool) (bool, bool, error) {
-	current, err := s.checkIsEnabledByEmail(clientEmail)
-	if err != nil {
-		return false, false, err
-	}
-	if current == enable {
-		return false, false, nil
-	}
-	newEnabled, needRestart, err := s.ToggleClientEnableByEmail(clientEmail)
-	if err != nil {
-		return false, needRestart, err
-	}
-	return newEnabled == enable, needRestart, nil
-}
-
-func (s *InboundService) ResetClientIpLimitByEmail(clientEmail string, count int) (bool, error) {
-	_, inbound, err := s.GetClientInboundByEmail(clientEmail)
+	db := database.GetDB()
+	var traffics []*xray.ClientTraffic
+	err = db.Model(xray.ClientTraffic{}).Where("email = ?", email).Find(&traffics).Error
 	if err != nil {
-		return false, err
+		logger.Warningf("Error retrieving ClientTraffic with email %s: %v", email, err)
+		return nil, nil, err
 	}
-	if inbound == nil {
-		return false, common.NewError("Inbound Not Found For Email:", clientEmail)
+	if len(traffics) > 0 {
+		inbound, err = s.GetInbound(traffics[0].InboundId)
+		return traffics[0], inbound, err
 	}
+	return nil, nil, nil
+}
 
-	oldClients, err := s.GetClients(inbound)
+func (s *InboundService) GetClientByEmail(clientEmail string) (*xray.ClientTraffic, *model.Client, error) {
+	db := database.GetDB()
+	traffic := &xray.ClientTraffic{}
+	err := db.Model(xray.ClientTraffic{}).Where("email = ?", clientEmail).Limit(1).Find(traffic).Error
 	if err != nil {
-		return false, err
-	}
-
-	clientId := ""
-
-	for _, oldClient := range oldClients {
-		if oldClient.Email == clientEmail {
-			switch inbound.Protocol {
-			case "trojan":
-				clientId = oldClient.Password
-			case "shadowsocks":
-				clientId = oldClient.Email
-			default:
-				clientId = oldClient.ID
-			}
-			break
-		}
+		return nil, nil, err
 	}
-
-	if len(clientId) == 0 {
-		return false, common.NewError("Client Not Found For Email:", clientEmail)
+	if traffic.Id == 0 {
+		return nil, nil, common.NewError("Inbound Not Found For Email:", clientEmail)
 	}
 
-	var settings map[string]any
-	err = json.Unmarshal(inbound.Settings, &settings)
+	client := &model.Client{}
+	err = db.Where("email = ?", clientEmail).Order("record_id").Limit(1).Find(client).Error
 	if err != nil {
-		return false, err
-	}
-	clients := settings["clients"].([]any)
-	var newClients []any
-	for client_index := range clients {
-		c := clients[client_index].(map[string]any)
-		if c["email"] == clientEmail {
-			c["limitIp"] = count
-			c["updated_at"] = time.Now().Unix() * 1000
-			newClients = append(newClients, any(c))
-		}
+		return nil, nil, err
 	}
-	settings["clients"] = newClients
-	modifiedSettings, err := json.MarshalIndent(settings, "", "  ")
-	if err != nil {
-		return false, err
+	if client.RecordId == 0 {
+		return nil, nil, common.NewError("Client Not Found In Inbound For Email:", clientEmail)
 	}
-	inbound.SetSettingsString(string(modifiedSettings))
-	needRestart, err := s.UpdateInboundClient(inbound, clientId)
-	return needRestart, err
+	return traffic, client, nil
 }
 
-func (s *InboundService) ResetClientExpiryTimeByEmail(clientEmail string, expiry_time int64) (bool, error) {
-	_, inbound, err := s.GetClientInboundByEmail(clientEmail)
+func (s *InboundService) SetClientTelegramUserID(trafficId int, tgId int64) (bool, error) {
+	traffic, inbound, err := s.GetClientInboundByTrafficID(trafficId)
 	if err != nil {
 		return false, err
 	}
 	if inbound == nil {
-		return false, common.NewError("Inbound Not Found For Email:", clientEmail)
+		return false, common.NewError("Inbound Not Found For Traffic ID:", trafficId)
 	}
+	return s.updateClientByEmail(traffic.Email, func(client *model.Client) {
+		client.TgID = tgId
+	})
+}
 
-	oldClients, err := s.GetClients(inbound)
+// updateClientByEmail applies change to the client with clientEmail and stores it
+// through UpdateInboundClient, which keeps stats and the running Xray in line.
+func (s *InboundService) updateClientByEmail(clientEmail string, change func(client *model.Client)) (bool, error) {
+	client := &model.Client{}
+	err := database.GetDB().Where("email = ?", clientEmail).Order("record_id").Limit(1).Find(client).Error
 	if err != nil {
 		return false, err
 	}
-
-	clientId := ""
-
-	for _, oldClient := range oldClients {
-		if oldClient.Email == clientEmail {
-			switch inbound.Protocol {
-			case "trojan":
-				clientId = oldClient.Password
-			case "shadowsocks":
-				clientId = oldClient.Email
-			default:
-				clientId = oldClient.ID
-			}
-			break
-		}
-	}
-
-	if len(clientId) == 0 {
+	if client.RecordId == 0 {
 		return false, common.NewError("Client Not Found For Email:", clientEmail)
 	}
-
-	var settings map[string]any
-	err = json.Unmarshal(inbound.Settings, &settings)
+	inbound, err := s.GetInbound(client.InboundId)
 	if err != nil {
 		return false, err
 	}
-	clients := settings["clients"].([]any)
-	var newClients []any
-	for client_index := range clients {
-		c := clients[client_index].(map[string]any)
-		if c["email"] == clientEmail {
-			c["expiryTime"] = expiry_time
-			c["updated_at"] = time.Now().Unix() * 1000
-			newClients = append(newClients, any(c))
-		}
-	}
-	settings["clients"] = newClients
-	modifiedSettings, err := json.MarshalIndent(settings, "", "  ")
-	if err != nil {
+	clientId := clientKey(inbound.Protocol, client)
+
+	change(client)
+	data := &model.Inbound{Id: inbound.Id}
+	if err := setSettingsClients(data, []model.Client{*client}); err != nil {
 		return false, err
 	}
-	inbound.SetSettingsString(string(modifiedSettings))
-	needRestart, err := s.UpdateInboundClient(inbound, clientId)
-	return needRestart, err
+	return s.UpdateInboundClient(data, clientId)
 }
 
-func (s *InboundService) ResetClientTrafficLimitByEmail(clientEmail string, totalGB int) (bool, error) {
-	if totalGB < 0 {
-		return false, common.NewError("totalGB must be >= 0")
-	}
-	_, inbound, err := s.GetClientInboundByEmail(clientEmail)
+func (s *InboundService) checkIsEnabledByEmail(clientEmail string) (bool, error) {
+	_, client, err := s.GetClientByEmail(clientEmail)
 	if err != nil {
 		return false, err
 	}
-	if inbound == nil {
-		return false, common.NewError("Inbound Not Found For Email:", clientEmail)
-	}
+	return client.Enable, nil
+}
 
-	oldClients, err := s.GetClients(inbound)
+func (s *InboundService) ToggleClientEnableByEmail(clientEmail string) (bool, bool, error) {
+	clientOldEnabled := false
+	needRestart, err := s.updateClientByEmail(clientEmail, func(client *model.Client) {
+		clientOldEnabled = client.Enable
+		client.Enable = !client.Enable
+	})
 	if err != nil {
-		return false, err
-	}
-
-	clientId := ""
-
-	for _, oldClient := range oldClients {
-		if oldClient.Email == clientEmail {
-			switch inbound.Protocol {
-			case "trojan":
-				clientId = oldClient.Password
-			case "shadowsocks":
-				clientId = oldClient.Email
-			default:
-				clientId = oldClient.ID
-			}
-			break
-		}
+		return false, needRestart, err
 	}
 
-	if len(clientId) == 0 {
-		return false, common.NewError("Client Not Found For Email:", clientEmail)
-	}
+	return !clientOldEnabled, needRestart, nil
+}
 
-	var settings map[string]any
-	err = json.Unmarshal(inbound.Settings, &settings)
+// SetClientEnableByEmail sets client enable state to desired value; returns (changed, needRestart, error)
+func (s *InboundService) SetClientEnableByEmail(clientEmail string, enable bool) (bool, bool, error) {
+	current, err := s.checkIsEnabledByEmail(clientEmail)
 	if err != nil {
-		return false, err
+		return false, false, err
 	}
-	clients := settings["clients"].([]any)
-	var newClients []any
-	for client_index := range clients {
-		c := clients[client_index].(map[string]any)
-		if c["email"] == clientEmail {
-			c["totalGB"] = totalGB * 1024 * 1024 * 1024
-			c["updated_at"] = time.Now().Unix() * 1000
-			newClients = append(newClients, any(c))
-		}
+	if current == enable {
+		return false, false, nil
 	}
-	settings["clients"] = newClients
-	modifiedSettings, err := json.MarshalIndent(settings, "", "  ")
+	newEnabled, needRestart, err := s.ToggleClientEnableByEmail(clientEmail)
 	if err != nil {
-		return false, err
+		return false, needRestart, err
 	}
-	inbound.SetSettingsString(string(modifiedSettings))
-	needRestart, err := s.UpdateInboundClient(inbound, clientId)
-	return needRestart, err
+	return newEnabled == enable, needRestart, nil
+}
+
+func (s *InboundService) ResetClientIpLimitByEmail(clientEmail string, count int) (bool, error) {
+	return s.updateClientByEmail(clientEmail, func(client *model.Client) {
+		client.LimitIP = count
+	})
+}
+
+func (s *InboundService) ResetClientExpiryTimeByEmail(clientEmail string, expiry_time int64) (bool, error) {
+	return s.updateClientByEmail(clientEmail, func(client *model.Client) {
+		client.ExpiryTime = expiry_time
+	})
+}
+
+func (s *InboundService) ResetClientTrafficLimitByEmail(clientEmail string, totalGB int) (bool, error) {
+	if totalGB < 0 {
+		return false, common.NewError("totalGB must be >= 0")
+	}
+	return s.updateClientByEmail(clientEmail, func(client *model.Client) {
+		client.TotalGB = int64(totalGB) * 1024 * 1024 * 1024
+	})
 }
 
 func (s *InboundService) ResetClientTrafficByEmail(clientEmail string) error {
@@ -1802,6 +1393,7 @@ func (s *InboundService) ResetClientTrafficByEmail(clientEmail string) error {
 		return err
 	}
 
+	s.audit(nil, "client.resetTraffic", 0, clientEmail, nil, nil)
 	return nil
 }
 
@@ -1854,6 +1446,7 @@ func (s *InboundService) ResetClientTraffic(id int, clientEmail string) (bool, e
 		}
 	}
 
+	before := map[string]any{"up": traffic.Up, "down": traffic.Down, "enable": traffic.Enable}
 	traffic.Up = 0
 	traffic.Down = 0
 	traffic.Enable = true
@@ -1864,6 +1457,7 @@ func (s *InboundService) ResetClientTraffic(id int, clientEmail string) (bool, e
 		return false, err
 	}
 
+	s.audit(nil, "client.resetTraffic", id, clientEmail, before, map[string]any{"up": 0, "down": 0, "enable": true})
 	return needRestart, nil
 }
 
@@ -1871,7 +1465,7 @@ func (s *InboundService) ResetAllClientTraffics(id int) error {
 	db := database.GetDB()
 	now := time.Now().Unix() * 1000
 
-	return db.Transaction(func(tx *gorm.DB) error {
+	err := db.Transaction(func(tx *gorm.DB) error {
 		whereText := "inbound_id "
 		if id == -1 {
 			whereText += " > ?"
@@ -1902,6 +1496,10 @@ func (s *InboundService) ResetAllClientTraffics(id int) error {
 
 		return result.Error
 	})
+	if err == nil {
+		s.audit(nil, "inbound.resetClientTraffics", max(id, 0), "", nil, nil)
+	}
+	return err
 }
 
 func (s *InboundService) ResetAllTraffics() error {
@@ -1912,117 +1510,94 @@ func (s *InboundService) ResetAllTraffics() error {
 		Updates(map[string]any{"up": 0, "down": 0})
 
 	err := result.Error
+	if err == nil {
+		s.audit(nil, "inbound.resetAllTraffics", 0, "", nil, nil)
+	}
 	return err
 }
 
-func (s *InboundService) DelDepletedClients(id int) (err error) {
-	db := database.GetDB()
-	tx := db.Begin()
-	defer func() {
-		if err == nil {
-			tx.Commit()
-		} else {
-			tx.Rollback()
-		}
-	}()
-
+func (s *InboundService) DelDepletedClients(id int) error {
 	whereText := "reset = 0 and inbound_id "
 	if id < 0 {
 		whereText += "> ?"
 	} else {
 		whereText += "= ?"
 	}
-
 	// Only consider truly depleted clients: expired OR traffic exhausted
+	whereText += " and ((total > 0 and up + down >= total) or (expiry_time > 0 and expiry_time <= ?))"
 	now := time.Now().Unix() * 1000
-	depletedClients := []xray.ClientTraffic{}
-	err = db.Model(xray.ClientTraffic{}).
-		Where(whereText+" and ((total > 0 and up + down >= total) or (expiry_time > 0 and expiry_time <= ?))", id, now).
-		Select("inbound_id, STRING_AGG(email, ',') as email").
-		Group("inbound_id").
-		Find(&depletedClients).Error
-	if err != nil {
-		return err
-	}
 
-	for _, depletedClient := range depletedClients {
-		emails := strings.Split(depletedClient.Email, ",")
-		oldInbound, err := s.GetInbound(depletedClient.InboundId)
-		if err != nil {
-			return err
-		}
-		var oldSettings map[string]any
-		err = json.Unmarshal(oldInbound.Settings, &oldSettings)
+	var emptyInbounds []int
+	err := database.GetDB().Transaction(func(tx *gorm.DB) error {
+		depletedClients := []xray.ClientTraffic{}
+		err := tx.Model(xray.ClientTraffic{}).Where(whereText, id, now).Find(&depletedClients).Error
 		if err != nil {
 			return err
 		}
 
-		oldClients := oldSettings["clients"].([]any)
-		var newClients []any
-		for _, client := range oldClients {
-			deplete := false
-			c := client.(map[string]any)
-			for _, email := range emails {
-				if email == c["email"].(string) {
-					deplete = true
-					break
-				}
-			}
-			if !deplete {
-				newClients = append(newClients, client)
+		var inboundIds []int
+		emailsByInbound := map[int][]string{}
+		for _, depletedClient := range depletedClients {
+			if _, ok := emailsByInbound[depletedClient.InboundId]; !ok {
+				inboundIds = append(inboundIds, depletedClient.InboundId)
 			}
+			emailsByInbound[depletedClient.InboundId] = append(emailsByInbound[depletedClient.InboundId], depletedClient.Email)
 		}
-		if len(newClients) > 0 {
-			oldSettings["clients"] = newClients
 
-			newSettings, err := json.MarshalIndent(oldSettings, "", "  ")
+		// Clients go before the stats they reference
+		for _, inboundId := range inboundIds {
+			var clients []model.Client
+			err = tx.Where("email IN ?", emailsByInbound[inboundId]).Find(&clients).Error
 			if err != nil {
 				return err
 			}
-
-			oldInbound.SetSettingsString(string(newSettings))
-			err = tx.Save(oldInbound).Error
+			if err = recycleClients(tx, clients); err != nil {
+				return err
+			}
+			err = tx.Where("email IN ?", emailsByInbound[inboundId]).Delete(&model.Client{}).Error
 			if err != nil {
 				return err
 			}
-		} else {
-			// Delete inbound if no client remains
-			s.DelInbound(depletedClient.InboundId)
+			var remaining int64
+			err = tx.Model(model.Client{}).Where("inbound_id = ?", inboundId).Count(&remaining).Error
+			if err != nil {
+				return err
+			}
+			if remaining == 0 {
+				emptyInbounds = append(emptyInbounds, inboundId)
+			}
+		}
+
+		// Delete stats only for truly depleted clients
+		err = tx.Where(whereText, id, now).Delete(xray.ClientTraffic{}).Error
+		if err != nil {
+			return err
 		}
-	}
 
-	// Delete stats only for truly depleted clients
-	err = tx.Where(whereText+" and ((total > 0 and up + down >= total) or (expiry_time > 0 and expiry_time <= ?))", id, now).Delete(xray.ClientTraffic{}).Error
+		for _, inboundId := range inboundIds {
+			s.audit(tx, "client.deleteDepleted", inboundId, "", map[string]any{"emails": emailsByInbound[inboundId]}, nil)
+		}
+		return nil
+	})
 	if err != nil {
 		return err
 	}
 
+	// Delete inbound if no client remains
+	for _, inboundId := range emptyInbounds {
+		s.DelInbound(inboundId)
+	}
 	return nil
 }
 
 func (s *InboundService) GetClientTrafficTgBot(tgId int64) ([]*xray.ClientTraffic, error) {
 	db := database.GetDB()
-	var inbounds []*model.Inbound
-
-	// Retrieve inbounds where settings contain the given tgId
-	err := db.Model(model.Inbound{}).Where("settings LIKE ?", fmt.Sprintf(`%%"tgId": %d%%`, tgId)).Find(&inbounds).Error
-	if err != nil && err != gorm.ErrRecordNotFound {
-		logger.Errorf("Error retrieving inbounds with tgId %d: %v", tgId, err)
-		return nil, err
-	}
 
 	var emails []string
-	for _, inbound := range inbounds {
-		clients, err := s.GetClients(inbound)
-		if err != nil {
-			logger.Errorf("Error retrieving clients for inbound %d: %v", inbound.Id, err)
-			continue
-		}
-		for _, client := range clients {
-			if client.TgID == tgId {
-				emails = append(emails, client.Email)
-			}
-		}
+	err := db.Model(model.Client{}).Where("tg_id = ?", tgId).Pluck("email", &emails).Error
+	if err != nil {
+		logger.Errorf("Error retrieving clients with tgId %d: %v", tgId, err)
+		return nil, err
 	}
 
 	var traffics []*xray.ClientTraffic
@@ -2076,6 +1651,7 @@ func (s *InboundService) UpdateClientTrafficByEmail(email string, upload int64,
 		logger.Warningf("Error updating ClientTraffic with email %s: %v", email, err)
 		return err
 	}
+	s.audit(nil, "client.updateTraffic", 0, email, nil, map[string]any{"up": upload, "down": download})
 	return nil
 }
 
@@ -2083,14 +1659,9 @@ func (s *InboundService) GetClientTrafficByID(id string) ([]xray.ClientTraffic,
 	db := database.GetDB()
 	var traffics []xray.ClientTraffic
 
-	// Для PostgreSQL используем jsonb_array_elements для работы с JSON массивом
-	err := db.Model(xray.ClientTraffic{}).Where(`email IN(
-		SELECT client->>'email' as email
-		FROM inbounds,
-			jsonb_array_elements(COALESCE(inbounds.settings->'clients','[]'::jsonb)) AS client
-		WHERE
-			client->>'id' IN (?)
-		)`, id).Find(&traffics).Error
+	err := db.Model(xray.ClientTraffic{}).
+		Where("email IN (?)", db.Model(model.Client{}).Select("email").Where("uuid = ?", id)).
+		Find(&traffics).Error
 
 	if err != nil {
 		logger.Debug(err)
@@ -2110,50 +1681,28 @@ func (s *InboundService) GetClientTrafficByID(id string) ([]xray.ClientTraffic,
 
 func (s *InboundService) SearchClientTraffic(query string) (traffic *xray.ClientTraffic, err error) {
 	db := database.GetDB()
-	inbound := &model.Inbound{}
 	traffic = &xray.ClientTraffic{}
 
-	// Search for inbound settings that contain the query
-	err = db.Model(model.Inbound{}).Where("settings::text LIKE ?", "%\""+query+"\"%").First(inbound).Error
+	// Search for the client whose id or password is the query
+	client := &model.Client{}
+	err = db.Where("(uuid = ? OR password = ?) AND email <> ''", query, query).Order("record_id").First(client).Error
 	if err != nil {
 		if err == gorm.ErrRecordNotFound {
-			logger.Warningf("Inbound settings containing query %s not found: %v", query, err)
+			logger.Warningf("No client found with query %s: %v", query, err)
 			return nil, err
 		}
-		logger.Errorf("Error searching for inbound settings with query %s: %v", query, err)
-		return nil, err
-	}
-
-	traffic.InboundId = inbound.Id
-
-	// Unmarshal settings to get clients
-	settings := map[string][]model.Client{}
-	if err := json.Unmarshal(inbound.Settings, &settings); err != nil {
-		logger.Errorf("Error unmarshalling inbound settings for inbound ID %d: %v", inbound.Id, err)
+		logger.Errorf("Error searching for client with query %s: %v", query, err)
 		return nil, err
 	}
 
-	clients := settings["clients"]
-	for _, client := range clients {
-		if (client.ID == query || client.Password == query) && client.Email != "" {
-			traffic.Email = client.Email
-			break
-		}
-	}
-
-	if traffic.Email == "" {
-		logger.Warningf("No client found with query %s in inbound ID %d", query, inbound.Id)
-		return nil, gorm.ErrRecordNotFound
-	}
-
 	// Retrieve ClientTraffic based on the found email
-	err = db.Model(xray.ClientTraffic{}).Where("email = ?", traffic.Email).First(traffic).Error
+	err = db.Model(xray.ClientTraffic{}).Where("email = ?", client.Email).First(traffic).Error
 	if err != nil {
 		if err == gorm.ErrRecordNotFound {
-			logger.Warningf("ClientTraffic for email %s not found: %v", traffic.Email, err)
+			logger.Warningf("ClientTraffic for email %s not found: %v", client.Email, err)
 			return nil, err
 		}
-		logger.Errorf("Error retrieving ClientTraffic for email %s: %v", traffic.Email, err)
+		logger.Errorf("Error retrieving ClientTraffic for email %s: %v", client.Email, err)
 		return nil, err
 	}
 
@@ -2217,6 +1766,7 @@ func (s *InboundService) ClearClientIps(clientEmail string) error {
 	if err != nil {
 		return err
 	}
+	s.audit(nil, "client.clearIps", 0, clientEmail, nil, nil)
 	return nil
 }
 
@@ -2227,6 +1777,9 @@ func (s *InboundService) SearchInbounds(query string) ([]*model.Inbound, error)
 	if err != nil && err != gorm.ErrRecordNotFound {
 		return nil, err
 	}
+	if err := s.enrichInbounds(db, inbounds); err != nil {
+		return nil, err
+	}
 	return inbounds, nil
 }
 
@@ -2278,82 +1831,41 @@ func (s *InboundService) MigrationRequirements() {
 		return
 	}
 
-	// Fix inbounds based problems
-	var inbounds []*model.Inbound
-	err = tx.Model(model.Inbound{}).Where("protocol IN (?)", []string{"vmess", "vless", "trojan"}).Find(&inbounds).Error
-	if err != nil && err != gorm.ErrRecordNotFound {
+	// Fix client configuration problems
+	// Remove "flow": "xtls-rprx-direct"
+	err = tx.Model(model.Client{}).Where("flow = ?", "xtls-rprx-direct").Update("flow", "").Error
+	if err != nil {
+		return
+	}
+	// Backfill created_at and updated_at
+	now := time.Now().Unix() * 1000
+	err = tx.Model(model.Client{}).Where("created_at = 0").Update("created_at", now).Error
+	if err != nil {
+		return
+	}
+	err = tx.Model(model.Client{}).Where("updated_at = 0").Update("updated_at", now).Error
+	if err != nil {
 		return
 	}
-	for inbound_index := range inbounds {
-		settings := map[string]any{}
-		json.Unmarshal(inbounds[inbound_index].Settings, &settings)
-		clients, ok := settings["clients"].([]any)
-		if ok {
-			// Fix Client configuration problems
-			var newClients []any
-			for client_index := range clients {
-				c, ok := clients[client_index].(map[string]any)
-				if !ok {
-					continue
-				}
-
-				// Add email='' if it is not exists
-				if _, ok := c["email"]; !ok {
-					c["email"] = ""
-				}
-
-				// Convert string tgId to int64
-				if _, ok := c["tgId"]; ok {
-					var tgId any = c["tgId"]
-					if tgIdStr, ok2 := tgId.(string); ok2 {
-						tgIdInt64, err := strconv.ParseInt(strings.ReplaceAll(tgIdStr, " ", ""), 10, 64)
-						if err == nil {
-							c["tgId"] = tgIdInt64
-						}
-					}
-				}
-
-				// Remove "flow": "xtls-rprx-direct"
-				if _, ok := c["flow"]; ok {
-					if c["flow"] == "xtls-rprx-direct" {
-						c["flow"] = ""
-					}
-				}
-				// Backfill created_at and updated_at
-				if _, ok := c["created_at"]; !ok {
-					c["created_at"] = time.Now().Unix() * 1000
-				}
-				c["updated_at"] = time.Now().Unix() * 1000
-				newClients = append(newClients, any(c))
-			}
-			settings["clients"] = newClients
-			modifiedSettings, err := json.MarshalIndent(settings, "", "  ")
-			if err != nil {
-				return
-			}
-
-			inbounds[inbound_index].SetSettingsString(string(modifiedSettings))
-		}
 
-		// Add client traffic row for all clients which has email
-		modelClients, err := s.GetClients(inbounds[inbound_index])
-		if err != nil {
+	// Add client traffic row for all clients which has email
+	var clients []model.Client
+	err = tx.Model(model.Client{}).
+		Where("email <> '' AND NOT EXISTS (SELECT 1 FROM client_traffics WHERE client_traffics.email = clients.email)").
+		Find(&clients).Error
+	if err != nil {
+		return
+	}
+	for _, client := range clients {
+		if err = s.ensureClientStat(tx, client.InboundId, &client); err != nil {
 			return
 		}
-		for _, modelClient := range modelClients {
-			if len(modelClient.Email) > 0 {
-				var count int64
-				tx.Model(xray.ClientTraffic{}).Where("email = ?", modelClient.Email).Count(&count)
-				if count == 0 {
-					s.AddClientStat(tx, inbounds[inbound_index].Id, &modelClient)
-				}
-			}
-		}
 	}
-	tx.Save(inbounds)
 
 	// Remove orphaned traffics
-	tx.Where("inbound_id = 0").Delete(xray.ClientTraffic{})
+	tx.Where("inbound_id = 0").
+		Where("NOT EXISTS (SELECT 1 FROM clients WHERE clients.email = client_traffics.email)").
+		Delete(xray.ClientTraffic{})
 
 	// Migrate old MultiDomain to External Proxy
 	var externalProxy []struct {
@@ -2470,104 +1982,13 @@ func (s *InboundService) DelInboundClientByEmail(inboundId int, email string) (b
 		return false, err
 	}
 
-	var settings map[string]any
-	if err := json.Unmarshal(oldInbound.Settings, &settings); err != nil {
-		return false, err
-	}
-
-	interfaceClients, ok := settings["clients"].([]any)
-	if !ok {
-		return false, common.NewError("invalid clients format in inbound settings")
-	}
-
-	var newClients []any
-	needApiDel := false
-	found := false
-
-	for _, client := range interfaceClients {
-		c, ok := client.(map[string]any)
-		if !ok {
-			continue
-		}
-		if cEmail, ok := c["email"].(string); ok && cEmail == email {
-			// matched client, drop it
-			found = true
-			needApiDel = true
-
-			if v, ok := c["enable"]; ok {
-				switch t := v.(type) {
-				case bool:
-					needApiDel = t
-				case string:
-					needApiDel = strings.EqualFold(t, "true")
-				case float64: // json numbers -> float64
-					needApiDel = t != 0
-				}
-			}
-		} else {
-			newClients = append(newClients, client)
-		}
-	}
-
-	if !found {
-		return false, common.NewError(fmt.Sprintf("client with email %s not found", email))
-	}
-	if len(newClients) == 0 {
-		return false, common.NewError("no client remained in Inbound")
-	}
-
-	settings["clients"] = newClients
-	newSettings, err := json.MarshalIndent(settings, "", "  ")
+	client := &model.Client{}
+	err = database.GetDB().Where("inbound_id = ? AND email = ?", inboundId, email).Limit(1).Find(client).Error
 	if err != nil {
 		return false, err
 	}
-
-	oldInbound.SetSettingsString(string(newSettings))
-
-	db := database.GetDB()
-
-	// remove IP bindings
-	if err := s.DelClientIPs(db, email); err != nil {
-		logger.Error("Error in delete client IPs")
-		return false, err
-	}
-
-	needRestart := false
-
-	// 1) DB часть — атомарно
-	if err := db.Transaction(func(tx *gorm.DB) error {
-		if err := tx.Save(oldInbound).Error; err != nil {
-			return err
-		}
-
-		if email != "" {
-			if err := s.DelClientIPs(tx, email); err != nil {
-				return err
-			}
-			if err := s.DelClientStat(tx, email); err != nil {
-				return err
-			}
-		}
-		return nil
-	}); err != nil {
-		return false, err
-	}
-
-	// 2) API часть — после коммита
-	if email != "" && needApiDel {
-		s.xrayApi.Init(p.GetAPIPort())
-		if err1 := s.xrayApi.RemoveUser(oldInbound.Tag, email); err1 == nil {
-			logger.Debug("Client deleted by api:", email)
-		} else {
-			if strings.Contains(err1.Error(), fmt.Sprintf("User %s not found.", email)) {
-				logger.Debug("User is already deleted. Nothing to do more...")
-			} else {
-				logger.Debug("Error in deleting client by api:", err1)
-				needRestart = true
-			}
-		}
-		s.xrayApi.Close()
+	if client.RecordId == 0 {
+		return false, common.NewError(fmt.Sprintf("client with email %s not found", email))
 	}
-
-	return needRestart, nil
+	return s.delClient(oldInbound, client)
 }
@@ -0,0 +1,166 @@
+package service
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/mhsanaei/3x-ui/v2/database"
+	"github.com/mhsanaei/3x-ui/v2/database/model"
+	"github.com/mhsanaei/3x-ui/v2/logger"
+	"github.com/mhsanaei/3x-ui/v2/util/common"
+	"github.com/mhsanaei/3x-ui/v2/util/firewall"
+)
+
+// notIPLimitBanned is a condition on the clients table that leaves out clients the
+// panel removed from Xray for exceeding their IP limit.
+const notIPLimitBanned = "NOT EXISTS (SELECT 1 FROM ip_limit_bans WHERE ip_limit_bans.client_email = clients.email" +
+	" AND ip_limit_bans.mode = '" + model.IPLimitModeXray + "' AND ip_limit_bans.lifted = false)"
+
+// BanIPLimitClient blocks the client with email on inbound for the IP limit cooldown,
+// as it connected from ips on top of the ones its limit allows. In the Xray mode the
+// client is removed from Xray, unless it already is; in the nftables mode ips are
+// dropped on the port of inbound. The ban is recorded, and lifted by LiftExpiredIPLimitBans. It reports
+// whether Xray needs a restart to drop the client.
+func (s *InboundService) BanIPLimitClient(mode string, inbound *model.Inbound, email string, ips []string) (bool, error) {
+	settingService := SettingService{}
+	cooldown, err := settingService.GetIpLimitCooldown()
+	if err != nil {
+		return false, err
+	}
+	db := database.GetDB()
+
+	switch mode {
+	case model.IPLimitModeXray:
+		var count int64
+		err := db.Model(model.IPLimitBan{}).
+			Where("client_email = ? AND mode = ? AND lifted = ?", email, model.IPLimitModeXray, false).
+			Count(&count).Error
+		if err != nil || count > 0 {
+			return false, err
+		}
+	case model.IPLimitModeNftables:
+		if err := firewall.Block(ips, inbound.Port, time.Duration(cooldown)*time.Second); err != nil {
+			return false, err
+		}
+	default:
+		return false, common.NewError("IP limit mode is not enforced by the panel:", mode)
+	}
+
+	now := time.Now()
+	ban := &model.IPLimitBan{
+		ClientEmail: email,
+		InboundId:   inbound.Id,
+		Mode:        mode,
+		Ips:         strings.Join(ips, ","),
+		Port:        inbound.Port,
+		CreatedAt:   now.UnixMilli(),
+		Until:       now.Add(time.Duration(cooldown) * time.Second).UnixMilli(),
+	}
+	if err := db.Create(ban).Error; err != nil {
+		return false, err
+	}
+	s.audit(nil, "client.ipLimitBan", inbound.Id, email, nil, ban)
+	if mode != model.IPLimitModeXray {
+		return false, nil
+	}
+
+	// The Xray config leaves the client out from now on, so a restart drops it too
+	s.xrayApi.Init(p.GetAPIPort())
+	defer s.xrayApi.Close()
+	err = s.xrayApi.RemoveUser(inbound.Tag, email)
+	if err != nil && !strings.Contains(err.Error(), fmt.Sprintf("User %s not found.", email)) {
+		logger.Debug("Error in deleting client by api:", err)
+		return true, nil
+	}
+	return false, nil
+}
+
+// GetIPLimitBans returns the most recent IP limit bans, newest first, or only the
+// ones in force when active is set.
+func (s *InboundService) GetIPLimitBans(active bool) ([]model.IPLimitBan, error) {
+	db := database.GetDB().Model(model.IPLimitBan{})
+	if active {
+		db = db.Where("lifted = ?", false)
+	}
+	var bans []model.IPLimitBan
+	err := db.Order("id DESC").Limit(500).Find(&bans).Error
+	return bans, err
+}
+
+// LiftIPLimitBan lifts an IP limit ban before its cooldown ends.
+func (s *InboundService) LiftIPLimitBan(id int) (bool, error) {
+	ban := &model.IPLimitBan{}
+	if err := database.GetDB().Where("id = ?", id).Limit(1).Find(ban).Error; err != nil {
+		return false, err
+	}
+	if ban.Id == 0 {
+		return false, common.NewError("IP limit ban not found:", id)
+	}
+	if ban.Lifted {
+		return false, nil
+	}
+	if ban.Mode == model.IPLimitModeNftables {
+		firewall.Unblock(strings.Split(ban.Ips, ","), ban.Port)
+	}
+	return s.liftIPLimitBan(ban)
+}
+
+// LiftExpiredIPLimitBans lifts the IP limit bans whose cooldown is over. Blocked IPs
+// time out in nftables by themselves, clients removed from Xray are added back.
+func (s *InboundService) LiftExpiredIPLimitBans() (bool, error) {
+	var bans []model.IPLimitBan
+	err := database.GetDB().Where("lifted = ? AND until <= ?", false, time.Now().UnixMilli()).Find(&bans).Error
+	if err != nil {
+		return false, err
+	}
+	needRestart := false
+	for i := range bans {
+		restart, err := s.liftIPLimitBan(&bans[i])
+		if err != nil {
+			return needRestart, err
+		}
+		needRestart = needRestart || restart
+	}
+	return needRestart, nil
+}
+
+// liftIPLimitBan marks ban as lifted and adds a client removed from Xray back if Xray
+// should still serve it. It reports whether Xray needs a restart to serve it.
+func (s *InboundService) liftIPLimitBan(ban *model.IPLimitBan) (bool, error) {
+	db := database.GetDB()
+	if err := db.Model(ban).Update("lifted", true).Error; err != nil {
+		return false, err
+	}
+	s.audit(nil, "client.ipLimitLift", ban.InboundId, ban.ClientEmail, nil, map[string]any{"id": ban.Id})
+	if ban.Mode != model.IPLimitModeXray {
+		return false, nil
+	}
+
+	var clients []model.Client
+	if err := db.Where("email = ?", ban.ClientEmail).Find(&clients).Error; err != nil {
+		return false, err
+	}
+	recordIds := make([]int, 0, len(clients))
+	for _, client := range clients {
+		recordIds = append(recordIds, client.RecordId)
+	}
+	served, err := servedClients(db, recordIds)
+	if err != nil || len(served) == 0 {
+		return false, err
+	}
+	needRestart := false
+	s.xrayApi.Init(p.GetAPIPort())
+	defer s.xrayApi.Close()
+	for _, client := range served {
+		inbound, err := s.GetInbound(client.InboundId)
+		if err != nil {
+			return needRestart, err
+		}
+		if err := s.xrayApi.AddUser(string(inbound.Protocol), inbound.Tag, apiUser(inbound, &client)); err != nil {
+			logger.Debug("Error in adding client by api:", err)
+			needRestart = true
+		}
+	}
+	return needRestart, nil
+}
@@ -0,0 +1,181 @@
+package service
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Login lockout kinds reported by LoginLockout.Kind
+const (
+	LoginLockoutIP   = "ip"
+	LoginLockoutUser = "user"
+)
+
+// maxLoginAttemptEntries bounds the number of tracked IPs and accounts before stale entries are pruned.
+const maxLoginAttemptEntries = 10000
+
+// LoginLockout describes the failed login state of an IP address or account.
+type LoginLockout struct {
+	Kind        string `json:"kind"`        // LoginLockoutIP or LoginLockoutUser
+	Key         string `json:"key"`         // IP address or lower-cased username
+	Failures    int    `json:"failures"`    // Failures since the last lockout
+	Lockouts    int    `json:"lockouts"`    // Consecutive lockouts, drives the backoff
+	LastFailure int64  `json:"lastFailure"` // Timestamp in milliseconds
+	LockedUntil int64  `json:"lockedUntil"` // Timestamp in milliseconds, 0 if not locked
+}
+
+// LoginLockedError is returned by UserService.CheckUser while the IP or account is locked out.
+type LoginLockedError struct {
+	Remaining time.Duration
+}
+
+func (e *LoginLockedError) Error() string {
+	return fmt.Sprintf("too many failed login attempts, try again in %v", e.Remaining.Round(time.Second))
+}
+
+// loginLimitConfig holds the brute-force protection settings.
+type loginLimitConfig struct {
+	enable      bool
+	maxAttempts int
+	lockout     time.Duration
+	maxLockout  time.Duration
+	window      time.Duration
+}
+
+type loginAttempt struct {
+	failures    int
+	lockouts    int
+	lastFailure time.Time
+	lockedUntil time.Time
+}
+
+// loginLimiter tracks failed logins per IP and per account in memory.
+type loginLimiter struct {
+	mu       sync.Mutex
+	attempts map[string]*loginAttempt
+}
+
+var loginLimits = &loginLimiter{attempts: make(map[string]*loginAttempt)}
+
+func loginLimitKeys(ip string, username string) []string {
+	keys := make([]string, 0, 2)
+	if ip != "" {
+		keys = append(keys, LoginLockoutIP+":"+ip)
+	}
+	if username != "" {
+		keys = append(keys, LoginLockoutUser+":"+strings.ToLower(username))
+	}
+	return keys
+}
+
+// lockedFor returns the longest remaining lockout of the IP and the account.
+func (l *loginLimiter) lockedFor(ip string, username string, now time.Time) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	var remaining time.Duration
+	for _, key := range loginLimitKeys(ip, username) {
+		if a, ok := l.attempts[key]; ok && a.lockedUntil.After(now) {
+			remaining = max(remaining, a.lockedUntil.Sub(now))
+		}
+	}
+	return remaining
+}
+
+// fail records a failed attempt and locks keys that reach the attempt limit.
+// Each further lockout doubles the duration up to the configured maximum.
+func (l *loginLimiter) fail(ip string, username string, cfg loginLimitConfig, now time.Time) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if len(l.attempts) > maxLoginAttemptEntries {
+		l.prune(cfg, now)
+	}
+	for _, key := range loginLimitKeys(ip, username) {
+		a, ok := l.attempts[key]
+		if !ok {
+			a = &loginAttempt{}
+			l.attempts[key] = a
+		} else if l.stale(a, cfg, now) {
+			*a = loginAttempt{}
+		}
+		a.failures++
+		a.lastFailure = now
+		if a.failures >= cfg.maxAttempts {
+			a.lockouts++
+			a.failures = 0
+			duration := cfg.lockout
+			for i := 1; i < a.lockouts && duration < cfg.maxLockout; i++ {
+				duration *= 2
+			}
+			a.lockedUntil = now.Add(min(duration, cfg.maxLockout))
+		}
+	}
+}
+
+// succeed forgets the failures of the IP and the account.
+func (l *loginLimiter) succeed(ip string, username string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for _, key := range loginLimitKeys(ip, username) {
+		delete(l.attempts, key)
+	}
+}
+
+// stale reports whether an entry is unlocked and has seen no failure within the window.
+func (l *loginLimiter) stale(a *loginAttempt, cfg loginLimitConfig, now time.Time) bool {
+	return !a.lockedUntil.After(now) && now.Sub(a.lastFailure) > cfg.window
+}
+
+// prune drops stale entries. The caller must hold l.mu.
+func (l *loginLimiter) prune(cfg loginLimitConfig, now time.Time) {
+	for key, a := range l.attempts {
+		if l.stale(a, cfg, now) {
+			delete(l.attempts, key)
+		}
+	}
+}
+
+// list returns the tracked entries, locked ones first.
+func (l *loginLimiter) list(cfg loginLimitConfig, now time.Time) []LoginLockout {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.prune(cfg, now)
+	result := make([]LoginLockout, 0, len(l.attempts))
+	for key, a := range l.attempts {
+		kind, value, _ := strings.Cut(key, ":")
+		lockout := LoginLockout{
+			Kind:        kind,
+			Key:         value,
+			Failures:    a.failures,
+			Lockouts:    a.lockouts,
+			LastFailure: a.lastFailure.UnixMilli(),
+		}
+		if a.lockedUntil.After(now) {
+			lockout.LockedUntil = a.lockedUntil.UnixMilli()
+		}
+		result = append(result, lockout)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].LockedUntil != result[j].LockedUntil {
+			return result[i].LockedUntil > result[j].LockedUntil
+		}
+		return result[i].LastFailure > result[j].LastFailure
+	})
+	return result
+}
+
+// clear removes the entry for kind and key, or every entry when kind is empty.
+func (l *loginLimiter) clear(kind string, key string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if kind == "" {
+		l.attempts = make(map[string]*loginAttempt)
+		return
+	}
+	if kind == LoginLockoutUser {
+		key = strings.ToLower(key)
+	}
+	delete(l.attempts, kind+":"+key)
+}
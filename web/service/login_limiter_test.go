@@ -0,0 +1,95 @@
+package service
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var testLimitConfig = loginLimitConfig{
+	enable:      true,
+	maxAttempts: 3,
+	lockout:     10 * time.Second,
+	maxLockout:  60 * time.Second,
+	window:      5 * time.Minute,
+}
+
+// failTimes records n failed logins of ip and username at now.
+func failTimes(l *loginLimiter, n int, ip string, username string, now time.Time) {
+	for range n {
+		l.fail(ip, username, testLimitConfig, now)
+	}
+}
+
+func TestLoginLimiterBackoff(t *testing.T) {
+	l := &loginLimiter{attempts: map[string]*loginAttempt{}}
+	now := time.Unix(1700000000, 0)
+
+	failTimes(l, 2, "1.2.3.4", "", now)
+	assert.Zero(t, l.lockedFor("1.2.3.4", "", now), "below the attempt limit")
+
+	failTimes(l, 1, "1.2.3.4", "", now)
+	assert.Equal(t, 10*time.Second, l.lockedFor("1.2.3.4", "", now))
+	now = now.Add(10 * time.Second)
+
+	// Each further lockout doubles until the maximum
+	for _, want := range []time.Duration{20 * time.Second, 40 * time.Second, time.Minute, time.Minute} {
+		failTimes(l, 3, "1.2.3.4", "", now)
+		assert.Equal(t, want, l.lockedFor("1.2.3.4", "", now))
+		now = now.Add(want)
+		assert.Zero(t, l.lockedFor("1.2.3.4", "", now), "lockout of %v over", want)
+	}
+
+	// A quiet window starts over at the base lockout
+	now = now.Add(testLimitConfig.window + time.Second)
+	failTimes(l, 3, "1.2.3.4", "", now)
+	assert.Equal(t, 10*time.Second, l.lockedFor("1.2.3.4", "", now))
+}
+
+func TestLoginLimiterKeys(t *testing.T) {
+	tests := []struct {
+		name     string
+		failIp   string
+		failUser string
+		ip       string
+		username string
+		locked   bool
+	}{
+		{"same ip", "1.1.1.1", "", "1.1.1.1", "", true},
+		{"other ip", "1.1.1.1", "", "2.2.2.2", "", false},
+		{"account from another ip", "1.1.1.1", "Admin", "2.2.2.2", "admin", true},
+		{"other account from another ip", "1.1.1.1", "admin", "2.2.2.2", "bob", false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			l := &loginLimiter{attempts: map[string]*loginAttempt{}}
+			now := time.Unix(1700000000, 0)
+			failTimes(l, 3, test.failIp, test.failUser, now)
+			assert.Equal(t, test.locked, l.lockedFor(test.ip, test.username, now) > 0)
+		})
+	}
+}
+
+func TestLoginLimiterSucceedListAndClear(t *testing.T) {
+	l := &loginLimiter{attempts: map[string]*loginAttempt{}}
+	now := time.Unix(1700000000, 0)
+
+	failTimes(l, 2, "1.1.1.1", "alice", now)
+	l.succeed("1.1.1.1", "alice")
+	failTimes(l, 1, "1.1.1.1", "alice", now)
+	assert.Zero(t, l.lockedFor("1.1.1.1", "alice", now), "success forgets earlier failures")
+
+	failTimes(l, 3, "9.9.9.9", "", now.Add(time.Second))
+	list := l.list(testLimitConfig, now.Add(time.Second))
+	assert.Len(t, list, 3)
+	assert.Equal(t, LoginLockoutIP, list[0].Kind)
+	assert.Equal(t, "9.9.9.9", list[0].Key)
+	assert.NotZero(t, list[0].LockedUntil, "locked entries come first")
+
+	l.clear(LoginLockoutUser, "ALICE")
+	assert.Len(t, l.list(testLimitConfig, now), 2)
+	assert.Empty(t, l.list(testLimitConfig, now.Add(time.Hour)), "stale entries are pruned")
+	l.clear("", "")
+	assert.Empty(t, l.attempts)
+}
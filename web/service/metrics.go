@@ -0,0 +1,268 @@
+package service
+
+import (
+	"fmt"
+	"io"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// latestStatus holds the status the status task of the panel collected last, so
+// metrics do not sample the CPU on their own and skew its readings.
+var latestStatus atomic.Pointer[Status]
+
+// JobStat counts the runs of a background job and the time they took.
+type JobStat struct {
+	Runs        int64
+	Seconds     float64 // Time of all runs together
+	LastSeconds float64 // Time of the last run
+}
+
+var jobStats = struct {
+	sync.Mutex
+	byName map[string]*JobStat
+}{byName: map[string]*JobStat{}}
+
+// RecordJobRun adds a run of the background job name that took duration.
+func RecordJobRun(name string, duration time.Duration) {
+	jobStats.Lock()
+	defer jobStats.Unlock()
+	stat, ok := jobStats.byName[name]
+	if !ok {
+		stat = &JobStat{}
+		jobStats.byName[name] = stat
+	}
+	stat.Runs++
+	stat.Seconds += duration.Seconds()
+	stat.LastSeconds = duration.Seconds()
+}
+
+// MetricsService renders the state of the server, Xray and its traffic in the
+// Prometheus text exposition format.
+type MetricsService struct {
+	inboundService  InboundService
+	outboundService OutboundService
+	xrayService     XrayService
+}
+
+// metricWriter writes metric families and keeps the first write error.
+type metricWriter struct {
+	w   io.Writer
+	err error
+}
+
+// family starts the metric family name of kind, such as gauge or counter, with help.
+func (m *metricWriter) family(name string, kind string, help string) {
+	if m.err == nil {
+		_, m.err = fmt.Fprintf(m.w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+	}
+}
+
+// sample writes a sample of name with labels given as name and value pairs.
+func (m *metricWriter) sample(name string, value float64, labels ...string) {
+	if m.err != nil {
+		return
+	}
+	var b strings.Builder
+	b.WriteString(name)
+	if len(labels) > 0 {
+		b.WriteByte('{')
+		for i := 0; i+1 < len(labels); i += 2 {
+			if i > 0 {
+				b.WriteByte(',')
+			}
+			b.WriteString(labels[i])
+			b.WriteString(`="`)
+			b.WriteString(escapeLabel(labels[i+1]))
+			b.WriteByte('"')
+		}
+		b.WriteByte('}')
+	}
+	b.WriteByte(' ')
+	b.WriteString(strconv.FormatFloat(value, 'g', -1, 64))
+	b.WriteByte('\n')
+	_, m.err = io.WriteString(m.w, b.String())
+}
+
+// escapeLabel escapes a label value for the exposition format.
+func escapeLabel(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(value)
+}
+
+// boolValue returns 1 for true and 0 for false.
+func boolValue(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// WriteMetrics writes all metrics to w.
+func (s *MetricsService) WriteMetrics(w io.Writer) error {
+	m := &metricWriter{w: w}
+	s.writeStatus(m)
+	if err := s.writeTraffic(m); err != nil {
+		return err
+	}
+	s.writeJobs(m)
+	return m.err
+}
+
+// writeStatus writes the fields of the latest server status.
+func (s *MetricsService) writeStatus(m *metricWriter) {
+	running := s.xrayService.IsXrayRunning()
+	m.family("xui_xray_up", "gauge", "Whether Xray is running.")
+	m.sample("xui_xray_up", boolValue(running))
+	m.family("xui_xray_info", "gauge", "Version of Xray.")
+	m.sample("xui_xray_info", 1, "version", s.xrayService.GetXrayVersion())
+
+	status := latestStatus.Load()
+	if status == nil {
+		return
+	}
+	m.family("xui_xray_state", "gauge", "State of the Xray process.")
+	for _, state := range []ProcessState{Running, Stop, Error} {
+		m.sample("xui_xray_state", boolValue(status.Xray.State == state), "state", string(state))
+	}
+	m.family("xui_cpu_usage_percent", "gauge", "CPU usage of the server.")
+	m.sample("xui_cpu_usage_percent", status.Cpu)
+	m.family("xui_cpu_cores", "gauge", "Physical CPU cores.")
+	m.sample("xui_cpu_cores", float64(status.CpuCores))
+	m.family("xui_cpu_logical_processors", "gauge", "Logical CPU processors.")
+	m.sample("xui_cpu_logical_processors", float64(status.LogicalPro))
+	m.family("xui_memory_bytes", "gauge", "Memory of the server.")
+	m.sample("xui_memory_bytes", float64(status.Mem.Current), "kind", "used")
+	m.sample("xui_memory_bytes", float64(status.Mem.Total), "kind", "total")
+	m.family("xui_swap_bytes", "gauge", "Swap of the server.")
+	m.sample("xui_swap_bytes", float64(status.Swap.Current), "kind", "used")
+	m.sample("xui_swap_bytes", float64(status.Swap.Total), "kind", "total")
+	m.family("xui_disk_bytes", "gauge", "Disk space of the root file system.")
+	m.sample("xui_disk_bytes", float64(status.Disk.Current), "kind", "used")
+	m.sample("xui_disk_bytes", float64(status.Disk.Total), "kind", "total")
+	if len(status.Loads) == 3 {
+		m.family("xui_load_average", "gauge", "System load averages.")
+		for i, period := range []string{"1", "5", "15"} {
+			m.sample("xui_load_average", status.Loads[i], "minutes", period)
+		}
+	}
+	m.family("xui_connections", "gauge", "Open network connections of the server.")
+	m.sample("xui_connections", float64(status.TcpCount), "protocol", "tcp")
+	m.sample("xui_connections", float64(status.UdpCount), "protocol", "udp")
+	m.family("xui_network_speed_bytes", "gauge", "Network throughput of the server per second.")
+	m.sample("xui_network_speed_bytes", float64(status.NetIO.Up), "direction", "up")
+	m.sample("xui_network_speed_bytes", float64(status.NetIO.Down), "direction", "down")
+	m.family("xui_network_bytes_total", "counter", "Network traffic of the server since boot.")
+	m.sample("xui_network_bytes_total", float64(status.NetTraffic.Sent), "direction", "up")
+	m.sample("xui_network_bytes_total", float64(status.NetTraffic.Recv), "direction", "down")
+	m.family("xui_uptime_seconds", "gauge", "Uptime of the server.")
+	m.sample("xui_uptime_seconds", float64(status.Uptime))
+	m.family("xui_app_uptime_seconds", "gauge", "Uptime of the panel.")
+	m.sample("xui_app_uptime_seconds", float64(status.AppStats.Uptime))
+	m.family("xui_app_memory_bytes", "gauge", "Memory the panel uses.")
+	m.sample("xui_app_memory_bytes", float64(status.AppStats.Mem))
+	m.family("xui_app_threads", "gauge", "Threads of the panel.")
+	m.sample("xui_app_threads", float64(status.AppStats.Threads))
+}
+
+// writeTraffic writes the traffic counters of inbounds, outbounds and clients and the
+// online clients.
+func (s *MetricsService) writeTraffic(m *metricWriter) error {
+	inbounds, err := s.inboundService.GetAllInbounds()
+	if err != nil {
+		return err
+	}
+	outbounds, err := s.outboundService.GetOutboundsTraffic()
+	if err != nil {
+		return err
+	}
+	online := map[string]bool{}
+	if s.xrayService.IsXrayRunning() {
+		for _, email := range s.inboundService.GetOnlineClients() {
+			online[email] = true
+		}
+	}
+
+	m.family("xui_inbound_enabled", "gauge", "Whether the inbound is enabled.")
+	for _, inbound := range inbounds {
+		m.sample("xui_inbound_enabled", boolValue(inbound.Enable),
+			"inbound_id", strconv.Itoa(inbound.Id), "tag", inbound.Tag, "remark", inbound.Remark)
+	}
+	m.family("xui_inbound_traffic_bytes_total", "counter", "Traffic of the inbound since its last reset.")
+	for _, inbound := range inbounds {
+		id := strconv.Itoa(inbound.Id)
+		m.sample("xui_inbound_traffic_bytes_total", float64(inbound.Up), "inbound_id", id, "tag", inbound.Tag, "direction", "up")
+		m.sample("xui_inbound_traffic_bytes_total", float64(inbound.Down), "inbound_id", id, "tag", inbound.Tag, "direction", "down")
+	}
+	m.family("xui_inbound_online_clients", "gauge", "Clients of the inbound connected now.")
+	for _, inbound := range inbounds {
+		count := 0
+		for _, stat := range inbound.ClientStats {
+			if online[stat.Email] {
+				count++
+			}
+		}
+		m.sample("xui_inbound_online_clients", float64(count), "inbound_id", strconv.Itoa(inbound.Id), "tag", inbound.Tag)
+	}
+	m.family("xui_online_clients", "gauge", "Clients connected now.")
+	m.sample("xui_online_clients", float64(len(online)))
+
+	m.family("xui_outbound_traffic_bytes_total", "counter", "Traffic of the outbound since its last reset.")
+	for _, outbound := range outbounds {
+		m.sample("xui_outbound_traffic_bytes_total", float64(outbound.Up), "tag", outbound.Tag, "direction", "up")
+		m.sample("xui_outbound_traffic_bytes_total", float64(outbound.Down), "tag", outbound.Tag, "direction", "down")
+	}
+
+	m.family("xui_client_traffic_bytes_total", "counter", "Traffic of the client since its last reset.")
+	for _, inbound := range inbounds {
+		id := strconv.Itoa(inbound.Id)
+		for _, stat := range inbound.ClientStats {
+			m.sample("xui_client_traffic_bytes_total", float64(stat.Up), "email", stat.Email, "inbound_id", id, "direction", "up")
+			m.sample("xui_client_traffic_bytes_total", float64(stat.Down), "email", stat.Email, "inbound_id", id, "direction", "down")
+		}
+	}
+	m.family("xui_client_enabled", "gauge", "Whether the client has traffic and time left.")
+	for _, inbound := range inbounds {
+		id := strconv.Itoa(inbound.Id)
+		for _, stat := range inbound.ClientStats {
+			m.sample("xui_client_enabled", boolValue(stat.Enable), "email", stat.Email, "inbound_id", id)
+		}
+	}
+	m.family("xui_client_online", "gauge", "Whether the client is connected now.")
+	for _, inbound := range inbounds {
+		id := strconv.Itoa(inbound.Id)
+		for _, stat := range inbound.ClientStats {
+			m.sample("xui_client_online", boolValue(online[stat.Email]), "email", stat.Email, "inbound_id", id)
+		}
+	}
+	return nil
+}
+
+// writeJobs writes the runs and durations of the background jobs.
+func (s *MetricsService) writeJobs(m *metricWriter) {
+	jobStats.Lock()
+	names := make([]string, 0, len(jobStats.byName))
+	stats := make(map[string]JobStat, len(jobStats.byName))
+	for name, stat := range jobStats.byName {
+		names = append(names, name)
+		stats[name] = *stat
+	}
+	jobStats.Unlock()
+	slices.Sort(names)
+
+	m.family("xui_job_runs_total", "counter", "Runs of the background job.")
+	for _, name := range names {
+		m.sample("xui_job_runs_total", float64(stats[name].Runs), "job", name)
+	}
+	m.family("xui_job_duration_seconds_total", "counter", "Time all runs of the background job took.")
+	for _, name := range names {
+		m.sample("xui_job_duration_seconds_total", stats[name].Seconds, "job", name)
+	}
+	m.family("xui_job_last_duration_seconds", "gauge", "Time the last run of the background job took.")
+	for _, name := range names {
+		m.sample("xui_job_last_duration_seconds", stats[name].LastSeconds, "job", name)
+	}
+}
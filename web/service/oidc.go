@@ -0,0 +1,289 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/mhsanaei/3x-ui/v2/database/model"
+	"github.com/mhsanaei/3x-ui/v2/logger"
+	"github.com/mhsanaei/3x-ui/v2/util/common"
+	"github.com/mhsanaei/3x-ui/v2/util/random"
+
+	"github.com/coreos/go-oidc/v3/oidc"
+	"golang.org/x/oauth2"
+)
+
+// OIDCLogin holds the values of a pending single sign-on login that must be kept
+// between the redirect to the identity provider and the callback.
+type OIDCLogin struct {
+	State    string
+	Verifier string // PKCE code verifier
+	Nonce    string
+}
+
+// oidcConfig holds the OpenID Connect settings.
+type oidcConfig struct {
+	issuer        string
+	clientId      string
+	clientSecret  string
+	redirectUrl   string
+	scopes        []string
+	usernameClaim string
+	groupsClaim   string
+	roleMapping   map[string]model.Role
+	defaultRole   model.Role
+	autoProvision bool
+}
+
+// oidcProviders caches discovered providers by issuer URL.
+var oidcProviders = struct {
+	sync.Mutex
+	m map[string]*oidc.Provider
+}{m: make(map[string]*oidc.Provider)}
+
+// roleRank orders roles from most to least privileged.
+var roleRank = []model.Role{model.RoleOwner, model.RoleOperator, model.RoleReseller, model.RoleSupport, model.RoleViewer}
+
+// OIDCService implements panel login through an OpenID Connect identity provider
+// using the authorization code flow with PKCE.
+type OIDCService struct {
+	settingService SettingService
+	userService    UserService
+}
+
+// IsEnabled reports whether single sign-on is turned on.
+func (s *OIDCService) IsEnabled() bool {
+	enable, err := s.settingService.GetOidcEnable()
+	return err == nil && enable
+}
+
+// BeginLogin returns the identity provider URL to send the browser to and the values
+// to keep until the callback. redirectUrl is used unless one is configured.
+func (s *OIDCService) BeginLogin(ctx context.Context, redirectUrl string) (string, *OIDCLogin, error) {
+	cfg, err := s.getConfig()
+	if err != nil {
+		return "", nil, err
+	}
+	oauthConfig, _, err := s.oauth2Config(ctx, cfg, redirectUrl)
+	if err != nil {
+		return "", nil, err
+	}
+	login := &OIDCLogin{
+		State:    random.Seq(32),
+		Verifier: oauth2.GenerateVerifier(),
+		Nonce:    random.Seq(32),
+	}
+	authUrl := oauthConfig.AuthCodeURL(login.State, oauth2.S256ChallengeOption(login.Verifier), oidc.Nonce(login.Nonce))
+	return authUrl, login, nil
+}
+
+// FinishLogin completes a login started by BeginLogin. It exchanges code for tokens,
+// verifies the ID token and returns the local panel user linked to its issuer and
+// subject, with the role its groups map to, creating the user when auto-provisioning
+// is enabled. When linkUserId is set the identity is linked to that user instead, so
+// a logged-in user can sign in through the identity provider from then on.
+func (s *OIDCService) FinishLogin(ctx context.Context, redirectUrl string, login *OIDCLogin, state string, code string, linkUserId int) (*model.User, error) {
+	if login == nil || login.State == "" || state != login.State {
+		return nil, errors.New("invalid or expired login state")
+	}
+	if code == "" {
+		return nil, errors.New("missing authorization code")
+	}
+	cfg, err := s.getConfig()
+	if err != nil {
+		return nil, err
+	}
+	oauthConfig, provider, err := s.oauth2Config(ctx, cfg, redirectUrl)
+	if err != nil {
+		return nil, err
+	}
+
+	token, err := oauthConfig.Exchange(ctx, code, oauth2.VerifierOption(login.Verifier))
+	if err != nil {
+		return nil, common.NewError("token exchange failed:", err)
+	}
+	rawIdToken, ok := token.Extra("id_token").(string)
+	if !ok || rawIdToken == "" {
+		return nil, errors.New("identity provider returned no id_token")
+	}
+	idToken, err := provider.Verifier(&oidc.Config{ClientID: cfg.clientId}).Verify(ctx, rawIdToken)
+	if err != nil {
+		return nil, common.NewError("invalid id_token:", err)
+	}
+	if idToken.Nonce != login.Nonce {
+		return nil, errors.New("id_token nonce mismatch")
+	}
+
+	claims := map[string]any{}
+	if err := idToken.Claims(&claims); err != nil {
+		return nil, err
+	}
+	// Some providers only release profile and group claims through the userinfo endpoint.
+	if claims[cfg.usernameClaim] == nil || (cfg.groupsClaim != "" && claims[cfg.groupsClaim] == nil) {
+		if userInfo, err := provider.UserInfo(ctx, oauthConfig.TokenSource(ctx, token)); err == nil {
+			extra := map[string]any{}
+			if userInfo.Claims(&extra) == nil {
+				for key, value := range extra {
+					if _, ok := claims[key]; !ok {
+						claims[key] = value
+					}
+				}
+			}
+		}
+	}
+
+	username := claimString(claims[cfg.usernameClaim])
+	if username == "" {
+		return nil, common.NewErrorf("claim %q is missing from the identity", cfg.usernameClaim)
+	}
+	groups := claimStrings(claims[cfg.groupsClaim])
+	role, mapped := resolveRole(cfg.roleMapping, groups)
+	if !mapped {
+		if cfg.defaultRole == "" {
+			return nil, common.NewError("user is not in a group allowed to use the panel:", username)
+		}
+		role = cfg.defaultRole
+	}
+	if linkUserId > 0 {
+		return s.userService.LinkOIDCUser(linkUserId, idToken.Issuer, idToken.Subject)
+	}
+	return s.userService.provisionOIDCUser(idToken.Issuer, idToken.Subject, username, role, cfg.autoProvision)
+}
+
+// getConfig reads and validates the OpenID Connect settings.
+func (s *OIDCService) getConfig() (*oidcConfig, error) {
+	if !s.IsEnabled() {
+		return nil, errors.New("single sign-on is disabled")
+	}
+	cfg := &oidcConfig{}
+	cfg.issuer, _ = s.settingService.GetOidcIssuer()
+	cfg.clientId, _ = s.settingService.GetOidcClientId()
+	cfg.clientSecret, _ = s.settingService.GetOidcClientSecret()
+	cfg.redirectUrl, _ = s.settingService.GetOidcRedirectUrl()
+	scopes, _ := s.settingService.GetOidcScopes()
+	cfg.usernameClaim, _ = s.settingService.GetOidcUsernameClaim()
+	cfg.groupsClaim, _ = s.settingService.GetOidcGroupsClaim()
+	mapping, _ := s.settingService.GetOidcRoleMapping()
+	defaultRole, _ := s.settingService.GetOidcDefaultRole()
+	cfg.autoProvision, _ = s.settingService.GetOidcAutoProvision()
+
+	if cfg.issuer == "" || cfg.clientId == "" {
+		return nil, errors.New("OIDC issuer and client id are required")
+	}
+	cfg.scopes = strings.Fields(scopes)
+	if !slices.Contains(cfg.scopes, oidc.ScopeOpenID) {
+		cfg.scopes = append([]string{oidc.ScopeOpenID}, cfg.scopes...)
+	}
+	if cfg.usernameClaim == "" {
+		cfg.usernameClaim = "preferred_username"
+	}
+	var err error
+	if cfg.roleMapping, err = parseRoleMapping(mapping); err != nil {
+		return nil, err
+	}
+	cfg.defaultRole = model.Role(strings.TrimSpace(defaultRole))
+	if cfg.defaultRole != "" && !cfg.defaultRole.IsValid() {
+		return nil, common.NewError("invalid OIDC default role:", cfg.defaultRole)
+	}
+	return cfg, nil
+}
+
+// oauth2Config discovers the provider and builds the OAuth2 client configuration.
+func (s *OIDCService) oauth2Config(ctx context.Context, cfg *oidcConfig, redirectUrl string) (*oauth2.Config, *oidc.Provider, error) {
+	provider, err := getOIDCProvider(ctx, cfg.issuer)
+	if err != nil {
+		return nil, nil, err
+	}
+	if cfg.redirectUrl != "" {
+		redirectUrl = cfg.redirectUrl
+	}
+	return &oauth2.Config{
+		ClientID:     cfg.clientId,
+		ClientSecret: cfg.clientSecret,
+		RedirectURL:  redirectUrl,
+		Endpoint:     provider.Endpoint(),
+		Scopes:       cfg.scopes,
+	}, provider, nil
+}
+
+// getOIDCProvider returns the cached provider for issuer, running discovery on first use.
+func getOIDCProvider(ctx context.Context, issuer string) (*oidc.Provider, error) {
+	oidcProviders.Lock()
+	defer oidcProviders.Unlock()
+	if provider, ok := oidcProviders.m[issuer]; ok {
+		return provider, nil
+	}
+	// Discovery keys are fetched lazily by the provider, so it must outlive this request.
+	provider, err := oidc.NewProvider(context.WithoutCancel(ctx), issuer)
+	if err != nil {
+		logger.Warning("OIDC discovery failed:", err)
+		return nil, common.NewError("OIDC discovery failed:", err)
+	}
+	oidcProviders.m[issuer] = provider
+	return provider, nil
+}
+
+// parseRoleMapping parses "group=role" pairs separated by commas.
+func parseRoleMapping(value string) (map[string]model.Role, error) {
+	mapping := make(map[string]model.Role)
+	for _, pair := range strings.Split(value, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		group, role, ok := strings.Cut(pair, "=")
+		group = strings.TrimSpace(group)
+		r := model.Role(strings.TrimSpace(role))
+		if !ok || group == "" || !r.IsValid() {
+			return nil, common.NewError("invalid role mapping:", pair)
+		}
+		mapping[group] = r
+	}
+	return mapping, nil
+}
+
+// resolveRole returns the most privileged role mapped from groups and whether any group matched.
+func resolveRole(mapping map[string]model.Role, groups []string) (model.Role, bool) {
+	best := -1
+	for _, group := range groups {
+		role, ok := mapping[group]
+		if !ok {
+			continue
+		}
+		for i, ranked := range roleRank {
+			if ranked == role && (best < 0 || i < best) {
+				best = i
+			}
+		}
+	}
+	if best < 0 {
+		return "", false
+	}
+	return roleRank[best], true
+}
+
+// claimString returns a claim value as a string, or "" if it is not one.
+func claimString(value any) string {
+	s, _ := value.(string)
+	return strings.TrimSpace(s)
+}
+
+// claimStrings returns a claim that is either a string or a list of strings.
+func claimStrings(value any) []string {
+	switch v := value.(type) {
+	case string:
+		return []string{v}
+	case []any:
+		result := make([]string, 0, len(v))
+		for _, item := range v {
+			if s, ok := item.(string); ok {
+				result = append(result, s)
+			}
+		}
+		return result
+	}
+	return nil
+}
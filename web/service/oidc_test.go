@@ -0,0 +1,299 @@
+package service
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mhsanaei/3x-ui/v2/database"
+	"github.com/mhsanaei/3x-ui/v2/database/model"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testRedirectUrl = "http://panel.test/oidc/callback"
+
+// mockGrant is an authorization code the mock identity provider handed out.
+type mockGrant struct {
+	challenge string
+	nonce     string
+	claims    map[string]any
+}
+
+// mockIdP is an OpenID Connect provider serving discovery, keys and the token endpoint.
+type mockIdP struct {
+	server *httptest.Server
+	key    *rsa.PrivateKey
+	mu     sync.Mutex
+	grants map[string]mockGrant
+}
+
+func newMockIdP(t *testing.T) *mockIdP {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	idp := &mockIdP{key: key, grants: map[string]mockGrant{}}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		issuer := idp.server.URL
+		json.NewEncoder(w).Encode(map[string]any{
+			"issuer":                                issuer,
+			"authorization_endpoint":                issuer + "/authorize",
+			"token_endpoint":                        issuer + "/token",
+			"jwks_uri":                              issuer + "/jwks",
+			"id_token_signing_alg_values_supported": []string{"RS256"},
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]any{"keys": []map[string]string{{
+			"kty": "RSA", "alg": "RS256", "use": "sig", "kid": "test",
+			"n": base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			"e": base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		}}})
+	})
+	mux.HandleFunc("/token", idp.token)
+	idp.server = httptest.NewServer(mux)
+	t.Cleanup(idp.server.Close)
+	return idp
+}
+
+// authorize plays the user signing in at the provider with claims, and returns the
+// state and code the provider redirects back with.
+func (idp *mockIdP) authorize(t *testing.T, authUrl string, claims map[string]any) (string, string) {
+	t.Helper()
+	parsed, err := url.Parse(authUrl)
+	require.NoError(t, err)
+	query := parsed.Query()
+	require.Equal(t, "S256", query.Get("code_challenge_method"))
+	require.NotEmpty(t, query.Get("code_challenge"))
+	require.NotEmpty(t, query.Get("nonce"))
+	code := base64.RawURLEncoding.EncodeToString([]byte(time.Now().String()))
+	idp.mu.Lock()
+	idp.grants[code] = mockGrant{challenge: query.Get("code_challenge"), nonce: query.Get("nonce"), claims: claims}
+	idp.mu.Unlock()
+	return query.Get("state"), code
+}
+
+// token redeems a code once, checking the PKCE verifier against its challenge.
+func (idp *mockIdP) token(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+	idp.mu.Lock()
+	grant, ok := idp.grants[r.PostForm.Get("code")]
+	delete(idp.grants, r.PostForm.Get("code"))
+	idp.mu.Unlock()
+	sum := sha256.Sum256([]byte(r.PostForm.Get("code_verifier")))
+	if !ok || base64.RawURLEncoding.EncodeToString(sum[:]) != grant.challenge {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(`{"error":"invalid_grant"}`))
+		return
+	}
+	claims := map[string]any{
+		"iss":   idp.server.URL,
+		"aud":   "panel",
+		"iat":   time.Now().Unix(),
+		"exp":   time.Now().Add(time.Hour).Unix(),
+		"nonce": grant.nonce,
+	}
+	for key, value := range grant.claims {
+		claims[key] = value
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{
+		"access_token": "access",
+		"token_type":   "Bearer",
+		"expires_in":   3600,
+		"id_token":     idp.sign(claims),
+	})
+}
+
+// sign returns claims as an RS256 JWT.
+func (idp *mockIdP) sign(claims map[string]any) string {
+	header, _ := json.Marshal(map[string]string{"alg": "RS256", "typ": "JWT", "kid": "test"})
+	payload, _ := json.Marshal(claims)
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	sum := sha256.Sum256([]byte(signed))
+	signature, _ := rsa.SignPKCS1v15(rand.Reader, idp.key, crypto.SHA256, sum[:])
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+// setupOIDC starts a mock provider and points the OpenID Connect settings at it.
+func setupOIDC(t *testing.T, defaultRole string) *mockIdP {
+	idp := newMockIdP(t)
+	setupTestDB(t, map[string]string{
+		"oidcEnable":        "true",
+		"oidcIssuer":        idp.server.URL,
+		"oidcClientId":      "panel",
+		"oidcGroupsClaim":   "groups",
+		"oidcRoleMapping":   "admins=owner,ops=operator,helpdesk=support",
+		"oidcDefaultRole":   defaultRole,
+		"oidcAutoProvision": "true",
+	})
+	return idp
+}
+
+// signIn runs a whole single sign-on login of the identity with claims.
+func signIn(t *testing.T, idp *mockIdP, claims map[string]any, linkUserId int) (*model.User, error) {
+	t.Helper()
+	s := &OIDCService{}
+	ctx := context.Background()
+	authUrl, login, err := s.BeginLogin(ctx, testRedirectUrl)
+	require.NoError(t, err)
+	state, code := idp.authorize(t, authUrl, claims)
+	return s.FinishLogin(ctx, testRedirectUrl, login, state, code, linkUserId)
+}
+
+func identity(subject string, username string, groups ...string) map[string]any {
+	return map[string]any{"sub": subject, "preferred_username": username, "groups": groups}
+}
+
+func TestOIDCLoginProvisionsAndMapsRole(t *testing.T) {
+	idp := setupOIDC(t, "")
+
+	user, err := signIn(t, idp, identity("sub-1", "alice", "ops", "helpdesk"), 0)
+	require.NoError(t, err)
+	assert.Equal(t, "alice", user.Username)
+	assert.Equal(t, model.RoleOperator, user.Role)
+	assert.Equal(t, idp.server.URL, user.OidcIssuer)
+	assert.Equal(t, "sub-1", user.OidcSubject)
+
+	// The groups decide the role on every login, and a rename at the provider is harmless
+	user, err = signIn(t, idp, identity("sub-1", "alice-renamed", "helpdesk"), 0)
+	require.NoError(t, err)
+	assert.Equal(t, "alice", user.Username)
+	assert.Equal(t, model.RoleSupport, user.Role)
+}
+
+func TestOIDCLoginChecksStateNonceAndVerifier(t *testing.T) {
+	idp := setupOIDC(t, "")
+	s := &OIDCService{}
+	ctx := context.Background()
+
+	tests := []struct {
+		name   string
+		tamper func(login *OIDCLogin, state *string)
+		err    string
+	}{
+		{"state", func(login *OIDCLogin, state *string) { *state = "forged" }, "invalid or expired login state"},
+		{"missing login", func(login *OIDCLogin, state *string) { *login = OIDCLogin{} }, "invalid or expired login state"},
+		{"nonce", func(login *OIDCLogin, state *string) { login.Nonce = "replayed" }, "nonce mismatch"},
+		{"verifier", func(login *OIDCLogin, state *string) { login.Verifier = "stolen-code-without-verifier" }, "token exchange failed"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			authUrl, login, err := s.BeginLogin(ctx, testRedirectUrl)
+			require.NoError(t, err)
+			state, code := idp.authorize(t, authUrl, identity("sub-2", "bob", "ops"))
+			test.tamper(login, &state)
+			_, err = s.FinishLogin(ctx, testRedirectUrl, login, state, code, 0)
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), test.err)
+		})
+	}
+}
+
+func TestOIDCLoginDeniesTakeoverAndUnmappedUsers(t *testing.T) {
+	idp := setupOIDC(t, "")
+
+	// A provider account named like the local owner does not become the owner
+	_, err := signIn(t, idp, identity("attacker", "admin", "ops"), 0)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "not linked")
+	admin := &model.User{}
+	require.NoError(t, database.GetDB().Where("username = ?", "admin").First(admin).Error)
+	assert.Equal(t, model.RoleOwner, admin.Role)
+	assert.Empty(t, admin.OidcSubject)
+
+	_, err = signIn(t, idp, identity("sub-3", "carol", "unknown"), 0)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "not in a group allowed")
+}
+
+func TestOIDCLinkAndLastOwner(t *testing.T) {
+	idp := setupOIDC(t, "viewer")
+	admin := &model.User{}
+	require.NoError(t, database.GetDB().Where("username = ?", "admin").First(admin).Error)
+
+	user, err := signIn(t, idp, identity("owner-sub", "someone", "admins"), admin.Id)
+	require.NoError(t, err)
+	assert.Equal(t, admin.Id, user.Id)
+
+	user, err = signIn(t, idp, identity("owner-sub", "someone", "admins"), 0)
+	require.NoError(t, err)
+	assert.Equal(t, admin.Id, user.Id)
+	assert.Equal(t, model.RoleOwner, user.Role)
+
+	// The last owner mapped to a lower role, even by the default role, is denied
+	for _, groups := range [][]string{{"ops"}, {"unknown"}} {
+		_, err = signIn(t, idp, identity("owner-sub", "someone", groups...), 0)
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "at least one owner")
+	}
+	require.NoError(t, database.GetDB().First(admin, admin.Id).Error)
+	assert.Equal(t, model.RoleOwner, admin.Role)
+
+	// An identity can not be linked to a second user
+	other, err := (&UserService{}).AddUser("other", "password", model.RoleViewer)
+	require.NoError(t, err)
+	_, err = signIn(t, idp, identity("owner-sub", "someone", "admins"), other.Id)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "already linked")
+}
+
+func TestParseRoleMapping(t *testing.T) {
+	tests := []struct {
+		value string
+		want  map[string]model.Role
+		err   bool
+	}{
+		{"", map[string]model.Role{}, false},
+		{"admins=owner", map[string]model.Role{"admins": model.RoleOwner}, false},
+		{" admins = owner , ops=operator,", map[string]model.Role{"admins": model.RoleOwner, "ops": model.RoleOperator}, false},
+		{"cn=Panel Admins,ou=groups=owner", nil, true},
+		{"admins", nil, true},
+		{"=owner", nil, true},
+		{"admins=root", nil, true},
+	}
+	for _, test := range tests {
+		got, err := parseRoleMapping(test.value)
+		if test.err {
+			assert.Error(t, err, test.value)
+			continue
+		}
+		require.NoError(t, err, test.value)
+		assert.Equal(t, test.want, got, test.value)
+	}
+}
+
+func TestResolveRole(t *testing.T) {
+	mapping := map[string]model.Role{"admins": model.RoleOwner, "ops": model.RoleOperator, "help": model.RoleSupport}
+	tests := []struct {
+		groups []string
+		role   model.Role
+		mapped bool
+	}{
+		{nil, "", false},
+		{[]string{"unknown"}, "", false},
+		{[]string{"help"}, model.RoleSupport, true},
+		{[]string{"help", "ops", "unknown"}, model.RoleOperator, true},
+		{[]string{"ops", "admins"}, model.RoleOwner, true},
+	}
+	for _, test := range tests {
+		role, mapped := resolveRole(mapping, test.groups)
+		assert.Equal(t, test.role, role, strings.Join(test.groups, ","))
+		assert.Equal(t, test.mapped, mapped, strings.Join(test.groups, ","))
+	}
+}
@@ -0,0 +1,306 @@
+package service
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mhsanaei/3x-ui/v2/database"
+	"github.com/mhsanaei/3x-ui/v2/database/model"
+	"github.com/mhsanaei/3x-ui/v2/util/common"
+	"github.com/mhsanaei/3x-ui/v2/xray"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// PlanService manages service plans. Renewals go through InboundService so they are
+// audited and reach Xray.
+type PlanService struct{}
+
+// GetPlans returns all plans ordered by name.
+func (s *PlanService) GetPlans() ([]model.Plan, error) {
+	var plans []model.Plan
+	err := database.GetDB().Order("name").Find(&plans).Error
+	return plans, err
+}
+
+// GetInboundPlans returns the plans clients of inbound may subscribe to.
+func (s *PlanService) GetInboundPlans(inboundId int) ([]model.Plan, error) {
+	plans, err := s.GetPlans()
+	if err != nil {
+		return nil, err
+	}
+	allowed := make([]model.Plan, 0, len(plans))
+	for _, plan := range plans {
+		if plan.Allows(inboundId) {
+			allowed = append(allowed, plan)
+		}
+	}
+	return allowed, nil
+}
+
+// GetPlan returns the plan with id.
+func (s *PlanService) GetPlan(id int) (*model.Plan, error) {
+	plan := &model.Plan{}
+	err := database.GetDB().Where("id = ?", id).Limit(1).Find(plan).Error
+	if err != nil {
+		return nil, err
+	}
+	if plan.Id == 0 {
+		return nil, common.NewError("Plan Not Found:", id)
+	}
+	return plan, nil
+}
+
+// AddPlan creates plan under a name no other plan has.
+func (s *PlanService) AddPlan(plan *model.Plan) error {
+	plan.Id = 0
+	if err := s.checkPlan(plan); err != nil {
+		return err
+	}
+	return database.GetDB().Create(plan).Error
+}
+
+// UpdatePlan changes plan. Clients on it get the new limits on their next renewal.
+func (s *PlanService) UpdatePlan(plan *model.Plan) error {
+	old, err := s.GetPlan(plan.Id)
+	if err != nil {
+		return err
+	}
+	if err := s.checkPlan(plan); err != nil {
+		return err
+	}
+	plan.CreatedAt = old.CreatedAt
+	return database.GetDB().Save(plan).Error
+}
+
+// checkPlan normalizes plan and rejects empty or taken names, negative limits and
+// unknown inbounds.
+func (s *PlanService) checkPlan(plan *model.Plan) error {
+	plan.Name = strings.TrimSpace(plan.Name)
+	if plan.Name == "" {
+		return common.NewError("plan name can not be empty")
+	}
+	if plan.TotalGB < 0 || plan.Days < 0 || plan.LimitIP < 0 || plan.Reset < 0 {
+		return common.NewError("plan limits can not be negative")
+	}
+	switch plan.TrafficMode {
+	case "":
+		plan.TrafficMode = model.PlanTrafficReset
+	case model.PlanTrafficReset, model.PlanTrafficAdd:
+	default:
+		return common.NewError("unknown traffic mode:", plan.TrafficMode)
+	}
+
+	db := database.GetDB()
+	ids := plan.InboundIdList()
+	if len(ids) > 0 {
+		var count int64
+		if err := db.Model(model.Inbound{}).Where("id IN ?", ids).Count(&count).Error; err != nil {
+			return err
+		}
+		if int(count) != len(ids) {
+			return common.NewError("unknown inbound in plan:", plan.InboundIds)
+		}
+		fields := make([]string, 0, len(ids))
+		for _, id := range ids {
+			fields = append(fields, strconv.Itoa(id))
+		}
+		plan.InboundIds = strings.Join(fields, ",")
+	} else {
+		plan.InboundIds = ""
+	}
+
+	var count int64
+	err := db.Model(model.Plan{}).Where("name = ? AND id <> ?", plan.Name, plan.Id).Count(&count).Error
+	if err != nil {
+		return err
+	}
+	if count > 0 {
+		return common.NewError("Duplicate plan name:", plan.Name)
+	}
+	return nil
+}
+
+// DelPlan deletes a plan. Its clients keep their limits and no longer have a plan.
+func (s *PlanService) DelPlan(id int) error {
+	return database.GetDB().Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(model.Client{}).Where("plan_id = ?", id).Update("plan_id", 0).Error; err != nil {
+			return err
+		}
+		return tx.Delete(&model.Plan{}, id).Error
+	})
+}
+
+// GetRenewals returns the renewals of the client with email, newest first.
+func (s *PlanService) GetRenewals(email string) ([]model.ClientRenewal, error) {
+	var renewals []model.ClientRenewal
+	err := database.GetDB().Where("client_email = ?", email).Order("id DESC").Find(&renewals).Error
+	return renewals, err
+}
+
+// RenewClient subscribes the client with email to a plan, or renews its current plan
+// when planId is 0, in one transaction: the client takes the IP limit and reset cycle
+// of the plan, its expiry moves by the plan duration, if any, from now or from a later
+// expiry, its traffic is reset or topped up with the plan quota, and it is enabled
+// again. The renewal is recorded in the history of the client. Resellers may only renew
+// with plans that add traffic. It also reports whether Xray needs a restart because the
+// API failed.
+func (s *InboundService) RenewClient(email string, planId int) (*model.ClientRenewal, bool, error) {
+	db := database.GetDB()
+	client := &model.Client{}
+	if err := db.Where("email = ?", email).Order("record_id").Limit(1).Find(client).Error; err != nil {
+		return nil, false, err
+	}
+	if client.RecordId == 0 {
+		return nil, false, common.NewError("Client Not Found For Email:", email)
+	}
+	if planId == 0 {
+		planId = client.PlanId
+	}
+	if planId == 0 {
+		return nil, false, common.NewError("client has no plan:", email)
+	}
+	plan, err := (&PlanService{}).GetPlan(planId)
+	if err != nil {
+		return nil, false, err
+	}
+	if !plan.Allows(client.InboundId) {
+		return nil, false, common.NewErrorf("plan %s is not offered on inbound %d", plan.Name, client.InboundId)
+	}
+	if plan.ResetsTraffic() {
+		// Like the traffic resets, renewals that reset the used traffic would let resellers
+		// hand out traffic beyond their quota
+		user, err := s.scopedUser()
+		if err != nil {
+			return nil, false, err
+		}
+		if user != nil {
+			return nil, false, common.NewErrorf("plan %s resets the traffic and can only be renewed by an admin", plan.Name)
+		}
+	}
+	inbound, err := s.GetInbound(client.InboundId)
+	if err != nil {
+		return nil, false, err
+	}
+
+	var renewal *model.ClientRenewal
+	var before, after map[int]model.Client
+	err = db.Transaction(func(tx *gorm.DB) error {
+		// The traffic job keeps adding to the counters and admins may edit the client
+		// meanwhile, so both are read again under a row lock and renewed as they are now.
+		locked := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Session(&gorm.Session{})
+		if err := locked.Where("record_id = ?", client.RecordId).Limit(1).Find(client).Error; err != nil {
+			return err
+		}
+		if client.RecordId == 0 || client.Email != email {
+			return common.NewError("Client Not Found For Email:", email)
+		}
+		traffic := &xray.ClientTraffic{}
+		if err := locked.Where("email = ?", email).Limit(1).Find(traffic).Error; err != nil {
+			return err
+		}
+
+		renewed := *client
+		renewal = renewPlan(&renewed, traffic, plan, time.Now().UnixMilli())
+		if err := s.checkResellerQuota(inbound, []model.Client{renewed}, client); err != nil {
+			return err
+		}
+
+		ids := []int{client.RecordId}
+		var err error
+		if before, err = servedClients(tx, ids); err != nil {
+			return err
+		}
+		err = tx.Model(model.Client{}).Where("record_id = ?", client.RecordId).Updates(map[string]any{
+			"plan_id":     renewed.PlanId,
+			"limit_ip":    renewed.LimitIP,
+			"reset":       renewed.Reset,
+			"total_gb":    renewed.TotalGB,
+			"expiry_time": renewed.ExpiryTime,
+			"enable":      renewed.Enable,
+			"updated_at":  renewed.UpdatedAt,
+		}).Error
+		if err != nil {
+			return err
+		}
+		if traffic.Id != 0 {
+			updates := map[string]any{
+				"enable":      true,
+				"total":       renewed.TotalGB,
+				"expiry_time": renewed.ExpiryTime,
+				"reset":       renewed.Reset,
+			}
+			// The counters are only written when the plan resets them
+			if plan.ResetsTraffic() {
+				updates["up"] = 0
+				updates["down"] = 0
+			}
+			err = tx.Model(xray.ClientTraffic{}).Where("id = ?", traffic.Id).Updates(updates).Error
+		} else {
+			err = s.AddClientStat(tx, client.InboundId, &renewed)
+		}
+		if err != nil {
+			return err
+		}
+		if err := tx.Create(renewal).Error; err != nil {
+			return err
+		}
+		if after, err = servedClients(tx, ids); err != nil {
+			return err
+		}
+		s.audit(tx, "client.renew", client.InboundId, email, client, renewed)
+		return nil
+	})
+	if err != nil {
+		return nil, false, err
+	}
+	return renewal, s.applyServedClients(before, after), nil
+}
+
+// renewPlan applies plan to client and traffic at now and returns the renewal record.
+func renewPlan(client *model.Client, traffic *xray.ClientTraffic, plan *model.Plan, now int64) *model.ClientRenewal {
+	used := traffic.Up + traffic.Down
+	renewal := &model.ClientRenewal{
+		ClientEmail:  client.Email,
+		PlanId:       plan.Id,
+		PlanName:     plan.Name,
+		ExpiryBefore: client.ExpiryTime,
+		TotalBefore:  client.TotalGB,
+		UsedBefore:   used,
+	}
+
+	client.PlanId = plan.Id
+	client.LimitIP = plan.LimitIP
+	client.Reset = plan.Reset
+	client.Enable = true
+	client.UpdatedAt = now
+
+	// Durations that start on first use and used up expiries start over from now
+	if plan.Days > 0 {
+		client.ExpiryTime = max(client.ExpiryTime, now) + int64(plan.Days)*86400000
+	}
+
+	switch {
+	case plan.TotalGB == 0:
+		client.TotalGB = 0
+	case plan.TrafficMode == model.PlanTrafficAdd:
+		// Unlimited or overused clients get the quota on top of what they used
+		base := client.TotalGB
+		if base == 0 || base < used {
+			base = used
+		}
+		client.TotalGB = base + plan.TotalGB
+	default:
+		client.TotalGB = plan.TotalGB
+	}
+	if plan.ResetsTraffic() {
+		traffic.Up = 0
+		traffic.Down = 0
+	}
+
+	renewal.ExpiryAfter = client.ExpiryTime
+	renewal.TotalAfter = client.TotalGB
+	return renewal
+}
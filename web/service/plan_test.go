@@ -0,0 +1,212 @@
+package service
+
+import (
+	"testing"
+	"time"
+
+	"github.com/mhsanaei/3x-ui/v2/database"
+	"github.com/mhsanaei/3x-ui/v2/database/model"
+	"github.com/mhsanaei/3x-ui/v2/xray"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRenewPlan(t *testing.T) {
+	const now = int64(1_000_000_000_000)
+	const day = int64(86400000)
+	tests := []struct {
+		name             string
+		client           model.Client
+		up, down         int64
+		plan             model.Plan
+		expiry, total    int64
+		wantUp, wantDown int64
+	}{
+		{
+			name:   "reset from expired",
+			client: model.Client{ExpiryTime: now - day, TotalGB: 100},
+			up:     60, down: 40,
+			plan:   model.Plan{Days: 30, TotalGB: 500, TrafficMode: model.PlanTrafficReset},
+			expiry: now + 30*day, total: 500,
+		},
+		{
+			name:   "reset extends a later expiry",
+			client: model.Client{ExpiryTime: now + 5*day, TotalGB: 100},
+			up:     10,
+			plan:   model.Plan{Days: 30, TotalGB: 500},
+			expiry: now + 35*day, total: 500,
+		},
+		{
+			name:   "no duration keeps the expiry",
+			client: model.Client{ExpiryTime: now + 5*day},
+			plan:   model.Plan{TotalGB: 500, TrafficMode: model.PlanTrafficReset},
+			expiry: now + 5*day, total: 500,
+		},
+		{
+			name:   "add on top of the limit",
+			client: model.Client{TotalGB: 100},
+			up:     30, down: 20,
+			plan:  model.Plan{TotalGB: 500, TrafficMode: model.PlanTrafficAdd},
+			total: 600, wantUp: 30, wantDown: 20,
+		},
+		{
+			name:   "add on top of overuse",
+			client: model.Client{TotalGB: 100},
+			up:     100, down: 50,
+			plan:  model.Plan{TotalGB: 500, TrafficMode: model.PlanTrafficAdd},
+			total: 650, wantUp: 100, wantDown: 50,
+		},
+		{
+			name:   "add to unlimited",
+			client: model.Client{},
+			up:     70,
+			plan:   model.Plan{TotalGB: 500, TrafficMode: model.PlanTrafficAdd},
+			total:  570, wantUp: 70,
+		},
+		{
+			name:   "unlimited plan",
+			client: model.Client{TotalGB: 100},
+			up:     70,
+			plan:   model.Plan{TrafficMode: model.PlanTrafficAdd},
+			total:  0, wantUp: 70,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			client := test.client
+			client.Email = "alice"
+			test.plan.Id, test.plan.Name, test.plan.LimitIP, test.plan.Reset = 7, "gold", 3, 30
+			traffic := &xray.ClientTraffic{Up: test.up, Down: test.down}
+			renewal := renewPlan(&client, traffic, &test.plan, now)
+
+			assert.Equal(t, test.expiry, client.ExpiryTime)
+			assert.Equal(t, test.total, client.TotalGB)
+			assert.Equal(t, test.wantUp, traffic.Up)
+			assert.Equal(t, test.wantDown, traffic.Down)
+			assert.Equal(t, 7, client.PlanId)
+			assert.Equal(t, 3, client.LimitIP)
+			assert.Equal(t, 30, client.Reset)
+			assert.True(t, client.Enable)
+			assert.Equal(t, model.ClientRenewal{
+				ClientEmail: "alice", PlanId: 7, PlanName: "gold",
+				ExpiryBefore: test.client.ExpiryTime, ExpiryAfter: test.expiry,
+				TotalBefore: test.client.TotalGB, TotalAfter: test.total,
+				UsedBefore: test.up + test.down,
+			}, *renewal)
+		})
+	}
+}
+
+func TestRenewClient(t *testing.T) {
+	setupTestDB(t, nil)
+	db := database.GetDB()
+	inbound := addTestInbound(t)
+	addTestClient(t, inbound.Id, model.Client{Email: "adder", Enable: true, TotalGB: 100}, xray.ClientTraffic{Enable: true, Total: 100, Up: 30, Down: 20})
+	addTestClient(t, inbound.Id, model.Client{Email: "resetter", Enable: true, TotalGB: 100}, xray.ClientTraffic{Enable: true, Total: 100, Up: 30, Down: 20})
+	add := &model.Plan{Name: "add", TotalGB: 500, TrafficMode: model.PlanTrafficAdd}
+	reset := &model.Plan{Name: "reset", TotalGB: 500, TrafficMode: model.PlanTrafficReset}
+	require.NoError(t, (&PlanService{}).AddPlan(add))
+	require.NoError(t, (&PlanService{}).AddPlan(reset))
+
+	s := &InboundService{}
+	_, _, err := s.RenewClient("adder", 0)
+	assert.Error(t, err, "client without a plan")
+
+	renewal, _, err := s.RenewClient("adder", add.Id)
+	require.NoError(t, err)
+	assert.Equal(t, int64(600), renewal.TotalAfter)
+	traffic := &xray.ClientTraffic{}
+	require.NoError(t, db.Where("email = ?", "adder").First(traffic).Error)
+	assert.Equal(t, int64(30), traffic.Up)
+	assert.Equal(t, int64(20), traffic.Down)
+	assert.Equal(t, int64(600), traffic.Total)
+
+	// Renewing again uses the plan the client is on now
+	renewal, _, err = s.RenewClient("adder", 0)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1100), renewal.TotalAfter)
+
+	_, _, err = s.RenewClient("resetter", reset.Id)
+	require.NoError(t, err)
+	traffic = &xray.ClientTraffic{}
+	require.NoError(t, db.Where("email = ?", "resetter").First(traffic).Error)
+	assert.Zero(t, traffic.Up)
+	assert.Zero(t, traffic.Down)
+	assert.Equal(t, int64(500), traffic.Total)
+
+	renewals, err := (&PlanService{}).GetRenewals("adder")
+	require.NoError(t, err)
+	assert.Len(t, renewals, 2)
+}
+
+func TestRenewClientAsReseller(t *testing.T) {
+	setupTestDB(t, nil)
+	db := database.GetDB()
+	reseller, err := (&UserService{}).AddUser("reseller", "password", model.RoleReseller)
+	require.NoError(t, err)
+	require.NoError(t, (&ResellerService{}).SetQuota(&model.ResellerQuota{UserId: reseller.Id, TotalGB: 1000}))
+	own := addTestInbound(t)
+	require.NoError(t, db.Model(own).Update("user_id", reseller.Id).Error)
+	other := addTestInbound(t)
+	addTestClient(t, own.Id, model.Client{Email: "alice", Enable: true, TotalGB: 100}, xray.ClientTraffic{Enable: true, Total: 100, Up: 60, Down: 40})
+	addTestClient(t, other.Id, model.Client{Email: "bob", Enable: true, TotalGB: 100}, xray.ClientTraffic{Enable: true, Total: 100})
+	add := &model.Plan{Name: "add", TotalGB: 500, TrafficMode: model.PlanTrafficAdd}
+	reset := &model.Plan{Name: "reset", TotalGB: 100, TrafficMode: model.PlanTrafficReset}
+	require.NoError(t, (&PlanService{}).AddPlan(add))
+	require.NoError(t, (&PlanService{}).AddPlan(reset))
+	s := (&InboundService{}).WithActor(&AuditActor{Type: model.AuditActorUser, UserId: reseller.Id})
+
+	// A reset would hand out the used traffic again on every renewal
+	_, _, err = s.RenewClient("alice", reset.Id)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "resets the traffic")
+	traffic := &xray.ClientTraffic{}
+	require.NoError(t, db.Where("email = ?", "alice").First(traffic).Error)
+	assert.Equal(t, int64(60), traffic.Up)
+	assert.Equal(t, int64(40), traffic.Down)
+
+	_, _, err = s.RenewClient("bob", add.Id)
+	assert.Error(t, err, "inbound of someone else")
+
+	renewal, _, err := s.RenewClient("alice", add.Id)
+	require.NoError(t, err)
+	assert.Equal(t, int64(600), renewal.TotalAfter)
+	_, _, err = s.RenewClient("alice", add.Id)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "traffic quota exceeded")
+
+	// Admins may still reset
+	_, _, err = (&InboundService{}).RenewClient("alice", reset.Id)
+	require.NoError(t, err)
+	require.NoError(t, db.Where("email = ?", "alice").First(traffic).Error)
+	assert.Zero(t, traffic.Up)
+}
+
+func TestGroupActionsKeepCounters(t *testing.T) {
+	setupTestDB(t, nil)
+	db := database.GetDB()
+	inbound := addTestInbound(t)
+	expiry := time.Now().AddDate(0, 0, 10).UnixMilli()
+	client := addTestClient(t, inbound.Id, model.Client{Email: "alice", Enable: true, ExpiryTime: expiry}, xray.ClientTraffic{Enable: true, ExpiryTime: expiry, Up: 30, Down: 20})
+	addTestClient(t, inbound.Id, model.Client{Email: "bob", Enable: true}, xray.ClientTraffic{Enable: true})
+	group := &model.ClientGroup{Name: "customers"}
+	require.NoError(t, db.Create(group).Error)
+	require.NoError(t, db.Create(&model.ClientGroupMember{GroupId: group.Id, ClientId: client.RecordId}).Error)
+
+	s := &InboundService{}
+	count, _, err := s.ExtendGroupExpiry(group.Id, 30)
+	require.NoError(t, err)
+	assert.Equal(t, 1, count)
+	traffic := &xray.ClientTraffic{}
+	require.NoError(t, db.Where("email = ?", "alice").First(traffic).Error)
+	assert.Equal(t, int64(30), traffic.Up)
+	assert.Equal(t, int64(20), traffic.Down)
+	assert.Equal(t, expiry+30*86400000, traffic.ExpiryTime)
+
+	_, _, err = s.ResetGroupTraffic(group.Id)
+	require.NoError(t, err)
+	require.NoError(t, db.Where("email = ?", "alice").First(traffic).Error)
+	assert.Zero(t, traffic.Up)
+	assert.Zero(t, traffic.Down)
+}
@@ -0,0 +1,387 @@
+package service
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/mhsanaei/3x-ui/v2/database"
+	"github.com/mhsanaei/3x-ui/v2/database/model"
+	"github.com/mhsanaei/3x-ui/v2/logger"
+	"github.com/mhsanaei/3x-ui/v2/util/common"
+	"github.com/mhsanaei/3x-ui/v2/xray"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// recycledRows is the snapshot kept in a recycle bin entry.
+type recycledRows struct {
+	Inbound   *model.Inbound           `json:"inbound,omitempty"` // Settings without the client list
+	UserId    int                      `json:"userId,omitempty"`  // Owner of the inbound, left out of its JSON
+	Clients   []model.Client           `json:"clients"`
+	Traffics  []xray.ClientTraffic     `json:"traffics"`
+	ClientIps []model.InboundClientIps `json:"clientIps"`
+}
+
+// RecycleBinService reads the recycle bin. Restoring and purging go through
+// InboundService so they are audited and reach Xray.
+type RecycleBinService struct{}
+
+// GetItems returns the recycle bin entries, newest first, or only the ones of kind.
+func (s *RecycleBinService) GetItems(kind string) ([]model.RecycledItem, error) {
+	db := database.GetDB().Model(model.RecycledItem{})
+	if kind != "" {
+		db = db.Where("kind = ?", kind)
+	}
+	var items []model.RecycledItem
+	err := db.Order("id DESC").Find(&items).Error
+	return items, err
+}
+
+// GetItem returns the recycle bin entry with id.
+func (s *RecycleBinService) GetItem(id int) (*model.RecycledItem, error) {
+	item, _, err := s.getItem(database.GetDB(), id)
+	return item, err
+}
+
+// getItem returns the recycle bin entry with id and its snapshot.
+func (s *RecycleBinService) getItem(db *gorm.DB, id int) (*model.RecycledItem, *recycledRows, error) {
+	item := &model.RecycledItem{}
+	if err := db.Where("id = ?", id).Limit(1).Find(item).Error; err != nil {
+		return nil, nil, err
+	}
+	if item.Id == 0 {
+		return nil, nil, common.NewError("Recycle bin item not found:", id)
+	}
+	rows := &recycledRows{}
+	if err := json.Unmarshal([]byte(item.Data), rows); err != nil {
+		return nil, nil, err
+	}
+	return item, rows, nil
+}
+
+// recycleBinEnabled reports whether deleted clients and inbounds are kept.
+func recycleBinEnabled() (bool, error) {
+	days, err := (&SettingService{}).GetRecycleBinDays()
+	return days > 0, err
+}
+
+// recycle stores rows in the recycle bin as an entry of kind.
+func recycle(tx *gorm.DB, kind string, inboundId int, email string, remark string, rows *recycledRows) error {
+	data, err := json.Marshal(rows)
+	if err != nil {
+		return err
+	}
+	return tx.Create(&model.RecycledItem{
+		Kind:      kind,
+		InboundId: inboundId,
+		Email:     email,
+		Remark:    remark,
+		Data:      string(data),
+		DeletedAt: time.Now().UnixMilli(),
+	}).Error
+}
+
+// recycleClients puts clients that are about to be deleted into the recycle bin, one
+// entry each, with their traffic and IP rows. Nothing is kept when the bin is disabled.
+func recycleClients(tx *gorm.DB, clients []model.Client) error {
+	if enabled, err := recycleBinEnabled(); err != nil || !enabled || len(clients) == 0 {
+		return err
+	}
+	emails := make([]string, 0, len(clients))
+	inboundIds := make([]int, 0, len(clients))
+	for _, client := range clients {
+		emails = append(emails, client.Email)
+		inboundIds = append(inboundIds, client.InboundId)
+	}
+	var inbounds []model.Inbound
+	if err := tx.Select("id", "remark").Where("id IN ?", inboundIds).Find(&inbounds).Error; err != nil {
+		return err
+	}
+	remarks := make(map[int]string, len(inbounds))
+	for _, inbound := range inbounds {
+		remarks[inbound.Id] = inbound.Remark
+	}
+	traffics, ips, err := recycledStats(tx, emails)
+	if err != nil {
+		return err
+	}
+	for _, client := range clients {
+		rows := &recycledRows{Clients: []model.Client{client}}
+		if traffic, ok := traffics[client.Email]; ok {
+			rows.Traffics = []xray.ClientTraffic{traffic}
+		}
+		if clientIps, ok := ips[client.Email]; ok {
+			rows.ClientIps = []model.InboundClientIps{clientIps}
+		}
+		if err := recycle(tx, model.RecycledClient, client.InboundId, client.Email, remarks[client.InboundId], rows); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// recycleInbound puts an inbound that is about to be deleted into the recycle bin with
+// its clients and their traffic and IP rows. Nothing is kept when the bin is disabled.
+func recycleInbound(tx *gorm.DB, inbound *model.Inbound) error {
+	if enabled, err := recycleBinEnabled(); err != nil || !enabled {
+		return err
+	}
+	var clients []model.Client
+	if err := tx.Where("inbound_id = ?", inbound.Id).Order("record_id").Find(&clients).Error; err != nil {
+		return err
+	}
+	emails := make([]string, 0, len(clients))
+	for _, client := range clients {
+		emails = append(emails, client.Email)
+	}
+	traffics, ips, err := recycledStats(tx, emails)
+	if err != nil {
+		return err
+	}
+
+	snapshot := *inbound
+	snapshot.ClientStats = nil
+	if err := setSettingsClients(&snapshot, nil); err != nil {
+		return err
+	}
+	rows := &recycledRows{Inbound: &snapshot, UserId: inbound.UserId, Clients: clients}
+	for _, client := range clients {
+		if traffic, ok := traffics[client.Email]; ok {
+			rows.Traffics = append(rows.Traffics, traffic)
+		}
+		if clientIps, ok := ips[client.Email]; ok {
+			rows.ClientIps = append(rows.ClientIps, clientIps)
+		}
+	}
+	return recycle(tx, model.RecycledInbound, inbound.Id, "", inbound.Remark, rows)
+}
+
+// recycledStats returns the traffic and IP rows of emails by email.
+func recycledStats(tx *gorm.DB, emails []string) (map[string]xray.ClientTraffic, map[string]model.InboundClientIps, error) {
+	traffics := map[string]xray.ClientTraffic{}
+	ips := map[string]model.InboundClientIps{}
+	if len(emails) == 0 {
+		return traffics, ips, nil
+	}
+	var trafficRows []xray.ClientTraffic
+	if err := tx.Where("email IN ?", emails).Find(&trafficRows).Error; err != nil {
+		return nil, nil, err
+	}
+	for _, traffic := range trafficRows {
+		traffics[traffic.Email] = traffic
+	}
+	var ipRows []model.InboundClientIps
+	if err := tx.Where("client_email IN ?", emails).Find(&ipRows).Error; err != nil {
+		return nil, nil, err
+	}
+	for _, clientIps := range ipRows {
+		ips[clientIps.ClientEmail] = clientIps
+	}
+	return traffics, ips, nil
+}
+
+// RestoreRecycledItem brings a recycled client back into its inbound, or a recycled
+// inbound back with its clients, including their traffic counters, and removes the
+// entry from the recycle bin. It reports whether Xray needs a restart because the API
+// failed.
+func (s *InboundService) RestoreRecycledItem(id int) (*model.RecycledItem, bool, error) {
+	db := database.GetDB()
+	item, rows, err := (&RecycleBinService{}).getItem(db, id)
+	if err != nil {
+		return nil, false, err
+	}
+	existEmail, err := s.checkEmailsExistForClients(rows.Clients)
+	if err != nil {
+		return nil, false, err
+	}
+	if existEmail != "" {
+		return nil, false, common.NewError("Duplicate email:", existEmail)
+	}
+	if item.Kind == model.RecycledInbound {
+		needRestart, err := s.restoreInbound(item, rows)
+		return item, needRestart, err
+	}
+
+	var count int64
+	if err := db.Model(model.Inbound{}).Where("id = ?", item.InboundId).Count(&count).Error; err != nil {
+		return nil, false, err
+	}
+	if count == 0 {
+		return nil, false, common.NewErrorf("inbound %d of the client no longer exists, restore it first", item.InboundId)
+	}
+	var after map[int]model.Client
+	err = db.Transaction(func(tx *gorm.DB) error {
+		if err := restoreClientRows(tx, item.InboundId, rows); err != nil {
+			return err
+		}
+		if err := tx.Delete(item).Error; err != nil {
+			return err
+		}
+		ids := make([]int, 0, len(rows.Clients))
+		for _, client := range rows.Clients {
+			ids = append(ids, client.RecordId)
+		}
+		if after, err = servedClients(tx, ids); err != nil {
+			return err
+		}
+		s.audit(tx, "client.restore", item.InboundId, item.Email, nil, rows.Clients)
+		return nil
+	})
+	if err != nil {
+		return nil, false, err
+	}
+	return item, s.applyServedClients(nil, after), nil
+}
+
+// restoreInbound recreates a recycled inbound under its old id, unless it was taken,
+// with its clients, and adds it to Xray if it is enabled.
+func (s *InboundService) restoreInbound(item *model.RecycledItem, rows *recycledRows) (bool, error) {
+	inbound := rows.Inbound
+	if inbound == nil {
+		return false, common.NewError("recycle bin item has no inbound:", item.Id)
+	}
+	exist, err := s.checkPortExist(inbound.Listen, inbound.Port, 0)
+	if err != nil {
+		return false, err
+	}
+	if exist {
+		return false, common.NewError("Port already exists:", inbound.Port)
+	}
+	db := database.GetDB()
+	var count int64
+	if err := db.Model(model.Inbound{}).Where("tag = ?", inbound.Tag).Count(&count).Error; err != nil {
+		return false, err
+	}
+	if count > 0 {
+		return false, common.NewError("Duplicate tag:", inbound.Tag)
+	}
+	if err := db.Model(model.Inbound{}).Where("id = ?", inbound.Id).Count(&count).Error; err != nil {
+		return false, err
+	}
+	if count > 0 {
+		inbound.Id = 0
+	}
+	inbound.UserId = rows.UserId
+
+	err = db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(inbound).Error; err != nil {
+			return err
+		}
+		if err := restoreClientRows(tx, inbound.Id, rows); err != nil {
+			return err
+		}
+		if err := tx.Delete(item).Error; err != nil {
+			return err
+		}
+		s.audit(tx, "inbound.restore", inbound.Id, "", nil, inbound)
+		return nil
+	})
+	if err != nil {
+		return false, err
+	}
+	if !inbound.Enable {
+		return false, nil
+	}
+
+	restored, err := s.GetInbound(inbound.Id)
+	if err != nil {
+		return false, err
+	}
+	inboundConfig, err := xrayInboundConfig(db, restored)
+	if err != nil {
+		return false, err
+	}
+	inboundJson, err := json.MarshalIndent(inboundConfig, "", "  ")
+	if err != nil {
+		return false, err
+	}
+	s.xrayApi.Init(p.GetAPIPort())
+	defer s.xrayApi.Close()
+	if err := s.xrayApi.AddInbound(inboundJson); err != nil {
+		logger.Debug("Unable to add inbound by api:", err)
+		return true, nil
+	}
+	logger.Debug("Restored inbound added by api:", restored.Tag)
+	return false, nil
+}
+
+// restoreClientRows recreates the clients in rows on inboundId with their traffic and
+// IP rows. Clients get new record ids, stored back into rows.
+func restoreClientRows(tx *gorm.DB, inboundId int, rows *recycledRows) error {
+	traffics := make(map[string]xray.ClientTraffic, len(rows.Traffics))
+	for _, traffic := range rows.Traffics {
+		traffics[traffic.Email] = traffic
+	}
+	for i := range rows.Clients {
+		client := &rows.Clients[i]
+		client.RecordId = 0
+		client.InboundId = inboundId
+		// A leftover row of the email would block the counters of the client, which
+		// go in before the client that references them
+		if err := tx.Where("email = ?", client.Email).Delete(xray.ClientTraffic{}).Error; err != nil {
+			return err
+		}
+		traffic, ok := traffics[client.Email]
+		if !ok {
+			traffic = xray.ClientTraffic{
+				Email:      client.Email,
+				Enable:     true,
+				Total:      client.TotalGB,
+				ExpiryTime: client.ExpiryTime,
+				Reset:      client.Reset,
+			}
+		}
+		traffic.Id = 0
+		traffic.InboundId = inboundId
+		if err := tx.Create(&traffic).Error; err != nil {
+			return err
+		}
+		if err := tx.Create(client).Error; err != nil {
+			return err
+		}
+	}
+	for _, clientIps := range rows.ClientIps {
+		clientIps.Id = 0
+		if err := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&clientIps).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// PurgeRecycledItem deletes a recycle bin entry for good.
+func (s *InboundService) PurgeRecycledItem(id int) error {
+	return database.GetDB().Transaction(func(tx *gorm.DB) error {
+		item, _, err := (&RecycleBinService{}).getItem(tx, id)
+		if err != nil {
+			return err
+		}
+		if err := tx.Delete(item).Error; err != nil {
+			return err
+		}
+		s.audit(tx, "recycleBin.purge", item.InboundId, item.Email, item, nil)
+		return nil
+	})
+}
+
+// PurgeRecycleBin deletes recycle bin entries for good: all of them, or the ones older
+// than the "recycleBinDays" setting when expired is set. It returns how many it deleted.
+func (s *InboundService) PurgeRecycleBin(expired bool) (int64, error) {
+	db := database.GetDB().Where("1 = 1")
+	if expired {
+		days, err := (&SettingService{}).GetRecycleBinDays()
+		if err != nil {
+			return 0, err
+		}
+		// Entries kept while the bin was enabled go as soon as it is disabled
+		cutoff := time.Now().AddDate(0, 0, -days).UnixMilli()
+		db = db.Where("deleted_at < ?", cutoff)
+	}
+	result := db.Delete(&model.RecycledItem{})
+	if result.Error != nil || result.RowsAffected == 0 {
+		return 0, result.Error
+	}
+	s.audit(nil, "recycleBin.purgeAll", 0, "", nil, map[string]any{"expired": expired, "count": result.RowsAffected})
+	return result.RowsAffected, nil
+}
@@ -0,0 +1,256 @@
+package service
+
+import (
+	"errors"
+	"time"
+
+	"github.com/mhsanaei/3x-ui/v2/database"
+	"github.com/mhsanaei/3x-ui/v2/database/model"
+	"github.com/mhsanaei/3x-ui/v2/util/common"
+	"github.com/mhsanaei/3x-ui/v2/xray"
+)
+
+// ResellerUsage summarizes what a reseller handed out against their quota.
+type ResellerUsage struct {
+	UserId    int                  `json:"userId"`
+	Username  string               `json:"username"`
+	Quota     *model.ResellerQuota `json:"quota"`
+	Inbounds  int                  `json:"inbounds"`  // Inbounds assigned to the reseller
+	Clients   int                  `json:"clients"`   // Clients across those inbounds
+	TotalGB   int64                `json:"totalGB"`   // Traffic in bytes allocated to clients with a limit
+	Unlimited int                  `json:"unlimited"` // Clients without a traffic limit
+	Up        int64                `json:"up"`        // Upload traffic used by the clients
+	Down      int64                `json:"down"`      // Download traffic used by the clients
+}
+
+// ResellerService manages reseller quotas and reports their usage. Resellers only
+// see the inbounds whose UserId is theirs; the quota itself is enforced by
+// InboundService when clients are added or updated. Traffic resets are closed to
+// resellers, as they would hand out traffic beyond the quota.
+type ResellerService struct {
+	userService UserService
+}
+
+// GetResellers returns the usage of every reseller.
+func (s *ResellerService) GetResellers() ([]*ResellerUsage, error) {
+	var ids []int
+	err := database.GetDB().Model(model.User{}).Where("role = ?", model.RoleReseller).Order("id").Pluck("id", &ids).Error
+	if err != nil {
+		return nil, err
+	}
+	result := make([]*ResellerUsage, 0, len(ids))
+	for _, id := range ids {
+		usage, err := s.GetUsage(id)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, usage)
+	}
+	return result, nil
+}
+
+// GetQuota returns the quota of a reseller, or an unlimited one if none was set.
+func (s *ResellerService) GetQuota(userId int) (*model.ResellerQuota, error) {
+	quota := &model.ResellerQuota{UserId: userId}
+	err := database.GetDB().Where("user_id = ?", userId).Limit(1).Find(quota).Error
+	if err != nil {
+		return nil, err
+	}
+	return quota, nil
+}
+
+// SetQuota creates or replaces the quota of a reseller. Clients handed out before
+// a lower quota are kept, but no new ones can be added until usage drops below it.
+func (s *ResellerService) SetQuota(quota *model.ResellerQuota) error {
+	if quota.MaxClients < 0 || quota.TotalGB < 0 || quota.MaxExpiryDays < 0 {
+		return errors.New("quota values can not be negative")
+	}
+	user, err := s.userService.GetUserById(quota.UserId)
+	if err != nil {
+		return err
+	}
+	if user.Role != model.RoleReseller {
+		return common.NewError("user is not a reseller:", user.Username)
+	}
+	current, err := s.GetQuota(quota.UserId)
+	if err != nil {
+		return err
+	}
+	quota.Id = current.Id
+	return database.GetDB().Save(quota).Error
+}
+
+// GetUsage returns the clients and traffic a reseller handed out across their inbounds.
+func (s *ResellerService) GetUsage(userId int) (*ResellerUsage, error) {
+	user, err := s.userService.GetUserById(userId)
+	if err != nil {
+		return nil, err
+	}
+	quota, err := s.GetQuota(userId)
+	if err != nil {
+		return nil, err
+	}
+	usage := &ResellerUsage{UserId: user.Id, Username: user.Username, Quota: quota}
+	inboundService := InboundService{}
+	inbounds, err := inboundService.GetInbounds(userId)
+	if err != nil {
+		return nil, err
+	}
+	usage.Inbounds = len(inbounds)
+	for _, inbound := range inbounds {
+		clients, err := inboundService.GetClients(inbound)
+		if err != nil {
+			return nil, err
+		}
+		usage.Clients += len(clients)
+		for _, client := range clients {
+			if client.TotalGB > 0 {
+				usage.TotalGB += client.TotalGB
+			} else {
+				usage.Unlimited++
+			}
+		}
+		for _, stat := range inbound.ClientStats {
+			usage.Up += stat.Up
+			usage.Down += stat.Down
+		}
+	}
+	return usage, nil
+}
+
+// SetInboundUser assigns an inbound to a panel user. Assigning it to a reseller
+// delegates the inbound and its clients to them.
+func (s *InboundService) SetInboundUser(inboundId int, userId int) error {
+	inbound, err := s.GetInbound(inboundId)
+	if err != nil {
+		return err
+	}
+	if _, err := (&UserService{}).GetUserById(userId); err != nil {
+		return err
+	}
+	err = database.GetDB().Model(model.Inbound{}).Where("id = ?", inboundId).Update("user_id", userId).Error
+	if err == nil {
+		s.audit(nil, "inbound.assign", inboundId, "", map[string]any{"userId": inbound.UserId}, map[string]any{"userId": userId})
+	}
+	return err
+}
+
+// scopedUser returns the acting panel user when their role limits them to their own
+// inbounds, or nil when the actor may manage every inbound.
+func (s *InboundService) scopedUser() (*model.User, error) {
+	if s.actor == nil || s.actor.UserId == 0 {
+		return nil, nil
+	}
+	user, err := (&UserService{}).GetUserById(s.actor.UserId)
+	if err != nil {
+		return nil, err
+	}
+	if !user.Role.IsScoped() {
+		return nil, nil
+	}
+	return user, nil
+}
+
+// checkResellerQuota rejects client changes on inbound that a reseller acting through
+// this service is not allowed to make: the inbound must be theirs and the clients must
+// fit into their quota. added are the new clients; replaced is the client being
+// updated, whose allocation is given back, or nil when clients are added.
+func (s *InboundService) checkResellerQuota(inbound *model.Inbound, added []model.Client, replaced *model.Client) error {
+	user, err := s.scopedUser()
+	if err != nil || user == nil {
+		return err
+	}
+	if inbound.UserId != user.Id {
+		return common.NewError("inbound is not assigned to you:", inbound.Id)
+	}
+	quota, err := (&ResellerService{}).GetQuota(user.Id)
+	if err != nil {
+		return err
+	}
+
+	if quota.MaxExpiryDays > 0 {
+		horizon := (time.Duration(quota.MaxExpiryDays) * 24 * time.Hour).Milliseconds()
+		for _, client := range added {
+			if replaced != nil && client.ExpiryTime == replaced.ExpiryTime {
+				continue
+			}
+			switch {
+			case client.ExpiryTime == 0:
+				return common.NewErrorf("clients must expire within %d days", quota.MaxExpiryDays)
+			case client.ExpiryTime > 0 && client.ExpiryTime > time.Now().UnixMilli()+horizon:
+				return common.NewErrorf("expiry of %s is more than %d days away", client.Email, quota.MaxExpiryDays)
+			case client.ExpiryTime < 0 && -client.ExpiryTime > horizon:
+				// Negative values are a duration in milliseconds that starts on first use.
+				return common.NewErrorf("expiry of %s is more than %d days away", client.Email, quota.MaxExpiryDays)
+			}
+		}
+	}
+	if quota.MaxClients == 0 && quota.TotalGB == 0 {
+		return nil
+	}
+
+	usage, err := (&ResellerService{}).GetUsage(user.Id)
+	if err != nil {
+		return err
+	}
+	if replaced == nil && quota.MaxClients > 0 && usage.Clients+len(added) > quota.MaxClients {
+		return common.NewErrorf("client quota exceeded: %d of %d clients in use", usage.Clients, quota.MaxClients)
+	}
+	if quota.TotalGB > 0 {
+		allocated := usage.TotalGB
+		if replaced != nil {
+			allocated -= replaced.TotalGB
+		}
+		for _, client := range added {
+			if client.TotalGB <= 0 && (replaced == nil || replaced.TotalGB > 0) {
+				return errors.New("clients need a traffic limit within your traffic quota")
+			}
+			if client.Reset > 0 && (replaced == nil || replaced.Reset != client.Reset) {
+				return errors.New("clients with a traffic quota can not reset their traffic automatically")
+			}
+			allocated += client.TotalGB
+		}
+		// Updates that do not raise the allocation stay possible after the quota was lowered.
+		if allocated > quota.TotalGB && allocated > usage.TotalGB {
+			return common.NewErrorf("traffic quota exceeded: %s of %s allocated",
+				common.FormatTraffic(usage.TotalGB), common.FormatTraffic(quota.TotalGB))
+		}
+	}
+	return nil
+}
+
+// CheckInboundAccess returns an error when the actor is limited to their own inbounds
+// and inboundId is not one of them.
+func (s *InboundService) CheckInboundAccess(inboundId int) error {
+	user, err := s.scopedUser()
+	if err != nil || user == nil {
+		return err
+	}
+	var count int64
+	err = database.GetDB().Model(model.Inbound{}).Where("id = ? AND user_id = ?", inboundId, user.Id).Count(&count).Error
+	if err != nil {
+		return err
+	}
+	if count == 0 {
+		return common.NewError("inbound is not assigned to you:", inboundId)
+	}
+	return nil
+}
+
+// CheckClientAccess returns an error when the actor is limited to their own inbounds
+// and the client with email does not belong to one of them.
+func (s *InboundService) CheckClientAccess(email string) error {
+	user, err := s.scopedUser()
+	if err != nil || user == nil {
+		return err
+	}
+	traffic := &xray.ClientTraffic{}
+	err = database.GetDB().Model(xray.ClientTraffic{}).Where("email = ?", email).First(traffic).Error
+	if err != nil && !database.IsNotFound(err) {
+		return err
+	}
+	if err != nil || s.CheckInboundAccess(traffic.InboundId) != nil {
+		return common.NewError("client is not assigned to you:", email)
+	}
+	return nil
+}
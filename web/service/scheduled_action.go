@@ -0,0 +1,272 @@
+package service
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/mhsanaei/3x-ui/v2/database"
+	"github.com/mhsanaei/3x-ui/v2/database/model"
+	"github.com/mhsanaei/3x-ui/v2/logger"
+	"github.com/mhsanaei/3x-ui/v2/util/common"
+
+	"gorm.io/gorm"
+)
+
+// ScheduledActionService reads scheduled actions. Changes and runs go through
+// InboundService so they are audited and reach Xray.
+type ScheduledActionService struct{}
+
+// GetActions returns the scheduled actions with status, or all of them if it is empty,
+// the next to run first.
+func (s *ScheduledActionService) GetActions(status string) ([]model.ScheduledAction, error) {
+	db := database.GetDB().Model(model.ScheduledAction{})
+	if status != "" {
+		db = db.Where("status = ?", status)
+	}
+	var actions []model.ScheduledAction
+	err := db.Order("run_at").Order("id").Find(&actions).Error
+	return actions, err
+}
+
+// GetAction returns the scheduled action with id.
+func (s *ScheduledActionService) GetAction(id int) (*model.ScheduledAction, error) {
+	action := &model.ScheduledAction{}
+	if err := database.GetDB().Where("id = ?", id).Limit(1).Find(action).Error; err != nil {
+		return nil, err
+	}
+	if action.Id == 0 {
+		return nil, common.NewError("Scheduled action not found:", id)
+	}
+	return action, nil
+}
+
+// checkScheduledAction validates action and normalises its target.
+func (s *InboundService) checkScheduledAction(action *model.ScheduledAction) error {
+	switch action.TargetType {
+	case model.ScheduleTargetClient:
+		action.Email = strings.TrimSpace(action.Email)
+		if action.Email == "" {
+			return common.NewError("client email can not be empty")
+		}
+		client := &model.Client{}
+		if err := database.GetDB().Where("email = ?", action.Email).Limit(1).Find(client).Error; err != nil {
+			return err
+		}
+		if client.RecordId == 0 {
+			return common.NewError("Client Not Found For Email:", action.Email)
+		}
+		action.InboundId = client.InboundId
+	case model.ScheduleTargetInbound:
+		inbound, err := s.GetInbound(action.InboundId)
+		if err != nil {
+			return err
+		}
+		action.Email = ""
+		if action.Operation != model.ScheduleEnable && action.Operation != model.ScheduleDisable &&
+			!inbound.Protocol.HasClients() {
+			return common.NewErrorf("inbound %d has no clients", inbound.Id)
+		}
+	default:
+		return common.NewError("unknown target type:", action.TargetType)
+	}
+
+	switch action.Operation {
+	case model.ScheduleEnable, model.ScheduleDisable, model.ScheduleResetTraffic:
+		action.Value = 0
+	case model.ScheduleSetLimitIP:
+		if action.Value < 0 {
+			return common.NewError("IP limit can not be negative")
+		}
+	case model.ScheduleAddTraffic:
+		if action.Value <= 0 {
+			return common.NewError("traffic must be positive")
+		}
+	case model.ScheduleExtendExpiry:
+		if action.Value <= 0 {
+			return common.NewError("days must be positive")
+		}
+	default:
+		return common.NewError("unknown operation:", action.Operation)
+	}
+	if action.RunAt <= 0 {
+		return common.NewError("run time can not be empty")
+	}
+	return nil
+}
+
+// AddScheduledAction schedules action. A run time in the past makes it run on the
+// next check.
+func (s *InboundService) AddScheduledAction(action *model.ScheduledAction) error {
+	if err := s.checkScheduledAction(action); err != nil {
+		return err
+	}
+	action.Id = 0
+	action.Status = model.SchedulePending
+	action.Result = ""
+	action.ExecutedAt = 0
+	return database.GetDB().Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(action).Error; err != nil {
+			return err
+		}
+		s.audit(tx, "schedule.add", action.InboundId, action.Email, nil, action)
+		return nil
+	})
+}
+
+// CancelScheduledAction keeps the pending action with id from running.
+func (s *InboundService) CancelScheduledAction(id int) error {
+	return database.GetDB().Transaction(func(tx *gorm.DB) error {
+		result := tx.Model(model.ScheduledAction{}).
+			Where("id = ? AND status = ?", id, model.SchedulePending).
+			Update("status", model.ScheduleCanceled)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return common.NewError("no pending scheduled action:", id)
+		}
+		s.audit(tx, "schedule.cancel", 0, "", nil, map[string]any{"id": id})
+		return nil
+	})
+}
+
+// DelScheduledAction deletes the scheduled action with id, whatever its status.
+func (s *InboundService) DelScheduledAction(id int) error {
+	return database.GetDB().Transaction(func(tx *gorm.DB) error {
+		action := &model.ScheduledAction{}
+		if err := tx.Where("id = ?", id).Limit(1).Find(action).Error; err != nil {
+			return err
+		}
+		if action.Id == 0 {
+			return common.NewError("Scheduled action not found:", id)
+		}
+		if err := tx.Delete(action).Error; err != nil {
+			return err
+		}
+		s.audit(tx, "schedule.delete", action.InboundId, action.Email, action, nil)
+		return nil
+	})
+}
+
+// RunScheduledActions runs the pending actions that are due, oldest first, and records
+// their outcome. Each action is first claimed by moving it from pending to running, so
+// an action canceled meanwhile or claimed by another run is skipped, and one cut off by
+// a panel restart is not run twice. A failed action does not stop the others. It
+// returns the actions it ran and whether Xray needs a restart because the API failed.
+func (s *InboundService) RunScheduledActions() ([]model.ScheduledAction, bool, error) {
+	db := database.GetDB()
+	var due []model.ScheduledAction
+	err := db.Where("status = ? AND run_at <= ?", model.SchedulePending, time.Now().UnixMilli()).
+		Order("run_at").Order("id").Find(&due).Error
+	if err != nil {
+		return nil, false, err
+	}
+
+	ran := make([]model.ScheduledAction, 0, len(due))
+	needRestart := false
+	for i := range due {
+		action := &due[i]
+		claim := db.Model(model.ScheduledAction{}).
+			Where("id = ? AND status = ?", action.Id, model.SchedulePending).
+			Update("status", model.ScheduleRunning)
+		if claim.Error != nil {
+			logger.Warning("claim scheduled action failed:", claim.Error)
+			continue
+		}
+		if claim.RowsAffected == 0 {
+			continue
+		}
+
+		result, restart, err := s.runScheduledAction(action)
+		needRestart = needRestart || restart
+		action.Status = model.ScheduleDone
+		action.Result = result
+		if err != nil {
+			action.Status = model.ScheduleFailed
+			action.Result = strings.TrimSpace(err.Error())
+		}
+		action.ExecutedAt = time.Now().UnixMilli()
+		err = db.Model(model.ScheduledAction{}).Where("id = ?", action.Id).Updates(map[string]any{
+			"status":      action.Status,
+			"result":      action.Result,
+			"executed_at": action.ExecutedAt,
+		}).Error
+		if err != nil {
+			logger.Warning("save scheduled action result failed:", err)
+		}
+		ran = append(ran, *action)
+	}
+	return ran, needRestart, nil
+}
+
+// runScheduledAction applies action to its target. It returns a summary of what
+// changed and whether Xray needs a restart.
+func (s *InboundService) runScheduledAction(action *model.ScheduledAction) (string, bool, error) {
+	if action.TargetType == model.ScheduleTargetInbound &&
+		(action.Operation == model.ScheduleEnable || action.Operation == model.ScheduleDisable) {
+		inbound, err := s.GetInbound(action.InboundId)
+		if err != nil {
+			return "", false, err
+		}
+		enable := action.Operation == model.ScheduleEnable
+		if inbound.Enable == enable {
+			return fmt.Sprintf("inbound %d was already %sd", inbound.Id, action.Operation), false, nil
+		}
+		inbound.Enable = enable
+		_, needRestart, err := s.UpdateInbound(inbound)
+		if err != nil {
+			return "", false, err
+		}
+		return fmt.Sprintf("inbound %d %sd", inbound.Id, action.Operation), needRestart, nil
+	}
+
+	var apply func(tx *gorm.DB, clients []model.Client) error
+	switch action.Operation {
+	case model.ScheduleEnable, model.ScheduleDisable:
+		apply = enableClients(action.Operation == model.ScheduleEnable)
+	case model.ScheduleSetLimitIP:
+		apply = func(tx *gorm.DB, clients []model.Client) error {
+			ids := make([]int, 0, len(clients))
+			for _, client := range clients {
+				ids = append(ids, client.RecordId)
+			}
+			return tx.Model(model.Client{}).Where("record_id IN ?", ids).Updates(map[string]any{
+				"limit_ip":   action.Value,
+				"updated_at": time.Now().UnixMilli(),
+			}).Error
+		}
+	case model.ScheduleAddTraffic:
+		apply = addClientsTraffic(action.Value)
+	case model.ScheduleExtendExpiry:
+		apply = extendClients(int(action.Value))
+	case model.ScheduleResetTraffic:
+		apply = resetClientsTraffic
+	default:
+		return "", false, common.NewError("unknown operation:", action.Operation)
+	}
+
+	count, needRestart, err := s.clientsAction("schedule."+action.Operation,
+		map[string]any{"scheduleId": action.Id, "value": action.Value},
+		func(tx *gorm.DB) ([]model.Client, error) {
+			var clients []model.Client
+			db := tx.Model(model.Client{})
+			if action.TargetType == model.ScheduleTargetClient {
+				db = db.Where("email = ?", action.Email)
+			} else {
+				db = db.Where("inbound_id = ?", action.InboundId)
+			}
+			err := db.Order("record_id").Find(&clients).Error
+			return clients, err
+		}, apply)
+	if err != nil {
+		return "", false, err
+	}
+	if count == 0 {
+		if action.TargetType == model.ScheduleTargetClient {
+			return "", false, common.NewError("Client Not Found For Email:", action.Email)
+		}
+		return "", false, common.NewErrorf("inbound %d has no clients", action.InboundId)
+	}
+	return fmt.Sprintf("%s applied to %d clients", action.Operation, count), needRestart, nil
+}
@@ -0,0 +1,88 @@
+package service
+
+import (
+	"testing"
+	"time"
+
+	"github.com/mhsanaei/3x-ui/v2/database"
+	"github.com/mhsanaei/3x-ui/v2/database/model"
+	"github.com/mhsanaei/3x-ui/v2/xray"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"gorm.io/gorm"
+)
+
+func TestRunScheduledActions(t *testing.T) {
+	setupTestDB(t, nil)
+	db := database.GetDB()
+	inbound := addTestInbound(t)
+	addTestClient(t, inbound.Id, model.Client{Email: "alice", Enable: true}, xray.ClientTraffic{Enable: true})
+
+	s := &InboundService{}
+	now := time.Now().UnixMilli()
+	schedule := func(runAt int64, value int64) *model.ScheduledAction {
+		action := &model.ScheduledAction{
+			TargetType: model.ScheduleTargetClient,
+			Email:      "alice",
+			Operation:  model.ScheduleSetLimitIP,
+			Value:      value,
+			RunAt:      runAt,
+		}
+		require.NoError(t, s.AddScheduledAction(action))
+		return action
+	}
+	due := schedule(now-1000, 2)
+	canceled := schedule(now-1000, 3)
+	canceledLate := schedule(now-1000, 4)
+	later := schedule(now+3600000, 5)
+	require.NoError(t, s.CancelScheduledAction(canceled.Id))
+
+	// Cancel an action after the run loaded the due ones, as an admin could meanwhile
+	err := db.Callback().Query().After("gorm:query").Register("test:cancel", func(tx *gorm.DB) {
+		if tx.Statement.Table == "scheduled_actions" {
+			tx.Session(&gorm.Session{NewDB: true}).Model(model.ScheduledAction{}).
+				Where("id = ?", canceledLate.Id).Update("status", model.ScheduleCanceled)
+		}
+	})
+	require.NoError(t, err)
+	ran, _, err := s.RunScheduledActions()
+	require.NoError(t, err)
+	require.NoError(t, db.Callback().Query().Remove("test:cancel"))
+
+	require.Len(t, ran, 1)
+	assert.Equal(t, due.Id, ran[0].Id)
+	assert.Equal(t, model.ScheduleDone, ran[0].Status)
+
+	status := func(id int) string {
+		action, err := (&ScheduledActionService{}).GetAction(id)
+		require.NoError(t, err)
+		return action.Status
+	}
+	assert.Equal(t, model.ScheduleDone, status(due.Id))
+	assert.Equal(t, model.ScheduleCanceled, status(canceled.Id))
+	assert.Equal(t, model.ScheduleCanceled, status(canceledLate.Id), "canceled after loading, not run")
+	assert.Equal(t, model.SchedulePending, status(later.Id))
+	client := &model.Client{}
+	require.NoError(t, db.Where("email = ?", "alice").First(client).Error)
+	assert.Equal(t, 2, client.LimitIP)
+
+	// An action claimed by another run is left to it
+	require.NoError(t, db.Model(model.ScheduledAction{}).Where("id = ?", later.Id).
+		Updates(map[string]any{"run_at": now - 1000, "status": model.ScheduleRunning}).Error)
+	ran, _, err = s.RunScheduledActions()
+	require.NoError(t, err)
+	assert.Empty(t, ran)
+	assert.Equal(t, model.ScheduleRunning, status(later.Id))
+
+	// Failures are recorded
+	missing := schedule(now-1000, 1)
+	require.NoError(t, db.Model(model.ScheduledAction{}).Where("id = ?", missing.Id).Update("email", "nobody").Error)
+	ran, _, err = s.RunScheduledActions()
+	require.NoError(t, err)
+	require.Len(t, ran, 1)
+	assert.Equal(t, model.ScheduleFailed, ran[0].Status)
+	assert.Contains(t, ran[0].Result, "nobody")
+	assert.Equal(t, model.ScheduleFailed, status(missing.Id))
+}
@@ -414,6 +414,7 @@ func (s *ServerService) GetStatus(lastStatus *Status) *Status {
 		status.AppStats.Uptime = 0
 	}
 
+	latestStatus.Store(status)
 	return status
 }
 
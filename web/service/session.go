@@ -0,0 +1,71 @@
+package service
+
+import (
+	"time"
+
+	"github.com/mhsanaei/3x-ui/v2/database"
+	"github.com/mhsanaei/3x-ui/v2/database/model"
+	"github.com/mhsanaei/3x-ui/v2/util/common"
+
+	"gorm.io/gorm"
+)
+
+// SessionService lists and revokes the server-side login sessions of panel users.
+type SessionService struct{}
+
+// GetSessions returns the active login sessions of userId, or of all users when userId is 0,
+// most recently used first. The session stored under currentKeyHash is flagged as current.
+func (s *SessionService) GetSessions(userId int, currentKeyHash string) ([]*model.Session, error) {
+	db := database.GetDB()
+	var sessions []*model.Session
+	query := db.Model(model.Session{}).
+		Where("user_id > 0 AND expires_at > ?", time.Now().UnixMilli()).
+		Order("last_seen desc")
+	if userId > 0 {
+		query = query.Where("user_id = ?", userId)
+	}
+	if err := query.Find(&sessions).Error; err != nil {
+		return nil, err
+	}
+	for _, session := range sessions {
+		session.Current = session.KeyHash == currentKeyHash
+	}
+	return sessions, nil
+}
+
+// DelSession revokes a session. When userId is not 0 the session must belong to that user.
+func (s *SessionService) DelSession(id int, userId int) error {
+	db := database.GetDB()
+	query := db.Where("id = ?", id)
+	if userId > 0 {
+		query = query.Where("user_id = ?", userId)
+	}
+	result := query.Delete(&model.Session{})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return common.NewError("session not found:", id)
+	}
+	return nil
+}
+
+// DelExpiredSessions deletes the sessions past their expiry and returns how many there were.
+func (s *SessionService) DelExpiredSessions() (int64, error) {
+	result := database.GetDB().Where("expires_at <= ?", time.Now().UnixMilli()).Delete(&model.Session{})
+	return result.RowsAffected, result.Error
+}
+
+// DelUserSessions revokes every session of userId except the one stored under exceptKeyHash.
+func (s *SessionService) DelUserSessions(userId int, exceptKeyHash string) error {
+	return deleteUserSessions(database.GetDB(), userId, exceptKeyHash)
+}
+
+// deleteUserSessions logs userId out everywhere, keeping the session stored under exceptKeyHash if not empty.
+func deleteUserSessions(tx *gorm.DB, userId int, exceptKeyHash string) error {
+	query := tx.Where("user_id = ?", userId)
+	if exceptKeyHash != "" {
+		query = query.Where("key_hash <> ?", exceptKeyHash)
+	}
+	return query.Delete(&model.Session{}).Error
+}
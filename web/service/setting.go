@@ -49,8 +49,22 @@ var defaultValueMap = map[string]string{
 	"tgBotLoginNotify":            "true",
 	"tgCpu":                       "80",
 	"tgLang":                      "en-US",
-	"twoFactorEnable":             "false",
-	"twoFactorToken":              "",
+	"loginLimitEnable":            "true",
+	"loginMaxAttempts":            "5",
+	"loginLockoutSeconds":         "60",
+	"loginLockoutMaxSeconds":      "3600",
+	"loginAttemptWindow":          "900",
+	"ipLimitMode":                 "fail2ban",
+	"ipLimitCooldown":             "300",
+	"recycleBinDays":              "30",
+	"trafficStatsHourlyDays":      "7",
+	"trafficStatsDailyDays":       "365",
+	"metricsEnable":               "false",
+	"metricsListen":               "127.0.0.1",
+	"metricsPort":                 "0",
+	"destinationStatsEnable":      "false",
+	"destinationStatsTopN":        "50",
+	"destinationStatsDays":        "30",
 	"subEnable":                   "true",
 	"subJsonEnable":               "false",
 	"subTitle":                    "",
@@ -102,6 +116,23 @@ var defaultValueMap = map[string]string{
 	"ldapDefaultTotalGB":    "0",
 	"ldapDefaultExpiryDays": "0",
 	"ldapDefaultLimitIP":    "0",
+	"ldapGroupAttr":         "memberOf",
+	"ldapRoleMapping":       "",
+	"ldapDefaultRole":       "",
+	"ldapAutoProvision":     "false",
+
+	// OIDC defaults
+	"oidcEnable":        "false",
+	"oidcIssuer":        "",
+	"oidcClientId":      "",
+	"oidcClientSecret":  "",
+	"oidcRedirectUrl":   "",
+	"oidcScopes":        "openid profile email",
+	"oidcUsernameClaim": "preferred_username",
+	"oidcGroupsClaim":   "groups",
+	"oidcRoleMapping":   "",
+	"oidcDefaultRole":   "",
+	"oidcAutoProvision": "false",
 }
 
 // SettingService provides business logic for application settings management.
@@ -358,20 +389,68 @@ func (s *SettingService) GetTgLang() (string, error) {
 	return s.getString("tgLang")
 }
 
-func (s *SettingService) GetTwoFactorEnable() (bool, error) {
-	return s.getBool("twoFactorEnable")
+func (s *SettingService) GetLoginLimitEnable() (bool, error) {
+	return s.getBool("loginLimitEnable")
 }
 
-func (s *SettingService) SetTwoFactorEnable(value bool) error {
-	return s.setBool("twoFactorEnable", value)
+func (s *SettingService) GetLoginMaxAttempts() (int, error) {
+	return s.getInt("loginMaxAttempts")
 }
 
-func (s *SettingService) GetTwoFactorToken() (string, error) {
-	return s.getString("twoFactorToken")
+func (s *SettingService) GetLoginLockoutSeconds() (int, error) {
+	return s.getInt("loginLockoutSeconds")
 }
 
-func (s *SettingService) SetTwoFactorToken(value string) error {
-	return s.setString("twoFactorToken", value)
+func (s *SettingService) GetLoginLockoutMaxSeconds() (int, error) {
+	return s.getInt("loginLockoutMaxSeconds")
+}
+
+func (s *SettingService) GetLoginAttemptWindow() (int, error) {
+	return s.getInt("loginAttemptWindow")
+}
+
+func (s *SettingService) GetIpLimitMode() (string, error) {
+	return s.getString("ipLimitMode")
+}
+
+func (s *SettingService) GetIpLimitCooldown() (int, error) {
+	return s.getInt("ipLimitCooldown")
+}
+
+func (s *SettingService) GetRecycleBinDays() (int, error) {
+	return s.getInt("recycleBinDays")
+}
+
+func (s *SettingService) GetTrafficStatsHourlyDays() (int, error) {
+	return s.getInt("trafficStatsHourlyDays")
+}
+
+func (s *SettingService) GetTrafficStatsDailyDays() (int, error) {
+	return s.getInt("trafficStatsDailyDays")
+}
+
+func (s *SettingService) GetMetricsEnable() (bool, error) {
+	return s.getBool("metricsEnable")
+}
+
+func (s *SettingService) GetMetricsListen() (string, error) {
+	return s.getString("metricsListen")
+}
+
+func (s *SettingService) GetMetricsPort() (int, error) {
+	return s.getInt("metricsPort")
+}
+
+func (s *SettingService) GetDestinationStatsEnable() (bool, error) {
+	return s.getBool("destinationStatsEnable")
+}
+
+func (s *SettingService) GetDestinationStatsTopN() (int, error) {
+	return s.getInt("destinationStatsTopN")
+}
+
+func (s *SettingService) GetDestinationStatsDays() (int, error) {
+	return s.getInt("destinationStatsDays")
 }
 
 func (s *SettingService) GetPort() (int, error) {
@@ -555,6 +634,86 @@ func (s *SettingService) GetSubJsonURI() (string, error) {
 	return s.getString("subJsonURI")
 }
 
+// GetSubURLs returns the subscription URL and, when JSON subscriptions are enabled, the
+// JSON subscription URL of subId. Without a subscription or panel domain the URLs use host.
+func (s *SettingService) GetSubURLs(subId string, host string) (string, string) {
+	// Gather settings to construct absolute URLs
+	subURI, _ := s.GetSubURI()
+	subJsonURI, _ := s.GetSubJsonURI()
+	subDomain, _ := s.GetSubDomain()
+	subPort, _ := s.GetSubPort()
+	subPath, _ := s.GetSubPath()
+	subJsonPath, _ := s.GetSubJsonPath()
+	subJsonEnable, _ := s.GetSubJsonEnable()
+	subKeyFile, _ := s.GetSubKeyFile()
+	subCertFile, _ := s.GetSubCertFile()
+
+	tls := (subKeyFile != "" && subCertFile != "")
+	scheme := "http"
+	if tls {
+		scheme = "https"
+	}
+
+	// Fallbacks
+	if subDomain == "" {
+		// try panel domain, otherwise the given host
+		if d, err := s.GetWebDomain(); err == nil && d != "" {
+			subDomain = d
+		} else {
+			subDomain = extractHostname(host)
+		}
+	}
+
+	subHost := subDomain
+	if (subPort == 443 && tls) || (subPort == 80 && !tls) {
+		// standard ports: no port in host
+	} else {
+		subHost = fmt.Sprintf("%s:%d", subDomain, subPort)
+	}
+
+	// Ensure paths
+	if !strings.HasPrefix(subPath, "/") {
+		subPath = "/" + subPath
+	}
+	if !strings.HasSuffix(subPath, "/") {
+		subPath = subPath + "/"
+	}
+	if !strings.HasPrefix(subJsonPath, "/") {
+		subJsonPath = "/" + subJsonPath
+	}
+	if !strings.HasSuffix(subJsonPath, "/") {
+		subJsonPath = subJsonPath + "/"
+	}
+
+	var subURL string
+	var subJsonURL string
+
+	// If pre-configured URIs are available, use them directly
+	if subURI != "" {
+		if !strings.HasSuffix(subURI, "/") {
+			subURI = subURI + "/"
+		}
+		subURL = fmt.Sprintf("%s%s", subURI, subId)
+	} else {
+		subURL = fmt.Sprintf("%s://%s%s%s", scheme, subHost, subPath, subId)
+	}
+
+	if subJsonURI != "" {
+		if !strings.HasSuffix(subJsonURI, "/") {
+			subJsonURI = subJsonURI + "/"
+		}
+		subJsonURL = fmt.Sprintf("%s%s", subJsonURI, subId)
+	} else {
+
+		subJsonURL = fmt.Sprintf("%s://%s%s%s", scheme, subHost, subJsonPath, subId)
+	}
+
+	if !subJsonEnable {
+		subJsonURL = ""
+	}
+	return subURL, subJsonURL
+}
+
 func (s *SettingService) GetSubJsonFragment() (string, error) {
 	return s.getString("subJsonFragment")
 }
@@ -688,6 +847,67 @@ func (s *SettingService) GetLdapDefaultLimitIP() (int, error) {
 	return s.getInt("ldapDefaultLimitIP")
 }
 
+func (s *SettingService) GetLdapGroupAttr() (string, error) {
+	return s.getString("ldapGroupAttr")
+}
+
+func (s *SettingService) GetLdapRoleMapping() (string, error) {
+	return s.getString("ldapRoleMapping")
+}
+
+func (s *SettingService) GetLdapDefaultRole() (string, error) {
+	return s.getString("ldapDefaultRole")
+}
+
+func (s *SettingService) GetLdapAutoProvision() (bool, error) {
+	return s.getBool("ldapAutoProvision")
+}
+
+// OIDC exported getters
+func (s *SettingService) GetOidcEnable() (bool, error) {
+	return s.getBool("oidcEnable")
+}
+
+func (s *SettingService) GetOidcIssuer() (string, error) {
+	return s.getString("oidcIssuer")
+}
+
+func (s *SettingService) GetOidcClientId() (string, error) {
+	return s.getString("oidcClientId")
+}
+
+func (s *SettingService) GetOidcClientSecret() (string, error) {
+	return s.getString("oidcClientSecret")
+}
+
+func (s *SettingService) GetOidcRedirectUrl() (string, error) {
+	return s.getString("oidcRedirectUrl")
+}
+
+func (s *SettingService) GetOidcScopes() (string, error) {
+	return s.getString("oidcScopes")
+}
+
+func (s *SettingService) GetOidcUsernameClaim() (string, error) {
+	return s.getString("oidcUsernameClaim")
+}
+
+func (s *SettingService) GetOidcGroupsClaim() (string, error) {
+	return s.getString("oidcGroupsClaim")
+}
+
+func (s *SettingService) GetOidcRoleMapping() (string, error) {
+	return s.getString("oidcRoleMapping")
+}
+
+func (s *SettingService) GetOidcDefaultRole() (string, error) {
+	return s.getString("oidcDefaultRole")
+}
+
+func (s *SettingService) GetOidcAutoProvision() (bool, error) {
+	return s.getBool("oidcAutoProvision")
+}
+
 func (s *SettingService) UpdateAllSetting(allSetting *entity.AllSetting) error {
 	if err := allSetting.CheckValid(); err != nil {
 		return err
@@ -0,0 +1,200 @@
+package service
+
+import (
+	"strings"
+	"time"
+
+	"github.com/mhsanaei/3x-ui/v2/database"
+	"github.com/mhsanaei/3x-ui/v2/database/model"
+	"github.com/mhsanaei/3x-ui/v2/util/common"
+	"github.com/mhsanaei/3x-ui/v2/xray"
+
+	"gorm.io/gorm"
+)
+
+// SubAccountUsage is a subscription account with the traffic its clients used.
+type SubAccountUsage struct {
+	model.SubAccount
+	Up      int64 `json:"up"`
+	Down    int64 `json:"down"`
+	Clients int   `json:"clients"` // Number of linked clients with traffic stats
+}
+
+// Exhausted reports whether the shared quota or expiry of the account is used up at now.
+func (u *SubAccountUsage) Exhausted(now int64) bool {
+	if u.TotalGB > 0 && u.Up+u.Down >= u.TotalGB {
+		return true
+	}
+	return u.ExpiryTime > 0 && u.ExpiryTime <= now
+}
+
+// subAccountUsage selects subscription accounts with the summed traffic of the clients
+// sharing their subscription id.
+func subAccountUsage(db *gorm.DB) *gorm.DB {
+	return db.Model(model.SubAccount{}).
+		Select("sub_accounts.*, COALESCE(SUM(client_traffics.up),0) AS up, " +
+			"COALESCE(SUM(client_traffics.down),0) AS down, COUNT(client_traffics.id) AS clients").
+		Joins("LEFT JOIN client_traffics ON client_traffics.email IN " +
+			"(SELECT clients.email FROM clients WHERE clients.sub_id = sub_accounts.sub_id)").
+		Group("sub_accounts.id")
+}
+
+// SubAccountService reads subscription accounts. Changes go through InboundService so
+// they are audited and reach Xray.
+type SubAccountService struct{}
+
+// GetSubAccounts returns all subscription accounts with their usage.
+func (s *SubAccountService) GetSubAccounts() ([]SubAccountUsage, error) {
+	var accounts []SubAccountUsage
+	err := subAccountUsage(database.GetDB()).Order("sub_accounts.sub_id").Scan(&accounts).Error
+	return accounts, err
+}
+
+// GetSubAccount returns the account of subId with its usage, or nil if the
+// subscription has none.
+func (s *SubAccountService) GetSubAccount(subId string) (*SubAccountUsage, error) {
+	var accounts []SubAccountUsage
+	err := subAccountUsage(database.GetDB()).Where("sub_accounts.sub_id = ?", subId).Scan(&accounts).Error
+	if err != nil || len(accounts) == 0 {
+		return nil, err
+	}
+	return &accounts[0], nil
+}
+
+// exhaustedSubIds returns the subscription ids whose account used up its shared quota
+// or expired at now.
+func exhaustedSubIds(tx *gorm.DB, now int64) ([]string, error) {
+	var subIds []string
+	err := tx.Table("(?) AS pools", subAccountUsage(tx)).
+		Where("(pools.total_gb > 0 AND pools.up + pools.down >= pools.total_gb) OR "+
+			"(pools.expiry_time > 0 AND pools.expiry_time <= ?)", now).
+		Pluck("pools.sub_id", &subIds).Error
+	return subIds, err
+}
+
+// SaveSubAccount creates or changes the account of a subscription id and enables or
+// disables its clients for the new shared limits. It reports whether Xray needs a
+// restart because the API failed.
+func (s *InboundService) SaveSubAccount(account *model.SubAccount) (bool, error) {
+	account.SubId = strings.TrimSpace(account.SubId)
+	if account.SubId == "" {
+		return false, common.NewError("subscription id can not be empty")
+	}
+	if account.TotalGB < 0 || account.ExpiryTime < 0 {
+		return false, common.NewError("subscription limits can not be negative")
+	}
+
+	var before, after map[int]model.Client
+	err := database.GetDB().Transaction(func(tx *gorm.DB) error {
+		var count int64
+		if err := tx.Model(model.Client{}).Where("sub_id = ?", account.SubId).Count(&count).Error; err != nil {
+			return err
+		}
+		if count == 0 {
+			return common.NewError("no client has subscription id:", account.SubId)
+		}
+		old := &model.SubAccount{}
+		if err := tx.Where("sub_id = ?", account.SubId).Limit(1).Find(old).Error; err != nil {
+			return err
+		}
+		account.Id = old.Id
+		account.CreatedAt = old.CreatedAt
+		if err := tx.Save(account).Error; err != nil {
+			return err
+		}
+		var err error
+		before, after, err = refreshSubClients(tx, account.SubId)
+		if err != nil {
+			return err
+		}
+		if old.Id == 0 {
+			old = nil
+		}
+		s.audit(tx, "subAccount.save", 0, "", old, account)
+		return nil
+	})
+	if err != nil {
+		return false, err
+	}
+	return s.applyServedClients(before, after), nil
+}
+
+// DelSubAccount deletes the account of subId. Its clients are left with their own
+// limits and enabled again where these allow it.
+func (s *InboundService) DelSubAccount(subId string) (bool, error) {
+	var before, after map[int]model.Client
+	err := database.GetDB().Transaction(func(tx *gorm.DB) error {
+		old := &model.SubAccount{}
+		if err := tx.Where("sub_id = ?", subId).Limit(1).Find(old).Error; err != nil {
+			return err
+		}
+		if old.Id == 0 {
+			return common.NewError("Subscription account not found:", subId)
+		}
+		if err := tx.Delete(old).Error; err != nil {
+			return err
+		}
+		var err error
+		before, after, err = refreshSubClients(tx, subId)
+		if err != nil {
+			return err
+		}
+		s.audit(tx, "subAccount.delete", 0, "", old, nil)
+		return nil
+	})
+	if err != nil {
+		return false, err
+	}
+	return s.applyServedClients(before, after), nil
+}
+
+// refreshSubClients enables the traffic of the clients of subId that their own limits
+// and the shared ones of the subscription, if it has an account, allow, and disables
+// the rest. It returns the clients Xray served before and after.
+func refreshSubClients(tx *gorm.DB, subId string) (map[int]model.Client, map[int]model.Client, error) {
+	var clients []model.Client
+	if err := tx.Where("sub_id = ?", subId).Find(&clients).Error; err != nil {
+		return nil, nil, err
+	}
+	if len(clients) == 0 {
+		return nil, nil, nil
+	}
+	ids := make([]int, 0, len(clients))
+	for _, client := range clients {
+		ids = append(ids, client.RecordId)
+	}
+	before, err := servedClients(tx, ids)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	now := time.Now().UnixMilli()
+	var usage []SubAccountUsage
+	if err := subAccountUsage(tx).Where("sub_accounts.sub_id = ?", subId).Scan(&usage).Error; err != nil {
+		return nil, nil, err
+	}
+	exhausted := len(usage) > 0 && usage[0].Exhausted(now)
+	traffics, err := groupTraffics(tx, clients)
+	if err != nil {
+		return nil, nil, err
+	}
+	for i := range clients {
+		traffic, ok := traffics[clients[i].Email]
+		if !ok {
+			continue
+		}
+		enable := !exhausted && trafficEnabled(&clients[i], traffic, now)
+		if enable == traffic.Enable {
+			continue
+		}
+		if err := tx.Model(xray.ClientTraffic{}).Where("id = ?", traffic.Id).Update("enable", enable).Error; err != nil {
+			return nil, nil, err
+		}
+	}
+
+	after, err := servedClients(tx, ids)
+	if err != nil {
+		return nil, nil, err
+	}
+	return before, after, nil
+}
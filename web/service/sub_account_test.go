@@ -0,0 +1,52 @@
+package service
+
+import (
+	"testing"
+
+	"github.com/mhsanaei/3x-ui/v2/database"
+	"github.com/mhsanaei/3x-ui/v2/database/model"
+	"github.com/mhsanaei/3x-ui/v2/xray"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExhaustedSubIds(t *testing.T) {
+	const now = int64(1_000_000_000_000)
+	tests := []struct {
+		name      string
+		account   model.SubAccount
+		used      []int64 // Traffic of each client of the subscription
+		exhausted bool
+	}{
+		{"unlimited", model.SubAccount{}, []int64{500, 500}, false},
+		{"quota left", model.SubAccount{TotalGB: 1000}, []int64{300, 600}, false},
+		{"quota shared up", model.SubAccount{TotalGB: 1000}, []int64{400, 600}, true},
+		{"one client over", model.SubAccount{TotalGB: 1000}, []int64{1200}, true},
+		{"no clients", model.SubAccount{TotalGB: 1000}, nil, false},
+		{"expired", model.SubAccount{ExpiryTime: now}, []int64{0}, true},
+		{"expires later", model.SubAccount{ExpiryTime: now + 1}, []int64{0}, false},
+	}
+	setupTestDB(t, nil)
+	db := database.GetDB()
+	inbound := addTestInbound(t)
+	var want []string
+	for _, test := range tests {
+		account := test.account
+		account.SubId = test.name
+		require.NoError(t, db.Create(&account).Error)
+		for j, used := range test.used {
+			client := model.Client{Email: test.name + string(rune('a'+j)), SubID: account.SubId, Enable: true}
+			addTestClient(t, inbound.Id, client, xray.ClientTraffic{Enable: true, Up: used / 2, Down: used - used/2})
+		}
+		if test.exhausted {
+			want = append(want, test.name)
+		}
+	}
+	// Traffic of clients outside the subscription does not count
+	addTestClient(t, inbound.Id, model.Client{Email: "stranger", SubID: "other", Enable: true}, xray.ClientTraffic{Up: 5000})
+
+	subIds, err := exhaustedSubIds(db, now)
+	require.NoError(t, err)
+	assert.ElementsMatch(t, want, subIds)
+}
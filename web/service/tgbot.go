@@ -106,6 +106,7 @@ const (
 // It handles bot commands, user interactions, and status reporting via Telegram.
 type Tgbot struct {
 	inboundService InboundService
+	planService    PlanService
 	settingService SettingService
 	serverService  ServerService
 	xrayService    XrayService
@@ -720,7 +721,7 @@ func (t *Tgbot) sendResponse(chatId int64, msg string, onlyMessage, isAdmin bool
 }
 
 // randomLowerAndNum generates a random string of lowercase letters and numbers.
-func (t *Tgbot) randomLowerAndNum(length int) string {
+func randomLowerAndNum(length int) string {
 	charset := "abcdefghijklmnopqrstuvwxyz0123456789"
 	bytes := make([]byte, length)
 	for i := range bytes {
@@ -731,11 +732,11 @@ func (t *Tgbot) randomLowerAndNum(length int) string {
 }
 
 // randomShadowSocksPassword generates a random password for Shadowsocks.
-func (t *Tgbot) randomShadowSocksPassword() string {
+func randomShadowSocksPassword() string {
 	array := make([]byte, 32)
 	_, err := rand.Read(array)
 	if err != nil {
-		return t.randomLowerAndNum(32)
+		return randomLowerAndNum(32)
 	}
 	return base64.StdEncoding.EncodeToString(array)
 }
@@ -744,7 +745,16 @@ func (t *Tgbot) randomShadowSocksPassword() string {
 func (t *Tgbot) answerCallback(callbackQuery *telego.CallbackQuery, isAdmin bool) {
 	chatId := callbackQuery.Message.GetChat().ID
 
+	// attribute changes made from callbacks to the Telegram chat in the audit log
+	actor := &AuditActor{
+		Type: model.AuditActorTelegram,
+		Id:   chatId,
+		Name: callbackQuery.From.Username,
+	}
+	inboundService := t.inboundService.WithActor(actor)
+
 	if isAdmin {
+
 		// get query from hash storage
 		decodedQuery, err := t.decodeQuery(callbackQuery.Data)
 		if err != nil {
@@ -839,7 +849,7 @@ func (t *Tgbot) answerCallback(callbackQuery *telego.CallbackQuery, isAdmin bool
 				)
 				t.editMessageCallbackTgBot(chatId, callbackQuery.Message.GetMessageID(), inlineKeyboard)
 			case "reset_traffic_c":
-				err := t.inboundService.ResetClientTrafficByEmail(email)
+				err := inboundService.ResetClientTrafficByEmail(email)
 				if err == nil {
 					t.sendCallbackAnswerTgBot(callbackQuery.ID, t.I18nBot("tgbot.answers.resetTrafficSuccess", "Email=="+email))
 					t.searchClient(chatId, email, callbackQuery.Message.GetMessageID())
@@ -881,7 +891,7 @@ func (t *Tgbot) answerCallback(callbackQuery *telego.CallbackQuery, isAdmin bool
 				if len(dataArray) == 3 {
 					limitTraffic, err := strconv.Atoi(dataArray[2])
 					if err == nil {
-						needRestart, err := t.inboundService.ResetClientTrafficLimitByEmail(email, limitTraffic)
+						needRestart, err := inboundService.ResetClientTrafficLimitByEmail(email, limitTraffic)
 						if needRestart {
 							t.xrayService.SetToNeedRestart()
 						}
@@ -1090,7 +1100,7 @@ func (t *Tgbot) answerCallback(callbackQuery *telego.CallbackQuery, isAdmin bool
 							}
 
 						}
-						needRestart, err := t.inboundService.ResetClientExpiryTimeByEmail(email, date)
+						needRestart, err := inboundService.ResetClientExpiryTimeByEmail(email, date)
 						if needRestart {
 							t.xrayService.SetToNeedRestart()
 						}
@@ -1287,7 +1297,7 @@ func (t *Tgbot) answerCallback(callbackQuery *telego.CallbackQuery, isAdmin bool
 				if len(dataArray) == 3 {
 					count, err := strconv.Atoi(dataArray[2])
 					if err == nil {
-						needRestart, err := t.inboundService.ResetClientIpLimitByEmail(email, count)
+						needRestart, err := inboundService.ResetClientIpLimitByEmail(email, count)
 						if needRestart {
 							t.xrayService.SetToNeedRestart()
 						}
@@ -1453,7 +1463,7 @@ func (t *Tgbot) answerCallback(callbackQuery *telego.CallbackQuery, isAdmin bool
 				)
 				t.editMessageCallbackTgBot(chatId, callbackQuery.Message.GetMessageID(), inlineKeyboard)
 			case "clear_ips_c":
-				err := t.inboundService.ClearClientIps(email)
+				err := inboundService.ClearClientIps(email)
 				if err == nil {
 					t.sendCallbackAnswerTgBot(callbackQuery.ID, t.I18nBot("tgbot.answers.clearIpSuccess", "Email=="+email))
 					t.searchClientIps(chatId, email, callbackQuery.Message.GetMessageID())
@@ -1482,7 +1492,7 @@ func (t *Tgbot) answerCallback(callbackQuery *telego.CallbackQuery, isAdmin bool
 					t.sendCallbackAnswerTgBot(callbackQuery.ID, t.I18nBot("tgbot.answers.errorOperation"))
 					return
 				}
-				needRestart, err := t.inboundService.SetClientTelegramUserID(traffic.Id, EmptyTelegramUserID)
+				needRestart, err := inboundService.SetClientTelegramUserID(traffic.Id, EmptyTelegramUserID)
 				if needRestart {
 					t.xrayService.SetToNeedRestart()
 				}
@@ -1492,6 +1502,54 @@ func (t *Tgbot) answerCallback(callbackQuery *telego.CallbackQuery, isAdmin bool
 				} else {
 					t.sendCallbackAnswerTgBot(callbackQuery.ID, t.I18nBot("tgbot.answers.errorOperation"))
 				}
+			case "renew":
+				_, client, err := t.inboundService.GetClientByEmail(email)
+				if err != nil {
+					t.sendCallbackAnswerTgBot(callbackQuery.ID, t.I18nBot("tgbot.answers.errorOperation"))
+					return
+				}
+				plans, err := t.planService.GetInboundPlans(client.InboundId)
+				if err != nil {
+					t.sendCallbackAnswerTgBot(callbackQuery.ID, t.I18nBot("tgbot.answers.errorOperation"))
+					return
+				}
+				if len(plans) == 0 {
+					t.sendCallbackAnswerTgBot(callbackQuery.ID, t.I18nBot("tgbot.answers.noPlans"))
+					return
+				}
+				rows := [][]telego.InlineKeyboardButton{
+					tu.InlineKeyboardRow(
+						tu.InlineKeyboardButton(t.I18nBot("tgbot.buttons.cancel")).WithCallbackData(t.encodeQuery("client_cancel " + email)),
+					),
+				}
+				// The current plan of the client is marked
+				for _, plan := range plans {
+					name := plan.Name
+					if plan.Id == client.PlanId {
+						name = "✅ " + name
+					}
+					rows = append(rows, tu.InlineKeyboardRow(
+						tu.InlineKeyboardButton(name).WithCallbackData(t.encodeQuery("renew_c "+email+" "+strconv.Itoa(plan.Id))),
+					))
+				}
+				t.editMessageCallbackTgBot(chatId, callbackQuery.Message.GetMessageID(), tu.InlineKeyboard(rows...))
+			case "renew_c":
+				if len(dataArray) == 3 {
+					planId, err := strconv.Atoi(dataArray[2])
+					if err == nil {
+						renewal, needRestart, err := inboundService.RenewClient(email, planId)
+						if needRestart {
+							t.xrayService.SetToNeedRestart()
+						}
+						if err == nil {
+							t.sendCallbackAnswerTgBot(callbackQuery.ID, t.I18nBot("tgbot.answers.renewSuccess", "Email=="+email, "Plan=="+renewal.PlanName))
+							t.searchClient(chatId, email, callbackQuery.Message.GetMessageID())
+							return
+						}
+						logger.Warning(err)
+					}
+				}
+				t.sendCallbackAnswerTgBot(callbackQuery.ID, t.I18nBot("tgbot.answers.errorOperation"))
 			case "toggle_enable":
 				inlineKeyboard := tu.InlineKeyboard(
 					tu.InlineKeyboardRow(
@@ -1503,7 +1561,7 @@ func (t *Tgbot) answerCallback(callbackQuery *telego.CallbackQuery, isAdmin bool
 				)
 				t.editMessageCallbackTgBot(chatId, callbackQuery.Message.GetMessageID(), inlineKeyboard)
 			case "toggle_enable_c":
-				enabled, needRestart, err := t.inboundService.ToggleClientEnableByEmail(email)
+				enabled, needRestart, err := inboundService.ToggleClientEnableByEmail(email)
 				if needRestart {
 					t.xrayService.SetToNeedRestart()
 				}
@@ -1539,18 +1597,18 @@ func (t *Tgbot) answerCallback(callbackQuery *telego.CallbackQuery, isAdmin bool
 				// assign default values to clients variables
 				client_Id = uuid.New().String()
 				client_Flow = ""
-				client_Email = t.randomLowerAndNum(8)
+				client_Email = randomLowerAndNum(8)
 				client_LimitIP = 0
 				client_TotalGB = 0
 				client_ExpiryTime = 0
 				client_Enable = true
 				client_TgID = ""
-				client_SubID = t.randomLowerAndNum(16)
+				client_SubID = randomLowerAndNum(16)
 				client_Comment = ""
 				client_Reset = 0
 				client_Security = "auto"
-				client_ShPassword = t.randomShadowSocksPassword()
-				client_TrPassword = t.randomLowerAndNum(10)
+				client_ShPassword = randomShadowSocksPassword()
+				client_TrPassword = randomLowerAndNum(10)
 				client_Method = ""
 
 				inboundId := dataArray[1]
@@ -1718,18 +1776,18 @@ func (t *Tgbot) answerCallback(callbackQuery *telego.CallbackQuery, isAdmin bool
 		// assign default values to clients variables
 		client_Id = uuid.New().String()
 		client_Flow = ""
-		client_Email = t.randomLowerAndNum(8)
+		client_Email = randomLowerAndNum(8)
 		client_LimitIP = 0
 		client_TotalGB = 0
 		client_ExpiryTime = 0
 		client_Enable = true
 		client_TgID = ""
-		client_SubID = t.randomLowerAndNum(16)
+		client_SubID = randomLowerAndNum(16)
 		client_Comment = ""
 		client_Reset = 0
 		client_Security = "auto"
-		client_ShPassword = t.randomShadowSocksPassword()
-		client_TrPassword = t.randomLowerAndNum(10)
+		client_ShPassword = randomShadowSocksPassword()
+		client_TrPassword = randomLowerAndNum(10)
 		client_Method = ""
 
 		inbounds, err := t.getInboundsAddClient()
@@ -1917,7 +1975,7 @@ func (t *Tgbot) answerCallback(callbackQuery *telego.CallbackQuery, isAdmin bool
 		t.sendCallbackAnswerTgBot(callbackQuery.ID, t.I18nBot("tgbot.answers.canceled", "Email=="+client_Email))
 	case "add_client_submit_disable":
 		client_Enable = false
-		_, err := t.SubmitAddClient()
+		_, err := t.SubmitAddClient(actor)
 		if err != nil {
 			errorMessage := fmt.Sprintf("%v", err)
 			t.SendMsgToTgbot(chatId, t.I18nBot("tgbot.messages.error_add_client", "error=="+errorMessage), tu.ReplyKeyboardRemove())
@@ -1927,7 +1985,7 @@ func (t *Tgbot) answerCallback(callbackQuery *telego.CallbackQuery, isAdmin bool
 		}
 	case "add_client_submit_enable":
 		client_Enable = true
-		_, err := t.SubmitAddClient()
+		_, err := t.SubmitAddClient(actor)
 		if err != nil {
 			errorMessage := fmt.Sprintf("%v", err)
 			t.SendMsgToTgbot(chatId, t.I18nBot("tgbot.messages.error_add_client", "error=="+errorMessage), tu.ReplyKeyboardRemove())
@@ -1957,7 +2015,7 @@ func (t *Tgbot) answerCallback(callbackQuery *telego.CallbackQuery, isAdmin bool
 		}
 
 		for _, email := range emails {
-			err := t.inboundService.ResetClientTrafficByEmail(email)
+			err := inboundService.ResetClientTrafficByEmail(email)
 			if err == nil {
 				msg := t.I18nBot("tgbot.messages.SuccessResetTraffic", "ClientEmail=="+email)
 				t.SendMsgToTgbot(chatId, msg, tu.ReplyKeyboardRemove())
@@ -2152,8 +2210,9 @@ func (t *Tgbot) BuildJSONForProtocol(protocol model.Protocol) (string, error) {
 	return jsonString, nil
 }
 
-// SubmitAddClient submits the client addition request to the inbound service.
-func (t *Tgbot) SubmitAddClient() (bool, error) {
+// SubmitAddClient submits the client addition request to the inbound service,
+// attributing it to actor in the audit log.
+func (t *Tgbot) SubmitAddClient(actor *AuditActor) (bool, error) {
 
 	inbound, err := t.inboundService.GetInbound(receiver_inbound_ID)
 	if err != nil {
@@ -2173,7 +2232,7 @@ func (t *Tgbot) SubmitAddClient() (bool, error) {
 
 	newInbound.SetSettingsString(jsonString)
 
-	return t.inboundService.AddInboundClient(newInbound)
+	return t.inboundService.WithActor(actor).AddInboundClient(newInbound)
 }
 
 // checkAdmin checks if the given Telegram ID is an admin.
@@ -2325,88 +2384,15 @@ func (t *Tgbot) SendMsgToTgbot(chatId int64, msg string, replyMarkup ...telego.R
 // buildSubscriptionURLs builds the HTML sub page URL and JSON subscription URL for a client email
 func (t *Tgbot) buildSubscriptionURLs(email string) (string, string, error) {
 	// Resolve subId from client email
-	traffic, client, err := t.inboundService.GetClientByEmail(email)
-	_ = traffic
+	_, client, err := t.inboundService.GetClientByEmail(email)
 	if err != nil || client == nil {
 		return "", "", errors.New("client not found")
 	}
-
-	// Gather settings to construct absolute URLs
-	subURI, _ := t.settingService.GetSubURI()
-	subJsonURI, _ := t.settingService.GetSubJsonURI()
-	subDomain, _ := t.settingService.GetSubDomain()
-	subPort, _ := t.settingService.GetSubPort()
-	subPath, _ := t.settingService.GetSubPath()
-	subJsonPath, _ := t.settingService.GetSubJsonPath()
-	subJsonEnable, _ := t.settingService.GetSubJsonEnable()
-	subKeyFile, _ := t.settingService.GetSubKeyFile()
-	subCertFile, _ := t.settingService.GetSubCertFile()
-
-	tls := (subKeyFile != "" && subCertFile != "")
-	scheme := "http"
-	if tls {
-		scheme = "https"
-	}
-
-	// Fallbacks
-	if subDomain == "" {
-		// try panel domain, otherwise OS hostname
-		if d, err := t.settingService.GetWebDomain(); err == nil && d != "" {
-			subDomain = d
-		} else if hostname != "" {
-			subDomain = hostname
-		} else {
-			subDomain = "localhost"
-		}
-	}
-
-	host := subDomain
-	if (subPort == 443 && tls) || (subPort == 80 && !tls) {
-		// standard ports: no port in host
-	} else {
-		host = fmt.Sprintf("%s:%d", subDomain, subPort)
-	}
-
-	// Ensure paths
-	if !strings.HasPrefix(subPath, "/") {
-		subPath = "/" + subPath
-	}
-	if !strings.HasSuffix(subPath, "/") {
-		subPath = subPath + "/"
-	}
-	if !strings.HasPrefix(subJsonPath, "/") {
-		subJsonPath = "/" + subJsonPath
-	}
-	if !strings.HasSuffix(subJsonPath, "/") {
-		subJsonPath = subJsonPath + "/"
-	}
-
-	var subURL string
-	var subJsonURL string
-
-	// If pre-configured URIs are available, use them directly
-	if subURI != "" {
-		if !strings.HasSuffix(subURI, "/") {
-			subURI = subURI + "/"
-		}
-		subURL = fmt.Sprintf("%s%s", subURI, client.SubID)
-	} else {
-		subURL = fmt.Sprintf("%s://%s%s%s", scheme, host, subPath, client.SubID)
-	}
-
-	if subJsonURI != "" {
-		if !strings.HasSuffix(subJsonURI, "/") {
-			subJsonURI = subJsonURI + "/"
-		}
-		subJsonURL = fmt.Sprintf("%s%s", subJsonURI, client.SubID)
-	} else {
-
-		subJsonURL = fmt.Sprintf("%s://%s%s%s", scheme, host, subJsonPath, client.SubID)
-	}
-
-	if !subJsonEnable {
-		subJsonURL = ""
+	host := hostname
+	if host == "" {
+		host = "localhost"
 	}
+	subURL, subJsonURL := t.settingService.GetSubURLs(client.SubID, host)
 	return subURL, subJsonURL, nil
 }
 
@@ -2990,6 +2976,17 @@ func (t *Tgbot) getInboundClients(id int) (*telego.InlineKeyboardMarkup, error)
 	return keyboard, nil
 }
 
+// formatSpeedLimit formats a speed limit in kbit/s, zero meaning unlimited.
+func (t *Tgbot) formatSpeedLimit(kbit int64) string {
+	if kbit <= 0 {
+		return t.I18nBot("tgbot.unlimited")
+	}
+	if kbit%1000 == 0 {
+		return fmt.Sprintf("%d Mbit/s", kbit/1000)
+	}
+	return fmt.Sprintf("%d kbit/s", kbit)
+}
+
 // clientInfoMsg formats client information message based on traffic and flags.
 func (t *Tgbot) clientInfoMsg(
 	traffic *xray.ClientTraffic,
@@ -3094,6 +3091,11 @@ func (t *Tgbot) clientInfoMsg(
 		output += t.I18nBot("tgbot.messages.upload", "Upload=="+common.FormatTraffic(traffic.Up))
 		output += t.I18nBot("tgbot.messages.download", "Download=="+common.FormatTraffic(traffic.Down))
 		output += t.I18nBot("tgbot.messages.total", "UpDown=="+common.FormatTraffic((traffic.Up+traffic.Down)), "Total=="+total)
+		if limit, err := t.inboundService.GetClientSpeedLimit(traffic.Email); err != nil {
+			logger.Warning(err)
+		} else if limit.Up > 0 || limit.Down > 0 {
+			output += t.I18nBot("tgbot.messages.speedLimit", "Upload=="+t.formatSpeedLimit(limit.Up), "Download=="+t.formatSpeedLimit(limit.Down))
+		}
 	}
 	if printRefreshed {
 		output += t.I18nBot("tgbot.messages.refreshedOn", "Time=="+time.Now().Format("2006-01-02 15:04:05"))
@@ -3285,6 +3287,7 @@ func (t *Tgbot) searchClient(chatId int64, email string, messageID ...int) {
 		),
 		tu.InlineKeyboardRow(
 			tu.InlineKeyboardButton(t.I18nBot("tgbot.buttons.resetExpire")).WithCallbackData(t.encodeQuery("reset_exp "+email)),
+			tu.InlineKeyboardButton(t.I18nBot("tgbot.buttons.renew")).WithCallbackData(t.encodeQuery("renew "+email)),
 		),
 		tu.InlineKeyboardRow(
 			tu.InlineKeyboardButton(t.I18nBot("tgbot.buttons.ipLog")).WithCallbackData(t.encodeQuery("ip_log "+email)),
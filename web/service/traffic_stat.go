@@ -0,0 +1,235 @@
+package service
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/mhsanaei/3x-ui/v2/database"
+	"github.com/mhsanaei/3x-ui/v2/database/model"
+	"github.com/mhsanaei/3x-ui/v2/util/common"
+	"github.com/mhsanaei/3x-ui/v2/xray"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Scopes traffic history can be queried for
+const (
+	TrafficScopeClient  = "client"  // Key is the client email
+	TrafficScopeInbound = "inbound" // Key is the inbound id
+	TrafficScopeSub     = "sub"     // Key is the subscription id
+	TrafficScopeServer  = "server"  // Key is unused
+)
+
+// maxTrafficBuckets caps the number of buckets one history query returns.
+const maxTrafficBuckets = 10000
+
+// TrafficUsage is the traffic within one bucket of a history.
+type TrafficUsage struct {
+	Bucket int64 `json:"bucket"` // Start of the bucket in milliseconds
+	Up     int64 `json:"up"`
+	Down   int64 `json:"down"`
+}
+
+// TrafficHistory is the traffic of a scope over a range, in buckets of one span.
+type TrafficHistory struct {
+	Span    string         `json:"span"`
+	From    int64          `json:"from"` // Start of the first bucket in milliseconds
+	To      int64          `json:"to"`   // End of the range in milliseconds
+	Up      int64          `json:"up"`
+	Down    int64          `json:"down"`
+	Buckets []TrafficUsage `json:"buckets"`
+}
+
+// TrafficStatService keeps the history of client and inbound traffic in hourly and
+// daily buckets. Each delta Xray reports is added to the bucket of both spans it falls
+// in, so daily totals need no rollup pass and outlive the hourly detail.
+type TrafficStatService struct {
+	settingService SettingService
+}
+
+// bucketStart returns the start of the span that t falls in, in location.
+func bucketStart(span string, t time.Time, location *time.Location) time.Time {
+	t = t.In(location)
+	if span == model.TrafficSpanDay {
+		return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, location)
+	}
+	return time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), 0, 0, 0, location)
+}
+
+// nextBucket returns the start of the bucket of span after the one starting at t.
+func nextBucket(span string, t time.Time) time.Time {
+	if span == model.TrafficSpanDay {
+		return t.AddDate(0, 0, 1)
+	}
+	return t.Add(time.Hour)
+}
+
+// RecordTraffic adds the traffic deltas of inbounds and clients Xray reported to the
+// buckets of the current hour and day.
+func (s *TrafficStatService) RecordTraffic(traffics []*xray.Traffic, clientTraffics []*xray.ClientTraffic) error {
+	type statKey struct {
+		inboundId int
+		email     string
+	}
+	deltas := map[statKey]*model.TrafficStat{}
+	db := database.GetDB()
+
+	tags := map[string]*xray.Traffic{}
+	for _, traffic := range traffics {
+		if traffic.IsInbound && traffic.Up+traffic.Down > 0 {
+			tags[traffic.Tag] = traffic
+		}
+	}
+	if len(tags) > 0 {
+		names := make([]string, 0, len(tags))
+		for tag := range tags {
+			names = append(names, tag)
+		}
+		var inbounds []model.Inbound
+		if err := db.Select("id, tag").Where("tag IN ?", names).Find(&inbounds).Error; err != nil {
+			return err
+		}
+		for _, inbound := range inbounds {
+			traffic := tags[inbound.Tag]
+			deltas[statKey{inbound.Id, ""}] = &model.TrafficStat{InboundId: inbound.Id, Up: traffic.Up, Down: traffic.Down}
+		}
+	}
+
+	emails := map[string]*xray.ClientTraffic{}
+	for _, traffic := range clientTraffics {
+		if traffic.Up+traffic.Down > 0 {
+			emails[traffic.Email] = traffic
+		}
+	}
+	if len(emails) > 0 {
+		names := make([]string, 0, len(emails))
+		for email := range emails {
+			names = append(names, email)
+		}
+		var rows []xray.ClientTraffic
+		if err := db.Select("email, inbound_id").Where("email IN ?", names).Find(&rows).Error; err != nil {
+			return err
+		}
+		for _, row := range rows {
+			traffic := emails[row.Email]
+			deltas[statKey{row.InboundId, row.Email}] = &model.TrafficStat{
+				InboundId: row.InboundId, Email: row.Email, Up: traffic.Up, Down: traffic.Down,
+			}
+		}
+	}
+	if len(deltas) == 0 {
+		return nil
+	}
+
+	location, err := s.settingService.GetTimeLocation()
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	stats := make([]model.TrafficStat, 0, 2*len(deltas))
+	for _, span := range []string{model.TrafficSpanHour, model.TrafficSpanDay} {
+		bucket := bucketStart(span, now, location).UnixMilli()
+		for _, delta := range deltas {
+			stat := *delta
+			stat.Span = span
+			stat.Bucket = bucket
+			stats = append(stats, stat)
+		}
+	}
+	return db.Clauses(clause.OnConflict{
+		Columns: []clause.Column{{Name: "span"}, {Name: "bucket"}, {Name: "inbound_id"}, {Name: "email"}},
+		DoUpdates: clause.Assignments(map[string]any{
+			"up":   gorm.Expr("traffic_stats.up + excluded.up"),
+			"down": gorm.Expr("traffic_stats.down + excluded.down"),
+		}),
+	}).Create(&stats).Error
+}
+
+// GetTrafficHistory returns the traffic of scope and key between from and to, in
+// milliseconds, in buckets of span. Buckets without traffic are included with zeros.
+func (s *TrafficStatService) GetTrafficHistory(scope string, key string, span string, from int64, to int64) (*TrafficHistory, error) {
+	if span != model.TrafficSpanHour && span != model.TrafficSpanDay {
+		return nil, common.NewError("unknown span:", span)
+	}
+	if from >= to {
+		return nil, common.NewError("the range must end after it starts")
+	}
+	location, err := s.settingService.GetTimeLocation()
+	if err != nil {
+		return nil, err
+	}
+	start := bucketStart(span, time.UnixMilli(from), location)
+	end := time.UnixMilli(to)
+	count := 0
+	for t := start; t.Before(end); t = nextBucket(span, t) {
+		if count++; count > maxTrafficBuckets {
+			return nil, common.NewErrorf("the range holds more than %d buckets", maxTrafficBuckets)
+		}
+	}
+
+	db := database.GetDB().Model(model.TrafficStat{}).
+		Select("bucket, SUM(up) AS up, SUM(down) AS down").
+		Where("span = ? AND bucket >= ? AND bucket < ?", span, start.UnixMilli(), to)
+	switch scope {
+	case TrafficScopeClient:
+		db = db.Where("email = ?", key)
+	case TrafficScopeInbound:
+		inboundId, err := strconv.Atoi(key)
+		if err != nil {
+			return nil, common.NewError("invalid inbound id:", key)
+		}
+		db = db.Where("inbound_id = ? AND email = ''", inboundId)
+	case TrafficScopeSub:
+		db = db.Where("email IN (SELECT clients.email FROM clients WHERE clients.sub_id = ?)", key)
+	case TrafficScopeServer:
+		db = db.Where("email = ''")
+	default:
+		return nil, common.NewError("unknown scope:", scope)
+	}
+	var rows []TrafficUsage
+	if err := db.Group("bucket").Scan(&rows).Error; err != nil {
+		return nil, err
+	}
+	byBucket := make(map[int64]TrafficUsage, len(rows))
+	for _, row := range rows {
+		byBucket[row.Bucket] = row
+	}
+
+	history := &TrafficHistory{Span: span, From: start.UnixMilli(), To: to, Buckets: make([]TrafficUsage, 0, count)}
+	for t := start; t.Before(end); t = nextBucket(span, t) {
+		usage := byBucket[t.UnixMilli()]
+		usage.Bucket = t.UnixMilli()
+		history.Up += usage.Up
+		history.Down += usage.Down
+		history.Buckets = append(history.Buckets, usage)
+	}
+	return history, nil
+}
+
+// PurgeTrafficStats deletes the hourly and daily buckets older than the
+// "trafficStatsHourlyDays" and "trafficStatsDailyDays" settings and returns how many.
+func (s *TrafficStatService) PurgeTrafficStats() (int64, error) {
+	hourlyDays, err := s.settingService.GetTrafficStatsHourlyDays()
+	if err != nil {
+		return 0, err
+	}
+	dailyDays, err := s.settingService.GetTrafficStatsDailyDays()
+	if err != nil {
+		return 0, err
+	}
+	var purged int64
+	now := time.Now()
+	for span, days := range map[string]int{model.TrafficSpanHour: hourlyDays, model.TrafficSpanDay: dailyDays} {
+		if days <= 0 {
+			continue
+		}
+		cutoff := now.AddDate(0, 0, -days).UnixMilli()
+		result := database.GetDB().Where("span = ? AND bucket < ?", span, cutoff).Delete(&model.TrafficStat{})
+		if result.Error != nil {
+			return purged, result.Error
+		}
+		purged += result.RowsAffected
+	}
+	return purged, nil
+}
@@ -0,0 +1,213 @@
+package service
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"strings"
+
+	"github.com/mhsanaei/3x-ui/v2/database"
+	"github.com/mhsanaei/3x-ui/v2/database/model"
+	"github.com/mhsanaei/3x-ui/v2/util/common"
+	"github.com/mhsanaei/3x-ui/v2/util/random"
+
+	"github.com/xlzd/gotp"
+	"gorm.io/gorm"
+)
+
+// recoveryCodeCount is the number of one-time recovery codes issued per user.
+const recoveryCodeCount = 10
+
+var errWrongTwoFactorCode = errors.New("wrong two-factor code")
+
+// TwoFactorStatus describes the two-factor state of a panel user.
+type TwoFactorStatus struct {
+	Enabled       bool  `json:"enabled"`
+	RecoveryCodes int64 `json:"recoveryCodes"` // Unused recovery codes left
+}
+
+// hashRecoveryCode returns the hex-encoded SHA-256 hash of a normalized recovery code.
+func hashRecoveryCode(code string) string {
+	sum := sha256.Sum256([]byte(normalizeRecoveryCode(code)))
+	return hex.EncodeToString(sum[:])
+}
+
+// normalizeRecoveryCode drops separators and case so codes can be typed loosely.
+func normalizeRecoveryCode(code string) string {
+	code = strings.ToLower(code)
+	return strings.NewReplacer("-", "", " ", "").Replace(code)
+}
+
+// GetTwoFactorStatus returns whether two-factor is enabled for a user and how many recovery codes are left.
+func (s *UserService) GetTwoFactorStatus(userId int) (*TwoFactorStatus, error) {
+	user, err := s.GetUserById(userId)
+	if err != nil {
+		return nil, err
+	}
+	status := &TwoFactorStatus{Enabled: user.TwoFactorEnable}
+	err = database.GetDB().Model(model.UserRecoveryCode{}).Where("user_id = ?", userId).Count(&status.RecoveryCodes).Error
+	if err != nil {
+		return nil, err
+	}
+	return status, nil
+}
+
+// HasTwoFactorUsers reports whether any panel user has two-factor enabled.
+func (s *UserService) HasTwoFactorUsers() (bool, error) {
+	var count int64
+	err := database.GetDB().Model(model.User{}).Where("two_factor_enable = ?", true).Count(&count).Error
+	return count > 0, err
+}
+
+// EnableTwoFactor stores secret for a user once code proves the authenticator app was set up,
+// and returns a fresh set of recovery codes. The plain codes are never stored.
+func (s *UserService) EnableTwoFactor(userId int, secret string, code string) ([]string, error) {
+	secret = strings.ToUpper(strings.TrimSpace(secret))
+	if len(secret) < 16 || !gotp.IsSecretValid(secret) {
+		return nil, errors.New("invalid two-factor secret")
+	}
+	if gotp.NewDefaultTOTP(secret).Now() != code {
+		return nil, errWrongTwoFactorCode
+	}
+	var codes []string
+	err := database.GetDB().Transaction(func(tx *gorm.DB) error {
+		err := tx.Model(model.User{}).Where("id = ?", userId).Updates(map[string]any{
+			"two_factor_enable": true,
+			"two_factor_secret": secret,
+		}).Error
+		if err != nil {
+			return err
+		}
+		codes, err = s.replaceRecoveryCodes(tx, userId)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// DisableTwoFactor turns two-factor off for a user after checking a TOTP or recovery code.
+func (s *UserService) DisableTwoFactor(userId int, code string) error {
+	user, err := s.GetUserById(userId)
+	if err != nil {
+		return err
+	}
+	if !user.TwoFactorEnable {
+		return nil
+	}
+	if !s.checkTwoFactorCode(user, code) {
+		return errWrongTwoFactorCode
+	}
+	return s.ResetTwoFactor(userId)
+}
+
+// RegenerateRecoveryCodes replaces the recovery codes of a user after checking a TOTP code.
+func (s *UserService) RegenerateRecoveryCodes(userId int, code string) ([]string, error) {
+	user, err := s.GetUserById(userId)
+	if err != nil {
+		return nil, err
+	}
+	if !user.TwoFactorEnable {
+		return nil, errors.New("two-factor authentication is not enabled")
+	}
+	if gotp.NewDefaultTOTP(user.TwoFactorSecret).Now() != code {
+		return nil, errWrongTwoFactorCode
+	}
+	var codes []string
+	err = database.GetDB().Transaction(func(tx *gorm.DB) error {
+		codes, err = s.replaceRecoveryCodes(tx, userId)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// VerifyTwoFactorCode checks a TOTP code for a user, accepting anything when two-factor is off.
+func (s *UserService) VerifyTwoFactorCode(userId int, code string) error {
+	user, err := s.GetUserById(userId)
+	if err != nil {
+		return err
+	}
+	if user.TwoFactorEnable && gotp.NewDefaultTOTP(user.TwoFactorSecret).Now() != code {
+		return errWrongTwoFactorCode
+	}
+	return nil
+}
+
+// ResetTwoFactor turns two-factor off for a user and drops their recovery codes without any check.
+func (s *UserService) ResetTwoFactor(userId int) error {
+	return database.GetDB().Transaction(func(tx *gorm.DB) error {
+		return s.resetTwoFactor(tx, []int{userId})
+	})
+}
+
+// ResetTwoFactorByUsername resets two-factor for the named user, or for every user when username is empty.
+// It backs the `x-ui setting -resetTwoFactor` command for admins who lost their device.
+func (s *UserService) ResetTwoFactorByUsername(username string) error {
+	db := database.GetDB()
+	query := db.Model(model.User{})
+	if username != "" {
+		query = query.Where("username = ?", username)
+	}
+	var ids []int
+	if err := query.Pluck("id", &ids).Error; err != nil {
+		return err
+	}
+	if username != "" && len(ids) == 0 {
+		return common.NewError("user not found:", username)
+	}
+	return db.Transaction(func(tx *gorm.DB) error {
+		return s.resetTwoFactor(tx, ids)
+	})
+}
+
+func (s *UserService) resetTwoFactor(tx *gorm.DB, userIds []int) error {
+	if len(userIds) == 0 {
+		return nil
+	}
+	err := tx.Model(model.User{}).Where("id IN ?", userIds).Updates(map[string]any{
+		"two_factor_enable": false,
+		"two_factor_secret": "",
+	}).Error
+	if err != nil {
+		return err
+	}
+	return tx.Where("user_id IN ?", userIds).Delete(&model.UserRecoveryCode{}).Error
+}
+
+// replaceRecoveryCodes drops the recovery codes of a user and stores a new set, returning the plain codes.
+func (s *UserService) replaceRecoveryCodes(tx *gorm.DB, userId int) ([]string, error) {
+	if err := tx.Where("user_id = ?", userId).Delete(&model.UserRecoveryCode{}).Error; err != nil {
+		return nil, err
+	}
+	codes := make([]string, 0, recoveryCodeCount)
+	rows := make([]*model.UserRecoveryCode, 0, recoveryCodeCount)
+	for range recoveryCodeCount {
+		code := strings.ToLower(random.Seq(10))
+		code = code[:5] + "-" + code[5:]
+		codes = append(codes, code)
+		rows = append(rows, &model.UserRecoveryCode{UserId: userId, CodeHash: hashRecoveryCode(code)})
+	}
+	if err := tx.Create(rows).Error; err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// checkTwoFactorCode accepts the current TOTP code of user or one of their recovery codes.
+// A matching recovery code is consumed.
+func (s *UserService) checkTwoFactorCode(user *model.User, code string) bool {
+	if code == "" {
+		return false
+	}
+	if gotp.NewDefaultTOTP(user.TwoFactorSecret).Now() == code {
+		return true
+	}
+	result := database.GetDB().
+		Where("user_id = ? AND code_hash = ?", user.Id, hashRecoveryCode(code)).
+		Delete(&model.UserRecoveryCode{})
+	return result.Error == nil && result.RowsAffected > 0
+}
@@ -0,0 +1,81 @@
+package service
+
+import (
+	"regexp"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/xlzd/gotp"
+)
+
+func TestNormalizeRecoveryCode(t *testing.T) {
+	tests := []struct {
+		code string
+		want string
+	}{
+		{"abcde-12345", "abcde12345"},
+		{"ABCDE-12345", "abcde12345"},
+		{" abcde 12345 ", "abcde12345"},
+		{"abcde12345", "abcde12345"},
+	}
+	for _, test := range tests {
+		assert.Equal(t, test.want, normalizeRecoveryCode(test.code), test.code)
+		assert.Equal(t, hashRecoveryCode("abcde-12345"), hashRecoveryCode(test.code), test.code)
+	}
+	assert.NotEqual(t, hashRecoveryCode("abcde-12345"), hashRecoveryCode("abcde-12346"))
+}
+
+func TestTwoFactorLifecycle(t *testing.T) {
+	setupTestDB(t, nil)
+	s := &UserService{}
+	secret := gotp.RandomSecret(32)
+	totp := gotp.NewDefaultTOTP(secret)
+
+	_, err := s.EnableTwoFactor(1, "short", "000000")
+	assert.Error(t, err, "invalid secret")
+	_, err = s.EnableTwoFactor(1, secret, "wrong")
+	assert.ErrorIs(t, err, errWrongTwoFactorCode)
+	require.NoError(t, s.VerifyTwoFactorCode(1, ""), "anything passes while two-factor is off")
+
+	codes, err := s.EnableTwoFactor(1, secret, totp.Now())
+	require.NoError(t, err)
+	require.Len(t, codes, recoveryCodeCount)
+	format := regexp.MustCompile(`^[a-z0-9]{5}-[a-z0-9]{5}$`)
+	seen := map[string]bool{}
+	for _, code := range codes {
+		assert.Regexp(t, format, code)
+		assert.False(t, seen[code], "codes are unique")
+		seen[code] = true
+	}
+
+	assert.ErrorIs(t, s.VerifyTwoFactorCode(1, "000000x"), errWrongTwoFactorCode)
+	assert.NoError(t, s.VerifyTwoFactorCode(1, totp.Now()))
+
+	// A recovery code may be typed loosely and works once
+	user, err := s.GetUserById(1)
+	require.NoError(t, err)
+	loose := " " + codes[0][:5] + " " + codes[0][6:]
+	assert.True(t, s.checkTwoFactorCode(user, loose))
+	assert.False(t, s.checkTwoFactorCode(user, codes[0]), "recovery code is consumed")
+	assert.False(t, s.checkTwoFactorCode(user, ""))
+	assert.True(t, s.checkTwoFactorCode(user, totp.Now()))
+	status, err := s.GetTwoFactorStatus(1)
+	require.NoError(t, err)
+	assert.True(t, status.Enabled)
+	assert.EqualValues(t, recoveryCodeCount-1, status.RecoveryCodes)
+
+	// Regenerating needs the TOTP code and retires the old codes
+	_, err = s.RegenerateRecoveryCodes(1, codes[1])
+	assert.ErrorIs(t, err, errWrongTwoFactorCode)
+	fresh, err := s.RegenerateRecoveryCodes(1, totp.Now())
+	require.NoError(t, err)
+	assert.False(t, s.checkTwoFactorCode(user, codes[1]))
+
+	assert.ErrorIs(t, s.DisableTwoFactor(1, codes[2]), errWrongTwoFactorCode)
+	require.NoError(t, s.DisableTwoFactor(1, fresh[0]))
+	status, err = s.GetTwoFactorStatus(1)
+	require.NoError(t, err)
+	assert.False(t, status.Enabled)
+	assert.Zero(t, status.RecoveryCodes)
+}
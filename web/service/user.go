@@ -2,16 +2,21 @@ package service
 
 import (
 	"errors"
+	"strings"
+	"time"
 
 	"github.com/mhsanaei/3x-ui/v2/database"
 	"github.com/mhsanaei/3x-ui/v2/database/model"
 	"github.com/mhsanaei/3x-ui/v2/logger"
+	"github.com/mhsanaei/3x-ui/v2/util/common"
 	"github.com/mhsanaei/3x-ui/v2/util/crypto"
 	ldaputil "github.com/mhsanaei/3x-ui/v2/util/ldap"
-	"github.com/xlzd/gotp"
+	"github.com/mhsanaei/3x-ui/v2/util/random"
 	"gorm.io/gorm"
 )
 
+var errWrongCredentials = errors.New("wrong username or password")
+
 // UserService provides business logic for user management and authentication.
 // It handles user creation, login, password management, and 2FA operations.
 type UserService struct {
@@ -33,7 +38,98 @@ func (s *UserService) GetFirstUser() (*model.User, error) {
 	return user, nil
 }
 
-func (s *UserService) CheckUser(username string, password string, twoFactorCode string) *model.User {
+// CheckUser authenticates a login attempt from remoteIp against the local password,
+// LDAP and two-factor code. Every failed attempt counts towards the brute-force
+// limits of both the IP and the account; while either is locked out a
+// *LoginLockedError is returned without evaluating the credentials.
+// Users with a security key but no TOTP get ErrSecurityKeyRequired after the password
+// matched and have to finish the login through WebAuthnService.
+func (s *UserService) CheckUser(username string, password string, twoFactorCode string, remoteIp string) (*model.User, error) {
+	user, err := s.limitLogin(username, remoteIp, func() *model.User {
+		user := s.checkPassword(username, password)
+		if user != nil && user.TwoFactorEnable && !s.checkTwoFactorCode(user, twoFactorCode) {
+			return nil
+		}
+		return user
+	})
+	if err != nil {
+		return nil, err
+	}
+	if !user.TwoFactorEnable && s.HasSecurityKeys(user.Id) {
+		return nil, ErrSecurityKeyRequired
+	}
+	return user, nil
+}
+
+// CheckPassword authenticates only the password of a login attempt, for logins whose
+// second factor is a security key. It is subject to the same brute-force limits as CheckUser.
+func (s *UserService) CheckPassword(username string, password string, remoteIp string) (*model.User, error) {
+	return s.limitLogin(username, remoteIp, func() *model.User {
+		return s.checkPassword(username, password)
+	})
+}
+
+// limitLogin runs check unless the IP or account is locked out and records its outcome.
+func (s *UserService) limitLogin(username string, remoteIp string, check func() *model.User) (*model.User, error) {
+	cfg := s.getLoginLimitConfig()
+	now := time.Now()
+	if cfg.enable {
+		if remaining := loginLimits.lockedFor(remoteIp, username, now); remaining > 0 {
+			return nil, &LoginLockedError{Remaining: remaining}
+		}
+	}
+
+	user := check()
+	if !cfg.enable {
+		if user == nil {
+			return nil, errWrongCredentials
+		}
+		return user, nil
+	}
+	if user == nil {
+		loginLimits.fail(remoteIp, username, cfg, now)
+		return nil, errWrongCredentials
+	}
+	loginLimits.succeed(remoteIp, username)
+	return user, nil
+}
+
+// GetLoginLockouts returns the IPs and accounts with recent failed logins.
+func (s *UserService) GetLoginLockouts() []LoginLockout {
+	return loginLimits.list(s.getLoginLimitConfig(), time.Now())
+}
+
+// ClearLoginLockout forgets the failures of one IP or account, or of all when kind is empty.
+func (s *UserService) ClearLoginLockout(kind string, key string) error {
+	if kind != "" && kind != LoginLockoutIP && kind != LoginLockoutUser {
+		return common.NewError("invalid lockout kind:", kind)
+	}
+	loginLimits.clear(kind, key)
+	return nil
+}
+
+// getLoginLimitConfig reads the brute-force protection settings.
+func (s *UserService) getLoginLimitConfig() loginLimitConfig {
+	enable, err := s.settingService.GetLoginLimitEnable()
+	if err != nil {
+		logger.Warning("get login limit setting err:", err)
+		enable = true
+	}
+	maxAttempts, _ := s.settingService.GetLoginMaxAttempts()
+	lockout, _ := s.settingService.GetLoginLockoutSeconds()
+	maxLockout, _ := s.settingService.GetLoginLockoutMaxSeconds()
+	window, _ := s.settingService.GetLoginAttemptWindow()
+	return loginLimitConfig{
+		enable:      enable,
+		maxAttempts: max(maxAttempts, 1),
+		lockout:     time.Duration(max(lockout, 1)) * time.Second,
+		maxLockout:  time.Duration(max(maxLockout, lockout, 1)) * time.Second,
+		window:      time.Duration(max(window, 1)) * time.Second,
+	}
+}
+
+// checkPassword verifies the password of username locally or via LDAP.
+func (s *UserService) checkPassword(username string, password string) *model.User {
 	db := database.GetDB()
 
 	user := &model.User{}
@@ -43,7 +139,7 @@ func (s *UserService) CheckUser(username string, password string, twoFactorCode
 		First(user).
 		Error
 	if err == gorm.ErrRecordNotFound {
-		return nil
+		return s.checkLdapPassword(username, password, nil)
 	} else if err != nil {
 		logger.Warning("check user err:", err)
 		return nil
@@ -51,59 +147,89 @@ func (s *UserService) CheckUser(username string, password string, twoFactorCode
 
 	// If LDAP enabled and local password check fails, attempt LDAP auth
 	if !crypto.CheckPasswordHash(user.Password, password) {
-		ldapEnabled, _ := s.settingService.GetLdapEnable()
-		if !ldapEnabled {
-			return nil
-		}
+		return s.checkLdapPassword(username, password, user)
+	}
 
-		host, _ := s.settingService.GetLdapHost()
-		port, _ := s.settingService.GetLdapPort()
-		useTLS, _ := s.settingService.GetLdapUseTLS()
-		bindDN, _ := s.settingService.GetLdapBindDN()
-		ldapPass, _ := s.settingService.GetLdapPassword()
-		baseDN, _ := s.settingService.GetLdapBaseDN()
-		userFilter, _ := s.settingService.GetLdapUserFilter()
-		userAttr, _ := s.settingService.GetLdapUserAttr()
-
-		cfg := ldaputil.Config{
-			Host:       host,
-			Port:       port,
-			UseTLS:     useTLS,
-			BindDN:     bindDN,
-			Password:   ldapPass,
-			BaseDN:     baseDN,
-			UserFilter: userFilter,
-			UserAttr:   userAttr,
-		}
-		ok, err := ldaputil.AuthenticateUser(cfg, username, password)
-		if err != nil || !ok {
-			return nil
-		}
-		// On successful LDAP auth, continue 2FA checks below
+	return user
+}
+
+// checkLdapPassword authenticates username against LDAP when it is enabled and returns
+// the panel user to log in; local is the panel user of that name, if any. Once a group
+// to role mapping is set the directory groups decide the role and users outside the
+// mapped groups are denied. Without a mapping, missing users are created with the
+// default role when auto-provisioning is on and existing users keep their role; without
+// a default role either, only existing users may log in.
+func (s *UserService) checkLdapPassword(username string, password string, local *model.User) *model.User {
+	ldapEnabled, _ := s.settingService.GetLdapEnable()
+	if !ldapEnabled {
+		return nil
 	}
 
-	twoFactorEnable, err := s.settingService.GetTwoFactorEnable()
+	host, _ := s.settingService.GetLdapHost()
+	port, _ := s.settingService.GetLdapPort()
+	useTLS, _ := s.settingService.GetLdapUseTLS()
+	bindDN, _ := s.settingService.GetLdapBindDN()
+	ldapPass, _ := s.settingService.GetLdapPassword()
+	baseDN, _ := s.settingService.GetLdapBaseDN()
+	userFilter, _ := s.settingService.GetLdapUserFilter()
+	userAttr, _ := s.settingService.GetLdapUserAttr()
+	groupAttr, _ := s.settingService.GetLdapGroupAttr()
+
+	cfg := ldaputil.Config{
+		Host:       host,
+		Port:       port,
+		UseTLS:     useTLS,
+		BindDN:     bindDN,
+		Password:   ldapPass,
+		BaseDN:     baseDN,
+		UserFilter: userFilter,
+		UserAttr:   userAttr,
+		GroupAttr:  strings.TrimSpace(groupAttr),
+	}
+	identity, err := ldaputil.AuthenticateUser(cfg, username, password)
 	if err != nil {
-		logger.Warning("check two factor err:", err)
+		logger.Warning("LDAP login failed:", err)
+		return nil
+	}
+	if identity == nil {
 		return nil
 	}
 
-	if twoFactorEnable {
-		twoFactorToken, err := s.settingService.GetTwoFactorToken()
-
-		if err != nil {
-			logger.Warning("check two factor token err:", err)
-			return nil
-		}
+	mappingValue, _ := s.settingService.GetLdapRoleMapping()
+	mapping, err := parseRoleMapping(mappingValue)
+	if err != nil {
+		logger.Warning("LDAP login failed:", err)
+		return nil
+	}
+	defaultRoleValue, _ := s.settingService.GetLdapDefaultRole()
+	defaultRole := model.Role(strings.TrimSpace(defaultRoleValue))
+	if defaultRole != "" && !defaultRole.IsValid() {
+		logger.Warning("LDAP login failed: invalid LDAP default role:", defaultRole)
+		return nil
+	}
+	autoProvision, _ := s.settingService.GetLdapAutoProvision()
 
-		if gotp.NewDefaultTOTP(twoFactorToken).Now() != twoFactorCode {
+	role, mapped := resolveRole(mapping, identity.Groups)
+	if !mapped {
+		if len(mapping) > 0 {
+			logger.Warning("LDAP user is not in a group allowed to use the panel:", identity.Username)
 			return nil
 		}
+		role = defaultRole
+	}
+	name := identity.Username
+	if local != nil {
+		name = local.Username
+	}
+	user, err := s.provisionUser(name, role, mapped, autoProvision && role != "")
+	if err != nil {
+		logger.Warning("LDAP login failed:", err)
+		return nil
 	}
-
 	return user
 }
 
+// UpdateUser changes the username and password of a panel user and logs them out everywhere.
 func (s *UserService) UpdateUser(id int, username string, password string) error {
 	db := database.GetDB()
 	hashedPassword, err := crypto.HashPasswordAsBcrypt(password)
@@ -112,20 +238,16 @@ func (s *UserService) UpdateUser(id int, username string, password string) error
 		return err
 	}
 
-	twoFactorEnable, err := s.settingService.GetTwoFactorEnable()
-	if err != nil {
-		return err
-	}
-
-	if twoFactorEnable {
-		s.settingService.SetTwoFactorEnable(false)
-		s.settingService.SetTwoFactorToken("")
-	}
-
-	return db.Model(model.User{}).
-		Where("id = ?", id).
-		Updates(map[string]any{"username": username, "password": hashedPassword}).
-		Error
+	return db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Model(model.User{}).
+			Where("id = ?", id).
+			Updates(map[string]any{"username": username, "password": hashedPassword}).
+			Error
+		if err != nil {
+			return err
+		}
+		return deleteUserSessions(tx, id, "")
+	})
 }
 
 func (s *UserService) UpdateFirstUser(username string, password string) error {
@@ -146,11 +268,276 @@ func (s *UserService) UpdateFirstUser(username string, password string) error {
 	if database.IsNotFound(err) {
 		user.Username = username
 		user.Password = hashedPassword
+		user.Role = model.RoleOwner
 		return db.Model(model.User{}).Create(user).Error
 	} else if err != nil {
 		return err
 	}
 	user.Username = username
 	user.Password = hashedPassword
-	return db.Save(user).Error
+	return db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Save(user).Error; err != nil {
+			return err
+		}
+		return deleteUserSessions(tx, user.Id, "")
+	})
+}
+
+// GetUserById retrieves a panel user by id.
+func (s *UserService) GetUserById(id int) (*model.User, error) {
+	db := database.GetDB()
+	user := &model.User{}
+	err := db.Model(model.User{}).Where("id = ?", id).First(user).Error
+	if err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+// GetUsers returns all panel users ordered by id.
+func (s *UserService) GetUsers() ([]*model.User, error) {
+	db := database.GetDB()
+	var users []*model.User
+	err := db.Model(model.User{}).Order("id").Find(&users).Error
+	if err != nil {
+		return nil, err
+	}
+	return users, nil
+}
+
+// AddUser creates a new panel user with the given role.
+func (s *UserService) AddUser(username string, password string, role model.Role) (*model.User, error) {
+	if username == "" {
+		return nil, errors.New("username can not be empty")
+	} else if password == "" {
+		return nil, errors.New("password can not be empty")
+	}
+	if !role.IsValid() {
+		return nil, common.NewError("invalid role:", role)
+	}
+	if err := s.checkUsernameFree(username, 0); err != nil {
+		return nil, err
+	}
+	hashedPassword, err := crypto.HashPasswordAsBcrypt(password)
+	if err != nil {
+		return nil, err
+	}
+	user := &model.User{
+		Username: username,
+		Password: hashedPassword,
+		Role:     role,
+	}
+	if err := database.GetDB().Create(user).Error; err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+// UpdateUserById changes the username, role and optionally the password of a panel user.
+// An empty password keeps the current one, a new one logs the user out everywhere.
+// The last owner can not be demoted.
+func (s *UserService) UpdateUserById(id int, username string, password string, role model.Role) error {
+	if username == "" {
+		return errors.New("username can not be empty")
+	}
+	if !role.IsValid() {
+		return common.NewError("invalid role:", role)
+	}
+	user, err := s.GetUserById(id)
+	if err != nil {
+		return err
+	}
+	if err := s.checkUsernameFree(username, id); err != nil {
+		return err
+	}
+	if user.Role == model.RoleOwner && role != model.RoleOwner {
+		if err := s.checkNotLastOwner(); err != nil {
+			return err
+		}
+	}
+	updates := map[string]any{"username": username, "role": role}
+	if password != "" {
+		hashedPassword, err := crypto.HashPasswordAsBcrypt(password)
+		if err != nil {
+			return err
+		}
+		updates["password"] = hashedPassword
+	}
+	return database.GetDB().Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(model.User{}).Where("id = ?", id).Updates(updates).Error; err != nil {
+			return err
+		}
+		if password == "" {
+			return nil
+		}
+		return deleteUserSessions(tx, id, "")
+	})
+}
+
+// DelUser deletes a panel user. The last owner can not be deleted.
+func (s *UserService) DelUser(id int) error {
+	user, err := s.GetUserById(id)
+	if err != nil {
+		return err
+	}
+	if user.Role == model.RoleOwner {
+		if err := s.checkNotLastOwner(); err != nil {
+			return err
+		}
+	}
+	return database.GetDB().Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("user_id = ?", id).Delete(&model.APIToken{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("user_id = ?", id).Delete(&model.UserRecoveryCode{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("user_id = ?", id).Delete(&model.WebAuthnCredential{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("user_id = ?", id).Delete(&model.ResellerQuota{}).Error; err != nil {
+			return err
+		}
+		if err := deleteUserSessions(tx, id, ""); err != nil {
+			return err
+		}
+		return tx.Delete(model.User{}, id).Error
+	})
+}
+
+// provisionUser returns the local user for a username authenticated by LDAP.
+// A missing user is created with role and an unusable random password when create
+// is set. An existing user takes role when syncRole is set; if that would demote the
+// last owner the login is denied rather than granted with the higher role.
+func (s *UserService) provisionUser(username string, role model.Role, syncRole bool, create bool) (*model.User, error) {
+	db := database.GetDB()
+	user := &model.User{}
+	err := db.Model(model.User{}).Where("username = ?", username).First(user).Error
+	if database.IsNotFound(err) {
+		if !create {
+			return nil, common.NewError("no panel user for:", username)
+		}
+		user, err = s.AddUser(username, random.Seq(32), role)
+		if err != nil {
+			return nil, err
+		}
+		logger.Infof("provisioned panel user %s with role %s", username, role)
+		return user, nil
+	} else if err != nil {
+		return nil, err
+	}
+	if syncRole {
+		if err := s.applyExternalRole(user, role); err != nil {
+			return nil, err
+		}
+	}
+	return user, nil
+}
+
+// provisionOIDCUser returns the local user linked to the OpenID Connect identity of
+// issuer and subject and gives it role. Without a linked user one named username is
+// created when create is set, but an existing user of that name is never taken over:
+// it has to link the identity itself through LinkOIDCUser.
+func (s *UserService) provisionOIDCUser(issuer string, subject string, username string, role model.Role, create bool) (*model.User, error) {
+	db := database.GetDB()
+	user := &model.User{}
+	err := db.Where("oidc_issuer = ? AND oidc_subject = ?", issuer, subject).Limit(1).Find(user).Error
+	if err != nil {
+		return nil, err
+	}
+	if user.Id > 0 {
+		if err := s.applyExternalRole(user, role); err != nil {
+			return nil, err
+		}
+		return user, nil
+	}
+	if !create {
+		return nil, common.NewError("no panel user is linked to the identity of", username)
+	}
+	if err := s.checkUsernameFree(username, 0); err != nil {
+		return nil, common.NewErrorf("panel user %s exists but is not linked to this identity", username)
+	}
+	user, err = s.AddUser(username, random.Seq(32), role)
+	if err != nil {
+		return nil, err
+	}
+	err = db.Model(user).Updates(map[string]any{"oidc_issuer": issuer, "oidc_subject": subject}).Error
+	if err != nil {
+		return nil, err
+	}
+	user.OidcIssuer, user.OidcSubject = issuer, subject
+	logger.Infof("provisioned panel user %s with role %s", username, role)
+	return user, nil
+}
+
+// LinkOIDCUser links the OpenID Connect identity of issuer and subject to the user
+// with id, so it signs in as that user from then on. An identity links one user only.
+func (s *UserService) LinkOIDCUser(id int, issuer string, subject string) (*model.User, error) {
+	var count int64
+	err := database.GetDB().Model(model.User{}).
+		Where("oidc_issuer = ? AND oidc_subject = ? AND id <> ?", issuer, subject, id).
+		Count(&count).Error
+	if err != nil {
+		return nil, err
+	}
+	if count > 0 {
+		return nil, errors.New("the identity is already linked to another panel user")
+	}
+	user, err := s.GetUserById(id)
+	if err != nil {
+		return nil, err
+	}
+	err = database.GetDB().Model(user).Updates(map[string]any{"oidc_issuer": issuer, "oidc_subject": subject}).Error
+	if err != nil {
+		return nil, err
+	}
+	user.OidcIssuer, user.OidcSubject = issuer, subject
+	logger.Infof("linked single sign-on identity to panel user %s", user.Username)
+	return user, nil
+}
+
+// applyExternalRole gives user the role an identity provider or directory mapped it
+// to. It fails when that would demote the last owner, so the login can be denied.
+func (s *UserService) applyExternalRole(user *model.User, role model.Role) error {
+	if user.Role == role {
+		return nil
+	}
+	if user.Role == model.RoleOwner {
+		if err := s.checkNotLastOwner(); err != nil {
+			return common.NewErrorf("can not give %s the role %s: %v", user.Username, role, err)
+		}
+	}
+	if err := database.GetDB().Model(user).Update("role", role).Error; err != nil {
+		return err
+	}
+	user.Role = role
+	return nil
+}
+
+// checkUsernameFree returns an error if username is taken by a user other than exceptId.
+func (s *UserService) checkUsernameFree(username string, exceptId int) error {
+	var count int64
+	err := database.GetDB().Model(model.User{}).
+		Where("username = ? AND id <> ?", username, exceptId).
+		Count(&count).Error
+	if err != nil {
+		return err
+	}
+	if count > 0 {
+		return common.NewError("username already exists:", username)
+	}
+	return nil
+}
+
+// checkNotLastOwner returns an error if fewer than two owners exist.
+func (s *UserService) checkNotLastOwner() error {
+	var count int64
+	err := database.GetDB().Model(model.User{}).Where("role = ?", model.RoleOwner).Count(&count).Error
+	if err != nil {
+		return err
+	}
+	if count <= 1 {
+		return errors.New("the panel must keep at least one owner")
+	}
+	return nil
 }
@@ -0,0 +1,45 @@
+package service
+
+import (
+	"testing"
+
+	"github.com/mhsanaei/3x-ui/v2/database"
+	"github.com/mhsanaei/3x-ui/v2/database/model"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestProvisionUser(t *testing.T) {
+	setupTestDB(t, nil)
+	s := &UserService{}
+
+	_, err := s.provisionUser("dave", model.RoleSupport, true, false)
+	assert.Error(t, err, "missing user without auto-provisioning")
+
+	user, err := s.provisionUser("dave", model.RoleSupport, true, true)
+	require.NoError(t, err)
+	assert.Equal(t, model.RoleSupport, user.Role)
+
+	user, err = s.provisionUser("dave", model.RoleOperator, true, true)
+	require.NoError(t, err)
+	assert.Equal(t, model.RoleOperator, user.Role)
+
+	user, err = s.provisionUser("dave", model.RoleViewer, false, true)
+	require.NoError(t, err)
+	assert.Equal(t, model.RoleOperator, user.Role, "role kept without sync")
+
+	// The last owner mapped to a lower role is denied, not logged in as owner
+	_, err = s.provisionUser("admin", model.RoleViewer, true, false)
+	require.Error(t, err)
+	admin := &model.User{}
+	require.NoError(t, database.GetDB().Where("username = ?", "admin").First(admin).Error)
+	assert.Equal(t, model.RoleOwner, admin.Role)
+
+	// With a second owner the demotion is applied
+	_, err = s.AddUser("erin", "password", model.RoleOwner)
+	require.NoError(t, err)
+	user, err = s.provisionUser("admin", model.RoleViewer, true, false)
+	require.NoError(t, err)
+	assert.Equal(t, model.RoleViewer, user.Role)
+}
@@ -0,0 +1,303 @@
+package service
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/mhsanaei/3x-ui/v2/database"
+	"github.com/mhsanaei/3x-ui/v2/database/model"
+	"github.com/mhsanaei/3x-ui/v2/logger"
+	"github.com/mhsanaei/3x-ui/v2/util/common"
+
+	"github.com/go-webauthn/webauthn/protocol"
+	"github.com/go-webauthn/webauthn/webauthn"
+)
+
+// ErrSecurityKeyRequired is returned by UserService.CheckUser when the password was right
+// but the user has to confirm the login with one of their security keys.
+var ErrSecurityKeyRequired = errors.New("security key required")
+
+// WebAuthnOrigin identifies the relying party a ceremony runs for, derived from the panel URL.
+type WebAuthnOrigin struct {
+	RPID   string // Host name without port
+	Origin string // Scheme, host and port as seen by the browser
+}
+
+// webauthnUser adapts a panel user and their credentials to webauthn.User.
+type webauthnUser struct {
+	user        *model.User
+	credentials []webauthn.Credential
+}
+
+func (u *webauthnUser) WebAuthnID() []byte                         { return webauthnUserHandle(u.user.Id) }
+func (u *webauthnUser) WebAuthnName() string                       { return u.user.Username }
+func (u *webauthnUser) WebAuthnDisplayName() string                { return u.user.Username }
+func (u *webauthnUser) WebAuthnCredentials() []webauthn.Credential { return u.credentials }
+
+// webauthnUserHandle returns the opaque WebAuthn user handle of a panel user id.
+func webauthnUserHandle(userId int) []byte {
+	handle := make([]byte, 8)
+	binary.BigEndian.PutUint64(handle, uint64(userId))
+	return handle
+}
+
+// WebAuthnService provides registration and login with security keys and passkeys.
+// Ceremony state is returned to the caller as an opaque string to keep until the finish step.
+type WebAuthnService struct {
+	userService UserService
+}
+
+func (s *WebAuthnService) newWebAuthn(origin WebAuthnOrigin) (*webauthn.WebAuthn, error) {
+	return webauthn.New(&webauthn.Config{
+		RPID:          origin.RPID,
+		RPDisplayName: "3x-ui",
+		RPOrigins:     []string{origin.Origin},
+	})
+}
+
+// HasSecurityKeys reports whether a user registered any security key or passkey.
+func (s *UserService) HasSecurityKeys(userId int) bool {
+	var count int64
+	err := database.GetDB().Model(model.WebAuthnCredential{}).Where("user_id = ?", userId).Count(&count).Error
+	return err == nil && count > 0
+}
+
+// GetCredentials returns the credentials registered by userId, or all credentials when userId is 0.
+func (s *WebAuthnService) GetCredentials(userId int) ([]*model.WebAuthnCredential, error) {
+	db := database.GetDB()
+	var credentials []*model.WebAuthnCredential
+	query := db.Model(model.WebAuthnCredential{}).Order("id")
+	if userId > 0 {
+		query = query.Where("user_id = ?", userId)
+	}
+	if err := query.Find(&credentials).Error; err != nil {
+		return nil, err
+	}
+	return credentials, nil
+}
+
+// DelCredential revokes a credential. When userId is not 0 the credential must belong to that user.
+func (s *WebAuthnService) DelCredential(id int, userId int) error {
+	db := database.GetDB()
+	query := db.Where("id = ?", id)
+	if userId > 0 {
+		query = query.Where("user_id = ?", userId)
+	}
+	result := query.Delete(&model.WebAuthnCredential{})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return common.NewError("credential not found:", id)
+	}
+	return nil
+}
+
+// BeginRegistration starts registering a new authenticator for userId.
+func (s *WebAuthnService) BeginRegistration(userId int, origin WebAuthnOrigin) (*protocol.CredentialCreation, string, error) {
+	wa, err := s.newWebAuthn(origin)
+	if err != nil {
+		return nil, "", err
+	}
+	user, err := s.loadUser(userId)
+	if err != nil {
+		return nil, "", err
+	}
+	creation, session, err := wa.BeginRegistration(user,
+		webauthn.WithExclusions(webauthn.Credentials(user.credentials).CredentialDescriptors()),
+		webauthn.WithResidentKeyRequirement(protocol.ResidentKeyRequirementPreferred),
+	)
+	if err != nil {
+		return nil, "", err
+	}
+	state, err := json.Marshal(session)
+	if err != nil {
+		return nil, "", err
+	}
+	return creation, string(state), nil
+}
+
+// FinishRegistration verifies the attestation in response, the JSON encoded result of
+// navigator.credentials.create(), and stores the new credential under name.
+func (s *WebAuthnService) FinishRegistration(userId int, origin WebAuthnOrigin, state string, name string, response string) (*model.WebAuthnCredential, error) {
+	if name == "" {
+		return nil, errors.New("credential name can not be empty")
+	}
+	session, err := decodeWebAuthnSession(state)
+	if err != nil {
+		return nil, err
+	}
+	wa, err := s.newWebAuthn(origin)
+	if err != nil {
+		return nil, err
+	}
+	user, err := s.loadUser(userId)
+	if err != nil {
+		return nil, err
+	}
+	parsed, err := protocol.ParseCredentialCreationResponseBytes([]byte(response))
+	if err != nil {
+		return nil, webauthnError(err)
+	}
+	credential, err := wa.CreateCredential(user, *session, parsed)
+	if err != nil {
+		return nil, webauthnError(err)
+	}
+	data, err := json.Marshal(credential)
+	if err != nil {
+		return nil, err
+	}
+	record := &model.WebAuthnCredential{
+		UserId:       userId,
+		Name:         name,
+		CredentialId: base64.RawURLEncoding.EncodeToString(credential.ID),
+		Credential:   string(data),
+	}
+	if err := database.GetDB().Create(record).Error; err != nil {
+		return nil, err
+	}
+	return record, nil
+}
+
+// BeginLogin starts a login ceremony. With a user it only accepts that user's
+// credentials and serves as second factor after the password; without one any
+// discoverable passkey may sign in and user verification is required.
+func (s *WebAuthnService) BeginLogin(user *model.User, origin WebAuthnOrigin) (*protocol.CredentialAssertion, string, error) {
+	wa, err := s.newWebAuthn(origin)
+	if err != nil {
+		return nil, "", err
+	}
+	var assertion *protocol.CredentialAssertion
+	var session *webauthn.SessionData
+	if user != nil {
+		waUser, err := s.loadUser(user.Id)
+		if err != nil {
+			return nil, "", err
+		}
+		if len(waUser.credentials) == 0 {
+			return nil, "", errors.New("no security key registered for this user")
+		}
+		assertion, session, err = wa.BeginLogin(waUser)
+		if err != nil {
+			return nil, "", err
+		}
+	} else {
+		assertion, session, err = wa.BeginDiscoverableLogin(webauthn.WithUserVerification(protocol.VerificationRequired))
+		if err != nil {
+			return nil, "", err
+		}
+	}
+	state, err := json.Marshal(session)
+	if err != nil {
+		return nil, "", err
+	}
+	return assertion, string(state), nil
+}
+
+// FinishLogin verifies the assertion in response, the JSON encoded result of
+// navigator.credentials.get(), against a ceremony started by BeginLogin and
+// returns the authenticated user.
+func (s *WebAuthnService) FinishLogin(origin WebAuthnOrigin, state string, response string) (*model.User, error) {
+	session, err := decodeWebAuthnSession(state)
+	if err != nil {
+		return nil, err
+	}
+	parsed, err := protocol.ParseCredentialRequestResponseBytes([]byte(response))
+	if err != nil {
+		return nil, webauthnError(err)
+	}
+	wa, err := s.newWebAuthn(origin)
+	if err != nil {
+		return nil, err
+	}
+
+	var user *webauthnUser
+	var credential *webauthn.Credential
+	if len(session.UserID) > 0 {
+		user, err = s.loadUser(int(binary.BigEndian.Uint64(session.UserID)))
+		if err != nil {
+			return nil, err
+		}
+		credential, err = wa.ValidateLogin(user, *session, parsed)
+	} else {
+		var found webauthn.User
+		found, credential, err = wa.ValidatePasskeyLogin(func(rawID, userHandle []byte) (webauthn.User, error) {
+			if len(userHandle) != 8 {
+				return nil, errors.New("unknown user handle")
+			}
+			return s.loadUser(int(binary.BigEndian.Uint64(userHandle)))
+		}, *session, parsed)
+		if err == nil {
+			user = found.(*webauthnUser)
+		}
+	}
+	if err != nil {
+		return nil, webauthnError(err)
+	}
+	// Authenticators without a counter always report 0 and never raise the warning.
+	if credential.Authenticator.CloneWarning {
+		logger.Warningf("security key of %s reported a sign counter that did not increase, it may be cloned", user.user.Username)
+		return nil, errors.New("security key sign counter did not increase")
+	}
+	s.updateCredential(credential)
+	return user.user, nil
+}
+
+// loadUser returns a panel user together with their registered credentials.
+func (s *WebAuthnService) loadUser(userId int) (*webauthnUser, error) {
+	user, err := s.userService.GetUserById(userId)
+	if err != nil {
+		return nil, err
+	}
+	records, err := s.GetCredentials(userId)
+	if err != nil {
+		return nil, err
+	}
+	credentials := make([]webauthn.Credential, 0, len(records))
+	for _, record := range records {
+		var credential webauthn.Credential
+		if err := json.Unmarshal([]byte(record.Credential), &credential); err != nil {
+			logger.Warning("skipping unreadable security key", record.Id, ":", err)
+			continue
+		}
+		credentials = append(credentials, credential)
+	}
+	return &webauthnUser{user: user, credentials: credentials}, nil
+}
+
+// updateCredential stores the new sign counter and last use of a credential after a login.
+func (s *WebAuthnService) updateCredential(credential *webauthn.Credential) {
+	data, err := json.Marshal(credential)
+	if err != nil {
+		return
+	}
+	err = database.GetDB().Model(model.WebAuthnCredential{}).
+		Where("credential_id = ?", base64.RawURLEncoding.EncodeToString(credential.ID)).
+		Updates(map[string]any{"credential": string(data), "last_used": time.Now().UnixMilli()}).Error
+	if err != nil {
+		logger.Warning("Unable to update security key:", err)
+	}
+}
+
+func decodeWebAuthnSession(state string) (*webauthn.SessionData, error) {
+	if state == "" {
+		return nil, errors.New("no security key ceremony in progress")
+	}
+	session := &webauthn.SessionData{}
+	if err := json.Unmarshal([]byte(state), session); err != nil {
+		return nil, err
+	}
+	return session, nil
+}
+
+// webauthnError adds the library's detail to protocol errors, whose messages are generic.
+func webauthnError(err error) error {
+	var protocolErr *protocol.Error
+	if errors.As(err, &protocolErr) && protocolErr.DevInfo != "" {
+		return common.NewError(protocolErr.Details, protocolErr.DevInfo)
+	}
+	return err
+}
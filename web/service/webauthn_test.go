@@ -0,0 +1,88 @@
+package service
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"testing"
+
+	"github.com/mhsanaei/3x-ui/v2/database"
+	"github.com/mhsanaei/3x-ui/v2/database/model"
+
+	"github.com/go-webauthn/webauthn/protocol"
+	"github.com/go-webauthn/webauthn/webauthn"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var testOrigin = WebAuthnOrigin{RPID: "panel.test", Origin: "https://panel.test"}
+
+// addTestCredential stores a credential with id for userId.
+func addTestCredential(t *testing.T, userId int, id string) *model.WebAuthnCredential {
+	t.Helper()
+	data, err := json.Marshal(webauthn.Credential{ID: []byte(id), PublicKey: []byte("key")})
+	require.NoError(t, err)
+	record := &model.WebAuthnCredential{
+		UserId:       userId,
+		Name:         id,
+		CredentialId: base64.RawURLEncoding.EncodeToString([]byte(id)),
+		Credential:   string(data),
+	}
+	require.NoError(t, database.GetDB().Create(record).Error)
+	return record
+}
+
+func TestWebAuthnUserHandle(t *testing.T) {
+	for _, id := range []int{1, 42, 1 << 40} {
+		handle := webauthnUserHandle(id)
+		assert.Len(t, handle, 8)
+		assert.Equal(t, id, int(binary.BigEndian.Uint64(handle)))
+	}
+}
+
+func TestWebAuthnCredentials(t *testing.T) {
+	setupTestDB(t, nil)
+	s := &WebAuthnService{}
+	other, err := (&UserService{}).AddUser("other", "password", model.RoleViewer)
+	require.NoError(t, err)
+
+	assert.False(t, s.userService.HasSecurityKeys(1))
+	_, _, err = s.BeginLogin(&model.User{Id: 1}, testOrigin)
+	assert.Error(t, err, "second factor without keys")
+
+	first := addTestCredential(t, 1, "key-1")
+	addTestCredential(t, other.Id, "key-2")
+	assert.True(t, s.userService.HasSecurityKeys(1))
+	mine, err := s.GetCredentials(1)
+	require.NoError(t, err)
+	require.Len(t, mine, 1)
+	all, err := s.GetCredentials(0)
+	require.NoError(t, err)
+	assert.Len(t, all, 2)
+
+	// Registering again excludes the keys the user already has
+	creation, state, err := s.BeginRegistration(1, testOrigin)
+	require.NoError(t, err)
+	require.Len(t, creation.Response.CredentialExcludeList, 1)
+	assert.Equal(t, []byte("key-1"), []byte(creation.Response.CredentialExcludeList[0].CredentialID))
+	assert.Equal(t, "panel.test", creation.Response.RelyingParty.ID)
+	assert.NotEmpty(t, state)
+
+	// As second factor only the user's keys are allowed, passwordless requires verification
+	assertion, _, err := s.BeginLogin(&model.User{Id: 1}, testOrigin)
+	require.NoError(t, err)
+	require.Len(t, assertion.Response.AllowedCredentials, 1)
+	assertion, _, err = s.BeginLogin(nil, testOrigin)
+	require.NoError(t, err)
+	assert.Empty(t, assertion.Response.AllowedCredentials)
+	assert.Equal(t, protocol.VerificationRequired, assertion.Response.UserVerification)
+
+	_, err = s.FinishLogin(testOrigin, "", "{}")
+	assert.Error(t, err, "no ceremony in progress")
+	_, err = s.FinishRegistration(1, testOrigin, state, "", "{}")
+	assert.Error(t, err, "empty name")
+
+	assert.Error(t, s.DelCredential(first.Id, other.Id), "key of another user")
+	require.NoError(t, s.DelCredential(first.Id, 1))
+	assert.False(t, s.userService.HasSecurityKeys(1))
+}
@@ -1,5 +1,5 @@
 // Package session provides session management utilities for the 3x-ui web panel.
-// It handles user authentication state, login sessions, and session storage using Gin sessions.
+// It handles user authentication state, login sessions, and the database backed session store.
 package session
 
 import (
@@ -13,8 +13,12 @@ import (
 )
 
 const (
-	loginUserKey = "LOGIN_USER"
-	defaultPath  = "/"
+	loginUserKey    = "LOGIN_USER"
+	oidcStateKey    = "OIDC_STATE"
+	oidcVerifierKey = "OIDC_VERIFIER"
+	oidcNonceKey    = "OIDC_NONCE"
+	webauthnKey     = "WEBAUTHN_SESSION"
+	defaultPath     = "/"
 )
 
 func init() {
@@ -23,12 +27,16 @@ func init() {
 
 // SetLoginUser stores the authenticated user in the session.
 // The user object is serialized and stored for subsequent requests.
+// The password hash and two-factor secret are left out as no request needs them.
 func SetLoginUser(c *gin.Context, user *model.User) {
 	if user == nil {
 		return
 	}
+	stored := *user
+	stored.Password = ""
+	stored.TwoFactorSecret = ""
 	s := sessions.Default(c)
-	s.Set(loginUserKey, *user)
+	s.Set(loginUserKey, stored)
 }
 
 // SetMaxAge configures the session cookie maximum age in seconds.
@@ -60,6 +68,40 @@ func GetLoginUser(c *gin.Context) *model.User {
 	return &user
 }
 
+// SetOIDCLogin stores the state, PKCE verifier and nonce of a pending single sign-on login.
+func SetOIDCLogin(c *gin.Context, state string, verifier string, nonce string) {
+	s := sessions.Default(c)
+	s.Set(oidcStateKey, state)
+	s.Set(oidcVerifierKey, verifier)
+	s.Set(oidcNonceKey, nonce)
+}
+
+// PopOIDCLogin returns and removes the values stored by SetOIDCLogin, so a callback can not be replayed.
+func PopOIDCLogin(c *gin.Context) (state string, verifier string, nonce string) {
+	s := sessions.Default(c)
+	state, _ = s.Get(oidcStateKey).(string)
+	verifier, _ = s.Get(oidcVerifierKey).(string)
+	nonce, _ = s.Get(oidcNonceKey).(string)
+	s.Delete(oidcStateKey)
+	s.Delete(oidcVerifierKey)
+	s.Delete(oidcNonceKey)
+	return
+}
+
+// SetWebAuthnSession stores the state of a pending security key registration or login.
+func SetWebAuthnSession(c *gin.Context, state string) {
+	s := sessions.Default(c)
+	s.Set(webauthnKey, state)
+}
+
+// PopWebAuthnSession returns and removes the state stored by SetWebAuthnSession, so a ceremony can not be replayed.
+func PopWebAuthnSession(c *gin.Context) string {
+	s := sessions.Default(c)
+	state, _ := s.Get(webauthnKey).(string)
+	s.Delete(webauthnKey)
+	return state
+}
+
 // IsLogin checks if a user is currently authenticated in the session.
 // Returns true if a valid user session exists, false otherwise.
 func IsLogin(c *gin.Context) bool {
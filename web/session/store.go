@@ -0,0 +1,199 @@
+package session
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base32"
+	"encoding/gob"
+	"encoding/hex"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/mhsanaei/3x-ui/v2/database"
+	"github.com/mhsanaei/3x-ui/v2/database/model"
+	"github.com/mhsanaei/3x-ui/v2/logger"
+
+	"github.com/gin-contrib/sessions"
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/securecookie"
+	gsessions "github.com/gorilla/sessions"
+)
+
+const (
+	// defaultSessionLifetime bounds sessions whose cookie lasts until the browser is closed.
+	defaultSessionLifetime = 30 * 24 * time.Hour
+
+	// preLoginSessionLifetime bounds sessions without a user, which only hold the state
+	// of a single sign-on or security key login in progress.
+	preLoginSessionLifetime = 10 * time.Minute
+
+	// lastSeenInterval limits how often the last request of a session is written.
+	lastSeenInterval = time.Minute
+)
+
+// dbStore keeps session values in the database. The cookie only holds an opaque
+// random id signed with the panel secret, so sessions can be listed and revoked.
+type dbStore struct {
+	codecs  []securecookie.Codec
+	options *gsessions.Options
+}
+
+// NewStore returns a database backed session store whose cookies are signed with secret.
+func NewStore(secret []byte) sessions.Store {
+	codecs := securecookie.CodecsFromPairs(secret)
+	for _, codec := range codecs {
+		if sc, ok := codec.(*securecookie.SecureCookie); ok {
+			// Expiry is enforced by the database row, not the cookie timestamp.
+			sc.MaxAge(0)
+		}
+	}
+	return &dbStore{
+		codecs:  codecs,
+		options: &gsessions.Options{Path: defaultPath, HttpOnly: true, SameSite: http.SameSiteLaxMode},
+	}
+}
+
+// KeyHash returns the hash under which the session of the request is stored, or "" if it has none.
+func KeyHash(c *gin.Context) string {
+	id := sessions.Default(c).ID()
+	if id == "" {
+		return ""
+	}
+	return hashSessionId(id)
+}
+
+func hashSessionId(id string) string {
+	sum := sha256.Sum256([]byte(id))
+	return hex.EncodeToString(sum[:])
+}
+
+func (s *dbStore) Options(options sessions.Options) {
+	s.options = options.ToGorillaOptions()
+}
+
+func (s *dbStore) Get(r *http.Request, name string) (*gsessions.Session, error) {
+	return gsessions.GetRegistry(r).Get(s, name)
+}
+
+// New loads the session named by the request cookie, or returns a new empty
+// session when the cookie is missing, invalid, expired or revoked.
+func (s *dbStore) New(r *http.Request, name string) (*gsessions.Session, error) {
+	session := gsessions.NewSession(s, name)
+	options := *s.options
+	session.Options = &options
+	session.IsNew = true
+
+	cookie, err := r.Cookie(name)
+	if err != nil {
+		return session, nil
+	}
+	var id string
+	if err := securecookie.DecodeMulti(name, cookie.Value, &id, s.codecs...); err != nil {
+		return session, nil
+	}
+	row := &model.Session{}
+	now := time.Now()
+	err = database.GetDB().Where("key_hash = ? AND expires_at > ?", hashSessionId(id), now.UnixMilli()).First(row).Error
+	if err != nil {
+		return session, nil
+	}
+	if err := gob.NewDecoder(bytes.NewReader(row.Data)).Decode(&session.Values); err != nil {
+		logger.Warning("Unable to decode session:", err)
+		return session, nil
+	}
+	session.ID = id
+	session.IsNew = false
+
+	if now.Sub(time.UnixMilli(row.LastSeen)) > lastSeenInterval {
+		database.GetDB().Model(model.Session{}).Where("id = ?", row.Id).Updates(map[string]any{
+			"last_seen":  now.UnixMilli(),
+			"ip":         remoteIp(r),
+			"user_agent": r.UserAgent(),
+		})
+	}
+	return session, nil
+}
+
+// Save stores the session values and sets the cookie. A negative MaxAge deletes the session.
+// The session id is replaced whenever a different user logs in, so an id obtained before
+// login can not be used to ride the logged-in session.
+func (s *dbStore) Save(r *http.Request, w http.ResponseWriter, session *gsessions.Session) error {
+	db := database.GetDB()
+	if session.Options.MaxAge < 0 {
+		if session.ID != "" {
+			if err := db.Where("key_hash = ?", hashSessionId(session.ID)).Delete(&model.Session{}).Error; err != nil {
+				return err
+			}
+		}
+		http.SetCookie(w, gsessions.NewCookie(session.Name(), "", session.Options))
+		return nil
+	}
+
+	userId := 0
+	if user, ok := session.Values[loginUserKey].(model.User); ok {
+		userId = user.Id
+	}
+	var data bytes.Buffer
+	if err := gob.NewEncoder(&data).Encode(session.Values); err != nil {
+		return err
+	}
+
+	now := time.Now()
+	lifetime := time.Duration(session.Options.MaxAge) * time.Second
+	if lifetime <= 0 {
+		lifetime = defaultSessionLifetime
+	}
+	if userId == 0 {
+		lifetime = min(lifetime, preLoginSessionLifetime)
+	}
+	row := &model.Session{}
+	if session.ID != "" {
+		err := db.Where("key_hash = ?", hashSessionId(session.ID)).First(row).Error
+		if err != nil && !database.IsNotFound(err) {
+			return err
+		}
+		if row.Id > 0 && row.UserId != userId {
+			if err := db.Delete(row).Error; err != nil {
+				return err
+			}
+			row = &model.Session{}
+			session.ID = ""
+		}
+	}
+	if session.ID == "" {
+		session.ID = strings.TrimRight(base32.StdEncoding.EncodeToString(securecookie.GenerateRandomKey(32)), "=")
+	}
+
+	row.KeyHash = hashSessionId(session.ID)
+	row.UserId = userId
+	row.Data = data.Bytes()
+	row.Ip = remoteIp(r)
+	row.UserAgent = r.UserAgent()
+	row.LastSeen = now.UnixMilli()
+	row.ExpiresAt = now.Add(lifetime).UnixMilli()
+	if err := db.Save(row).Error; err != nil {
+		return err
+	}
+
+	encoded, err := securecookie.EncodeMulti(session.Name(), session.ID, s.codecs...)
+	if err != nil {
+		return err
+	}
+	http.SetCookie(w, gsessions.NewCookie(session.Name(), encoded, session.Options))
+	return nil
+}
+
+// remoteIp returns the client address of r, preferring the headers set by a reverse proxy.
+func remoteIp(r *http.Request) string {
+	if value := r.Header.Get("X-Real-IP"); value != "" {
+		return value
+	}
+	if value := r.Header.Get("X-Forwarded-For"); value != "" {
+		ip, _, _ := strings.Cut(value, ",")
+		return strings.TrimSpace(ip)
+	}
+	ip, _, _ := net.SplitHostPort(r.RemoteAddr)
+	return ip
+}
@@ -0,0 +1,76 @@
+package session
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/mhsanaei/3x-ui/v2/database"
+	"github.com/mhsanaei/3x-ui/v2/database/model"
+	"github.com/mhsanaei/3x-ui/v2/logger"
+
+	"github.com/gin-contrib/sessions"
+	"github.com/gin-gonic/gin"
+	"github.com/op/go-logging"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// storedSession returns the only session row.
+func storedSession(t *testing.T) *model.Session {
+	t.Helper()
+	var rows []*model.Session
+	require.NoError(t, database.GetDB().Find(&rows).Error)
+	require.Len(t, rows, 1)
+	return rows[0]
+}
+
+func TestStoreLifetimes(t *testing.T) {
+	logger.InitLogger(logging.ERROR)
+	require.NoError(t, database.InitDB(filepath.Join(t.TempDir(), "x-ui.db")))
+	t.Cleanup(func() { database.CloseDB() })
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(sessions.Sessions("3x-ui", NewStore([]byte("secret"))))
+	router.GET("/begin", func(c *gin.Context) {
+		SetOIDCLogin(c, "state", "verifier", "nonce")
+		sessions.Default(c).Save()
+	})
+	router.GET("/login", func(c *gin.Context) {
+		SetMaxAge(c, 0)
+		SetLoginUser(c, &model.User{Id: 1, Username: "admin", Password: "hash", TwoFactorSecret: "secret"})
+		sessions.Default(c).Save()
+	})
+	router.GET("/user", func(c *gin.Context) {
+		c.JSON(http.StatusOK, GetLoginUser(c))
+	})
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/begin", nil))
+	cookie := w.Header().Get("Set-Cookie")
+	require.NotEmpty(t, cookie)
+	row := storedSession(t)
+	assert.Zero(t, row.UserId)
+	assert.LessOrEqual(t, row.ExpiresAt, time.Now().Add(preLoginSessionLifetime).UnixMilli())
+
+	// Logging in replaces the pre-login session with a long lived one
+	w = httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodGet, "/login", nil)
+	r.Header.Set("Cookie", cookie)
+	router.ServeHTTP(w, r)
+	cookie = w.Header().Get("Set-Cookie")
+	row = storedSession(t)
+	assert.Equal(t, 1, row.UserId)
+	assert.Greater(t, row.ExpiresAt, time.Now().Add(24*time.Hour).UnixMilli())
+
+	w = httptest.NewRecorder()
+	r = httptest.NewRequest(http.MethodGet, "/user", nil)
+	r.Header.Set("Cookie", cookie)
+	router.ServeHTTP(w, r)
+	assert.Contains(t, w.Body.String(), `"username":"admin"`)
+	assert.NotContains(t, string(row.Data), "hash")
+	assert.NotContains(t, string(row.Data), "secret")
+}
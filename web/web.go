@@ -12,6 +12,7 @@ import (
 	"net"
 	"net/http"
 	"os"
+	"reflect"
 	"strconv"
 	"strings"
 	"time"
@@ -25,11 +26,11 @@ import (
 	"github.com/mhsanaei/3x-ui/v2/web/middleware"
 	"github.com/mhsanaei/3x-ui/v2/web/network"
 	"github.com/mhsanaei/3x-ui/v2/web/service"
+	"github.com/mhsanaei/3x-ui/v2/web/session"
 	"github.com/mhsanaei/3x-ui/v2/web/websocket"
 
 	"github.com/gin-contrib/gzip"
 	"github.com/gin-contrib/sessions"
-	"github.com/gin-contrib/sessions/cookie"
 	"github.com/gin-gonic/gin"
 	"github.com/robfig/cron/v3"
 )
@@ -96,6 +97,8 @@ type Server struct {
 	httpServer *http.Server
 	listener   net.Listener
 
+	metricsServer *http.Server
+
 	index *controller.IndexController
 	panel *controller.XUIController
 	api   *controller.APIController
@@ -203,7 +206,7 @@ func (s *Server) initRouter() (*gin.Engine, error) {
 	engine.Use(gzip.Gzip(gzip.DefaultCompression, gzip.WithExcludedPaths([]string{basePath + "panel/api/"})))
 	assetsBasePath := basePath + "assets/"
 
-	store := cookie.NewStore(secret)
+	store := session.NewStore(secret)
 	// Configure default session cookie options, including expiration (MaxAge)
 	if sessionMaxAge, err := s.settingService.GetSessionMaxAge(); err == nil {
 		store.Options(sessions.Options{
@@ -321,9 +324,30 @@ func (s *Server) startTask() {
 	// check client ips from log file every 10 sec
 	s.cron.AddJob("@every 10s", job.NewCheckClientIpJob())
 
+	// shape clients with speed limits by the ips recorded for them every 10 sec
+	s.cron.AddJob("@every 10s", job.NewSpeedLimitJob())
+
 	// check client ips from log file every day
 	s.cron.AddJob("@daily", job.NewClearLogsJob())
 
+	// delete expired login sessions every 10 min
+	s.cron.AddJob("@every 10m", job.NewSessionCleanupJob())
+
+	// purge deleted clients and inbounds past their retention every hour
+	s.cron.AddJob("@hourly", job.NewRecycleBinJob())
+
+	// drop traffic history past its retention every day
+	s.cron.AddJob("@daily", job.NewTrafficStatsJob())
+
+	// trim the destination report to its retention and top destinations every hour
+	s.cron.AddJob("@hourly", job.NewClientDestinationJob())
+
+	// run scheduled client and inbound actions that are due every 30 sec
+	s.cron.AddJob("@every 30s", job.NewScheduledActionJob())
+
+	// turn clients on and off at the start of every minute, where their access windows begin and end
+	s.cron.AddJob("0 * * * * *", job.NewAccessScheduleJob())
+
 	// Inbound traffic reset jobs
 	// Run once a day, midnight
 	s.cron.AddJob("@daily", job.NewPeriodicTrafficResetJob("daily"))
@@ -385,7 +409,7 @@ func (s *Server) Start() (err error) {
 	if err != nil {
 		return err
 	}
-	s.cron = cron.New(cron.WithLocation(loc), cron.WithSeconds())
+	s.cron = cron.New(cron.WithLocation(loc), cron.WithSeconds(), cron.WithChain(timeJob))
 	s.cron.Start()
 
 	engine, err := s.initRouter()
@@ -440,6 +464,10 @@ func (s *Server) Start() (err error) {
 		s.httpServer.Serve(listener)
 	}()
 
+	if err := s.startMetricsServer(); err != nil {
+		logger.Warning("start metrics server failed:", err)
+	}
+
 	s.startTask()
 
 	isTgbotenabled, err := s.settingService.GetTgbotEnabled()
@@ -451,6 +479,54 @@ func (s *Server) Start() (err error) {
 	return nil
 }
 
+// startMetricsServer serves Prometheus metrics on the metrics port, when metrics are
+// enabled and the port is set.
+func (s *Server) startMetricsServer() error {
+	enabled, err := s.settingService.GetMetricsEnable()
+	if err != nil || !enabled {
+		return err
+	}
+	port, err := s.settingService.GetMetricsPort()
+	if err != nil || port <= 0 {
+		return err
+	}
+	listen, err := s.settingService.GetMetricsListen()
+	if err != nil {
+		return err
+	}
+	listener, err := net.Listen("tcp", net.JoinHostPort(listen, strconv.Itoa(port)))
+	if err != nil {
+		return err
+	}
+	logger.Info("Metrics server running HTTP on", listener.Addr())
+	s.metricsServer = &http.Server{
+		Handler:           controller.MetricsHandler(),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	go func() {
+		s.metricsServer.Serve(listener)
+	}()
+	return nil
+}
+
+// timeJob wraps the cron jobs of a named type to record how long each run takes for
+// the metrics, under the name of the type.
+func timeJob(j cron.Job) cron.Job {
+	if _, ok := j.(cron.FuncJob); ok {
+		return j
+	}
+	t := reflect.TypeOf(j)
+	if t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	name := t.Name()
+	return cron.FuncJob(func() {
+		start := time.Now()
+		j.Run()
+		service.RecordJobRun(name, time.Since(start))
+	})
+}
+
 // Stop gracefully shuts down the web server, stops Xray, cron jobs, and Telegram bot.
 func (s *Server) Stop() error {
 	s.cancel()
@@ -467,6 +543,9 @@ func (s *Server) Stop() error {
 	}
 	var err1 error
 	var err2 error
+	if s.metricsServer != nil {
+		s.metricsServer.Close()
+	}
 	if s.httpServer != nil {
 		err1 = s.httpServer.Shutdown(s.ctx)
 	}
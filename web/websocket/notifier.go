@@ -32,27 +32,27 @@ func BroadcastStatus(status any) {
 	}
 }
 
-// BroadcastTraffic broadcasts traffic statistics update to all connected clients
+// BroadcastTraffic broadcasts traffic statistics update to clients subscribed to traffic
 func BroadcastTraffic(traffic any) {
 	hub := GetHub()
 	if hub != nil {
-		hub.Broadcast(MessageTypeTraffic, traffic)
+		hub.BroadcastToTopic(MessageTypeTraffic, traffic)
 	}
 }
 
-// BroadcastInbounds broadcasts inbounds list update to all connected clients
+// BroadcastInbounds broadcasts inbounds list update to clients subscribed to inbounds
 func BroadcastInbounds(inbounds any) {
 	hub := GetHub()
 	if hub != nil {
-		hub.Broadcast(MessageTypeInbounds, inbounds)
+		hub.BroadcastToTopic(MessageTypeInbounds, inbounds)
 	}
 }
 
-// BroadcastOutbounds broadcasts outbounds list update to all connected clients
+// BroadcastOutbounds broadcasts outbounds list update to clients subscribed to outbounds
 func BroadcastOutbounds(outbounds any) {
 	hub := GetHub()
 	if hub != nil {
-		hub.Broadcast(MessageTypeOutbounds, outbounds)
+		hub.BroadcastToTopic(MessageTypeOutbounds, outbounds)
 	}
 }
 